@@ -0,0 +1,60 @@
+// заглушка системы расчета баллов лояльности для локальной разработки и тестов без настоящей системы расчета
+//
+//	accrual-stub [-a ADDRESS] [-script FILE]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/accrual/stub"
+)
+
+func main() {
+	address := flag.String("a", envOr("RUN_ADDRESS", "localhost:8081"), "run address")
+	scriptFile := flag.String("script", os.Getenv("ACCRUAL_STUB_SCRIPT"), "json script with responses")
+	flag.Parse()
+
+	script := stub.Script{}
+	if *scriptFile != "" {
+		var err error
+		script, err = stub.LoadScript(*scriptFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	server := &http.Server{
+		Addr:    *address,
+		Handler: stub.New(script),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	fmt.Println("заглушка системы расчета запущена на", *address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// envOr значение переменной окружения name или def, если она не задана
+func envOr(name, def string) string {
+	if val, ok := os.LookupEnv(name); ok {
+		return val
+	}
+	return def
+}
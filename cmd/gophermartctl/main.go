@@ -0,0 +1,281 @@
+// утилита администратора gophermart. Работает напрямую с базой данных
+//
+//	gophermartctl [-d DATABASE_URI] unlock [-login LOGIN] [-ip IP]
+//	gophermartctl [-d DATABASE_URI] role -login LOGIN -role ROLE
+//	gophermartctl [-d DATABASE_URI] logins conflicts
+//	gophermartctl [-d DATABASE_URI] logins rename -user USER_ID -login LOGIN
+//	gophermartctl [-d DATABASE_URI] dlq list [-limit N] [-offset N]
+//	gophermartctl [-d DATABASE_URI] dlq show|requeue|invalidate -order NUMBER
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kTowkA/gophermart/internal/logger"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/policy"
+	"github.com/kTowkA/gophermart/internal/storage"
+	"github.com/kTowkA/gophermart/internal/storage/postgres"
+)
+
+var errUsage = errors.New(`использование:
+	gophermartctl [-d DATABASE_URI] unlock [-login LOGIN] [-ip IP]
+	gophermartctl [-d DATABASE_URI] role -login LOGIN -role ROLE
+	gophermartctl [-d DATABASE_URI] logins conflicts
+	gophermartctl [-d DATABASE_URI] logins rename -user USER_ID -login LOGIN
+	gophermartctl [-d DATABASE_URI] dlq list [-limit N] [-offset N]
+	gophermartctl [-d DATABASE_URI] dlq show|requeue|invalidate -order NUMBER`)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// run разбирает аргументы и выполняет команду. Строка подключения берется из флага -d или переменной окружения DATABASE_URI
+func run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("gophermartctl", flag.ContinueOnError)
+	databaseURI := fs.String("d", os.Getenv("DATABASE_URI"), "database URI")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 || *databaseURI == "" {
+		return errUsage
+	}
+
+	log, err := logger.NewLog(logger.WithLevel(slog.LevelWarn))
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	pstorage, err := postgres.NewStorage(ctx, *databaseURI, log)
+	if err != nil {
+		return fmt.Errorf("подключение к БД. %w", err)
+	}
+	defer pstorage.Close(ctx)
+
+	switch fs.Arg(0) {
+	case "unlock":
+		return unlock(ctx, pstorage, fs.Args()[1:])
+	case "role":
+		return setRole(ctx, pstorage, fs.Args()[1:])
+	case "logins":
+		return logins(ctx, pstorage, fs.Args()[1:])
+	case "dlq":
+		return deadLetters(ctx, pstorage, fs.Args()[1:])
+	default:
+		return errUsage
+	}
+}
+
+// unlock снимает блокировку входа и сбрасывает неудачные попытки для логина и, если указан, ip адреса
+func unlock(ctx context.Context, pstorage *postgres.PStorage, args []string) error {
+	fs := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	login := fs.String("login", "", "login to unlock")
+	ip := fs.String("ip", "", "ip address to unlock")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *login == "" && *ip == "" {
+		return errUsage
+	}
+
+	keys := []string{}
+	if *login != "" {
+		keys = append(keys, model.LoginAttemptsLoginKey(policy.NormalizeLogin(*login)))
+	}
+	if *ip != "" {
+		keys = append(keys, model.LoginAttemptsIPKey(*ip))
+	}
+	for _, key := range keys {
+		if err := pstorage.ResetLoginFailures(ctx, key); err != nil {
+			return fmt.Errorf("разблокировка %s. %w", key, err)
+		}
+		fmt.Println("разблокировано:", key)
+	}
+	return nil
+}
+
+// setRole назначает пользователю роль. Так назначается первый администратор, дальше роли можно менять через /api/admin
+func setRole(ctx context.Context, pstorage *postgres.PStorage, args []string) error {
+	fs := flag.NewFlagSet("role", flag.ContinueOnError)
+	login := fs.String("login", "", "user login")
+	role := fs.String("role", "", "role: "+strings.Join(model.Roles, ", "))
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *login == "" || len(policy.ValidateRole(*role)) > 0 {
+		return errUsage
+	}
+	normalized := policy.NormalizeLogin(*login)
+	if err := pstorage.SetUserRole(ctx, normalized, *role); err != nil {
+		return fmt.Errorf("назначение роли %s пользователю %s. %w", *role, normalized, err)
+	}
+	fmt.Printf("пользователю %s назначена роль %s\n", normalized, *role)
+	return nil
+}
+
+// logins работа с конфликтами логинов: пользователями, чей логин при приведении к каноническому виду совпал с чужим.
+// Такие пользователи не могут войти, пока им не назначен новый логин
+func logins(ctx context.Context, pstorage *postgres.PStorage, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "conflicts":
+		return listLoginConflicts(ctx, pstorage)
+	case "rename":
+		return renameConflictingLogin(ctx, pstorage, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+// listLoginConflicts выводит конфликты логинов таблицей
+func listLoginConflicts(ctx context.Context, pstorage *postgres.PStorage) error {
+	conflicts, err := pstorage.LoginConflicts(ctx)
+	if errors.Is(err, storage.ErrLoginConflictNotFound) {
+		fmt.Println("конфликтов логинов нет")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("получение конфликтов логинов. %w", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ПОЛЬЗОВАТЕЛЬ\tЛОГИН\tКАНОНИЧЕСКИЙ\tВЛАДЕЛЕЦ\tОБНАРУЖЕН")
+	for _, c := range conflicts {
+		owner := c.OwnerLogin
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%q\t%s\t%s\t%s\n", c.UserID, c.Login, c.CanonicalLogin, owner, c.DetectedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// renameConflictingLogin назначает пользователю с конфликтом логина новый логин. Логин приводится к каноническому виду,
+// как при регистрации, поэтому после переименования пользователь входит с ним
+func renameConflictingLogin(ctx context.Context, pstorage *postgres.PStorage, args []string) error {
+	fs := flag.NewFlagSet("logins rename", flag.ContinueOnError)
+	user := fs.String("user", "", "user id from logins conflicts")
+	login := fs.String("login", "", "new login")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	userID, err := uuid.Parse(*user)
+	if err != nil {
+		return errUsage
+	}
+	normalized := policy.NormalizeLogin(*login)
+	if normalized == "" {
+		return errUsage
+	}
+	if err = pstorage.ResolveLoginConflict(ctx, userID, normalized); err != nil {
+		return fmt.Errorf("смена логина пользователя %s на %s. %w", userID, normalized, err)
+	}
+	fmt.Printf("пользователь %s теперь входит с логином %s\n", userID, normalized)
+	return nil
+}
+
+// deadLetters работа с очередью недоставленных: заказами, по которым система расчета так и не вернула окончательный статус
+func deadLetters(ctx context.Context, pstorage *postgres.PStorage, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if args[0] == "list" {
+		return listDeadLetters(ctx, pstorage, args[1:])
+	}
+
+	fs := flag.NewFlagSet("dlq "+args[0], flag.ContinueOnError)
+	order := fs.String("order", "", "order number")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+	if *order == "" {
+		return errUsage
+	}
+	orderNum := model.OrderNumber(*order)
+	switch args[0] {
+	case "show":
+		dl, err := pstorage.DeadLetter(ctx, orderNum)
+		if err != nil {
+			return fmt.Errorf("получение заказа %s из очереди недоставленных. %w", orderNum, err)
+		}
+		printDeadLetter(dl)
+	case "requeue":
+		if err := pstorage.RequeueDeadLetter(ctx, orderNum); err != nil {
+			return fmt.Errorf("возврат заказа %s на проверку. %w", orderNum, err)
+		}
+		fmt.Printf("заказ %s возвращен на проверку\n", orderNum)
+	case "invalidate":
+		if err := pstorage.InvalidateDeadLetter(ctx, orderNum); err != nil {
+			return fmt.Errorf("перевод заказа %s в INVALID. %w", orderNum, err)
+		}
+		fmt.Printf("заказ %s переведен в INVALID\n", orderNum)
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+// listDeadLetters выводит очередь недоставленных таблицей, начиная с последних переведенных заказов
+func listDeadLetters(ctx context.Context, pstorage *postgres.PStorage, args []string) error {
+	fs := flag.NewFlagSet("dlq list", flag.ContinueOnError)
+	limit := fs.Int("limit", 100, "max orders")
+	offset := fs.Int("offset", 0, "orders to skip")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *limit <= 0 || *offset < 0 {
+		return errUsage
+	}
+	dls, err := pstorage.DeadLetters(ctx, *limit, *offset)
+	if errors.Is(err, storage.ErrOrdersNotFound) {
+		fmt.Println("очередь недоставленных пуста")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("получение очереди недоставленных. %w", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ЗАКАЗ\tЛОГИН\tСТАТУС\tПОПЫТОК\tHTTP\tПЕРЕВЕДЕН\tПОСЛЕДНЯЯ ОШИБКА")
+	for _, dl := range dls {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			dl.OrderNumber, dl.Login, dl.Status, dl.Attempts, dl.LastHTTPStatus, dl.DeadLetteredAt.Format(time.RFC3339), dl.LastError,
+		)
+	}
+	return tw.Flush()
+}
+
+// printDeadLetter выводит заказ из очереди недоставленных
+func printDeadLetter(dl model.DeadLetter) {
+	fmt.Println("заказ:           ", dl.OrderNumber)
+	fmt.Println("логин:           ", dl.Login)
+	fmt.Println("статус:          ", dl.Status)
+	fmt.Println("загружен:        ", dl.UploadedAt.Format(time.RFC3339))
+	fmt.Println("попыток:         ", dl.Attempts)
+	fmt.Println("последний HTTP:  ", dl.LastHTTPStatus)
+	fmt.Println("последняя ошибка:", dl.LastError)
+	fmt.Println("переведен:       ", dl.DeadLetteredAt.Format(time.RFC3339))
+}
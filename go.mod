@@ -18,6 +18,7 @@ require (
 	go.uber.org/zap/exp v0.2.0
 	golang.org/x/crypto v0.23.0
 	golang.org/x/sync v0.5.0
+	golang.org/x/text v0.15.0
 )
 
 require (
@@ -58,7 +59,6 @@ require (
 	golang.org/x/mod v0.11.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.15.0 // indirect
 	golang.org/x/tools v0.10.0 // indirect
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,61 @@
+// пакет клиента внешней системы расчета баллов лояльности
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/ratelimit"
+)
+
+// Client клиент системы расчета баллов лояльности
+type Client interface {
+	// Order запрашивает информацию о расчете баллов по заказу number.
+	// Ошибку возвращает только если ответ не получен (сетевая ошибка, отмена контекста), любой код ответа возвращается в Response
+	Order(ctx context.Context, number model.OrderNumber) (Response, error)
+}
+
+// Response ответ системы расчета
+type Response struct {
+	// StatusCode код ответа
+	StatusCode int
+	// Order информация по заказу. Заполняется только при StatusCode 200
+	Order model.ResponseAccuralSystem
+	// RetryAfter пауза из заголовка Retry-After при ответе 429
+	RetryAfter time.Duration
+	// HasRetryAfter был ли в ответе корректный заголовок Retry-After
+	HasRetryAfter bool
+}
+
+// HTTPClient клиент системы расчета по http
+type HTTPClient struct {
+	client *resty.Client
+}
+
+// NewHTTPClient клиент системы расчета по адресу baseURL.
+// Повторов на уровне клиента нет: повторять ли запрос и когда, решает вызывающий по коду ответа
+func NewHTTPClient(baseURL string) *HTTPClient {
+	return &HTTPClient{
+		client: resty.New().SetBaseURL(baseURL),
+	}
+}
+
+func (c *HTTPClient) Order(ctx context.Context, number model.OrderNumber) (Response, error) {
+	result := model.ResponseAccuralSystem{}
+	resp, err := c.client.R().SetContext(ctx).SetResult(&result).Get("/api/orders/" + string(number))
+	if err != nil {
+		return Response{}, err
+	}
+	r := Response{StatusCode: resp.StatusCode()}
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		r.Order = result
+		r.Order.Payload = resp.Body()
+	case http.StatusTooManyRequests:
+		r.RetryAfter, r.HasRetryAfter = ratelimit.ParseRetryAfter(resp.Header().Get("Retry-After"), time.Now())
+	}
+	return r, nil
+}
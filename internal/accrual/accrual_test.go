@@ -0,0 +1,64 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/accrual/stub"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPClient(t *testing.T) {
+	srv := stub.New(stub.Script{
+		Orders: map[model.OrderNumber][]stub.Step{
+			"1": {
+				{Code: http.StatusTooManyRequests, RetryAfter: "60"},
+				{Code: http.StatusTooManyRequests},
+				{Code: http.StatusInternalServerError},
+				{Status: "PROCESSING"},
+				{Status: "PROCESSED", Accrual: 72998},
+			},
+		},
+	})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	client := NewHTTPClient(ts.URL)
+	ctx := context.Background()
+
+	want := []Response{
+		{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute, HasRetryAfter: true},
+		{StatusCode: http.StatusTooManyRequests},
+		{StatusCode: http.StatusInternalServerError},
+		{StatusCode: http.StatusOK, Order: model.ResponseAccuralSystem{OrderNumber: "1", Status: model.NewStatus(0, "PROCESSING")}},
+		{StatusCode: http.StatusOK, Order: model.ResponseAccuralSystem{OrderNumber: "1", Status: model.NewStatus(0, "PROCESSED"), Accrual: 72998}},
+		// последний ответ повторяется
+		{StatusCode: http.StatusOK, Order: model.ResponseAccuralSystem{OrderNumber: "1", Status: model.NewStatus(0, "PROCESSED"), Accrual: 72998}},
+	}
+	for i, w := range want {
+		resp, err := client.Order(ctx, "1")
+		require.NoError(t, err, i)
+		// тело ответа сохраняется как есть для истории статусов заказа
+		if resp.StatusCode == http.StatusOK {
+			assert.True(t, json.Valid(resp.Order.Payload), i)
+			resp.Order.Payload = nil
+		}
+		assert.Equal(t, w, resp, i)
+	}
+	assert.Equal(t, len(want), srv.Calls("1"))
+
+	// заказ не из сценария не зарегистрирован
+	resp, err := client.Order(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// недоступная система расчета - ошибка
+	ts.Close()
+	_, err = client.Order(ctx, "1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+// Code generated by mockery v2.53.7. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	accrual "github.com/kTowkA/gophermart/internal/accrual"
+
+	mock "github.com/stretchr/testify/mock"
+
+	model "github.com/kTowkA/gophermart/internal/model"
+)
+
+// Client is an autogenerated mock type for the Client type
+type Client struct {
+	mock.Mock
+}
+
+// Order provides a mock function with given fields: ctx, number
+func (_m *Client) Order(ctx context.Context, number model.OrderNumber) (accrual.Response, error) {
+	ret := _m.Called(ctx, number)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Order")
+	}
+
+	var r0 accrual.Response
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderNumber) (accrual.Response, error)); ok {
+		return rf(ctx, number)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderNumber) accrual.Response); ok {
+		r0 = rf(ctx, number)
+	} else {
+		r0 = ret.Get(0).(accrual.Response)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.OrderNumber) error); ok {
+		r1 = rf(ctx, number)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewClient creates a new instance of Client. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewClient(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *Client {
+	mock := &Client{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
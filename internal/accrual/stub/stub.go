@@ -0,0 +1,158 @@
+// пакет заглушки системы расчета баллов лояльности. Отвечает по API системы расчета (GET /api/orders/{number}) по сценарию:
+// для каждого заказа задается последовательность ответов, каждый запрос по заказу получает следующий ответ, последний повторяется.
+// Так можно воспроизвести смену статусов, 204, 429 с Retry-After и 500 без настоящей системы расчета
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kTowkA/gophermart/internal/model"
+)
+
+// Step один ответ заглушки
+type Step struct {
+	// Code код ответа. По умолчанию 200
+	Code int `json:"code,omitempty"`
+	// Status статус заказа для ответа 200. По умолчанию REGISTERED
+	Status string `json:"status,omitempty"`
+	// Accrual начисленные баллы для ответа 200
+	Accrual model.Money `json:"accrual,omitempty"`
+	// RetryAfter значение заголовка Retry-After для ответа 429
+	RetryAfter string `json:"retry_after,omitempty"`
+}
+
+// Script сценарий ответов заглушки
+type Script struct {
+	// Default ответы для заказов, которых нет в Orders. Если пусто, такие заказы не зарегистрированы (204)
+	Default []Step `json:"default,omitempty"`
+	// Orders ответы для отдельных заказов
+	Orders map[model.OrderNumber][]Step `json:"orders,omitempty"`
+}
+
+// LoadScript читает сценарий из json файла
+func LoadScript(path string) (Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Script{}, err
+	}
+	script := Script{}
+	if err = json.Unmarshal(data, &script); err != nil {
+		return Script{}, fmt.Errorf("разбор сценария %s. %w", path, err)
+	}
+	return script, nil
+}
+
+// Server заглушка системы расчета. Кроме API системы расчета принимает изменения сценария:
+// PUT /stub/orders/{number} со списком ответов в теле и DELETE /stub/orders для сброса к исходному сценарию
+type Server struct {
+	mu      sync.Mutex
+	initial Script
+	script  Script
+	calls   map[model.OrderNumber]int
+	router  chi.Router
+}
+
+// New заглушка со сценарием script
+func New(script Script) *Server {
+	s := &Server{initial: script}
+	s.reset()
+	r := chi.NewRouter()
+	r.Get("/api/orders/{number}", s.order)
+	r.Put("/stub/orders/{number}", s.setOrder)
+	r.Delete("/stub/orders", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.reset()
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	s.router = r
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// SetOrder задает ответы для заказа number и сбрасывает счетчик запросов по нему
+func (s *Server) SetOrder(number model.OrderNumber, steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script.Orders[number] = steps
+	delete(s.calls, number)
+}
+
+// Calls сколько запросов было по заказу number
+func (s *Server) Calls(number model.OrderNumber) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[number]
+}
+
+// reset возвращает исходный сценарий. Вызывается под блокировкой
+func (s *Server) reset() {
+	s.script = Script{Default: s.initial.Default, Orders: make(map[model.OrderNumber][]Step, len(s.initial.Orders))}
+	for number, steps := range s.initial.Orders {
+		s.script.Orders[number] = steps
+	}
+	s.calls = map[model.OrderNumber]int{}
+}
+
+// next следующий ответ по заказу number
+func (s *Server) next(number model.OrderNumber) (Step, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	steps, ok := s.script.Orders[number]
+	if !ok {
+		steps = s.script.Default
+	}
+	i := s.calls[number]
+	s.calls[number]++
+	if len(steps) == 0 {
+		return Step{}, false
+	}
+	return steps[min(i, len(steps)-1)], true
+}
+
+func (s *Server) order(w http.ResponseWriter, r *http.Request) {
+	number := model.OrderNumber(chi.URLParam(r, "number"))
+	step, ok := s.next(number)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch code := step.Code; code {
+	case 0, http.StatusOK:
+		status := step.Status
+		if status == "" {
+			status = "REGISTERED"
+		}
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(model.ResponseAccuralSystem{OrderNumber: number, Status: model.NewStatus(0, status), Accrual: step.Accrual})
+	case http.StatusTooManyRequests:
+		if step.RetryAfter != "" {
+			w.Header().Set("Retry-After", step.RetryAfter)
+		}
+		w.Header().Set("content-type", "text/plain")
+		w.WriteHeader(code)
+		_, _ = fmt.Fprint(w, "No more than N requests per minute allowed")
+	default:
+		w.WriteHeader(code)
+	}
+}
+
+func (s *Server) setOrder(w http.ResponseWriter, r *http.Request) {
+	steps := []Step{}
+	if err := json.NewDecoder(r.Body).Decode(&steps); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	s.SetOrder(model.OrderNumber(chi.URLParam(r, "number")), steps...)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,56 @@
+package stub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"default": [{"status":"REGISTERED"},{"status":"PROCESSED","accrual":100}],
+		"orders": {"1": [{"code":429,"retry_after":"5"},{"code":204}]}
+	}`), 0o600))
+	script, err := LoadScript(path)
+	require.NoError(t, err)
+	srv := New(script)
+
+	get := func(number string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/"+number, nil))
+		return w
+	}
+
+	w := get("1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusNoContent, get("1").Code)
+
+	// заказы не из сценария получают ответы по умолчанию
+	w = get("2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"order":"2","status":"REGISTERED"}`, w.Body.String())
+	assert.JSONEq(t, `{"order":"2","status":"PROCESSED","accrual":100}`, get("2").Body.String())
+
+	// сценарий меняется на ходу
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/stub/orders/1", strings.NewReader(`[{"code":500}]`)))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusInternalServerError, get("1").Code)
+	assert.Equal(t, 1, srv.Calls("1"))
+
+	// сброс к исходному сценарию
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/stub/orders", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("1").Code)
+	assert.Zero(t, srv.Calls(model.OrderNumber("2")))
+}
@@ -0,0 +1,33 @@
+package accrual
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TimestampHeader заголовок с временем отправки события системой расчета (unix, секунды)
+	TimestampHeader = "X-Accrual-Timestamp"
+	// SignatureHeader заголовок с подписью события: sha256=<hex HMAC-SHA256 от "<timestamp>.<тело>">
+	SignatureHeader = "X-Accrual-Signature"
+)
+
+// Sign подпись события с телом body, отправленного в timestamp. Время входит в подпись, чтобы его нельзя было подменить
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature проверяет подпись signature события с телом body, отправленного в timestamp
+func VerifySignature(secret []byte, timestamp int64, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
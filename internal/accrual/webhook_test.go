@@ -0,0 +1,21 @@
+package accrual
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"order":"1","status":"PROCESSED","accrual":500}`)
+	sig := Sign(secret, 1700000000, body)
+
+	assert.True(t, VerifySignature(secret, 1700000000, body, sig))
+	// подпись привязана к секрету, времени и телу
+	assert.False(t, VerifySignature([]byte("other"), 1700000000, body, sig))
+	assert.False(t, VerifySignature(secret, 1700000001, body, sig))
+	assert.False(t, VerifySignature(secret, 1700000000, []byte(`{"order":"1","status":"PROCESSED","accrual":5000}`), sig))
+	assert.False(t, VerifySignature(secret, 1700000000, body, sig[len("sha256="):]))
+	assert.False(t, VerifySignature(secret, 1700000000, body, ""))
+}
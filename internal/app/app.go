@@ -6,11 +6,21 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/kTowkA/gophermart/internal/accrual"
+	"github.com/kTowkA/gophermart/internal/breaker"
 	"github.com/kTowkA/gophermart/internal/config"
+	"github.com/kTowkA/gophermart/internal/jwtkeys"
 	"github.com/kTowkA/gophermart/internal/logger"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/notifier"
+	"github.com/kTowkA/gophermart/internal/password"
+	"github.com/kTowkA/gophermart/internal/policy"
+	"github.com/kTowkA/gophermart/internal/ratelimit"
 	"github.com/kTowkA/gophermart/internal/storage"
 	"github.com/kTowkA/gophermart/internal/storage/postgres"
 	"golang.org/x/sync/errgroup"
@@ -26,6 +36,25 @@ type AppServer struct {
 	log *slog.Logger
 	// server http сервер
 	server *http.Server
+	// keys ключи подписи и проверки jwt
+	keys *jwtkeys.KeySet
+	// policy правила для логина и пароля
+	policy *policy.Policy
+	// hasher хеширование паролей
+	hasher *password.Hasher
+	// notifier доставка уведомлений пользователям
+	notifier notifier.Notifier
+	// dummyHash хеш пароля для сравнения при входе под несуществующим логином
+	dummyHash   string
+	dummyHashMu sync.Mutex
+	// passwordResets очередь логинов, которым нужно отправить токен сброса пароля
+	passwordResets chan string
+	// accrual клиент системы расчета баллов
+	accrual accrual.Client
+	// accrualLimiter общий ограничитель частоты запросов к системе расчета баллов
+	accrualLimiter *ratelimit.Limiter
+	// accrualBreaker автоматический выключатель запросов к системе расчета баллов
+	accrualBreaker *breaker.Breaker
 }
 
 // RunApp запуск приложения
@@ -39,6 +68,50 @@ func RunApp(ctx context.Context, cfg config.Config, log *logger.Log) error {
 		Handler: app.createRoute(),
 	}
 
+	// ключи для jwt. без файла ключа подписи используем общий секрет
+	app.keys = jwtkeys.NewHMAC(cfg.Secret())
+	if cfg.JWTSigningKeyFile() != "" {
+		keys, err := jwtkeys.Load(cfg.JWTSigningKeyFile(), cfg.JWTVerifyKeyFiles())
+		if err != nil {
+			app.log.Error("загрузка ключей jwt", slog.String("файл ключа подписи", cfg.JWTSigningKeyFile()), slog.String("ошибка", err.Error()))
+			return err
+		}
+		app.keys = keys
+		app.log.Info("токены подписываются асимметричным ключом", slog.String("kid", keys.SigningKID()), slog.Int("ключей проверки", len(keys.JWKS().Keys)))
+	}
+
+	userPolicy, err := newPolicy(cfg)
+	if err != nil {
+		app.log.Error("настройка правил для логина и пароля", slog.String("ошибка", err.Error()))
+		return err
+	}
+	app.policy = userPolicy
+
+	app.hasher, err = newHasher(cfg)
+	if err != nil {
+		app.log.Error("настройка хеширования паролей", slog.String("ошибка", err.Error()))
+		return err
+	}
+
+	switch cfg.Notifier() {
+	case "log":
+		app.notifier = notifier.NewLog(app.log.WithGroup("notifier"))
+	case "file":
+		app.notifier = notifier.NewFile(cfg.NotifierFile())
+	default:
+		err := fmt.Errorf("неизвестный способ доставки уведомлений %q", cfg.Notifier())
+		app.log.Error("настройка уведомлений", slog.String("ошибка", err.Error()))
+		return err
+	}
+
+	switch cfg.Mode() {
+	case "all", "web", "worker":
+	default:
+		err := fmt.Errorf("неизвестный режим работы %q", cfg.Mode())
+		app.log.Error("настройка режима работы", slog.String("ошибка", err.Error()))
+		return err
+	}
+
 	if cfg.DatabaseURI() == "" {
 		app.log.Error("невозможно запустить приложение. отсутствует строка подключения к базе данных")
 	}
@@ -54,9 +127,31 @@ func RunApp(ctx context.Context, cfg config.Config, log *logger.Log) error {
 
 	group, ctxErr := errgroup.WithContext(ctx)
 
+	err = app.initAccrual()
+	if err != nil {
+		app.log.Error("настройка опроса системы расчета баллов", slog.String("ошибка", err.Error()))
+		return err
+	}
+	if cfg.Mode() != "web" {
+		app.log.Info(
+			"опрос системы расчета баллов",
+			slog.String("экземпляр", cfg.InstanceID()),
+			slog.Float64("лимит запросов в секунду", app.accrualLimiter.Rate()),
+			slog.Bool("выбор ведущего", cfg.AccrualLeaderElection()),
+		)
+		group.Go(func() error {
+			// наш обработчик для работы с накопительной системой
+			app.runUpdater(ctxErr)
+			return nil
+		})
+	}
+	if cfg.Mode() == "worker" {
+		return group.Wait()
+	}
+
+	app.initPasswordResets()
 	group.Go(func() error {
-		// наш обработчик для работы с накопительной системой
-		app.updaterStatus(ctx)
+		app.runPasswordResets(ctxErr)
 		return nil
 	})
 
@@ -95,20 +190,93 @@ func RunApp(ctx context.Context, cfg config.Config, log *logger.Log) error {
 	return group.Wait()
 }
 
+// newPolicy правила для логина и пароля из конфигурации
+func newPolicy(cfg config.Config) (*policy.Policy, error) {
+	return policy.New(policy.Rules{
+		LoginMinLength:     cfg.LoginMinLength(),
+		LoginMaxLength:     cfg.LoginMaxLength(),
+		LoginPattern:       cfg.LoginPattern(),
+		PasswordMinLength:  cfg.PasswordMinLength(),
+		PasswordMaxBytes:   cfg.PasswordMaxBytes(),
+		PasswordMinClasses: cfg.PasswordMinClasses(),
+		BreachedFile:       cfg.PasswordBreachedFile(),
+	})
+}
+
+// newHasher хеширование паролей из конфигурации. Новые пароли хешируются выбранным алгоритмом, но проверяются оба
+func newHasher(cfg config.Config) (*password.Hasher, error) {
+	argon2id := password.NewArgon2id(password.Argon2Params{
+		Memory:  cfg.Argon2Memory(),
+		Time:    cfg.Argon2Time(),
+		Threads: cfg.Argon2Threads(),
+	})
+	bcrypt := password.NewBcrypt(cfg.BcryptCost())
+	switch cfg.PasswordHash() {
+	case "argon2id":
+		return password.New(cfg.PasswordHashConcurrency(), argon2id, bcrypt), nil
+	case "bcrypt":
+		return password.New(cfg.PasswordHashConcurrency(), bcrypt, argon2id), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", password.ErrUnknownAlgorithm, cfg.PasswordHash())
+	}
+}
+
 // createRoute создание обработчика
 func (a *AppServer) createRoute() http.Handler {
 	r := chi.NewRouter()
+	// за доверенным прокси адрес клиента берем из заголовков, иначе блокировку по ip можно обойти или навязать прокси
+	if a.config.TrustProxyHeaders() {
+		r.Use(middleware.RealIP)
+	}
 	r.Use(middlewarePostBody, a.middlewareAuthUser, a.middlewareLog)
+	r.Get("/.well-known/jwks.json", a.rJWKS)
 	r.Route("/api/user", func(r chi.Router) {
 		r.Post("/register", a.rRegisterUser)
 		r.Post("/login", a.rLoginUser)
-		r.Post("/orders", a.rOrdersPost)
-		r.Get("/orders", a.rOrdersGet)
+		r.Post("/login/2fa", a.rLoginMFA)
+		r.Post("/token/refresh", a.rRefreshToken)
+		r.Post("/password/reset", a.rPasswordReset)
+		r.Post("/password/reset/confirm", a.rPasswordResetConfirm)
+		// управление аккаунтом доступно только с токеном сессии, но не с api ключом
+		r.Group(func(r chi.Router) {
+			r.Use(requireSession)
+			r.Post("/logout", a.rLogout)
+			r.Post("/token/revoke", a.rRevokeToken)
+			r.Post("/password", a.rChangePassword)
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Post("/", a.rAPIKeyCreate)
+				r.Get("/", a.rAPIKeys)
+				r.Delete("/{keyID}", a.rAPIKeyRevoke)
+			})
+			r.Route("/2fa", func(r chi.Router) {
+				r.Post("/totp", a.rTOTPEnroll)
+				r.Post("/totp/confirm", a.rTOTPConfirm)
+				r.Post("/totp/disable", a.rTOTPDisable)
+				r.Post("/recovery-codes", a.rRecoveryCodes)
+			})
+		})
+		r.With(a.requireScope(model.ScopeOrdersWrite)).Post("/orders", a.rOrdersPost)
+		r.With(a.requireScope(model.ScopeOrdersRead)).Get("/orders", a.rOrdersGet)
+		r.With(a.requireScope(model.ScopeOrdersRead)).Get("/orders/{number}/history", a.rOrderHistory)
 		r.Route("/balance", func(r chi.Router) {
-			r.Get("/", a.rBalance)
-			r.Post("/withdraw", a.rWithdraw)
+			r.With(a.requireScope(model.ScopeBalanceRead)).Get("/", a.rBalance)
+			r.With(a.requireScope(model.ScopeBalanceWrite), a.requireWithdrawMFA).Post("/withdraw", a.rWithdraw)
 		})
-		r.Get("/withdrawals", a.rWithdrawals)
+		r.With(a.requireScope(model.ScopeBalanceRead)).Get("/withdrawals", a.rWithdrawals)
+	})
+	r.Post("/api/internal/accrual/events", a.rAccrualEvent)
+	// методы для сотрудников. Доступны только с токеном сессии
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(requireSession, a.requireRole(model.RoleSupport, model.RoleAdmin))
+		r.Get("/users/{login}", a.rAdminUser)
+		r.Post("/users/{login}/sessions/revoke", a.rAdminRevokeSessions)
+		r.Post("/unlock", a.rAdminUnlock)
+		r.With(a.requireRole(model.RoleAdmin)).Put("/users/{login}/role", a.rAdminSetRole)
+		r.Get("/accrual", a.rAdminAccrual)
+		r.Get("/dlq", a.rAdminDeadLetters)
+		r.Get("/dlq/{number}", a.rAdminDeadLetter)
+		r.Post("/dlq/{number}/requeue", a.rAdminRequeueDeadLetter)
+		r.With(a.requireRole(model.RoleAdmin)).Post("/dlq/{number}/invalidate", a.rAdminInvalidateDeadLetter)
 	})
 	return r
 }
@@ -3,20 +3,26 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/google/uuid"
 	"github.com/kTowkA/gophermart/internal/config"
+	"github.com/kTowkA/gophermart/internal/jwtkeys"
 	"github.com/kTowkA/gophermart/internal/logger"
 	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/notifier"
+	"github.com/kTowkA/gophermart/internal/policy"
 	"github.com/kTowkA/gophermart/internal/storage"
 	mocks "github.com/kTowkA/gophermart/internal/storage/mocs"
+	"github.com/kTowkA/gophermart/internal/totp"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/crypto/bcrypt"
@@ -27,6 +33,26 @@ type AppTestSuite struct {
 	suite.Suite
 	app         *AppServer
 	mockStorage *mocks.Storage
+	// revokedSessions отозванные в тестах сессии и токены
+	revokedSessions sync.Map
+	// loginAttempts неудачные попытки входа по ключам
+	loginAttempts sync.Map
+	// resetRequests запросы на сброс пароля по ключам
+	resetRequests sync.Map
+	// rehashed пересчитанные при входе хеши паролей по userID
+	rehashed sync.Map
+	// notifications отправленные пользователям уведомления
+	notifications chan notifier.Message
+	// mfaChallenges незавершенные входы со вторым фактором: хеш токена -> model.MFAChallenge
+	mfaChallenges sync.Map
+}
+
+// chanNotifier складывает уведомления в канал, чтобы тест мог их дождаться
+type chanNotifier chan notifier.Message
+
+func (n chanNotifier) Notify(_ context.Context, msg notifier.Message) error {
+	n <- msg
+	return nil
 }
 
 // Test общая структура для тестовых запросов. Не во всех тестах нужно так много полей, но это общая
@@ -46,12 +72,30 @@ func (suite *AppTestSuite) SetupSuite() {
 	suite.Require().NoError(err)
 	// создаем моки
 	mockStorage := new(mocks.Storage)
+	suite.notifications = make(chan notifier.Message, 10)
 	// создаем приложение
 	app := &AppServer{
 		storage: mockStorage,
-		config:  config.NewConfig(fmt.Sprintf(":%d", 8188), "", "", "secret"),
-		log:     mlog.WithGroup("test-file-app"),
+		// все запросы в тестах идут с одного адреса, поэтому лимит по ip делаем большим, а задержки начинаются только после блокировки логина
+		config: config.NewConfig(
+			fmt.Sprintf(":%d", 8188), "", "", "secret",
+			config.WithLoginThrottle(3, 1000, time.Minute),
+			config.WithLoginDelay(3, time.Second, time.Minute),
+			// минимальные параметры, чтобы тесты не тратили время на хеширование
+			config.WithArgon2Params(1024, 1, 1),
+			config.WithAccrualPolling("test-instance", time.Minute, 2, 10*time.Millisecond),
+		),
+		log:      mlog.WithGroup("test-file-app"),
+		keys:     jwtkeys.NewHMAC("secret"),
+		notifier: chanNotifier(suite.notifications),
 	}
+	app.policy, err = newPolicy(app.config)
+	suite.Require().NoError(err)
+	app.hasher, err = newHasher(app.config)
+	suite.Require().NoError(err)
+	suite.Require().NoError(app.initAccrual())
+	app.initPasswordResets()
+	go app.runPasswordResets(context.Background())
 	app.server = &http.Server{
 		Addr:    app.config.AddressApp(),
 		Handler: app.createRoute(),
@@ -59,6 +103,100 @@ func (suite *AppTestSuite) SetupSuite() {
 	suite.app = app
 	suite.mockStorage = mockStorage
 
+	// сессии нужны почти в каждом тесте, поэтому настраиваем их один раз для всех
+	mockStorage.On("CreateSession", mock.Anything, mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time"), mock.AnythingOfType("bool")).
+		Return(func(context.Context, uuid.UUID, string, time.Time, bool) (uuid.UUID, error) { return uuid.New(), nil })
+	mockStorage.On("SessionActive", mock.Anything, mock.Anything, mock.Anything).
+		Return(func(_ context.Context, sessionID, tokenID uuid.UUID) (bool, error) {
+			_, revokedSession := suite.revokedSessions.Load(sessionID)
+			_, revokedToken := suite.revokedSessions.Load(tokenID)
+			return !revokedSession && !revokedToken, nil
+		})
+	mockStorage.On("RevokeToken", mock.Anything, mock.Anything, mock.AnythingOfType("time.Time")).
+		Return(func(_ context.Context, tokenID uuid.UUID, _ time.Time) error {
+			suite.revokedSessions.Store(tokenID, struct{}{})
+			return nil
+		})
+
+	// большинство тестов входят с хешами bcrypt, которые пересчитываются в argon2id
+	mockStorage.On("RehashPassword", mock.Anything, mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).
+		Return(func(_ context.Context, userID uuid.UUID, _, newHash string) error {
+			suite.rehashed.Store(userID, newHash)
+			return nil
+		})
+	// неудачные попытки входа храним в памяти, повторяя логику хранилища
+	mockStorage.On("LoginAttempts", mock.Anything, mock.Anything, mock.Anything).
+		Return(func(_ context.Context, keys ...string) ([]model.LoginAttempts, error) {
+			attempts := []model.LoginAttempts{}
+			for _, key := range keys {
+				if at, ok := suite.loginAttempts.Load(key); ok {
+					attempts = append(attempts, at.(model.LoginAttempts))
+				}
+			}
+			return attempts, nil
+		})
+	mockStorage.On("RegisterLoginFailure", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time"), mock.AnythingOfType("int"), mock.AnythingOfType("time.Time")).
+		Return(func(_ context.Context, key string, windowStart time.Time, maxFailures int, lockedUntil time.Time) (model.LoginAttempts, error) {
+			at := model.LoginAttempts{Key: key}
+			if v, ok := suite.loginAttempts.Load(key); ok {
+				at = v.(model.LoginAttempts)
+			}
+			if at.LastFailureAt.Before(windowStart) {
+				at.Failures = 0
+			}
+			at.Failures++
+			at.LastFailureAt = time.Now()
+			if at.Failures >= maxFailures {
+				at.LockedUntil = lockedUntil
+			}
+			suite.loginAttempts.Store(key, at)
+			return at, nil
+		})
+	mockStorage.On("ResetLoginFailures", mock.Anything, mock.AnythingOfType("string")).
+		Return(func(_ context.Context, key string) error {
+			suite.loginAttempts.Delete(key)
+			return nil
+		})
+	mockStorage.On("RegisterPasswordResetRequest", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Return(func(_ context.Context, key string, windowStart time.Time) (model.PasswordResetRequests, error) {
+			requests := model.PasswordResetRequests{Key: key}
+			if v, ok := suite.resetRequests.Load(key); ok {
+				requests = v.(model.PasswordResetRequests)
+			}
+			if requests.WindowStartedAt.Before(windowStart) {
+				requests.Requests, requests.WindowStartedAt = 0, time.Now()
+			}
+			requests.Requests++
+			suite.resetRequests.Store(key, requests)
+			return requests, nil
+		})
+
+	// незавершенные входы со вторым фактором. Пользователь для входа запоминается в LoggedClientMFA и TestTOTP
+	mockStorage.On("CreateMFAChallenge", mock.Anything, mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Return(func(_ context.Context, userID uuid.UUID, tokenHash string, _ time.Time) error {
+			suite.mfaChallenges.Store(tokenHash, userID)
+			return nil
+		})
+	mockStorage.On("MFAChallenge", mock.Anything, mock.AnythingOfType("string")).
+		Return(func(_ context.Context, tokenHash string) (model.MFAChallenge, error) {
+			userID, ok := suite.mfaChallenges.Load(tokenHash)
+			if !ok {
+				return model.MFAChallenge{}, storage.ErrMFAChallengeNotFound
+			}
+			challenge, ok := suite.mfaChallenges.Load(userID)
+			if !ok {
+				return model.MFAChallenge{}, storage.ErrMFAChallengeNotFound
+			}
+			return challenge.(model.MFAChallenge), nil
+		})
+	mockStorage.On("DeleteMFAChallenge", mock.Anything, mock.AnythingOfType("string")).
+		Return(func(_ context.Context, tokenHash string) error {
+			if _, ok := suite.mfaChallenges.LoadAndDelete(tokenHash); !ok {
+				return storage.ErrMFAChallengeNotFound
+			}
+			return nil
+		})
+
 	// запускаем приложение
 	go func() {
 		err = suite.app.server.ListenAndServe()
@@ -83,7 +221,7 @@ func (suite *AppTestSuite) TestMiddlewareCheckOnlyAuthUser() {
 			name:           "разрешенный запрос всем пользователям",
 			path:           "/api/user/register",
 			method:         http.MethodPost,
-			body:           `{"login":"test-middleware-login","password":"1"}`,
+			body:           `{"login":"test-middleware-login","password":"Str0ng-passphrase"}`,
 			wantStatusCode: http.StatusOK,
 		},
 		{
@@ -149,11 +287,11 @@ func (suite *AppTestSuite) TestRouteRegister() {
 			wantStatusCode: http.StatusBadRequest,
 		},
 		{
-			name: "успешно",
+			name: "успешно (логин приводится к нижнему регистру)",
 			body: `
 			{
-				"login": "login-valid",
-				"password": "password"
+				"login": "Login-Valid",
+				"password": "Str0ng-passphrase"
 			}`,
 			wantStatusCode: http.StatusOK,
 		},
@@ -162,7 +300,7 @@ func (suite *AppTestSuite) TestRouteRegister() {
 			body: `
 			{
 				"login": "login-error",
-				"password": "password"
+				"password": "Str0ng-passphrase"
 			}`,
 			wantStatusCode: http.StatusInternalServerError,
 		},
@@ -171,7 +309,7 @@ func (suite *AppTestSuite) TestRouteRegister() {
 			body: `
 			{
 				"login": "login-is_used",
-				"password": "password"
+				"password": "Str0ng-passphrase"
 			}`,
 			wantStatusCode: http.StatusConflict,
 		},
@@ -192,17 +330,74 @@ func (suite *AppTestSuite) TestRouteRegister() {
 		suite.EqualValues(t.wantStatusCode, resp.StatusCode())
 	}
 }
+func (suite *AppTestSuite) TestRegisterValidation() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name      string
+		body      string
+		wantCodes []string
+	}{
+		{
+			name:      "пустые логин и пароль",
+			body:      `{"login":"","password":""}`,
+			wantCodes: []string{policy.CodeLoginRequired, policy.CodePasswordRequired},
+		},
+		{
+			name:      "слишком длинный логин",
+			body:      `{"login":"` + strings.Repeat("a", 10*1024) + `","password":"Str0ng-passphrase"}`,
+			wantCodes: []string{policy.CodeLoginTooLong},
+		},
+		{
+			name:      "недопустимые символы в логине",
+			body:      `{"login":"bad login!","password":"Str0ng-passphrase"}`,
+			wantCodes: []string{policy.CodeLoginInvalidChars},
+		},
+		{
+			name:      "пароль длиннее 72 байт",
+			body:      `{"login":"login-long-password","password":"Str0ng-` + strings.Repeat("x", 72) + `"}`,
+			wantCodes: []string{policy.CodePasswordTooLong},
+		},
+		{
+			name:      "утекший пароль",
+			body:      `{"login":"login-breached","password":"P@ssw0rd"}`,
+			wantCodes: []string{policy.CodePasswordBreached},
+		},
+		{
+			name:      "короткий и простой пароль",
+			body:      `{"login":"login-simple","password":"abc"}`,
+			wantCodes: []string{policy.CodePasswordTooShort, policy.CodePasswordTooSimple},
+		},
+	}
+	client := resty.New().
+		SetHeader("Content-type", "application/json").
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp())
+	for _, t := range tests {
+		result := model.ResponseValidationErrors{}
+		resp, err := client.R().
+			SetContext(ctx).
+			SetBody(t.body).
+			SetError(&result).
+			Post("/api/user/register")
+		suite.NoError(err, t.name)
+		suite.EqualValues(http.StatusBadRequest, resp.StatusCode(), t.name)
+		codes := []string{}
+		for _, e := range result.Errors {
+			codes = append(codes, e.Code)
+		}
+		suite.ElementsMatch(t.wantCodes, codes, t.name)
+	}
+}
 func (suite *AppTestSuite) TestRouteLogin() {
 	testPassword := "test"
 	hashTestPassword, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.DefaultCost)
 	suite.NoError(err)
-	userIDvalid, userIDnotFound := uuid.New(), uuid.New()
-	validLogin, validLoginButUserIDnotFound, loginNotFound := "login-valid", "login-valid-2", "login-not-found"
-	suite.mockStorage.On("UserID", mock.Anything, loginNotFound).Return(uuid.UUID{}, storage.ErrUserNotFound)
-	suite.mockStorage.On("UserID", mock.Anything, validLoginButUserIDnotFound).Return(userIDnotFound, nil)
-	suite.mockStorage.On("HashPassword", mock.Anything, userIDnotFound).Return("", storage.ErrUserNotFound)
-	suite.mockStorage.On("UserID", mock.Anything, validLogin).Return(userIDvalid, nil)
-	suite.mockStorage.On("HashPassword", mock.Anything, userIDvalid).Return(string(hashTestPassword), nil)
+	userIDvalid := uuid.New()
+	validLogin, loginNotFound, loginError := "login-valid", "login-not-found", "login-error"
+	suite.mockStorage.On("UserCredentials", mock.Anything, loginNotFound).Return(model.UserCredentials{}, storage.ErrUserNotFound)
+	suite.mockStorage.On("UserCredentials", mock.Anything, loginError).Return(model.UserCredentials{}, errors.New("database error"))
+	suite.mockStorage.On("UserCredentials", mock.Anything, validLogin).Return(model.UserCredentials{UserID: userIDvalid, Login: validLogin, PasswordHash: string(hashTestPassword)}, nil)
 	tests := []Test{
 		{
 			name:           "пользовать не найден (по логину)",
@@ -211,10 +406,10 @@ func (suite *AppTestSuite) TestRouteLogin() {
 			wantStatusCode: http.StatusUnauthorized,
 		},
 		{
-			name:           "пользовать не найден (по id)",
+			name:           "ошибка хранилища",
 			path:           "/api/user/login",
-			body:           `{"login":"` + validLoginButUserIDnotFound + `","password":"` + testPassword + `"}`,
-			wantStatusCode: http.StatusUnauthorized,
+			body:           `{"login":"` + loginError + `","password":"` + testPassword + `"}`,
+			wantStatusCode: http.StatusInternalServerError,
 		},
 		{
 			name:           "пользовать успешно аутентифицирован",
@@ -222,6 +417,12 @@ func (suite *AppTestSuite) TestRouteLogin() {
 			body:           `{"login":"` + validLogin + `","password":"` + testPassword + `"}`,
 			wantStatusCode: http.StatusOK,
 		},
+		{
+			name:           "логин в другом регистре",
+			path:           "/api/user/login",
+			body:           `{"login":"` + strings.ToUpper(validLogin) + `","password":"` + testPassword + `"}`,
+			wantStatusCode: http.StatusOK,
+		},
 		{
 			name:           "невалидный запрос (ошибка в JSON)",
 			path:           "/api/user/login",
@@ -321,13 +522,16 @@ func (suite *AppTestSuite) TestRouteOrdersPost() {
 
 // LoggedClient получаем авторизованного клиента
 func (suite *AppTestSuite) LoggedClient(ctx context.Context, login, password string, called string) (*resty.Client, uuid.UUID, error) {
+	return suite.LoggedClientWithRole(ctx, login, password, model.RoleUser, called)
+}
 
+// LoggedClientWithRole клиент, вошедший под пользователем с ролью role
+func (suite *AppTestSuite) LoggedClientWithRole(ctx context.Context, login, password, role string, called string) (*resty.Client, uuid.UUID, error) {
 	hashTestPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	suite.NoError(err)
 
 	userID := uuid.New()
-	suite.mockStorage.On("UserID", mock.Anything, login).Return(userID, nil)
-	suite.mockStorage.On("HashPassword", mock.Anything, userID).Return(string(hashTestPassword), nil)
+	suite.mockStorage.On("UserCredentials", mock.Anything, login).Return(model.UserCredentials{UserID: userID, Login: login, Role: role, PasswordHash: string(hashTestPassword)}, nil)
 
 	client := resty.
 		New().
@@ -342,6 +546,43 @@ func (suite *AppTestSuite) LoggedClient(ctx context.Context, login, password str
 	return client, userID, err
 }
 
+// LoggedClientMFA клиент пользователя с включенной двухфакторной аутентификацией, вошедший с кодом из приложения
+func (suite *AppTestSuite) LoggedClientMFA(ctx context.Context, login, password string, called string) (*resty.Client, uuid.UUID, error) {
+	hashTestPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	suite.NoError(err)
+	secret, err := totp.NewSecret()
+	suite.NoError(err)
+
+	userID := uuid.New()
+	suite.mockStorage.On("UserCredentials", mock.Anything, login).Return(model.UserCredentials{UserID: userID, Login: login, Role: model.RoleUser, PasswordHash: string(hashTestPassword), TOTPEnabled: true}, nil)
+	suite.mockStorage.On("TOTP", mock.Anything, userID).Return(model.TOTP{Secret: secret, Confirmed: true}, nil)
+	suite.mockStorage.On("UseTOTPStep", mock.Anything, userID, mock.AnythingOfType("int64")).Return(nil)
+	suite.mfaChallenges.Store(userID, model.MFAChallenge{UserID: userID, Login: login, Role: model.RoleUser})
+
+	client := resty.
+		New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp())
+	challenge := model.ResponseMFARequired{}
+	resp, err := client.
+		R().SetContext(ctx).
+		SetBody(`{"login":"`+login+`","password":"`+password+`"}`).
+		SetHeader("Content-type", "application/json").
+		SetResult(&challenge).
+		Post("/api/user/login")
+	suite.NoError(err, "logged client mfa", "called: "+called)
+	suite.EqualValues(http.StatusAccepted, resp.StatusCode(), "logged client mfa", "called: "+called)
+	code, err := totp.Code(secret, time.Now())
+	suite.NoError(err)
+	resp, err = client.
+		R().SetContext(ctx).
+		SetBody(model.RequestSecondFactor{MFAToken: challenge.MFAToken, Code: code}).
+		SetHeader("Content-type", "application/json").
+		Post("/api/user/login/2fa")
+	suite.NoError(err, "logged client mfa", "called: "+called)
+	suite.EqualValues(http.StatusOK, resp.StatusCode(), "logged client mfa", "called: "+called)
+	return client, userID, err
+}
+
 // RouteOrdersGetV1 первый вариант - у пользователя нет заказов
 func (suite *AppTestSuite) RouteOrdersGetV1(ctx context.Context) {
 
@@ -462,19 +703,43 @@ func (suite *AppTestSuite) TestBalance() {
 func (suite *AppTestSuite) TestWithdraw() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	client, userID, err := suite.LoggedClient(ctx, "login-withdraw", "test", "TestWithdraw")
+	// пользователь подключил TOTP уже после входа по паролю: без второго фактора списывать нельзя
+	clientNoMFA, userNoMFA, err := suite.LoggedClient(ctx, "login-withdraw-no-mfa", "test", "TestWithdraw")
+	suite.Require().NoError(err)
+	suite.mockStorage.On("TOTP", mock.Anything, userNoMFA).Return(model.TOTP{Secret: "secret", Confirmed: true}, nil)
+	resp, err := clientNoMFA.R().
+		SetBody(`{"order":"49927398716","sum":1}`).
+		SetHeader("Content-type", "application/json").
+		Post("/api/user/balance/withdraw")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusForbidden, resp.StatusCode())
+
+	// пользователь без TOTP списывает по паролю
+	clientNoTOTP, userNoTOTP, err := suite.LoggedClient(ctx, "login-withdraw-no-totp", "test", "TestWithdraw")
+	suite.Require().NoError(err)
+	reqNoTOTP := model.RequestWithdraw{OrderNumber: "49927398716", Sum: 1}
+	suite.mockStorage.On("TOTP", mock.Anything, userNoTOTP).Return(model.TOTP{}, storage.ErrTOTPNotFound)
+	suite.mockStorage.On("Withdraw", mock.Anything, userNoTOTP, reqNoTOTP).Return(nil)
+	resp, err = clientNoTOTP.R().
+		SetBody(reqNoTOTP).
+		SetHeader("Content-type", "application/json").
+		Post("/api/user/balance/withdraw")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+
+	client, userID, err := suite.LoggedClientMFA(ctx, "login-withdraw", "test", "TestWithdraw")
 	suite.Require().NoError(err)
 	reqNotEnough := model.RequestWithdraw{
 		OrderNumber: "49927398716",
-		Sum:         1111.11,
+		Sum:         111111,
 	}
 	reqErr := model.RequestWithdraw{
 		OrderNumber: "49927398716",
-		Sum:         666.666,
+		Sum:         66666,
 	}
 	reqOK := model.RequestWithdraw{
 		OrderNumber: "49927398716",
-		Sum:         111.11,
+		Sum:         11111,
 	}
 	suite.mockStorage.On("Withdraw", mock.Anything, userID, reqNotEnough).Return(storage.ErrWithdrawNotEnough)
 	suite.mockStorage.On("Withdraw", mock.Anything, userID, reqErr).Return(errors.New("withdraw error"))
@@ -495,7 +760,25 @@ func (suite *AppTestSuite) TestWithdraw() {
 			name:           "неверный номер запроса",
 			contentType:    "application/json",
 			wantStatusCode: http.StatusUnprocessableEntity,
-			body:           `{"order":"123","sum":123.123}`,
+			body:           `{"order":"123","sum":123.12}`,
+		},
+		{
+			name:           "сумма точнее сотых",
+			contentType:    "application/json",
+			wantStatusCode: http.StatusBadRequest,
+			body:           `{"order":"49927398716","sum":1.005}`,
+		},
+		{
+			name:           "отрицательная сумма",
+			contentType:    "application/json",
+			wantStatusCode: http.StatusBadRequest,
+			body:           `{"order":"49927398716","sum":-1}`,
+		},
+		{
+			name:           "нулевая сумма",
+			contentType:    "application/json",
+			wantStatusCode: http.StatusBadRequest,
+			body:           `{"order":"49927398716","sum":0}`,
 		},
 		{
 			name:           "недостаточно средств на балансе",
@@ -548,12 +831,12 @@ func (suite *AppTestSuite) TestWithdrawals() {
 	returnValue := model.ResponseWithdrawals{
 		{
 			OrderNumber: "111",
-			Sum:         111.11,
+			Sum:         11111,
 			ProcessedAt: time1,
 		},
 		{
 			OrderNumber: "222",
-			Sum:         222.22,
+			Sum:         22222,
 			ProcessedAt: time2,
 		},
 	}
@@ -589,6 +872,939 @@ func (suite *AppTestSuite) TestWithdrawals() {
 	suite.EqualValues(http.StatusInternalServerError, resp.StatusCode())
 	suite.EqualValues(model.ResponseWithdrawals{}, result)
 }
+func (suite *AppTestSuite) TestRefreshAndLogout() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, userID, err := suite.LoggedClient(ctx, "login-refresh", "test", "TestRefreshAndLogout")
+	suite.Require().NoError(err)
+
+	// без refresh токена обновиться нельзя
+	resp, err := resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		R().SetContext(ctx).
+		Post("/api/user/token/refresh")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode())
+
+	// неизвестный refresh токен
+	suite.mockStorage.On("RotateSession", mock.Anything, hashToken("unknown"), mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Return(model.Session{}, storage.ErrSessionNotFound)
+	resp, err = resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		SetCookie(&http.Cookie{Name: suite.app.config.CookieRefreshTokenName(), Value: "unknown"}).
+		R().SetContext(ctx).
+		Post("/api/user/token/refresh")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode())
+
+	// обновляем токены по выданному при входе refresh токену
+	sessionID := uuid.New()
+	suite.mockStorage.On("RotateSession", mock.Anything, mock.MatchedBy(func(h string) bool { return h != hashToken("unknown") }), mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Return(model.Session{SessionID: sessionID, UserID: userID, Login: "login-refresh"}, nil)
+	resp, err = client.R().SetContext(ctx).Post("/api/user/token/refresh")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	cookies := make(map[string]string)
+	for _, c := range resp.Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	suite.NotEmpty(cookies[suite.app.config.CookieTokenName()])
+	suite.NotEmpty(cookies[suite.app.config.CookieRefreshTokenName()])
+
+	// выходим - сессия отзывается и токен больше не принимается
+	suite.mockStorage.On("RevokeSession", mock.Anything, sessionID).
+		Return(func(_ context.Context, sessionID uuid.UUID) error {
+			suite.revokedSessions.Store(sessionID, struct{}{})
+			return nil
+		})
+	resp, err = resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		SetCookie(&http.Cookie{Name: suite.app.config.CookieTokenName(), Value: cookies[suite.app.config.CookieTokenName()]}).
+		R().SetContext(ctx).
+		Post("/api/user/logout")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	resp, err = resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		SetCookie(&http.Cookie{Name: suite.app.config.CookieTokenName(), Value: cookies[suite.app.config.CookieTokenName()]}).
+		R().SetContext(ctx).
+		Get("/api/user/orders")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode())
+}
+func (suite *AppTestSuite) TestBearerAuth() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hashTestPassword, err := bcrypt.GenerateFromPassword([]byte("test"), bcrypt.DefaultCost)
+	suite.Require().NoError(err)
+	userID := uuid.New()
+	suite.mockStorage.On("UserCredentials", mock.Anything, "login-bearer").Return(model.UserCredentials{UserID: userID, Login: "login-bearer", PasswordHash: string(hashTestPassword)}, nil)
+	suite.mockStorage.On("Balance", mock.Anything, userID).Return(model.ResponseBalance{Current: 1}, nil)
+
+	// токены приходят в теле ответа, а куки выставлены с нужными атрибутами
+	tokens := model.ResponseToken{}
+	resp, err := resty.New().
+		SetBaseURL("http://localhost"+suite.app.config.AddressApp()).
+		R().SetContext(ctx).
+		SetHeader("Content-type", "application/json").
+		SetBody(`{"login":"login-bearer","password":"test"}`).
+		SetResult(&tokens).
+		Post("/api/user/login")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.NotEmpty(tokens.AccessToken)
+	suite.NotEmpty(tokens.RefreshToken)
+	suite.EqualValues("Bearer", tokens.TokenType)
+	suite.EqualValues(suite.app.config.AccessTokenTTL().Seconds(), tokens.ExpiresIn)
+	for _, c := range resp.Cookies() {
+		suite.True(c.HttpOnly, c.Name)
+		suite.EqualValues(suite.app.config.CookiePath(), c.Path, c.Name)
+		suite.EqualValues(http.SameSiteLaxMode, c.SameSite, c.Name)
+		suite.Positive(c.MaxAge, c.Name)
+	}
+
+	tests := []struct {
+		name           string
+		authorization  string
+		wantStatusCode int
+	}{
+		{
+			name:           "токен в заголовке",
+			authorization:  "Bearer " + tokens.AccessToken,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "схема в другом регистре",
+			authorization:  "bearer " + tokens.AccessToken,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "другая схема",
+			authorization:  "Basic " + tokens.AccessToken,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "пустой токен",
+			authorization:  "Bearer ",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "невалидный токен",
+			authorization:  "Bearer " + tokens.AccessToken + "x",
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+	for _, t := range tests {
+		resp, err := resty.New().
+			SetBaseURL("http://localhost"+suite.app.config.AddressApp()).
+			R().SetContext(ctx).
+			SetHeader("Authorization", t.authorization).
+			Get("/api/user/balance")
+		suite.NoError(err, t.name)
+		suite.EqualValues(t.wantStatusCode, resp.StatusCode(), t.name)
+	}
+
+	// обновление по refresh токену из тела запроса
+	suite.mockStorage.On("RotateSession", mock.Anything, hashToken(tokens.RefreshToken), mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Return(model.Session{SessionID: uuid.New(), UserID: userID, Login: "login-bearer"}, nil).Once()
+	refreshed := model.ResponseToken{}
+	resp, err = resty.New().
+		SetBaseURL("http://localhost"+suite.app.config.AddressApp()).
+		R().SetContext(ctx).
+		SetHeader("Content-type", "application/json").
+		SetBody(model.RequestRefreshToken{RefreshToken: tokens.RefreshToken}).
+		SetResult(&refreshed).
+		Post("/api/user/token/refresh")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.NotEmpty(refreshed.AccessToken)
+	suite.NotEqualValues(tokens.RefreshToken, refreshed.RefreshToken)
+}
+func (suite *AppTestSuite) TestRevokeToken() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// токены выпущенные для другого окружения не принимаются даже с верным ключом
+	staging := config.NewConfig("", "", "", "secret", config.WithJWTAudience("gophermart-staging"))
+	token, err := buildJWTString(UserClaims{UserID: uuid.New(), SessionID: uuid.New()}, suite.app.keys, staging, time.Hour)
+	suite.Require().NoError(err)
+	resp, err := resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		R().SetContext(ctx).
+		SetAuthToken(token).
+		Get("/api/user/orders")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode())
+
+	// отозванный токен больше не принимается
+	token, err = buildJWTString(UserClaims{UserID: uuid.New(), SessionID: uuid.New()}, suite.app.keys, suite.app.config, time.Hour)
+	suite.Require().NoError(err)
+	client := resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		SetAuthToken(token)
+	resp, err = client.R().SetContext(ctx).Post("/api/user/token/revoke")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	resp, err = client.R().SetContext(ctx).Post("/api/user/token/revoke")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode())
+}
+func (suite *AppTestSuite) TestJWKS() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// при подписи общим секретом ключи не публикуются, но эндпоинт доступен без авторизации
+	jwks := jwtkeys.JWKS{}
+	resp, err := resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		R().SetContext(ctx).
+		SetResult(&jwks).
+		Get("/.well-known/jwks.json")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.Empty(jwks.Keys)
+	suite.NotNil(jwks.Keys)
+}
+func (suite *AppTestSuite) TestLoginLockout() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hashTestPassword, err := bcrypt.GenerateFromPassword([]byte("test"), bcrypt.DefaultCost)
+	suite.Require().NoError(err)
+	suite.mockStorage.On("UserCredentials", mock.Anything, "login-lockout").Return(model.UserCredentials{UserID: uuid.New(), Login: "login-lockout", PasswordHash: string(hashTestPassword)}, nil)
+	suite.mockStorage.On("UserCredentials", mock.Anything, "login-lockout-unknown").Return(model.UserCredentials{}, storage.ErrUserNotFound)
+
+	login := func(login, password string) *resty.Response {
+		resp, err := resty.New().
+			SetBaseURL("http://localhost"+suite.app.config.AddressApp()).
+			R().SetContext(ctx).
+			SetHeader("Content-type", "application/json").
+			SetBody(`{"login":"` + login + `","password":"` + password + `"}`).
+			Post("/api/user/login")
+		suite.Require().NoError(err)
+		return resp
+	}
+
+	// существующий и несуществующий логины ведут себя одинаково: после 3 неудач вход блокируется даже с верным паролем
+	for _, l := range []string{"login-lockout", "login-lockout-unknown"} {
+		for i := 0; i < 3; i++ {
+			suite.EqualValues(http.StatusUnauthorized, login(l, "wrong").StatusCode(), l)
+		}
+		resp := login(l, "test")
+		suite.EqualValues(http.StatusTooManyRequests, resp.StatusCode(), l)
+		suite.Equal("60", resp.Header().Get("Retry-After"), l)
+	}
+
+	// после разблокировки можно войти, а счетчик логина сбрасывается
+	suite.loginAttempts.Delete(model.LoginAttemptsLoginKey("login-lockout"))
+	suite.EqualValues(http.StatusOK, login("login-lockout", "test").StatusCode())
+	_, ok := suite.loginAttempts.Load(model.LoginAttemptsLoginKey("login-lockout"))
+	suite.False(ok)
+	// а счетчик ip адреса остается
+	_, ok = suite.loginAttempts.Load(model.LoginAttemptsIPKey("127.0.0.1"))
+	suite.True(ok)
+}
+
+func (suite *AppTestSuite) TestChangePassword() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	hashTestPassword, err := bcrypt.GenerateFromPassword([]byte("test"), bcrypt.DefaultCost)
+	suite.Require().NoError(err)
+	userID := uuid.New()
+	suite.mockStorage.On("UserCredentials", mock.Anything, "login-change-password").Return(model.UserCredentials{UserID: userID, Login: "login-change-password", PasswordHash: string(hashTestPassword)}, nil)
+	suite.mockStorage.On("HashPassword", mock.Anything, userID).Return(string(hashTestPassword), nil)
+
+	// сессия, в которой пользователь вошел
+	loginTokens := model.ResponseToken{}
+	resp, err := resty.New().
+		SetBaseURL("http://localhost"+suite.app.config.AddressApp()).
+		R().SetContext(ctx).
+		SetHeader("Content-type", "application/json").
+		SetBody(`{"login":"login-change-password","password":"test"}`).
+		SetResult(&loginTokens).
+		Post("/api/user/login")
+	suite.Require().NoError(err)
+	suite.Require().EqualValues(http.StatusOK, resp.StatusCode())
+	oldToken := loginTokens.AccessToken
+	uc, err := getUserClaimsFromToken(oldToken, suite.app.keys, suite.app.config)
+	suite.Require().NoError(err)
+	client := resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		SetAuthToken(oldToken)
+
+	tests := []Test{
+		{
+			name:           "пустой новый пароль",
+			body:           `{"old_password":"test","new_password":""}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "неверный старый пароль",
+			body:           `{"old_password":"wrong","new_password":"new-password"}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+	for _, t := range tests {
+		resp, err := client.R().SetContext(ctx).
+			SetHeader("Content-type", "application/json").
+			SetBody(t.body).
+			Post("/api/user/password")
+		suite.NoError(err, t.name)
+		suite.EqualValues(t.wantStatusCode, resp.StatusCode(), t.name)
+	}
+
+	// смена пароля отзывает все сессии пользователя и выдает токены новой
+	suite.mockStorage.On("ChangePassword", mock.Anything, userID, mock.MatchedBy(func(hash string) bool {
+		match, _, _ := suite.app.hasher.Verify(context.Background(), "new-password", hash)
+		return match
+	})).
+		Return(func(context.Context, uuid.UUID, string) error {
+			suite.revokedSessions.Store(uc.SessionID, struct{}{})
+			return nil
+		})
+	tokens := model.ResponseToken{}
+	resp, err = client.R().SetContext(ctx).
+		SetHeader("Content-type", "application/json").
+		SetBody(`{"old_password":"test","new_password":"new-password"}`).
+		SetResult(&tokens).
+		Post("/api/user/password")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.NotEmpty(tokens.AccessToken)
+
+	suite.mockStorage.On("Orders", mock.Anything, userID).Return(nil, storage.ErrOrdersNotFound)
+	resp, err = resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		R().SetContext(ctx).
+		SetAuthToken(oldToken).
+		Get("/api/user/orders")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode())
+	resp, err = resty.New().
+		SetBaseURL("http://localhost" + suite.app.config.AddressApp()).
+		R().SetContext(ctx).
+		SetAuthToken(tokens.AccessToken).
+		Get("/api/user/orders")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusNoContent, resp.StatusCode())
+}
+func (suite *AppTestSuite) TestPasswordReset() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	userID := uuid.New()
+	suite.mockStorage.On("UserID", mock.Anything, "login-reset").Return(userID, nil)
+	suite.mockStorage.On("UserID", mock.Anything, "login-reset-unknown").Return(uuid.UUID{}, storage.ErrUserNotFound)
+	var tokenHash string
+	suite.mockStorage.On("CreatePasswordReset", mock.Anything, userID, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Return(func(_ context.Context, _ uuid.UUID, hash string, _ time.Time) error {
+			tokenHash = hash
+			return nil
+		}).Once()
+
+	client := resty.New().
+		SetBaseURL("http://localhost"+suite.app.config.AddressApp()).
+		SetHeader("Content-type", "application/json")
+
+	// для существующего и несуществующего логина ответ одинаковый, но уведомление уходит только существующему
+	for _, login := range []string{"login-reset-unknown", "login-reset"} {
+		resp, err := client.R().SetContext(ctx).
+			SetBody(`{"login":"` + login + `"}`).
+			Post("/api/user/password/reset")
+		suite.NoError(err)
+		suite.EqualValues(http.StatusAccepted, resp.StatusCode(), login)
+	}
+	var msg notifier.Message
+	select {
+	case msg = <-suite.notifications:
+	case <-ctx.Done():
+		suite.FailNow("уведомление не было отправлено")
+	}
+	suite.Equal("login-reset", msg.To)
+	token := ""
+	for _, f := range strings.Fields(msg.Body) {
+		if hashToken(f) == tokenHash {
+			token = f
+		}
+	}
+	suite.Require().NotEmpty(token, "в уведомлении нет токена")
+
+	// частые запросы для одного логина отклоняются, при этом лишние уведомления не отправляются
+	suite.mockStorage.On("UserID", mock.Anything, "login-reset-flood").Return(uuid.UUID{}, storage.ErrUserNotFound)
+	for i := 0; i < suite.app.config.PasswordResetMaxRequests()+2; i++ {
+		resp, err := client.R().SetContext(ctx).
+			SetBody(`{"login":"login-reset-flood"}`).
+			Post("/api/user/password/reset")
+		suite.NoError(err)
+		if i < suite.app.config.PasswordResetMaxRequests() {
+			suite.EqualValues(http.StatusAccepted, resp.StatusCode(), i)
+			continue
+		}
+		suite.EqualValues(http.StatusTooManyRequests, resp.StatusCode(), i)
+		suite.NotEmpty(resp.Header().Get("Retry-After"))
+	}
+	// запросы на сброс не считаются неудачными попытками входа
+	_, ok := suite.loginAttempts.Load(model.LoginAttemptsLoginKey("login-reset-flood"))
+	suite.False(ok)
+
+	// токен одноразовый
+	suite.mockStorage.On("RedeemPasswordReset", mock.Anything, tokenHash, mock.AnythingOfType("string")).Return(userID, nil).Once()
+	suite.mockStorage.On("RedeemPasswordReset", mock.Anything, tokenHash, mock.AnythingOfType("string")).Return(uuid.UUID{}, storage.ErrPasswordResetNotFound)
+	tests := []Test{
+		{
+			name:           "нет нового пароля",
+			body:           `{"token":"` + token + `"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "успешный сброс",
+			body:           `{"token":"` + token + `","new_password":"new-password"}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "повторное использование токена",
+			body:           `{"token":"` + token + `","new_password":"new-password"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, t := range tests {
+		resp, err := client.R().SetContext(ctx).
+			SetBody(t.body).
+			Post("/api/user/password/reset/confirm")
+		suite.NoError(err, t.name)
+		suite.EqualValues(t.wantStatusCode, resp.StatusCode(), t.name)
+	}
+}
+func (suite *AppTestSuite) TestRehashOnLogin() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("test"), bcrypt.MinCost)
+	suite.Require().NoError(err)
+	currentHash, err := suite.app.hasher.Hash(context.Background(), "test")
+	suite.Require().NoError(err)
+	userLegacy, userCurrent := uuid.New(), uuid.New()
+	suite.mockStorage.On("UserCredentials", mock.Anything, "login-rehash-legacy").Return(model.UserCredentials{UserID: userLegacy, Login: "login-rehash-legacy", PasswordHash: string(bcryptHash)}, nil)
+	suite.mockStorage.On("UserCredentials", mock.Anything, "login-rehash-current").Return(model.UserCredentials{UserID: userCurrent, Login: "login-rehash-current", PasswordHash: currentHash}, nil)
+
+	client := resty.New().
+		SetBaseURL("http://localhost"+suite.app.config.AddressApp()).
+		SetHeader("Content-type", "application/json")
+	for _, login := range []string{"login-rehash-legacy", "login-rehash-current"} {
+		resp, err := client.R().SetContext(ctx).
+			SetBody(`{"login":"` + login + `","password":"test"}`).
+			Post("/api/user/login")
+		suite.NoError(err)
+		suite.EqualValues(http.StatusOK, resp.StatusCode(), login)
+	}
+
+	// хеш bcrypt пересчитан в argon2id
+	newHash, ok := suite.rehashed.Load(userLegacy)
+	suite.Require().True(ok)
+	suite.True(strings.HasPrefix(newHash.(string), "$argon2id$"))
+	match, needsRehash, err := suite.app.hasher.Verify(context.Background(), "test", newHash.(string))
+	suite.NoError(err)
+	suite.True(match)
+	suite.False(needsRehash)
+
+	// актуальный хеш не трогаем
+	_, ok = suite.rehashed.Load(userCurrent)
+	suite.False(ok)
+}
+func (suite *AppTestSuite) TestAPIKeys() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, userID, err := suite.LoggedClient(ctx, "login-api-keys", "test", "TestAPIKeys")
+	suite.Require().NoError(err)
+
+	// неизвестная область действия
+	validation := model.ResponseValidationErrors{}
+	resp, err := client.R().SetContext(ctx).
+		SetHeader("Content-type", "application/json").
+		SetBody(`{"name":"partner","scopes":["orders:write","orders:delete"]}`).
+		SetError(&validation).
+		Post("/api/user/api-keys")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusBadRequest, resp.StatusCode())
+	suite.Require().Len(validation.Errors, 1)
+	suite.Equal(policy.CodeScopeUnknown, validation.Errors[0].Code)
+
+	// выпускаем ключ только на загрузку заказов и ключ без списка областей действия
+	keyHashes := make(map[string]string)
+	suite.mockStorage.On("CreateAPIKey", mock.Anything, userID, mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.Anything).
+		Return(func(_ context.Context, _ uuid.UUID, name, keyHash, prefix string, scopes []string) (model.APIKey, error) {
+			keyHashes[name] = keyHash
+			return model.APIKey{KeyID: uuid.New(), Name: name, Prefix: prefix, Scopes: scopes}, nil
+		})
+	keys := make(map[string]string)
+	for name, body := range map[string]string{
+		"orders":   `{"name":"orders","scopes":["orders:write"]}`,
+		"unscoped": `{"name":"unscoped"}`,
+	} {
+		created := model.ResponseAPIKeyCreated{}
+		resp, err = client.R().SetContext(ctx).
+			SetHeader("Content-type", "application/json").
+			SetBody(body).
+			SetResult(&created).
+			Post("/api/user/api-keys")
+		suite.NoError(err)
+		suite.EqualValues(http.StatusCreated, resp.StatusCode(), name)
+		suite.True(strings.HasPrefix(created.Key, "gm_"), name)
+		suite.True(strings.HasPrefix(created.Key, created.Prefix), name)
+		suite.Equal(hashToken(created.Key), keyHashes[name], name)
+		keys[name] = created.Key
+	}
+	suite.mockStorage.On("APIKeyOwner", mock.Anything, keyHashes["orders"]).
+		Return(model.APIKeyOwner{KeyID: uuid.New(), UserID: userID, Login: "login-api-keys", Scopes: []string{model.ScopeOrdersWrite}}, nil)
+	suite.mockStorage.On("APIKeyOwner", mock.Anything, keyHashes["unscoped"]).
+		Return(model.APIKeyOwner{KeyID: uuid.New(), UserID: userID, Login: "login-api-keys", Scopes: []string{}}, nil)
+	suite.mockStorage.On("APIKeyOwner", mock.Anything, hashToken("gm_unknown")).
+		Return(model.APIKeyOwner{}, storage.ErrAPIKeyNotFound)
+	suite.mockStorage.On("SaveOrder", mock.Anything, userID, model.OrderNumber("79927398713")).
+		Return(storage.ErrorWithHTTPStatus{StorageError: nil, HTTPStatus: http.StatusAccepted})
+	suite.mockStorage.On("Balance", mock.Anything, userID).Return(model.ResponseBalance{Current: 1}, nil)
+
+	tests := []struct {
+		name           string
+		key            string
+		method         string
+		path           string
+		contentType    string
+		body           string
+		wantStatusCode int
+	}{
+		{name: "неизвестный ключ", key: "gm_unknown", method: http.MethodGet, path: "/api/user/balance", wantStatusCode: http.StatusUnauthorized},
+		{name: "загрузка заказа", key: keys["orders"], method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", body: "79927398713", wantStatusCode: http.StatusAccepted},
+		{name: "нет области действия", key: keys["orders"], method: http.MethodGet, path: "/api/user/balance", wantStatusCode: http.StatusForbidden},
+		{name: "ключ без областей действия. чтение", key: keys["unscoped"], method: http.MethodGet, path: "/api/user/balance", wantStatusCode: http.StatusOK},
+		{name: "ключ без областей действия. загрузка заказа", key: keys["unscoped"], method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", body: "79927398713", wantStatusCode: http.StatusForbidden},
+		{name: "ключ без областей действия. списание", key: keys["unscoped"], method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"49927398716","sum":1}`, wantStatusCode: http.StatusForbidden},
+		{name: "ключом нельзя выпускать ключи", key: keys["unscoped"], method: http.MethodGet, path: "/api/user/api-keys", wantStatusCode: http.StatusForbidden},
+		{name: "ключом нельзя менять пароль", key: keys["unscoped"], method: http.MethodPost, path: "/api/user/password", contentType: "application/json", body: `{}`, wantStatusCode: http.StatusForbidden},
+	}
+	for _, t := range tests {
+		resp, err := resty.New().
+			SetBaseURL("http://localhost"+suite.app.config.AddressApp()).
+			R().SetContext(ctx).
+			SetHeader("X-API-Key", t.key).
+			SetHeader("Content-type", t.contentType).
+			SetBody(t.body).
+			Execute(t.method, t.path)
+		suite.NoError(err, t.name)
+		suite.EqualValues(t.wantStatusCode, resp.StatusCode(), t.name)
+	}
+
+	// список и отзыв ключей
+	keyID := uuid.New()
+	suite.mockStorage.On("APIKeys", mock.Anything, userID).Return([]model.APIKey{{KeyID: keyID, Name: "orders"}}, nil)
+	list := []model.APIKey{}
+	resp, err = client.R().SetContext(ctx).SetResult(&list).Get("/api/user/api-keys")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.Require().Len(list, 1)
+	suite.Equal(keyID, list[0].KeyID)
+
+	suite.mockStorage.On("RevokeAPIKey", mock.Anything, userID, keyID).Return(nil)
+	suite.mockStorage.On("RevokeAPIKey", mock.Anything, userID, mock.Anything).Return(storage.ErrAPIKeyNotFound)
+	for path, status := range map[string]int{
+		"/api/user/api-keys/" + keyID.String():   http.StatusNoContent,
+		"/api/user/api-keys/" + uuid.NewString(): http.StatusNotFound,
+		"/api/user/api-keys/not-uuid":            http.StatusBadRequest,
+	} {
+		resp, err = client.R().SetContext(ctx).Delete(path)
+		suite.NoError(err)
+		suite.EqualValues(status, resp.StatusCode(), path)
+	}
+}
+func (suite *AppTestSuite) TestAdmin() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	user, _, err := suite.LoggedClientWithRole(ctx, "login-admin-user", "test", model.RoleUser, "TestAdmin")
+	suite.Require().NoError(err)
+	support, _, err := suite.LoggedClientWithRole(ctx, "login-admin-support", "test", model.RoleSupport, "TestAdmin")
+	suite.Require().NoError(err)
+	admin, adminID, err := suite.LoggedClientWithRole(ctx, "login-admin-admin", "test", model.RoleAdmin, "TestAdmin")
+	suite.Require().NoError(err)
+
+	targetID := uuid.New()
+	suite.mockStorage.On("User", mock.Anything, "login-admin-target").Return(model.User{UserID: targetID, Login: "login-admin-target", Role: model.RoleUser}, nil)
+	suite.mockStorage.On("User", mock.Anything, "login-admin-unknown").Return(model.User{}, storage.ErrUserNotFound)
+	suite.mockStorage.On("UserID", mock.Anything, "login-admin-target").Return(targetID, nil)
+	suite.mockStorage.On("RevokeUserSessions", mock.Anything, targetID).Return(nil)
+	suite.mockStorage.On("SetUserRole", mock.Anything, "login-admin-target", model.RoleSupport).Return(nil)
+	suite.mockStorage.On("SetUserRole", mock.Anything, "login-admin-unknown", model.RoleSupport).Return(storage.ErrUserNotFound)
+	// админ, вошедший с api ключом
+	suite.mockStorage.On("APIKeyOwner", mock.Anything, hashToken("gm_admin")).
+		Return(model.APIKeyOwner{KeyID: uuid.New(), UserID: adminID, Login: "login-admin-admin", Role: model.RoleAdmin}, nil)
+	apiKey := resty.New().SetBaseURL("http://localhost"+suite.app.config.AddressApp()).SetHeader("X-API-Key", "gm_admin")
+
+	tests := []struct {
+		name           string
+		client         *resty.Client
+		method         string
+		path           string
+		body           string
+		wantStatusCode int
+	}{
+		{name: "пользователю недоступно", client: user, method: http.MethodGet, path: "/api/admin/users/login-admin-target", wantStatusCode: http.StatusForbidden},
+		{name: "api ключу недоступно", client: apiKey, method: http.MethodGet, path: "/api/admin/users/login-admin-target", wantStatusCode: http.StatusForbidden},
+		{name: "поддержка. пользователь", client: support, method: http.MethodGet, path: "/api/admin/users/Login-Admin-Target", wantStatusCode: http.StatusOK},
+		{name: "поддержка. неизвестный пользователь", client: support, method: http.MethodGet, path: "/api/admin/users/login-admin-unknown", wantStatusCode: http.StatusNotFound},
+		{name: "поддержка. отзыв сессий", client: support, method: http.MethodPost, path: "/api/admin/users/login-admin-target/sessions/revoke", wantStatusCode: http.StatusNoContent},
+		{name: "поддержка. разблокировка без логина и ip", client: support, method: http.MethodPost, path: "/api/admin/unlock", body: `{}`, wantStatusCode: http.StatusBadRequest},
+		{name: "поддержка. назначение роли", client: support, method: http.MethodPut, path: "/api/admin/users/login-admin-target/role", body: `{"role":"support"}`, wantStatusCode: http.StatusForbidden},
+		{name: "админ. назначение роли", client: admin, method: http.MethodPut, path: "/api/admin/users/login-admin-target/role", body: `{"role":"support"}`, wantStatusCode: http.StatusNoContent},
+		{name: "админ. неизвестная роль", client: admin, method: http.MethodPut, path: "/api/admin/users/login-admin-target/role", body: `{"role":"root"}`, wantStatusCode: http.StatusBadRequest},
+		{name: "админ. неизвестный пользователь", client: admin, method: http.MethodPut, path: "/api/admin/users/login-admin-unknown/role", body: `{"role":"support"}`, wantStatusCode: http.StatusNotFound},
+		{name: "админ. своя роль", client: admin, method: http.MethodPut, path: "/api/admin/users/login-admin-admin/role", body: `{"role":"user"}`, wantStatusCode: http.StatusConflict},
+	}
+	for _, t := range tests {
+		resp, err := t.client.R().SetContext(ctx).
+			SetHeader("Content-type", "application/json").
+			SetBody(t.body).
+			Execute(t.method, t.path)
+		suite.NoError(err, t.name)
+		suite.EqualValues(t.wantStatusCode, resp.StatusCode(), t.name)
+	}
+
+	// снятие блокировки входа
+	key := model.LoginAttemptsLoginKey("login-admin-locked")
+	suite.loginAttempts.Store(key, model.LoginAttempts{Key: key, Failures: 10, LockedUntil: time.Now().Add(time.Hour)})
+	resp, err := support.R().SetContext(ctx).
+		SetHeader("Content-type", "application/json").
+		SetBody(`{"login":"Login-Admin-Locked"}`).
+		Post("/api/admin/unlock")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusNoContent, resp.StatusCode())
+	_, locked := suite.loginAttempts.Load(key)
+	suite.False(locked)
+}
+func (suite *AppTestSuite) TestAdminDeadLetters() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	user, _, err := suite.LoggedClientWithRole(ctx, "login-dlq-user", "test", model.RoleUser, "TestAdminDeadLetters")
+	suite.Require().NoError(err)
+	support, _, err := suite.LoggedClientWithRole(ctx, "login-dlq-support", "test", model.RoleSupport, "TestAdminDeadLetters")
+	suite.Require().NoError(err)
+	admin, _, err := suite.LoggedClientWithRole(ctx, "login-dlq-admin", "test", model.RoleAdmin, "TestAdminDeadLetters")
+	suite.Require().NoError(err)
+
+	dl := model.DeadLetter{
+		OrderNumber:    "12345678903",
+		Login:          "login-dlq-user",
+		Status:         "NEW",
+		Attempts:       30,
+		LastError:      "заказ не зарегистрирован в системе расчета",
+		LastHTTPStatus: http.StatusNoContent,
+		UploadedAt:     time.Now().Add(-time.Hour).UTC().Truncate(time.Second),
+		DeadLetteredAt: time.Now().UTC().Truncate(time.Second),
+	}
+	suite.mockStorage.On("DeadLetters", mock.Anything, 100, 0).Return([]model.DeadLetter{dl}, nil).Once()
+	suite.mockStorage.On("DeadLetters", mock.Anything, 10, 20).Return(nil, storage.ErrOrdersNotFound).Once()
+	suite.mockStorage.On("DeadLetter", mock.Anything, dl.OrderNumber).Return(dl, nil).Once()
+	suite.mockStorage.On("DeadLetter", mock.Anything, model.OrderNumber("79927398713")).Return(model.DeadLetter{}, storage.ErrOrdersNotFound).Once()
+	suite.mockStorage.On("RequeueDeadLetter", mock.Anything, dl.OrderNumber).Return(nil).Once()
+	suite.mockStorage.On("RequeueDeadLetter", mock.Anything, model.OrderNumber("79927398713")).Return(storage.ErrOrdersNotFound).Once()
+	suite.mockStorage.On("InvalidateDeadLetter", mock.Anything, dl.OrderNumber).Return(nil).Once()
+
+	tests := []struct {
+		name           string
+		client         *resty.Client
+		method         string
+		path           string
+		wantStatusCode int
+	}{
+		{name: "пользователю недоступно", client: user, method: http.MethodGet, path: "/api/admin/dlq", wantStatusCode: http.StatusForbidden},
+		{name: "поддержка. пустая страница", client: support, method: http.MethodGet, path: "/api/admin/dlq?limit=10&offset=20", wantStatusCode: http.StatusNoContent},
+		{name: "поддержка. неверный лимит", client: support, method: http.MethodGet, path: "/api/admin/dlq?limit=0", wantStatusCode: http.StatusBadRequest},
+		{name: "поддержка. слишком большой лимит", client: support, method: http.MethodGet, path: "/api/admin/dlq?limit=100000", wantStatusCode: http.StatusBadRequest},
+		{name: "поддержка. неверное смещение", client: support, method: http.MethodGet, path: "/api/admin/dlq?offset=-1", wantStatusCode: http.StatusBadRequest},
+		{name: "поддержка. заказа нет в очереди", client: support, method: http.MethodGet, path: "/api/admin/dlq/79927398713", wantStatusCode: http.StatusNotFound},
+		{name: "поддержка. возврат на проверку", client: support, method: http.MethodPost, path: "/api/admin/dlq/12345678903/requeue", wantStatusCode: http.StatusNoContent},
+		{name: "поддержка. возврат заказа не из очереди", client: support, method: http.MethodPost, path: "/api/admin/dlq/79927398713/requeue", wantStatusCode: http.StatusNotFound},
+		{name: "поддержка. перевод в INVALID", client: support, method: http.MethodPost, path: "/api/admin/dlq/12345678903/invalidate", wantStatusCode: http.StatusForbidden},
+		{name: "админ. перевод в INVALID", client: admin, method: http.MethodPost, path: "/api/admin/dlq/12345678903/invalidate", wantStatusCode: http.StatusNoContent},
+	}
+	for _, t := range tests {
+		resp, err := t.client.R().SetContext(ctx).Execute(t.method, t.path)
+		suite.NoError(err, t.name)
+		suite.EqualValues(t.wantStatusCode, resp.StatusCode(), t.name)
+	}
+
+	// список и отдельный заказ с последней ошибкой и числом попыток
+	list := []model.DeadLetter{}
+	resp, err := support.R().SetContext(ctx).SetResult(&list).Get("/api/admin/dlq")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.Equal([]model.DeadLetter{dl}, list)
+
+	got := model.DeadLetter{}
+	resp, err = support.R().SetContext(ctx).SetResult(&got).Get("/api/admin/dlq/12345678903")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.Equal(dl, got)
+
+	// состояние опроса системы расчета
+	state := model.ResponseAccrualState{}
+	resp, err = support.R().SetContext(ctx).SetResult(&state).Get("/api/admin/accrual")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.Equal("test-instance", state.Instance)
+	suite.Equal("closed", state.Breaker)
+	suite.Nil(state.BreakerOpenUntil)
+	suite.Positive(state.RateLimit)
+}
+func (suite *AppTestSuite) TestTOTP() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	const login = "login-totp"
+	hashTestPassword, err := bcrypt.GenerateFromPassword([]byte("test"), bcrypt.DefaultCost)
+	suite.Require().NoError(err)
+	userID := uuid.New()
+	suite.mfaChallenges.Store(userID, model.MFAChallenge{UserID: userID, Login: login, Role: model.RoleUser})
+
+	// состояние двухфакторной аутентификации пользователя, повторяющее логику хранилища
+	var mu sync.Mutex
+	state := model.TOTP{}
+	recovery := map[string]bool{}
+	suite.mockStorage.On("UserCredentials", mock.Anything, login).
+		Return(func(context.Context, string) (model.UserCredentials, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return model.UserCredentials{UserID: userID, Login: login, Role: model.RoleUser, PasswordHash: string(hashTestPassword), TOTPEnabled: state.Confirmed}, nil
+		})
+	suite.mockStorage.On("SaveTOTP", mock.Anything, userID, mock.AnythingOfType("string")).
+		Return(func(_ context.Context, _ uuid.UUID, secret string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if state.Confirmed {
+				return storage.ErrTOTPAlreadyEnabled
+			}
+			state = model.TOTP{Secret: secret}
+			return nil
+		})
+	suite.mockStorage.On("TOTP", mock.Anything, userID).
+		Return(func(context.Context, uuid.UUID) (model.TOTP, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if state.Secret == "" {
+				return model.TOTP{}, storage.ErrTOTPNotFound
+			}
+			return state, nil
+		})
+	suite.mockStorage.On("ConfirmTOTP", mock.Anything, userID, mock.AnythingOfType("int64"), mock.Anything).
+		Return(func(_ context.Context, _ uuid.UUID, step int64, hashes []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			state.Confirmed, state.LastStep = true, step
+			recovery = map[string]bool{}
+			for _, h := range hashes {
+				recovery[h] = true
+			}
+			return nil
+		})
+	suite.mockStorage.On("ReplaceRecoveryCodes", mock.Anything, userID, mock.Anything).
+		Return(func(_ context.Context, _ uuid.UUID, hashes []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			recovery = map[string]bool{}
+			for _, h := range hashes {
+				recovery[h] = true
+			}
+			return nil
+		})
+	suite.mockStorage.On("UseTOTPStep", mock.Anything, userID, mock.AnythingOfType("int64")).
+		Return(func(_ context.Context, _ uuid.UUID, step int64) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if step <= state.LastStep {
+				return storage.ErrNothingHasBeenDone
+			}
+			state.LastStep = step
+			return nil
+		})
+	suite.mockStorage.On("UseRecoveryCode", mock.Anything, userID, mock.AnythingOfType("string")).
+		Return(func(_ context.Context, _ uuid.UUID, codeHash string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !recovery[codeHash] {
+				return storage.ErrRecoveryCodeNotFound
+			}
+			delete(recovery, codeHash)
+			return nil
+		})
+	suite.mockStorage.On("DisableTOTP", mock.Anything, userID).
+		Return(func(context.Context, uuid.UUID) error {
+			mu.Lock()
+			defer mu.Unlock()
+			state = model.TOTP{}
+			recovery = map[string]bool{}
+			return nil
+		})
+
+	baseURL := "http://localhost" + suite.app.config.AddressApp()
+	post := func(client *resty.Client, path string, body any) *resty.Response {
+		resp, err := client.R().SetContext(ctx).
+			SetHeader("Content-type", "application/json").
+			SetBody(body).
+			Post(path)
+		suite.Require().NoError(err, path)
+		return resp
+	}
+	// пока второй фактор не включен, вход обычный
+	client := resty.New().SetBaseURL(baseURL)
+	resp := post(client, "/api/user/login", `{"login":"`+login+`","password":"test"}`)
+	suite.Require().EqualValues(http.StatusOK, resp.StatusCode())
+
+	// подключение
+	resp = post(client, "/api/user/2fa/totp/confirm", model.RequestSecondFactor{Code: "123456"})
+	suite.EqualValues(http.StatusNotFound, resp.StatusCode(), "подтверждение без секрета")
+	enroll := model.ResponseTOTPEnroll{}
+	resp, err = client.R().SetContext(ctx).SetResult(&enroll).Post("/api/user/2fa/totp")
+	suite.Require().NoError(err)
+	suite.Require().EqualValues(http.StatusOK, resp.StatusCode())
+	suite.Contains(enroll.URI, "otpauth://totp/")
+	suite.Contains(enroll.URI, "secret="+enroll.Secret)
+
+	wrong, err := totp.Code(enroll.Secret, time.Now().Add(-5*totp.Period))
+	suite.Require().NoError(err)
+	resp = post(client, "/api/user/2fa/totp/confirm", model.RequestSecondFactor{Code: wrong})
+	suite.EqualValues(http.StatusForbidden, resp.StatusCode(), "подтверждение неверным кодом")
+	code, err := totp.Code(enroll.Secret, time.Now())
+	suite.Require().NoError(err)
+	codes := model.ResponseRecoveryCodes{}
+	resp, err = client.R().SetContext(ctx).
+		SetHeader("Content-type", "application/json").
+		SetBody(model.RequestSecondFactor{Code: code}).
+		SetResult(&codes).
+		Post("/api/user/2fa/totp/confirm")
+	suite.Require().NoError(err)
+	suite.Require().EqualValues(http.StatusOK, resp.StatusCode())
+	suite.Require().Len(codes.Codes, recoveryCodesCount)
+	suite.Regexp(`^[a-z2-7]{4}-[a-z2-7]{4}$`, codes.Codes[0])
+	resp = post(client, "/api/user/2fa/totp/confirm", model.RequestSecondFactor{Code: code})
+	suite.EqualValues(http.StatusConflict, resp.StatusCode(), "повторное подтверждение")
+	resp, err = client.R().SetContext(ctx).Post("/api/user/2fa/totp")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusConflict, resp.StatusCode(), "повторное подключение")
+
+	// вход в два шага
+	loginMFA := func(factor model.RequestSecondFactor) (*resty.Client, *resty.Response) {
+		mfaClient := resty.New().SetBaseURL(baseURL)
+		challenge := model.ResponseMFARequired{}
+		resp, err := mfaClient.R().SetContext(ctx).
+			SetHeader("Content-type", "application/json").
+			SetBody(`{"login":"` + login + `","password":"test"}`).
+			SetResult(&challenge).
+			Post("/api/user/login")
+		suite.Require().NoError(err)
+		suite.Require().EqualValues(http.StatusAccepted, resp.StatusCode())
+		suite.Require().True(challenge.MFARequired)
+		// без второго фактора токены не выдаются
+		suite.Empty(resp.Cookies())
+		factor.MFAToken = challenge.MFAToken
+		return mfaClient, post(mfaClient, "/api/user/login/2fa", factor)
+	}
+	_, resp = loginMFA(model.RequestSecondFactor{Code: wrong})
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode(), "неверный код")
+	resp = post(resty.New().SetBaseURL(baseURL), "/api/user/login/2fa", model.RequestSecondFactor{MFAToken: "unknown", Code: code})
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode(), "неизвестный токен второго шага")
+
+	// код предыдущего шага уже принят при подтверждении, поэтому берем код следующего
+	next, err := totp.Code(enroll.Secret, time.Now().Add(totp.Period))
+	suite.Require().NoError(err)
+	mfaClient, resp := loginMFA(model.RequestSecondFactor{Code: next})
+	suite.Require().EqualValues(http.StatusOK, resp.StatusCode(), "верный код")
+	tokens := model.ResponseToken{}
+	suite.Require().NoError(json.Unmarshal(resp.Body(), &tokens))
+	uc, err := getUserClaimsFromToken(tokens.AccessToken, suite.app.keys, suite.app.config)
+	suite.Require().NoError(err)
+	suite.True(uc.MFA)
+	_, resp = loginMFA(model.RequestSecondFactor{Code: next})
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode(), "повторное использование кода")
+
+	_, resp = loginMFA(model.RequestSecondFactor{RecoveryCode: strings.ToUpper(codes.Codes[0])})
+	suite.EqualValues(http.StatusOK, resp.StatusCode(), "код восстановления")
+	_, resp = loginMFA(model.RequestSecondFactor{RecoveryCode: codes.Codes[0]})
+	suite.EqualValues(http.StatusUnauthorized, resp.StatusCode(), "повторное использование кода восстановления")
+
+	// новые коды восстановления заменяют прежние
+	newCodes := model.ResponseRecoveryCodes{}
+	resp, err = mfaClient.R().SetContext(ctx).
+		SetHeader("Content-type", "application/json").
+		SetBody(model.RequestSecondFactor{RecoveryCode: codes.Codes[1]}).
+		SetResult(&newCodes).
+		Post("/api/user/2fa/recovery-codes")
+	suite.Require().NoError(err)
+	suite.Require().EqualValues(http.StatusOK, resp.StatusCode())
+	suite.Require().Len(newCodes.Codes, recoveryCodesCount)
+
+	// отключение
+	resp = post(mfaClient, "/api/user/2fa/totp/disable", model.RequestSecondFactor{RecoveryCode: codes.Codes[2]})
+	suite.EqualValues(http.StatusForbidden, resp.StatusCode(), "отключение старым кодом восстановления")
+	resp = post(mfaClient, "/api/user/2fa/totp/disable", model.RequestSecondFactor{RecoveryCode: newCodes.Codes[0]})
+	suite.EqualValues(http.StatusNoContent, resp.StatusCode(), "отключение")
+	resp = post(mfaClient, "/api/user/2fa/totp/disable", model.RequestSecondFactor{RecoveryCode: newCodes.Codes[1]})
+	suite.EqualValues(http.StatusNotFound, resp.StatusCode(), "повторное отключение")
+	resp = post(resty.New().SetBaseURL(baseURL), "/api/user/login", `{"login":"`+login+`","password":"test"}`)
+	suite.EqualValues(http.StatusOK, resp.StatusCode(), "вход после отключения")
+}
+func (suite *AppTestSuite) TestGettingOrders() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// полная пачка - сразу захватываем следующую, неполная - ждем, свободных нет - ждем
+	suite.mockStorage.On("ClaimOrders", mock.Anything, "test-instance", mock.Anything, 2, time.Minute).
+		Return(model.ResponseOrders{{OrderNumber: "1"}, {OrderNumber: "2"}}, nil).Once()
+	suite.mockStorage.On("ClaimOrders", mock.Anything, "test-instance", mock.Anything, 2, time.Minute).
+		Return(model.ResponseOrders{{OrderNumber: "3"}}, nil).Once()
+	suite.mockStorage.On("ClaimOrders", mock.Anything, "test-instance", mock.Anything, 2, time.Minute).
+		Return(nil, storage.ErrOrdersNotFound)
+
+	orders := suite.app.gettingOrders(ctx)
+	got := []model.OrderNumber{}
+	for len(got) < 3 {
+		select {
+		case o := <-orders:
+			got = append(got, o.OrderNumber)
+		case <-ctx.Done():
+			suite.FailNow("заказы не получены")
+		}
+	}
+	suite.EqualValues([]model.OrderNumber{"1", "2", "3"}, got)
+
+	// после остановки канал закрывается
+	cancel()
+	for range orders {
+	}
+}
+func (suite *AppTestSuite) TestOrderHistory() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, userID, err := suite.LoggedClient(ctx, "login-order-history", "test", "TestOrderHistory")
+	suite.Require().NoError(err)
+	uploaded, _ := time.Parse(time.RFC3339, time.Now().Add(-time.Hour).Format(time.RFC3339))
+	processed, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	history := model.OrderHistory{
+		{Status: storage.StatusNew, Source: model.StatusSourceUpload, ChangedAt: uploaded},
+		{
+			Status:    storage.StatusProcessed,
+			Source:    model.StatusSourceWebhook,
+			Payload:   []byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`),
+			ChangedAt: processed,
+		},
+	}
+	suite.mockStorage.On("OrderHistory", mock.Anything, userID, model.OrderNumber("12345678903")).Return(history, nil).Once()
+	suite.mockStorage.On("OrderHistory", mock.Anything, userID, model.OrderNumber("79927398713")).Return(nil, storage.ErrOrdersNotFound).Once()
+
+	result := model.OrderHistory{}
+	resp, err := client.R().SetContext(ctx).SetResult(&result).Get("/api/user/orders/12345678903/history")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusOK, resp.StatusCode())
+	suite.EqualValues(history, result)
+
+	// чужой или несуществующий заказ
+	resp, err = client.R().SetContext(ctx).Get("/api/user/orders/79927398713/history")
+	suite.NoError(err)
+	suite.EqualValues(http.StatusNotFound, resp.StatusCode())
+}
 func TestExampleTestSuite(t *testing.T) {
 	suite.Run(t, new(AppTestSuite))
 }
@@ -2,11 +2,23 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
+	"github.com/kTowkA/gophermart/internal/config"
+	"github.com/kTowkA/gophermart/internal/jwtkeys"
+)
+
+var (
+	errTokenExpired     = errors.New("срок действия токена истек")
+	errTokenNotValidYet = errors.New("токен еще не действует")
+	errTokenIssuedAt    = errors.New("токен выпущен в будущем")
+	errTokenIssuer      = errors.New("токен выпущен другим издателем")
+	errTokenAudience    = errors.New("токен предназначен для другой аудитории")
+	errTokenID          = errors.New("у токена нет корректного идентификатора")
 )
 
 // Claims — структура утверждений, которая включает стандартные утверждения и одно пользовательское UserClaims
@@ -19,25 +31,42 @@ type Claims struct {
 type UserClaims struct {
 	UserID uuid.UUID
 	Login  string
+	// Role роль пользователя на момент выпуска токена. Изменение роли вступает в силу со следующим обновлением токенов
+	Role string
+	// MFA вход в сессию подтвержден вторым фактором
+	MFA bool
+	// SessionID сессия, в рамках которой выпущен токен. По ней проверяется, что токен не был отозван
+	SessionID uuid.UUID
+	// TokenID идентификатор (jti) токена, из которого получены утверждения. В сам токен отдельно не пишется
+	TokenID uuid.UUID `json:"-"`
+	// TokenExpiresAt когда истекает токен, из которого получены утверждения. В сам токен отдельно не пишется
+	TokenExpiresAt time.Time `json:"-"`
+	// APIKeyID api ключ, по которому прошел запрос. Нулевой, если пользователь вошел с токеном
+	APIKeyID uuid.UUID `json:"-"`
+	// Scopes области действия api ключа. Для токенов не используются
+	Scopes []string `json:"-"`
 }
 
-// buildJWTString создаёт токен и возвращает его в виде строки.
-func buildJWTString(userID uuid.UUID, userLogin, appSecret string, dur time.Duration) (string, error) {
-	// создаём новый токен с алгоритмом подписи HS256 и утверждениями — Claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// buildJWTString создаёт токен и возвращает его в виде строки. Токен подписывается текущим ключом подписи из набора keys.
+// Издатель и аудитория берутся из конфигурации cfg
+func buildJWTString(uc UserClaims, keys *jwtkeys.KeySet, cfg config.Config, dur time.Duration) (string, error) {
+	now := time.Now()
+	// создаём строку токена с утверждениями — Claims
+	tokenString, err := keys.Sign(Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
+			// уникальный идентификатор токена, по нему токен можно отозвать
+			ID:       uuid.NewString(),
+			Issuer:   cfg.JWTIssuer(),
+			Audience: jwt.ClaimStrings{cfg.JWTAudience()},
+			Subject:  uc.UserID.String(),
 			// когда создан токен
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(dur)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(dur)),
 		},
 		// собственное утверждение
-		UserClaims: UserClaims{
-			UserID: userID,
-			Login:  userLogin,
-		},
+		UserClaims: uc,
 	})
-
-	// создаём строку токена
-	tokenString, err := token.SignedString([]byte(appSecret))
 	if err != nil {
 		return "", err
 	}
@@ -47,15 +76,12 @@ func buildJWTString(userID uuid.UUID, userLogin, appSecret string, dur time.Dura
 }
 
 // getUserClaimsFromToken - получает UserClaims из JWT токена
-func getUserClaimsFromToken(tokenString, secret string) (UserClaims, error) {
+// Ключ проверки выбирается из набора keys по заголовку kid, метод подписи должен соответствовать ключу.
+// Стандартные утверждения проверяются с учетом допустимого расхождения часов из конфигурации cfg
+func getUserClaimsFromToken(tokenString string, keys *jwtkeys.KeySet, cfg config.Config) (UserClaims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("неожиданный метод подписи: %v", t.Header["alg"])
-			}
-			return []byte(secret), nil
-		})
+	// стандартная проверка утверждений в jwt/v4 не умеет учитывать расхождение часов, поэтому проверяем их сами
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc, jwt.WithoutClaimsValidation())
 	if err != nil {
 		return UserClaims{}, err
 	}
@@ -64,5 +90,35 @@ func getUserClaimsFromToken(tokenString, secret string) (UserClaims, error) {
 		return UserClaims{}, fmt.Errorf("токен не прошел проверку")
 	}
 
-	return claims.UserClaims, nil
+	if err = claims.validate(time.Now(), cfg); err != nil {
+		return UserClaims{}, err
+	}
+
+	uc := claims.UserClaims
+	uc.TokenID, err = uuid.Parse(claims.ID)
+	if err != nil {
+		return UserClaims{}, errTokenID
+	}
+	uc.TokenExpiresAt = claims.ExpiresAt.Time
+	return uc, nil
+}
+
+// validate проверяет обязательные стандартные утверждения. Время сравнивается с допуском cfg.JWTLeeway()
+func (c *Claims) validate(now time.Time, cfg config.Config) error {
+	leeway := cfg.JWTLeeway()
+	switch {
+	case !c.VerifyExpiresAt(now.Add(-leeway), true):
+		return errTokenExpired
+	case !c.VerifyNotBefore(now.Add(leeway), true):
+		return errTokenNotValidYet
+	case !c.VerifyIssuedAt(now.Add(leeway), true):
+		return errTokenIssuedAt
+	case !c.VerifyIssuer(cfg.JWTIssuer(), true):
+		return errTokenIssuer
+	case !c.VerifyAudience(cfg.JWTAudience(), true):
+		return errTokenAudience
+	case c.ID == "":
+		return errTokenID
+	}
+	return nil
 }
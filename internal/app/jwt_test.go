@@ -0,0 +1,120 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/kTowkA/gophermart/internal/config"
+	"github.com/kTowkA/gophermart/internal/jwtkeys"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserClaimsFromToken(t *testing.T) {
+	cfg := config.NewConfig("", "", "", "secret")
+	keys := jwtkeys.NewHMAC(cfg.Secret())
+	uc := UserClaims{UserID: uuid.New(), Login: "login", Role: model.RoleAdmin, SessionID: uuid.New()}
+
+	token, err := buildJWTString(uc, keys, cfg, time.Minute)
+	require.NoError(t, err)
+	actual, err := getUserClaimsFromToken(token, keys, cfg)
+	require.NoError(t, err)
+	assert.EqualValues(t, uc.UserID, actual.UserID)
+	assert.EqualValues(t, uc.SessionID, actual.SessionID)
+	assert.EqualValues(t, uc.Role, actual.Role)
+	assert.NotEqualValues(t, uuid.UUID{}, actual.TokenID)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), actual.TokenExpiresAt, 2*time.Second)
+
+	// у каждого токена свой jti
+	other, err := buildJWTString(uc, keys, cfg, time.Minute)
+	require.NoError(t, err)
+	otherClaims, err := getUserClaimsFromToken(other, keys, cfg)
+	require.NoError(t, err)
+	assert.NotEqualValues(t, actual.TokenID, otherClaims.TokenID)
+}
+
+func TestClaimsValidate(t *testing.T) {
+	cfg := config.NewConfig("", "", "", "secret")
+	now := time.Now()
+	valid := func() Claims {
+		return Claims{RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    cfg.JWTIssuer(),
+			Audience:  jwt.ClaimStrings{cfg.JWTAudience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}}
+	}
+	tests := []struct {
+		name   string
+		change func(c *Claims)
+		want   error
+	}{
+		{
+			name:   "все верно",
+			change: func(c *Claims) {},
+		},
+		{
+			name:   "истек в пределах допуска",
+			change: func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-cfg.JWTLeeway() / 2)) },
+		},
+		{
+			name:   "истек",
+			change: func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-2 * cfg.JWTLeeway())) },
+			want:   errTokenExpired,
+		},
+		{
+			name:   "без срока действия",
+			change: func(c *Claims) { c.ExpiresAt = nil },
+			want:   errTokenExpired,
+		},
+		{
+			name:   "начинает действовать в пределах допуска",
+			change: func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(cfg.JWTLeeway() / 2)) },
+		},
+		{
+			name:   "еще не действует",
+			change: func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(2 * cfg.JWTLeeway())) },
+			want:   errTokenNotValidYet,
+		},
+		{
+			name:   "выпущен в будущем",
+			change: func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(2 * cfg.JWTLeeway())) },
+			want:   errTokenIssuedAt,
+		},
+		{
+			name:   "другой издатель",
+			change: func(c *Claims) { c.Issuer = "staging" },
+			want:   errTokenIssuer,
+		},
+		{
+			name:   "другая аудитория",
+			change: func(c *Claims) { c.Audience = jwt.ClaimStrings{"staging"} },
+			want:   errTokenAudience,
+		},
+		{
+			name:   "без аудитории",
+			change: func(c *Claims) { c.Audience = nil },
+			want:   errTokenAudience,
+		},
+		{
+			name:   "без идентификатора",
+			change: func(c *Claims) { c.ID = "" },
+			want:   errTokenID,
+		},
+	}
+	for _, tt := range tests {
+		c := valid()
+		tt.change(&c)
+		err := c.validate(now, cfg)
+		if tt.want == nil {
+			assert.NoError(t, err, tt.name)
+			continue
+		}
+		assert.ErrorIs(t, err, tt.want, tt.name)
+	}
+}
@@ -0,0 +1,112 @@
+// в этом файле содержатся функции защиты входа от перебора паролей: задержки после неудачных попыток и временная блокировка по логину и ip адресу
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/password"
+)
+
+// clientIP адрес клиента. Если включено доверие заголовкам прокси, RemoteAddr уже заменен middleware.RealIP
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginDelay задержка перед следующей попыткой после failures неудач подряд. Первые LoginFreeAttempts неудач без задержки, дальше задержка удваивается до LoginDelayMax
+func (a *AppServer) loginDelay(failures int) time.Duration {
+	n := failures - a.config.LoginFreeAttempts()
+	if n <= 0 {
+		return 0
+	}
+	delay := a.config.LoginDelayBase()
+	for i := 1; i < n && delay < a.config.LoginDelayMax(); i++ {
+		delay *= 2
+	}
+	if delay > a.config.LoginDelayMax() {
+		delay = a.config.LoginDelayMax()
+	}
+	return delay
+}
+
+// loginRetryAfter через сколько можно повторить попытку входа. Нулевое значение - вход разрешен.
+// Блокировка действует для любого ключа, а задержки только для логина: с одного адреса могут входить многие пользователи
+func (a *AppServer) loginRetryAfter(attempts []model.LoginAttempts, login string, now time.Time) time.Duration {
+	var wait time.Duration
+	for _, at := range attempts {
+		if at.LockedUntil.After(now) {
+			wait = max(wait, at.LockedUntil.Sub(now))
+			continue
+		}
+		if at.Key != model.LoginAttemptsLoginKey(login) {
+			continue
+		}
+		// неудачи за пределами окна уже не учитываются
+		if now.Sub(at.LastFailureAt) > a.config.LoginFailureWindow() {
+			continue
+		}
+		wait = max(wait, at.LastFailureAt.Add(a.loginDelay(at.Failures)).Sub(now))
+	}
+	return wait
+}
+
+// registerLoginFailure учитывает неудачную попытку входа по логину и ip адресу. Ошибки хранилища только логируются - клиент в любом случае получает отказ
+func (a *AppServer) registerLoginFailure(ctx context.Context, login, ip string) {
+	now := time.Now()
+	limits := map[string]int{
+		model.LoginAttemptsLoginKey(login): a.config.LoginMaxFailures(),
+		model.LoginAttemptsIPKey(ip):       a.config.LoginIPMaxFailures(),
+	}
+	for key, maxFailures := range limits {
+		at, err := a.storage.RegisterLoginFailure(ctx, key, now.Add(-a.config.LoginFailureWindow()), maxFailures, now.Add(a.config.LoginLockout()))
+		if err != nil {
+			a.log.Error("сохранение неудачной попытки входа", slog.String("ключ", key), slog.String("ошибка", err.Error()))
+			continue
+		}
+		if at.LockedUntil.After(now) {
+			a.log.Warn("вход заблокирован после неудачных попыток", slog.String("ключ", key), slog.Int("неудач подряд", at.Failures), slog.Time("до", at.LockedUntil))
+		}
+	}
+}
+
+// writeRetryAfter отказ из-за слишком частых попыток. Время ожидания округляется вверх до секунд
+func writeRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	sec := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(sec, 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
+// dummyPasswordHash хеш, с которым сравнивается пароль для несуществующего логина. Так ответ для неизвестного логина занимает столько же времени, сколько для неверного пароля.
+// Хеш создается при первом обращении, если это не удалось (например, хеширование перегружено), создается при следующем
+func (a *AppServer) dummyPasswordHash(ctx context.Context) (string, error) {
+	a.dummyHashMu.Lock()
+	defer a.dummyHashMu.Unlock()
+	if a.dummyHash != "" {
+		return a.dummyHash, nil
+	}
+	hash, err := a.hasher.Hash(ctx, "dummy password")
+	if err != nil {
+		return "", err
+	}
+	a.dummyHash = hash
+	return a.dummyHash, nil
+}
+
+// writeHashError ответ на ошибку хеширования пароля: 503, если хеширование перегружено и запрос не дождался очереди, иначе 500
+func writeHashError(w http.ResponseWriter, err error) {
+	if errors.Is(err, password.ErrBusy) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/config"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+	mocks "github.com/kTowkA/gophermart/internal/storage/mocs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginRetryAfter(t *testing.T) {
+	a := &AppServer{
+		config: config.NewConfig("", "", "", "secret", config.WithLoginDelay(2, time.Second, 5*time.Second)),
+	}
+	now := time.Now()
+	tests := []struct {
+		name     string
+		attempts []model.LoginAttempts
+		want     time.Duration
+	}{
+		{
+			name: "неудачных попыток нет",
+			want: 0,
+		},
+		{
+			name:     "неудачи без задержки",
+			attempts: []model.LoginAttempts{{Key: model.LoginAttemptsLoginKey("login"), Failures: 2, LastFailureAt: now}},
+			want:     0,
+		},
+		{
+			name:     "первая задержка",
+			attempts: []model.LoginAttempts{{Key: model.LoginAttemptsLoginKey("login"), Failures: 3, LastFailureAt: now}},
+			want:     time.Second,
+		},
+		{
+			name:     "задержка удваивается",
+			attempts: []model.LoginAttempts{{Key: model.LoginAttemptsLoginKey("login"), Failures: 4, LastFailureAt: now.Add(-500 * time.Millisecond)}},
+			want:     1500 * time.Millisecond,
+		},
+		{
+			name:     "задержка не больше максимальной",
+			attempts: []model.LoginAttempts{{Key: model.LoginAttemptsLoginKey("login"), Failures: 40, LastFailureAt: now}},
+			want:     5 * time.Second,
+		},
+		{
+			name:     "задержка уже прошла",
+			attempts: []model.LoginAttempts{{Key: model.LoginAttemptsLoginKey("login"), Failures: 3, LastFailureAt: now.Add(-2 * time.Second)}},
+			want:     0,
+		},
+		{
+			name:     "неудачи за пределами окна не учитываются",
+			attempts: []model.LoginAttempts{{Key: model.LoginAttemptsLoginKey("login"), Failures: 40, LastFailureAt: now.Add(-time.Hour)}},
+			want:     0,
+		},
+		{
+			name:     "для ip адреса задержки нет",
+			attempts: []model.LoginAttempts{{Key: model.LoginAttemptsIPKey("127.0.0.1"), Failures: 40, LastFailureAt: now}},
+			want:     0,
+		},
+		{
+			name: "блокировка важнее задержки",
+			attempts: []model.LoginAttempts{
+				{Key: model.LoginAttemptsLoginKey("login"), Failures: 3, LastFailureAt: now},
+				{Key: model.LoginAttemptsIPKey("127.0.0.1"), Failures: 1, LastFailureAt: now, LockedUntil: now.Add(time.Minute)},
+			},
+			want: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, a.loginRetryAfter(tt.attempts, "login", now))
+		})
+	}
+}
+
+func TestHashBusy(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	a := &AppServer{
+		storage: mockStorage,
+		config:  config.NewConfig("", "", "", "secret", config.WithArgon2Params(1024, 1, 1)),
+		log:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	var err error
+	a.policy, err = newPolicy(a.config)
+	require.NoError(t, err)
+	a.hasher, err = newHasher(a.config)
+	require.NoError(t, err)
+	mockStorage.On("LoginAttempts", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	mockStorage.On("UserCredentials", mock.Anything, "login-busy").Return(model.UserCredentials{}, storage.ErrUserNotFound)
+
+	// запрос не дождался хеширования. попытка входа неудачной не считается
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for path, handler := range map[string]http.HandlerFunc{
+		"/api/user/register": a.rRegisterUser,
+		"/api/user/login":    a.rLoginUser,
+	} {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"login":"login-busy","password":"busy-password"}`)).WithContext(ctx)
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+	}
+}
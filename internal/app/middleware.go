@@ -2,8 +2,12 @@
 package app
 
 import (
+	"encoding/json"
+	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/kTowkA/gophermart/internal/model"
 )
 
 // checkContentType вначале было middleware, но теперь просто вспомогательная функция для проверки Сontent-type
@@ -17,6 +21,16 @@ func checkContentType(r *http.Request, allowedContentTypes []string) bool {
 	return false
 }
 
+// writeValidationErrors отвечает 400 с перечнем нарушений в теле, чтобы клиент мог показать их по полям
+func (a *AppServer) writeValidationErrors(w http.ResponseWriter, errs []model.ValidationError) {
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	err := json.NewEncoder(w).Encode(model.ResponseValidationErrors{Errors: errs})
+	if err != nil {
+		a.log.Error("отправка ошибок проверки запроса", slog.String("ошибка", err.Error()))
+	}
+}
+
 // middlewarePostBody проверяем пост запрос на то, что он имеет тело тело
 func middlewarePostBody(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -7,11 +7,16 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/kTowkA/gophermart/internal/storage"
 )
 
 // userClaims - структура для ключа context.Value чтобы избежать коллизий со стандартными типами. Используется чтобы передать информацию о пользователе дальше по запросам используем context.Value
 type userClaims struct{}
 
+// apiKeyHeader заголовок с api ключом
+const apiKeyHeader = "X-API-Key"
+
 // middlewareAuthUser функция проверки на возможность доступа к методам API
 func (a *AppServer) middlewareAuthUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -19,6 +24,13 @@ func (a *AppServer) middlewareAuthUser(next http.Handler) http.Handler {
 		linksAllowedAllUsers := []string{
 			"api/user/register",
 			"api/user/login",
+			"api/user/login/2fa",
+			"api/user/token/refresh",
+			"api/user/password/reset",
+			"api/user/password/reset/confirm",
+			".well-known/jwks.json",
+			// события системы расчета проверяются по подписи
+			"api/internal/accrual/events",
 		}
 		path := strings.Trim(r.URL.Path, "/")
 		path = strings.ToLower(path)
@@ -29,24 +41,40 @@ func (a *AppServer) middlewareAuthUser(next http.Handler) http.Handler {
 			}
 		}
 
-		// получаем токен из кук
-		cookieToken, err := r.Cookie(a.config.CookieTokenName())
-		if errors.Is(err, http.ErrNoCookie) {
+		// автоматизация партнеров ходит с api ключом вместо токена
+		if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" {
+			a.authByAPIKey(next, w, r, apiKey)
+			return
+		}
+
+		// получаем токен из заголовка Authorization или из кук
+		token, ok := tokenFromRequest(r, a.config.CookieTokenName())
+		if !ok {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		// получаем пользовательские данные
+		uc, err := getUserClaimsFromToken(token, a.keys, a.config)
 		if err != nil {
-			a.log.Error("получение значения куки",
-				slog.String("кука", a.config.CookieTokenName()),
+			a.log.Error("получение данных пользователя",
 				slog.String("ошибка", err.Error()))
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-		// получаем пользовательские данные
-		uc, err := getUserClaimsFromToken(cookieToken.Value, a.config.Secret())
+		// проверяем, что ни сессия, ни сам токен не были отозваны
+		active, err := a.storage.SessionActive(r.Context(), uc.SessionID, uc.TokenID)
 		if err != nil {
-			a.log.Error("получение данных пользователя",
+			a.log.Error("проверка сессии пользователя",
+				slog.String("сессия", uc.SessionID.String()),
+				slog.String("токен", uc.TokenID.String()),
 				slog.String("ошибка", err.Error()))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			a.log.Info("сессия пользователя или токен отозваны",
+				slog.String("сессия", uc.SessionID.String()),
+				slog.String("токен", uc.TokenID.String()))
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -54,3 +82,26 @@ func (a *AppServer) middlewareAuthUser(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userClaims{}, uc)))
 	})
 }
+
+// authByAPIKey авторизация по api ключу. В контекст попадают те же UserClaims, что и при входе с токеном, плюс ключ и его области действия
+func (a *AppServer) authByAPIKey(next http.Handler, w http.ResponseWriter, r *http.Request, apiKey string) {
+	owner, err := a.storage.APIKeyOwner(r.Context(), hashToken(apiKey))
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		a.log.Info("api ключ не найден или отозван")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		a.log.Error("проверка api ключа", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	uc := UserClaims{
+		UserID:   owner.UserID,
+		Login:    owner.Login,
+		Role:     owner.Role,
+		APIKeyID: owner.KeyID,
+		Scopes:   owner.Scopes,
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userClaims{}, uc)))
+}
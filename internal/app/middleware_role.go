@@ -0,0 +1,30 @@
+// middleware, которая ограничивает доступ к методам в зависимости от роли пользователя
+package app
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// requireRole пропускает только пользователей с одной из ролей roles. Роль берется из утверждений токена
+func (a *AppServer) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !slices.Contains(roles, uc.Role) {
+				a.log.Warn("недостаточно прав",
+					slog.String("логин", uc.Login),
+					slog.String("роль", uc.Role),
+					slog.String("путь", r.URL.Path))
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// middleware, которые ограничивают доступ к методам в зависимости от способа входа: с токеном сессии или с api ключом
+package app
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+// requireSession пропускает только пользователей, вошедших с токеном сессии. Api ключом нельзя управлять аккаунтом: менять пароль, выпускать ключи и т.д.
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if uc.APIKeyID != (uuid.UUID{}) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireScope для api ключей проверяет, что у ключа есть область действия scope. Ключу без областей действия доступны только model.DefaultScopes.
+// Вошедших с токеном сессии не ограничивает
+func (a *AppServer) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			scopes := uc.Scopes
+			if len(scopes) == 0 {
+				scopes = model.DefaultScopes
+			}
+			if uc.APIKeyID != (uuid.UUID{}) && !slices.Contains(scopes, scope) {
+				a.log.Info("у api ключа нет нужной области действия",
+					slog.String("ключ", uc.APIKeyID.String()),
+					slog.String("область", scope))
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireWithdrawMFA пропускает к списанию баллов. Вход, подтвержденный вторым фактором, пропускается всегда.
+// Пользователь с подтвержденным TOTP, вошедший только по паролю (например, подключивший TOTP уже после входа), получает 403.
+// Пользователи без TOTP и api ключи с явно выданной областью balance:write пропускаются, если не включен WITHDRAW_REQUIRE_MFA
+func (a *AppServer) requireWithdrawMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if uc.MFA {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if a.config.WithdrawRequireMFA() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if uc.APIKeyID != (uuid.UUID{}) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret, err := a.storage.TOTP(r.Context(), uc.UserID)
+		if err != nil && !errors.Is(err, storage.ErrTOTPNotFound) {
+			a.log.Error("получение секрета TOTP", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if secret.Confirmed {
+			a.log.Info("списание без второго фактора у пользователя с TOTP", slog.String("логин", uc.Login))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
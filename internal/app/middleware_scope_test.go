@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kTowkA/gophermart/internal/config"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+	mocks "github.com/kTowkA/gophermart/internal/storage/mocs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRequireWithdrawMFA(t *testing.T) {
+	var (
+		enrolled   = uuid.New()
+		unenrolled = uuid.New()
+		broken     = uuid.New()
+	)
+	mockStorage := mocks.NewStorage(t)
+	mockStorage.On("TOTP", mock.Anything, enrolled).Return(model.TOTP{Secret: "secret", Confirmed: true}, nil).Maybe()
+	mockStorage.On("TOTP", mock.Anything, unenrolled).Return(model.TOTP{}, storage.ErrTOTPNotFound).Maybe()
+	mockStorage.On("TOTP", mock.Anything, broken).Return(model.TOTP{}, errors.New("totp error")).Maybe()
+
+	tests := []struct {
+		name           string
+		requireMFA     bool
+		claims         UserClaims
+		wantStatusCode int
+	}{
+		{name: "вход со вторым фактором", claims: UserClaims{UserID: enrolled, MFA: true}, wantStatusCode: http.StatusOK},
+		{name: "пользователь с TOTP вошел по паролю", claims: UserClaims{UserID: enrolled}, wantStatusCode: http.StatusForbidden},
+		{name: "пользователь без TOTP", claims: UserClaims{UserID: unenrolled}, wantStatusCode: http.StatusOK},
+		{name: "ошибка хранилища", claims: UserClaims{UserID: broken}, wantStatusCode: http.StatusInternalServerError},
+		{name: "api ключ", claims: UserClaims{UserID: enrolled, APIKeyID: uuid.New()}, wantStatusCode: http.StatusOK},
+		{name: "обязательный второй фактор. вход со вторым фактором", requireMFA: true, claims: UserClaims{UserID: unenrolled, MFA: true}, wantStatusCode: http.StatusOK},
+		{name: "обязательный второй фактор. пользователь без TOTP", requireMFA: true, claims: UserClaims{UserID: unenrolled}, wantStatusCode: http.StatusForbidden},
+		{name: "обязательный второй фактор. api ключ", requireMFA: true, claims: UserClaims{UserID: unenrolled, APIKeyID: uuid.New()}, wantStatusCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &AppServer{
+				storage: mockStorage,
+				config:  config.NewConfig("", "", "", "secret", config.WithWithdrawRequireMFA(tt.requireMFA)),
+				log:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			handler := a.requireWithdrawMFA(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", nil)
+			r = r.WithContext(context.WithValue(r.Context(), userClaims{}, tt.claims))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantStatusCode, w.Code)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// в этом файле описан прием событий об изменении статуса заказов от системы расчета баллов лояльности.
+// События дополняют опрос: заказ, по которому событие пришло, опрашивается только если следующего события не будет дольше config.AccrualPushTimeout
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/accrual"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+// maxAccrualEventSize больше этого тело события не читаем
+const maxAccrualEventSize = 64 << 10
+
+// rAccrualEvent хендлер события системы расчета в формате ответа GET /api/orders/{number}.
+// Событие подписано HMAC-SHA256 общим секретом вместе с временем отправки. События старше config.AccrualWebhookTolerance отклоняются,
+// а повтор уже принятого события в этом окне отсекается по подписи. Если событие не удалось применить (500),
+// система расчета должна отправить его заново с новым временем и подписью
+func (a *AppServer) rAccrualEvent(w http.ResponseWriter, r *http.Request) {
+	secret := a.config.AccrualWebhookSecret()
+	if secret == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAccrualEventSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	signature := r.Header.Get(accrual.SignatureHeader)
+	timestamp, err := strconv.ParseInt(r.Header.Get(accrual.TimestampHeader), 10, 64)
+	if err != nil || !accrual.VerifySignature([]byte(secret), timestamp, body, signature) {
+		a.log.Warn("событие системы расчета с неверной подписью")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	sentAt := time.Unix(timestamp, 0)
+	if skew := time.Since(sentAt); skew > a.config.AccrualWebhookTolerance() || skew < -a.config.AccrualWebhookTolerance() {
+		a.log.Warn("событие системы расчета вне допустимого окна времени", slog.Time("отправлено", sentAt))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	event := model.ResponseAccuralSystem{}
+	if err = json.Unmarshal(body, &event); err != nil {
+		a.log.Error("декодирование события системы расчета", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !validAccrualEvent(event) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if event.AccrualReported != "" {
+		a.log.Warn("начисление в событии системы расчета точнее сотых, лишние знаки отброшены",
+			slog.String("заказ", string(event.OrderNumber)),
+			slog.String("прислано", event.AccrualReported),
+			slog.String("принято", event.Accrual.String()))
+	}
+	event.Source = model.StatusSourceWebhook
+	event.Payload = body
+
+	// подпись уникальна для пары время+тело, поэтому ее хеш служит признаком события до конца окна
+	err = a.storage.SaveWebhookNonce(r.Context(), hashToken(signature), sentAt.Add(a.config.AccrualWebhookTolerance()))
+	if errors.Is(err, storage.ErrWebhookReplay) {
+		a.log.Warn("повторное событие системы расчета", slog.String("заказ", string(event.OrderNumber)))
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.log.Error("сохранение признака события системы расчета", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = a.storage.UpdateOrder(r.Context(), event)
+	switch {
+	case errors.Is(err, storage.ErrOrdersNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, storage.ErrNothingHasBeenDone):
+	case errors.Is(err, storage.ErrIllegalTransition):
+		// событие опоздало: заказ уже в более позднем или окончательном статусе
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		a.log.Error("обновление заказа по событию системы расчета", slog.String("заказ", string(event.OrderNumber)), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.Debug("принято событие системы расчета", slog.String("заказ", string(event.OrderNumber)), slog.String("статус", event.Status.Value()))
+	if !isFinalStatus(event.Status) {
+		a.deferAccrualPolling(r.Context(), event.OrderNumber)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validAccrualEvent проверяет, что в событии есть номер заказа и известный статус системы расчета.
+// Отрицательное начисление отклоняется еще при декодировании
+func validAccrualEvent(event model.ResponseAccuralSystem) bool {
+	if event.OrderNumber == "" {
+		return false
+	}
+	switch event.Status.Value() {
+	case storage.StatusRegistered.Value(), storage.StatusProcessing.Value(), storage.StatusInvalid.Value(), storage.StatusProcessed.Value():
+		return true
+	default:
+		return false
+	}
+}
+
+// deferAccrualPolling откладывает опрос заказа на config.AccrualPushTimeout в ожидании события от системы расчета.
+// Ошибка только логируется: в худшем случае заказ будет опрошен раньше
+func (a *AppServer) deferAccrualPolling(ctx context.Context, orderNum model.OrderNumber) {
+	err := a.storage.DeferOrderCheck(ctx, orderNum, time.Now().Add(a.config.AccrualPushTimeout()))
+	if err != nil {
+		a.log.Error("перенос опроса заказа", slog.String("заказ", string(orderNum)), slog.String("ошибка", err.Error()))
+	}
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/accrual"
+	"github.com/kTowkA/gophermart/internal/config"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+	mocks "github.com/kTowkA/gophermart/internal/storage/mocs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAccrualEvent(t *testing.T) {
+	secret := []byte("webhook-secret")
+	event := func(body string, sentAt time.Time, key []byte) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/internal/accrual/events", strings.NewReader(body))
+		r.Header.Set("content-type", "application/json")
+		r.Header.Set(accrual.TimestampHeader, strconv.FormatInt(sentAt.Unix(), 10))
+		r.Header.Set(accrual.SignatureHeader, accrual.Sign(key, sentAt.Unix(), []byte(body)))
+		return r
+	}
+	byOrder := func(number model.OrderNumber) any {
+		return mock.MatchedBy(func(e model.ResponseAccuralSystem) bool { return e.OrderNumber == number })
+	}
+
+	mockStorage := mocks.NewStorage(t)
+	a := &AppServer{
+		storage: mockStorage,
+		config:  config.NewConfig("", "", "", "secret", config.WithAccrualWebhook(string(secret), time.Minute, 10*time.Minute)),
+		log:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	now := time.Now()
+
+	mockStorage.On("SaveWebhookNonce", mock.Anything, mock.Anything, mock.Anything).Return(nil).Times(5)
+	mockStorage.On("UpdateOrder", mock.Anything, byOrder("1")).Return(nil).Once()
+	mockStorage.On("UpdateOrder", mock.Anything, byOrder("2")).Return(nil).Once()
+	mockStorage.On("UpdateOrder", mock.Anything, byOrder("3")).Return(storage.ErrOrdersNotFound).Once()
+	mockStorage.On("UpdateOrder", mock.Anything, byOrder("4")).Return(storage.ErrIllegalTransition).Once()
+	// начисление точнее сотых усекается, а не отклоняется
+	mockStorage.On("UpdateOrder", mock.Anything, mock.MatchedBy(func(e model.ResponseAccuralSystem) bool {
+		return e.OrderNumber == "5" && e.Accrual == 100 && e.AccrualReported == "1.009"
+	})).Return(nil).Once()
+	// опрос заказа, который еще считается, откладывается в ожидании следующего события
+	mockStorage.On("DeferOrderCheck", mock.Anything, model.OrderNumber("2"), mock.MatchedBy(func(until time.Time) bool {
+		return until.After(now.Add(9*time.Minute)) && until.Before(time.Now().Add(11*time.Minute))
+	})).Return(nil).Once()
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		code int
+	}{
+		{"окончательный статус", event(`{"order":"1","status":"PROCESSED","accrual":500}`, now, secret), http.StatusNoContent},
+		{"промежуточный статус", event(`{"order":"2","status":"PROCESSING"}`, now, secret), http.StatusNoContent},
+		{"неизвестный заказ", event(`{"order":"3","status":"INVALID"}`, now, secret), http.StatusNotFound},
+		{"запоздавшее событие", event(`{"order":"4","status":"REGISTERED"}`, now, secret), http.StatusConflict},
+		{"чужой секрет", event(`{"order":"1","status":"PROCESSED","accrual":500}`, now, []byte("other")), http.StatusUnauthorized},
+		{"устаревшее событие", event(`{"order":"1","status":"PROCESSED","accrual":500}`, now.Add(-2*time.Minute), secret), http.StatusUnauthorized},
+		{"неизвестный статус", event(`{"order":"1","status":"DONE"}`, now, secret), http.StatusBadRequest},
+		{"начисление точнее сотых", event(`{"order":"5","status":"PROCESSED","accrual":1.009}`, now, secret), http.StatusNoContent},
+		{"отрицательное начисление", event(`{"order":"6","status":"PROCESSED","accrual":-1}`, now, secret), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			a.rAccrualEvent(w, tt.r)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+
+	// повтор уже принятого события
+	mockStorage.On("SaveWebhookNonce", mock.Anything, mock.Anything, mock.Anything).Return(storage.ErrWebhookReplay).Once()
+	w := httptest.NewRecorder()
+	a.rAccrualEvent(w, event(`{"order":"1","status":"PROCESSED","accrual":500}`, now, secret))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// без секрета прием событий выключен
+	a.config = config.NewConfig("", "", "", "secret")
+	w = httptest.NewRecorder()
+	a.rAccrualEvent(w, event(`{"order":"1","status":"PROCESSED","accrual":500}`, now, secret))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -2,15 +2,20 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/password"
+	"github.com/kTowkA/gophermart/internal/policy"
 	"github.com/kTowkA/gophermart/internal/storage"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // rRegister хендлер для регистрации пользователей
@@ -29,16 +34,25 @@ func (a *AppServer) rRegisterUser(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	// генерируем хеш от пароля с помощью пакета bcrypt
-	bytes, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	// логин храним в каноническом виде, чтобы "Alice" и "alice" не могли зарегистрироваться оба
+	req.Login = policy.NormalizeLogin(req.Login)
+	errs := append(a.policy.ValidateLogin(req.Login), a.policy.ValidatePassword(req.Password, req.Login)...)
+	if len(errs) > 0 {
+		a.log.Info("регистрация. логин или пароль не соответствуют правилам", slog.String("логин", req.Login), slog.Any("нарушения", errs))
+		a.writeValidationErrors(w, errs)
+		return
+	}
+
+	// генерируем хеш от пароля текущим алгоритмом
+	hashPassword, err := a.hasher.Hash(r.Context(), req.Password)
 	if err != nil {
 		a.log.Error("генерация пароля", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeHashError(w, err)
 		return
 	}
 
 	// сохраняем пользователя в хранилище
-	userID, err := a.storage.SaveUser(r.Context(), req.Login, string(bytes))
+	userID, err := a.storage.SaveUser(r.Context(), req.Login, hashPassword)
 	// если такой логин уже занят, то возвращаем конфликт
 	if errors.Is(err, storage.ErrLoginIsUsed) {
 		a.log.Info("сохранение пользователя. логин уже занят", slog.String("логин", req.Login))
@@ -51,18 +65,15 @@ func (a *AppServer) rRegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// генерируем новый токен
-	token, err := buildJWTString(userID, req.Login, a.config.Secret(), 24*time.Hour)
+	// создаем новую сессию и выдаем токены
+	tokens, err := a.startSession(r.Context(), w, UserClaims{UserID: userID, Login: req.Login, Role: model.RoleUser})
 	if err != nil {
-		a.log.Error("генерация токена", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+		a.log.Error("создание сессии", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// выставляем новый токен в куках, чтобы пользователь дальше его продолжил использовать
-	http.SetCookie(w, &http.Cookie{Name: a.config.CookieTokenName(), Value: token})
-
-	w.WriteHeader(http.StatusOK)
+	a.writeTokens(w, tokens)
 }
 
 // rLogin хендлер для получения токена для работы
@@ -80,46 +91,185 @@ func (a *AppServer) rLoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
+	req.Login = policy.NormalizeLogin(req.Login)
+
+	// проверяем, не заблокирован ли вход для логина или ip адреса
+	ip := clientIP(r)
+	attempts, err := a.storage.LoginAttempts(r.Context(), model.LoginAttemptsLoginKey(req.Login), model.LoginAttemptsIPKey(ip))
+	if err != nil {
+		a.log.Error("получение неудачных попыток входа", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait := a.loginRetryAfter(attempts, req.Login, time.Now()); wait > 0 {
+		a.log.Info("слишком частые попытки входа", slog.String("логин", req.Login), slog.String("ip", ip), slog.Duration("ожидание", wait))
+		writeRetryAfter(w, wait)
+		return
+	}
 
-	userID, err := a.storage.UserID(r.Context(), req.Login)
+	// данные пользователя получаем одним запросом, а пароль сравниваем всегда, даже если логин не найден.
+	// так по ответу и времени ответа нельзя понять, существует ли аккаунт
+	credentials, err := a.storage.UserCredentials(r.Context(), req.Login)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		a.log.Error("получение данных для входа", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	hashPassword := credentials.PasswordHash
 	if errors.Is(err, storage.ErrUserNotFound) {
-		a.log.Info("поиск пользователя. пользователь не найден", slog.String("логин", req.Login))
+		dummyHash, errDummy := a.dummyPasswordHash(r.Context())
+		if errDummy != nil {
+			a.log.Error("генерация хеша для несуществующих пользователей", slog.String("ошибка", errDummy.Error()))
+			writeHashError(w, errDummy)
+			return
+		}
+		hashPassword = dummyHash
+	}
+	match, needsRehash, errCompare := a.hasher.Verify(r.Context(), req.Password, hashPassword)
+	if errors.Is(errCompare, password.ErrBusy) {
+		// пароль не проверен, поэтому и неудачной попыткой это не считается
+		a.log.Warn("сравнение пароля и сохраненного хеша. хеширование перегружено", slog.String("логин", req.Login))
+		writeHashError(w, errCompare)
+		return
+	}
+	if errCompare != nil {
+		a.log.Error("сравнение пароля и сохраненного хеша", slog.String("логин", req.Login), slog.String("ошибка", errCompare.Error()))
+	}
+	if err != nil || !match {
+		a.log.Info("неудачная попытка входа", slog.String("логин", req.Login), slog.String("ip", ip))
+		a.registerLoginFailure(r.Context(), req.Login, ip)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+	// хеш сделан устаревшим алгоритмом или с устаревшими параметрами. пока пароль известен, пересчитываем его
+	if needsRehash {
+		a.rehashPassword(r.Context(), credentials, req.Password)
+	}
+	// при включенной двухфакторной аутентификации сессию создаем только после проверки второго фактора.
+	// счетчик неудачных попыток тоже сбросится только тогда, иначе подбор кода можно было бы разбавлять вводом известного пароля
+	if credentials.TOTPEnabled {
+		a.startMFAChallenge(w, r, credentials)
+		return
+	}
+	// успешный вход сбрасывает счетчик логина. счетчик ip не сбрасываем, иначе перебор можно разбавлять входом в свой аккаунт
+	err = a.storage.ResetLoginFailures(r.Context(), model.LoginAttemptsLoginKey(req.Login))
 	if err != nil {
-		a.log.Error("поиск пользователя.", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+		a.log.Error("сброс неудачных попыток входа", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+	}
+
+	// создаем новую сессию и выдаем токены
+	tokens, err := a.startSession(r.Context(), w, UserClaims{UserID: credentials.UserID, Login: credentials.Login, Role: credentials.Role})
+	if err != nil {
+		a.log.Error("создание сессии", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	hashPassword, err := a.storage.HashPassword(r.Context(), userID)
-	if errors.Is(err, storage.ErrUserNotFound) {
-		a.log.Info("получение хеша пароля. пользователь не найден", slog.String("логин", req.Login))
+
+	a.writeTokens(w, tokens)
+}
+
+// rehashPassword пересчитывает хеш пароля пользователя текущим алгоритмом. Ошибки только логируются - вход от них не зависит
+func (a *AppServer) rehashPassword(ctx context.Context, credentials model.UserCredentials, password string) {
+	newHash, err := a.hasher.Hash(ctx, password)
+	if err != nil {
+		a.log.Error("пересчет хеша пароля", slog.String("логин", credentials.Login), slog.String("ошибка", err.Error()))
+		return
+	}
+	err = a.storage.RehashPassword(ctx, credentials.UserID, credentials.PasswordHash, newHash)
+	if err != nil && !errors.Is(err, storage.ErrNothingHasBeenDone) {
+		a.log.Error("сохранение пересчитанного хеша пароля", slog.String("логин", credentials.Login), slog.String("ошибка", err.Error()))
+		return
+	}
+	a.log.Info("хеш пароля пересчитан", slog.String("логин", credentials.Login))
+}
+
+// rRefreshToken хендлер для обновления пары токенов по refresh токену. Старый refresh токен после этого становится недействительным
+func (a *AppServer) rRefreshToken(w http.ResponseWriter, r *http.Request) {
+	// refresh токен можно передать в теле запроса (для клиентов без кук), либо он будет взят из куки
+	presented := ""
+	if checkContentType(r, []string{"application/json"}) {
+		req := model.RequestRefreshToken{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		// пустое тело допустимо - тогда токен берем из куки
+		if err != nil && !errors.Is(err, io.EOF) {
+			a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		presented = req.RefreshToken
+	}
+	if presented == "" {
+		cookieRefresh, err := r.Cookie(a.config.CookieRefreshTokenName())
+		if err == nil {
+			presented = cookieRefresh.Value
+		}
+	}
+	if presented == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+
+	// генерируем новый refresh токен и заменяем им старый
+	refreshToken, refreshHash, err := newRandomToken()
 	if err != nil {
-		a.log.Error("получение хеша пароля.", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+		a.log.Error("генерация refresh токена", slog.String("ошибка", err.Error()))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(hashPassword), []byte(req.Password))
-	if err != nil {
-		a.log.Error("сравнение пароля и сохраненного хеша", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+	session, err := a.storage.RotateSession(r.Context(), hashToken(presented), refreshHash, time.Now().Add(a.config.RefreshTokenTTL()))
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		a.log.Info("обновление токенов. сессия не найдена")
+		a.clearSessionTokens(w)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		a.log.Error("обновление токенов", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	// генерируем новый токен
-	token, err := buildJWTString(userID, req.Login, a.config.Secret(), 24*time.Hour)
+	tokens, err := a.setSessionTokens(w, UserClaims{UserID: session.UserID, Login: session.Login, Role: session.Role, MFA: session.MFA, SessionID: session.SessionID}, refreshToken)
 	if err != nil {
-		a.log.Error("генерация токена", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+		a.log.Error("выдача токенов", slog.String("логин", session.Login), slog.String("ошибка", err.Error()))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// выставляем новый токен в куках, чтобы пользователь дальше его продолжил использовать
-	http.SetCookie(w, &http.Cookie{Name: a.config.CookieTokenName(), Value: token})
+	a.writeTokens(w, tokens)
+}
+
+// rLogout хендлер для выхода пользователя. Отзывает текущую сессию, после чего ни токен доступа, ни refresh токен больше не принимаются
+func (a *AppServer) rLogout(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok || uc.SessionID == (uuid.UUID{}) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err := a.storage.RevokeSession(r.Context(), uc.SessionID)
+	if err != nil {
+		a.log.Error("отзыв сессии", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.clearSessionTokens(w)
+	w.WriteHeader(http.StatusOK)
+}
 
+// rRevokeToken хендлер для отзыва текущего токена доступа (по jti). Сессия при этом остается, новый токен можно получить по refresh токену
+func (a *AppServer) rRevokeToken(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok || uc.TokenID == (uuid.UUID{}) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err := a.storage.RevokeToken(r.Context(), uc.TokenID, uc.TokenExpiresAt)
+	if err != nil {
+		a.log.Error("отзыв токена", slog.String("логин", uc.Login), slog.String("токен", uc.TokenID.String()), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, a.tokenCookie(a.config.CookieTokenName(), "", -1))
 	w.WriteHeader(http.StatusOK)
 }
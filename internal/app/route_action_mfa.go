@@ -0,0 +1,380 @@
+// в этом файле описаны методы двухфакторной аутентификации: подключение TOTP, коды восстановления и второй шаг входа
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+	"github.com/kTowkA/gophermart/internal/totp"
+)
+
+const (
+	// recoveryCodesCount сколько кодов восстановления выдается за раз
+	recoveryCodesCount = 10
+	// totpSkew сколько соседних шагов времени принимается при проверке кода
+	totpSkew = 1
+)
+
+// startMFAChallenge отвечает на верный пароль пользователя с включенной двухфакторной аутентификацией: выдает токен для второго шага входа
+func (a *AppServer) startMFAChallenge(w http.ResponseWriter, r *http.Request, credentials model.UserCredentials) {
+	token, tokenHash, err := newRandomToken()
+	if err != nil {
+		a.log.Error("генерация токена второго шага входа", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = a.storage.CreateMFAChallenge(r.Context(), credentials.UserID, tokenHash, time.Now().Add(a.config.MFATokenTTL()))
+	if err != nil {
+		a.log.Error("создание второго шага входа", slog.String("логин", credentials.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("cache-control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(model.ResponseMFARequired{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(a.config.MFATokenTTL().Seconds()),
+	})
+	if err != nil {
+		a.log.Error("отправка токена второго шага входа", slog.String("ошибка", err.Error()))
+	}
+}
+
+// rLoginMFA хендлер второго шага входа: по токену первого шага и коду из приложения (или коду восстановления) выдает токены сессии
+func (a *AppServer) rLoginMFA(w http.ResponseWriter, r *http.Request) {
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := model.RequestSecondFactor{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if req.MFAToken == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	challenge, err := a.storage.MFAChallenge(r.Context(), hashToken(req.MFAToken))
+	if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение второго шага входа", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !a.checkSecondFactor(w, r, challenge.UserID, challenge.Login, req, http.StatusUnauthorized) {
+		return
+	}
+	// токен второго шага одноразовый. если параллельный запрос уже им воспользовался, второй вход не создаем
+	err = a.storage.DeleteMFAChallenge(r.Context(), hashToken(req.MFAToken))
+	if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		a.log.Error("удаление второго шага входа", slog.String("логин", challenge.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = a.storage.ResetLoginFailures(r.Context(), model.LoginAttemptsLoginKey(challenge.Login))
+	if err != nil {
+		a.log.Error("сброс неудачных попыток входа", slog.String("логин", challenge.Login), slog.String("ошибка", err.Error()))
+	}
+
+	tokens, err := a.startSession(r.Context(), w, UserClaims{UserID: challenge.UserID, Login: challenge.Login, Role: challenge.Role, MFA: true})
+	if err != nil {
+		a.log.Error("создание сессии", slog.String("логин", challenge.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.writeTokens(w, tokens)
+}
+
+// checkSecondFactor проверяет второй фактор пользователя с учетом блокировки входа. Неверный код засчитывается как неудачная попытка входа.
+// При неудаче сам отвечает клиенту (failStatus при неверном коде) и возвращает false
+func (a *AppServer) checkSecondFactor(w http.ResponseWriter, r *http.Request, userID uuid.UUID, login string, req model.RequestSecondFactor, failStatus int) bool {
+	ip := clientIP(r)
+	attempts, err := a.storage.LoginAttempts(r.Context(), model.LoginAttemptsLoginKey(login), model.LoginAttemptsIPKey(ip))
+	if err != nil {
+		a.log.Error("получение неудачных попыток входа", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if wait := a.loginRetryAfter(attempts, login, time.Now()); wait > 0 {
+		a.log.Info("слишком частые попытки ввода второго фактора", slog.String("логин", login), slog.String("ip", ip), slog.Duration("ожидание", wait))
+		writeRetryAfter(w, wait)
+		return false
+	}
+	ok, err := a.verifySecondFactor(r.Context(), userID, req)
+	if err != nil {
+		a.log.Error("проверка второго фактора", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		a.log.Info("неверный второй фактор", slog.String("логин", login), slog.String("ip", ip))
+		a.registerLoginFailure(r.Context(), login, ip)
+		w.WriteHeader(failStatus)
+		return false
+	}
+	return true
+}
+
+// verifySecondFactor проверяет код из приложения или код восстановления. Принятый код повторно не принимается
+func (a *AppServer) verifySecondFactor(ctx context.Context, userID uuid.UUID, req model.RequestSecondFactor) (bool, error) {
+	switch {
+	case req.Code != "":
+		secret, err := a.storage.TOTP(ctx, userID)
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !secret.Confirmed {
+			return false, nil
+		}
+		step, ok := totp.Verify(secret.Secret, req.Code, time.Now(), totpSkew)
+		if !ok || step <= secret.LastStep {
+			return false, nil
+		}
+		err = a.storage.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, storage.ErrNothingHasBeenDone) {
+			return false, nil
+		}
+		return err == nil, err
+	case req.RecoveryCode != "":
+		err := a.storage.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(req.RecoveryCode)))
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return false, nil
+	}
+}
+
+// rTOTPEnroll хендлер начала подключения TOTP. Выдает новый секрет, который заработает после подтверждения кодом
+func (a *AppServer) rTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		a.log.Error("генерация секрета TOTP", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = a.storage.SaveTOTP(r.Context(), uc.UserID, secret)
+	if errors.Is(err, storage.ErrTOTPAlreadyEnabled) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.log.Error("сохранение секрета TOTP", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("cache-control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(model.ResponseTOTPEnroll{
+		Secret: secret,
+		URI:    totp.URI(a.config.MFAIssuer(), uc.Login, secret),
+	})
+	if err != nil {
+		a.log.Error("отправка секрета TOTP", slog.String("ошибка", err.Error()))
+	}
+}
+
+// rTOTPConfirm хендлер подтверждения TOTP кодом из приложения. Включает двухфакторную аутентификацию и выдает коды восстановления
+func (a *AppServer) rTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := model.RequestSecondFactor{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	secret, err := a.storage.TOTP(r.Context(), uc.UserID)
+	if errors.Is(err, storage.ErrTOTPNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение секрета TOTP", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if secret.Confirmed {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	step, ok := totp.Verify(secret.Secret, req.Code, time.Now(), totpSkew)
+	if !ok {
+		a.log.Info("подтверждение TOTP. неверный код", slog.String("логин", uc.Login))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		a.log.Error("генерация кодов восстановления", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = a.storage.ConfirmTOTP(r.Context(), uc.UserID, step, hashes)
+	if errors.Is(err, storage.ErrNothingHasBeenDone) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.log.Error("подтверждение TOTP", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("включена двухфакторная аутентификация", slog.String("логин", uc.Login))
+	a.writeRecoveryCodes(w, codes)
+}
+
+// rTOTPDisable хендлер отключения двухфакторной аутентификации. Требует действующий второй фактор
+func (a *AppServer) rTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	uc, req, ok := a.secondFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	if !a.checkSecondFactor(w, r, uc.UserID, uc.Login, req, http.StatusForbidden) {
+		return
+	}
+	err := a.storage.DisableTOTP(r.Context(), uc.UserID)
+	if err != nil {
+		a.log.Error("отключение TOTP", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("отключена двухфакторная аутентификация", slog.String("логин", uc.Login))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rRecoveryCodes хендлер выпуска новых кодов восстановления взамен прежних. Требует действующий второй фактор
+func (a *AppServer) rRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	uc, req, ok := a.secondFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	if !a.checkSecondFactor(w, r, uc.UserID, uc.Login, req, http.StatusForbidden) {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		a.log.Error("генерация кодов восстановления", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = a.storage.ReplaceRecoveryCodes(r.Context(), uc.UserID, hashes)
+	if err != nil {
+		a.log.Error("замена кодов восстановления", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.writeRecoveryCodes(w, codes)
+}
+
+// secondFactorRequest разбирает запрос со вторым фактором пользователя с включенной двухфакторной аутентификацией.
+// Если аутентификация не включена, отвечает 404
+func (a *AppServer) secondFactorRequest(w http.ResponseWriter, r *http.Request) (UserClaims, model.RequestSecondFactor, bool) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return UserClaims{}, model.RequestSecondFactor{}, false
+	}
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return UserClaims{}, model.RequestSecondFactor{}, false
+	}
+	req := model.RequestSecondFactor{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return UserClaims{}, model.RequestSecondFactor{}, false
+	}
+	defer r.Body.Close()
+
+	secret, err := a.storage.TOTP(r.Context(), uc.UserID)
+	if errors.Is(err, storage.ErrTOTPNotFound) || (err == nil && !secret.Confirmed) {
+		w.WriteHeader(http.StatusNotFound)
+		return UserClaims{}, model.RequestSecondFactor{}, false
+	}
+	if err != nil {
+		a.log.Error("получение секрета TOTP", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return UserClaims{}, model.RequestSecondFactor{}, false
+	}
+	return uc, req, true
+}
+
+// writeRecoveryCodes отправляет коды восстановления в теле ответа
+func (a *AppServer) writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("cache-control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(model.ResponseRecoveryCodes{Codes: codes})
+	if err != nil {
+		a.log.Error("отправка кодов восстановления", slog.String("ошибка", err.Error()))
+	}
+}
+
+// newRecoveryCodes генерирует коды восстановления вида xxxx-xxxx и их хеши для хранения
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	b := make([]byte, 5)
+	for i := 0; i < recoveryCodesCount; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode приводит введенный код восстановления к виду, от которого считается хеш: без разделителей и в нижнем регистре
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,261 @@
+// в этом файле описаны методы смены и сброса пароля пользователя
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/notifier"
+	"github.com/kTowkA/gophermart/internal/password"
+	"github.com/kTowkA/gophermart/internal/policy"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+// rChangePassword хендлер для смены пароля авторизованным пользователем. Все сессии пользователя отзываются, взамен выдаются токены новой сессии
+func (a *AppServer) rChangePassword(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := model.RequestChangePassword{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if errs := a.policy.ValidatePassword(req.NewPassword, policy.NormalizeLogin(uc.Login)); len(errs) > 0 {
+		a.log.Info("смена пароля. пароль не соответствует правилам", slog.String("логин", uc.Login), slog.Any("нарушения", errs))
+		a.writeValidationErrors(w, errs)
+		return
+	}
+
+	// проверяем старый пароль
+	hashPassword, err := a.storage.HashPassword(r.Context(), uc.UserID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		a.log.Info("получение хеша пароля. пользователь не найден", slog.String("логин", uc.Login))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение хеша пароля", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	match, _, err := a.hasher.Verify(r.Context(), req.OldPassword, hashPassword)
+	if errors.Is(err, password.ErrBusy) {
+		a.log.Warn("сравнение пароля и сохраненного хеша. хеширование перегружено", slog.String("логин", uc.Login))
+		writeHashError(w, err)
+		return
+	}
+	if err != nil {
+		a.log.Error("сравнение пароля и сохраненного хеша", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+	}
+	if !match {
+		a.log.Info("смена пароля. старый пароль не совпадает", slog.String("логин", uc.Login))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	newHash, err := a.hasher.Hash(r.Context(), req.NewPassword)
+	if err != nil {
+		a.log.Error("генерация пароля", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		writeHashError(w, err)
+		return
+	}
+	err = a.storage.ChangePassword(r.Context(), uc.UserID, newHash)
+	if err != nil {
+		a.log.Error("смена пароля", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// текущая сессия отозвана вместе с остальными, поэтому сразу выдаем токены новой
+	tokens, err := a.startSession(r.Context(), w, uc)
+	if err != nil {
+		a.log.Error("создание сессии", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.writeTokens(w, tokens)
+}
+
+// rPasswordReset хендлер запроса на сброс пароля. Ответ всегда 202, чтобы по нему нельзя было понять, существует ли логин.
+// Токен создается и отправляется уже после ответа из очереди passwordResets, так что и время ответа от этого не зависит.
+// Число запросов ограничено для логина и ip адреса, иначе пользователя можно завалить письмами и постоянно сбрасывать его токен
+func (a *AppServer) rPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := model.RequestPasswordReset{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	req.Login = policy.NormalizeLogin(req.Login)
+	if req.Login == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ip := clientIP(r)
+	wait, err := a.passwordResetRetryAfter(r.Context(), req.Login, ip)
+	if err != nil {
+		a.log.Error("учет запросов на сброс пароля", slog.String("логин", req.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		a.log.Info("слишком частые запросы на сброс пароля", slog.String("логин", req.Login), slog.String("ip", ip), slog.Duration("ожидание", wait))
+		writeRetryAfter(w, wait)
+		return
+	}
+
+	select {
+	case a.passwordResets <- req.Login:
+	default:
+		a.log.Warn("очередь запросов на сброс пароля заполнена", slog.String("логин", req.Login))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// passwordResetRetryAfter учитывает запрос на сброс пароля для ip адреса и логина и возвращает, через сколько можно повторить запрос.
+// Нулевое значение - запрос разрешен. Окно подсчета отсчитывается от первого запроса, поэтому отклоненные запросы его не продлевают.
+// Сначала проверяется ip адрес: запросы с заблокированного адреса не расходуют лимит логина
+func (a *AppServer) passwordResetRetryAfter(ctx context.Context, login, ip string) (time.Duration, error) {
+	now := time.Now()
+	window := a.config.PasswordResetWindow()
+	limits := []struct {
+		key         string
+		maxRequests int
+	}{
+		{key: model.PasswordResetIPKey(ip), maxRequests: a.config.PasswordResetIPMaxRequests()},
+		{key: model.PasswordResetLoginKey(login), maxRequests: a.config.PasswordResetMaxRequests()},
+	}
+	for _, l := range limits {
+		requests, err := a.storage.RegisterPasswordResetRequest(ctx, l.key, now.Add(-window))
+		if err != nil {
+			return 0, err
+		}
+		if requests.Requests > l.maxRequests {
+			return max(requests.WindowStartedAt.Add(window).Sub(now), time.Second), nil
+		}
+	}
+	return 0, nil
+}
+
+// initPasswordResets создает очередь запросов на сброс пароля
+func (a *AppServer) initPasswordResets() {
+	a.passwordResets = make(chan string, a.config.PasswordResetQueue())
+}
+
+// runPasswordResets отправляет токены сброса пароля из очереди по одному, пока не отменен ctx.
+// Так число одновременных отправок и записей в базу не зависит от числа запросов
+func (a *AppServer) runPasswordResets(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			if n := len(a.passwordResets); n > 0 {
+				a.log.Warn("остановка отправки токенов сброса пароля. запросы в очереди не обработаны", slog.Int("запросов", n))
+			}
+			return
+		case login := <-a.passwordResets:
+			err := a.sendPasswordReset(ctx, login)
+			if err != nil {
+				a.log.Error("отправка токена сброса пароля", slog.String("логин", login), slog.String("ошибка", err.Error()))
+			}
+		}
+	}
+}
+
+// sendPasswordReset создает токен сброса пароля для логина login и отправляет его пользователю. Для несуществующего логина ничего не делает
+func (a *AppServer) sendPasswordReset(ctx context.Context, login string) error {
+	userID, err := a.storage.UserID(ctx, login)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		a.log.Info("сброс пароля. пользователь не найден", slog.String("логин", login))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("поиск пользователя. %w", err)
+	}
+	token, tokenHash, err := newRandomToken()
+	if err != nil {
+		return fmt.Errorf("генерация токена. %w", err)
+	}
+	err = a.storage.CreatePasswordReset(ctx, userID, tokenHash, time.Now().Add(a.config.PasswordResetTTL()))
+	if err != nil {
+		return fmt.Errorf("сохранение токена. %w", err)
+	}
+	return a.notifier.Notify(ctx, notifier.Message{
+		To:      login,
+		Subject: "Сброс пароля",
+		Body: fmt.Sprintf(
+			"Для сброса пароля отправьте токен %s на /api/user/password/reset/confirm. Токен действует %s",
+			token,
+			a.config.PasswordResetTTL(),
+		),
+	})
+}
+
+// rPasswordResetConfirm хендлер сброса пароля по токену. Токен одноразовый, все сессии пользователя отзываются
+func (a *AppServer) rPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := model.RequestPasswordResetConfirm{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if req.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if errs := a.policy.ValidatePassword(req.NewPassword, ""); len(errs) > 0 {
+		a.log.Info("сброс пароля. пароль не соответствует правилам", slog.Any("нарушения", errs))
+		a.writeValidationErrors(w, errs)
+		return
+	}
+
+	newHash, err := a.hasher.Hash(r.Context(), req.NewPassword)
+	if err != nil {
+		a.log.Error("генерация пароля", slog.String("ошибка", err.Error()))
+		writeHashError(w, err)
+		return
+	}
+	userID, err := a.storage.RedeemPasswordReset(r.Context(), hashToken(req.Token), newHash)
+	if errors.Is(err, storage.ErrPasswordResetNotFound) {
+		a.log.Info("сброс пароля. токен не найден")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		a.log.Error("сброс пароля", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("пароль сброшен", slog.String("userID", userID.String()))
+	w.WriteHeader(http.StatusOK)
+}
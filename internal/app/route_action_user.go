@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"log/slog"
 	"net/http"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/kTowkA/gophermart/internal/luhn"
 	"github.com/kTowkA/gophermart/internal/model"
 	"github.com/kTowkA/gophermart/internal/storage"
@@ -34,6 +36,10 @@ func (a *AppServer) rOrdersPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	orderErr := a.storage.SaveOrder(r.Context(), uc.UserID, model.OrderNumber(orderBytes))
+	// при приеме событий даем системе расчета время сообщить о заказе самой, опрос - только если событие не придет
+	if orderErr.HTTPStatus == http.StatusAccepted && a.config.AccrualWebhookSecret() != "" {
+		a.deferAccrualPolling(r.Context(), model.OrderNumber(orderBytes))
+	}
 	w.WriteHeader(orderErr.HTTPStatus)
 }
 func (a *AppServer) rOrdersGet(w http.ResponseWriter, r *http.Request) {
@@ -58,3 +64,28 @@ func (a *AppServer) rOrdersGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// rOrderHistory история статусов заказа пользователя: когда и откуда пришел каждый статус
+func (a *AppServer) rOrderHistory(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	orderNum := model.OrderNumber(chi.URLParam(r, "number"))
+	history, err := a.storage.OrderHistory(r.Context(), uc.UserID, orderNum)
+	if errors.Is(err, storage.ErrOrdersNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение истории статусов заказа", slog.String("заказ", string(orderNum)), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	err = json.NewEncoder(w).Encode(history)
+	if err != nil {
+		a.log.Error("отправка истории статусов заказа", slog.String("ошибка", err.Error()))
+	}
+}
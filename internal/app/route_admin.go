@@ -0,0 +1,190 @@
+// в этом файле описаны методы администрирования для сотрудников поддержки и администраторов
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/policy"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+// loginFromURL логин пользователя из пути запроса в каноническом виде
+func loginFromURL(r *http.Request) (string, bool) {
+	login, err := url.PathUnescape(chi.URLParam(r, "login"))
+	if err != nil || login == "" {
+		return "", false
+	}
+	return policy.NormalizeLogin(login), true
+}
+
+// rAdminUser хендлер получения информации о пользователе
+func (a *AppServer) rAdminUser(w http.ResponseWriter, r *http.Request) {
+	login, ok := loginFromURL(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user, err := a.storage.User(r.Context(), login)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение информации о пользователе", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(user)
+	if err != nil {
+		a.log.Error("отправка информации о пользователе", slog.String("ошибка", err.Error()))
+	}
+}
+
+// rAdminSetRole хендлер назначения роли пользователю. Свою роль менять нельзя, чтобы не остаться без администратора
+func (a *AppServer) rAdminSetRole(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	login, ok := loginFromURL(r)
+	if !ok || !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := model.RequestRole{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if errs := policy.ValidateRole(req.Role); len(errs) > 0 {
+		a.writeValidationErrors(w, errs)
+		return
+	}
+	if login == uc.Login {
+		a.log.Warn("попытка изменить собственную роль", slog.String("логин", uc.Login))
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	err = a.storage.SetUserRole(r.Context(), login, req.Role)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log.Error("назначение роли", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("назначена роль", slog.String("кто", uc.Login), slog.String("логин", login), slog.String("роль", req.Role))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rAdminRevokeSessions хендлер завершения всех сессий пользователя. Например, при подозрении на кражу аккаунта
+func (a *AppServer) rAdminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	login, ok := loginFromURL(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	userID, err := a.storage.UserID(r.Context(), login)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение пользователя", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = a.storage.RevokeUserSessions(r.Context(), userID)
+	if err != nil {
+		a.log.Error("отзыв сессий пользователя", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("сессии пользователя отозваны", slog.String("кто", uc.Login), slog.String("логин", login))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rAdminUnlock хендлер снятия блокировки входа для логина и (или) ip адреса
+func (a *AppServer) rAdminUnlock(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := model.RequestUnlock{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if req.Login == "" && req.IP == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	keys := []string{}
+	if req.Login != "" {
+		keys = append(keys, model.LoginAttemptsLoginKey(policy.NormalizeLogin(req.Login)))
+	}
+	if req.IP != "" {
+		keys = append(keys, model.LoginAttemptsIPKey(req.IP))
+	}
+	for _, key := range keys {
+		err = a.storage.ResetLoginFailures(r.Context(), key)
+		if err != nil {
+			a.log.Error("снятие блокировки входа", slog.String("ключ", key), slog.String("ошибка", err.Error()))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		a.log.Info("блокировка входа снята", slog.String("кто", uc.Login), slog.String("ключ", key))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rAdminAccrual хендлер получения состояния опроса системы расчета на этом экземпляре: выключателя и ограничения частоты запросов
+func (a *AppServer) rAdminAccrual(w http.ResponseWriter, r *http.Request) {
+	state := model.ResponseAccrualState{
+		Instance:  a.config.InstanceID(),
+		Breaker:   a.accrualBreaker.State().String(),
+		RateLimit: a.accrualLimiter.Rate(),
+	}
+	if until := a.accrualBreaker.OpenUntil(); !until.IsZero() {
+		state.BreakerOpenUntil = &until
+	}
+	if until := a.accrualLimiter.PausedUntil(); until.After(time.Now()) {
+		state.PausedUntil = &until
+	}
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(state)
+	if err != nil {
+		a.log.Error("отправка состояния опроса системы расчета", slog.String("ошибка", err.Error()))
+	}
+}
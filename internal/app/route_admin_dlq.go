@@ -0,0 +1,129 @@
+// в этом файле описаны методы администрирования очереди недоставленных: заказов, по которым система расчета так и не вернула окончательный статус
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+const (
+	// deadLettersDefaultLimit сколько заказов очереди недоставленных отдается, если лимит не указан
+	deadLettersDefaultLimit = 100
+	// deadLettersMaxLimit больше стольких заказов очереди недоставленных за раз не отдаем
+	deadLettersMaxLimit = 1000
+)
+
+// queryInt целое неотрицательное число из параметра запроса name. Если параметра нет, возвращает def
+func queryInt(r *http.Request, name string, def int) (int, bool) {
+	val := r.URL.Query().Get(name)
+	if val == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// rAdminDeadLetters хендлер получения очереди недоставленных. Поддерживает пагинацию параметрами limit и offset
+func (a *AppServer) rAdminDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, okLimit := queryInt(r, "limit", deadLettersDefaultLimit)
+	offset, okOffset := queryInt(r, "offset", 0)
+	if !okLimit || !okOffset || limit == 0 || limit > deadLettersMaxLimit {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dls, err := a.storage.DeadLetters(r.Context(), limit, offset)
+	if errors.Is(err, storage.ErrOrdersNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение очереди недоставленных", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(dls)
+	if err != nil {
+		a.log.Error("отправка очереди недоставленных", slog.String("ошибка", err.Error()))
+	}
+}
+
+// rAdminDeadLetter хендлер получения заказа из очереди недоставленных с последней ошибкой и числом попыток
+func (a *AppServer) rAdminDeadLetter(w http.ResponseWriter, r *http.Request) {
+	orderNum := model.OrderNumber(chi.URLParam(r, "number"))
+	dl, err := a.storage.DeadLetter(r.Context(), orderNum)
+	if errors.Is(err, storage.ErrOrdersNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение заказа из очереди недоставленных", slog.String("заказ", string(orderNum)), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(dl)
+	if err != nil {
+		a.log.Error("отправка заказа из очереди недоставленных", slog.String("ошибка", err.Error()))
+	}
+}
+
+// rAdminRequeueDeadLetter хендлер возврата заказа из очереди недоставленных на проверку в систему расчета
+func (a *AppServer) rAdminRequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	orderNum := model.OrderNumber(chi.URLParam(r, "number"))
+	err := a.storage.RequeueDeadLetter(r.Context(), orderNum)
+	if errors.Is(err, storage.ErrOrdersNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log.Error("возврат заказа из очереди недоставленных", slog.String("заказ", string(orderNum)), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("заказ возвращен из очереди недоставленных на проверку", slog.String("кто", uc.Login), slog.String("заказ", string(orderNum)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rAdminInvalidateDeadLetter хендлер перевода заказа из очереди недоставленных в статус INVALID
+func (a *AppServer) rAdminInvalidateDeadLetter(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	orderNum := model.OrderNumber(chi.URLParam(r, "number"))
+	err := a.storage.InvalidateDeadLetter(r.Context(), orderNum)
+	if errors.Is(err, storage.ErrOrdersNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrIllegalTransition) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.log.Error("перевод заказа из очереди недоставленных в INVALID", slog.String("заказ", string(orderNum)), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("заказ из очереди недоставленных переведен в INVALID", slog.String("кто", uc.Login), slog.String("заказ", string(orderNum)))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+// в этом файле описаны методы управления api ключами пользователя
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/policy"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+// apiKeyPrefix начало всех api ключей. По нему ключ легко найти, если он случайно попал в код или логи
+const apiKeyPrefix = "gm_"
+
+// apiKeyDisplayLength сколько первых символов ключа храним для отображения в списке
+const apiKeyDisplayLength = len(apiKeyPrefix) + 8
+
+// rAPIKeyCreate хендлер выпуска нового api ключа. Ключ возвращается только в этом ответе, дальше хранится лишь его хеш
+func (a *AppServer) rAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !checkContentType(r, []string{"application/json"}) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req := model.RequestAPIKey{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		a.log.Error("декодирование запроса", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if errs := policy.ValidateAPIKey(req.Name, req.Scopes); len(errs) > 0 {
+		a.writeValidationErrors(w, errs)
+		return
+	}
+
+	token, _, err := newRandomToken()
+	if err != nil {
+		a.log.Error("генерация api ключа", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	apiKey := apiKeyPrefix + token
+	key, err := a.storage.CreateAPIKey(r.Context(), uc.UserID, req.Name, hashToken(apiKey), apiKey[:apiKeyDisplayLength], req.Scopes)
+	if err != nil {
+		a.log.Error("сохранение api ключа", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("cache-control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(model.ResponseAPIKeyCreated{APIKey: key, Key: apiKey})
+	if err != nil {
+		a.log.Error("отправка api ключа", slog.String("ошибка", err.Error()))
+	}
+}
+
+// rAPIKeys хендлер списка действующих api ключей пользователя
+func (a *AppServer) rAPIKeys(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	keys, err := a.storage.APIKeys(r.Context(), uc.UserID)
+	if errors.Is(err, storage.ErrAPIKeysNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		a.log.Error("получение api ключей", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	err = json.NewEncoder(w).Encode(keys)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// rAPIKeyRevoke хендлер отзыва api ключа
+func (a *AppServer) rAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	uc, ok := (r.Context().Value(userClaims{})).(UserClaims)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	keyID, err := uuid.Parse(chi.URLParam(r, "keyID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = a.storage.RevokeAPIKey(r.Context(), uc.UserID, keyID)
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log.Error("отзыв api ключа", slog.String("логин", uc.Login), slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
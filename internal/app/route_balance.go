@@ -40,6 +40,11 @@ func (a *AppServer) rWithdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
+	// отрицательные суммы и суммы точнее сотых отклоняются при декодировании
+	if req.Sum == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	_, ok := luhn.ValidateLuhnNumber(string(req.OrderNumber))
 	if !ok {
@@ -0,0 +1,19 @@
+package app
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// rJWKS отдает открытые ключи проверки токенов, чтобы другие сервисы могли проверять сессии сами
+func (a *AppServer) rJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("cache-control", "public, max-age=300")
+	err := json.NewEncoder(w).Encode(a.keys.JWKS())
+	if err != nil {
+		a.log.Error("отправка ключей проверки", slog.String("ошибка", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,117 @@
+// в этом файле содержатся функции для работы с сессиями пользователя: выпуск пары токенов, refresh токены и куки
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/model"
+)
+
+// newRandomToken генерирует новый случайный токен (refresh токен, токен сброса пароля) и возвращает его вместе с хешом для хранения
+func newRandomToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken хеш от токена. В хранилище храним только его, чтобы утечка базы не давала доступ к сессиям
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession создает новую сессию пользователя и выставляет токены в куках. Возвращает выданные токены.
+// Из uc используются данные пользователя и признак MFA, идентификатор сессии заполняется здесь
+func (a *AppServer) startSession(ctx context.Context, w http.ResponseWriter, uc UserClaims) (model.ResponseToken, error) {
+	refreshToken, refreshHash, err := newRandomToken()
+	if err != nil {
+		return model.ResponseToken{}, fmt.Errorf("генерация refresh токена. %w", err)
+	}
+	uc.SessionID, err = a.storage.CreateSession(ctx, uc.UserID, refreshHash, time.Now().Add(a.config.RefreshTokenTTL()), uc.MFA)
+	if err != nil {
+		return model.ResponseToken{}, fmt.Errorf("создание сессии. %w", err)
+	}
+	return a.setSessionTokens(w, UserClaims{UserID: uc.UserID, Login: uc.Login, Role: uc.Role, MFA: uc.MFA, SessionID: uc.SessionID}, refreshToken)
+}
+
+// setSessionTokens выпускает токен доступа для сессии и выставляет его вместе с refresh токеном в куках
+func (a *AppServer) setSessionTokens(w http.ResponseWriter, uc UserClaims, refreshToken string) (model.ResponseToken, error) {
+	token, err := buildJWTString(uc, a.keys, a.config, a.config.AccessTokenTTL())
+	if err != nil {
+		return model.ResponseToken{}, fmt.Errorf("генерация токена. %w", err)
+	}
+	// выставляем новые токены в куках, чтобы пользователь дальше их продолжил использовать
+	http.SetCookie(w, a.tokenCookie(a.config.CookieTokenName(), token, a.config.AccessTokenTTL()))
+	http.SetCookie(w, a.tokenCookie(a.config.CookieRefreshTokenName(), refreshToken, a.config.RefreshTokenTTL()))
+	return model.ResponseToken{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(a.config.AccessTokenTTL().Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// clearSessionTokens удаляет куки с токенами
+func (a *AppServer) clearSessionTokens(w http.ResponseWriter) {
+	http.SetCookie(w, a.tokenCookie(a.config.CookieTokenName(), "", -1))
+	http.SetCookie(w, a.tokenCookie(a.config.CookieRefreshTokenName(), "", -1))
+}
+
+// tokenCookie кука с токеном с атрибутами из конфигурации. При отрицательном ttl кука удаляется
+func (a *AppServer) tokenCookie(name, value string, ttl time.Duration) *http.Cookie {
+	maxAge := int(ttl.Seconds())
+	if ttl < 0 {
+		maxAge = -1
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     a.config.CookiePath(),
+		Domain:   a.config.CookieDomain(),
+		MaxAge:   maxAge,
+		Secure:   a.config.CookieSecure(),
+		HttpOnly: true,
+		SameSite: a.config.CookieSameSite(),
+	}
+}
+
+// writeTokens отправляет выданные токены в теле ответа
+func (a *AppServer) writeTokens(w http.ResponseWriter, tokens model.ResponseToken) {
+	w.Header().Add("content-type", "application/json")
+	// токены не должны оседать в кешах
+	w.Header().Add("cache-control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(tokens)
+	if err != nil {
+		a.log.Error("отправка токенов", slog.String("ошибка", err.Error()))
+	}
+}
+
+// tokenFromRequest получает токен доступа из заголовка Authorization (схема Bearer), а при его отсутствии - из куки cookieName
+func tokenFromRequest(r *http.Request, cookieName string) (string, bool) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, found := strings.Cut(auth, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", false
+		}
+		return strings.TrimSpace(token), true
+	}
+	cookieToken, err := r.Cookie(cookieName)
+	if err != nil || cookieToken.Value == "" {
+		return "", false
+	}
+	return cookieToken.Value, true
+}
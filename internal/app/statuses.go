@@ -4,18 +4,79 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
-	"github.com/go-resty/resty/v2"
+	"github.com/kTowkA/gophermart/internal/accrual"
+	"github.com/kTowkA/gophermart/internal/backoff"
+	"github.com/kTowkA/gophermart/internal/breaker"
 	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/ratelimit"
 	"github.com/kTowkA/gophermart/internal/storage"
 )
 
-// updaterStatus это конвейер для взаимодействия с внешней системой расчета баллов лояльности. На первом шаге получаем заказы с неокончательными статусами и записываем их в канал, далее читаем эти заказы из канала и делаем обращение к внешней системе расчета баллов лояльности, результат записываем в канал и далее читаем из этого канала и обновляем в нашей базе данных соответсвующие заказы
+// accrualLeaderTask имя задачи опроса системы расчета при выборе ведущего экземпляра
+const accrualLeaderTask = "gophermart-accrual-updater"
+
+// runUpdater запускает опрос системы расчета до отмены контекста. При config.AccrualLeaderElection опрос ведет только ведущий экземпляр:
+// остальные раз в config.AccrualLeaderInterval пытаются занять его место, а при потере блокировки ведущий останавливает опрос
+func (a *AppServer) runUpdater(ctx context.Context) {
+	if !a.config.AccrualLeaderElection() {
+		a.updaterStatus(ctx)
+		return
+	}
+	interval := a.config.AccrualLeaderInterval()
+	for {
+		leader, err := a.storage.TryLead(ctx, accrualLeaderTask, interval)
+		switch {
+		case err == nil:
+			a.leadUpdater(ctx, leader)
+		case errors.Is(err, storage.ErrNotLeader):
+			a.log.Debug("опрос системы расчета ведет другой экземпляр")
+		default:
+			a.log.Error("выбор ведущего экземпляра", slog.String("ошибка", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// leadUpdater ведет опрос системы расчета, пока экземпляр ведущий, и отпускает блокировку
+func (a *AppServer) leadUpdater(ctx context.Context, leader storage.Leader) {
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-leader.Lost():
+			a.log.Warn("экземпляр больше не ведущий. опрос системы расчета остановлен")
+			cancel()
+		case <-leadCtx.Done():
+		}
+	}()
+	a.updaterStatus(leadCtx)
+
+	// контекст приложения уже может быть отменен, а блокировку нужно отпустить, чтобы другой экземпляр не ждал обрыва соединения
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), time.Duration(a.config.ShutdownServerSec())*time.Second)
+	defer cancelRelease()
+	if err := leader.Release(releaseCtx); err != nil {
+		a.log.Error("снятие блокировки ведущего", slog.String("ошибка", err.Error()))
+	}
+}
+
+// updaterStatus это конвейер для взаимодействия с внешней системой расчета баллов лояльности. На первом шаге получаем заказы с неокончательными статусами и записываем их в канал, далее читаем эти заказы из канала и делаем обращение к внешней системе расчета баллов лояльности, результат записываем в канал и далее читаем из этого канала и обновляем в нашей базе данных соответсвующие заказы.
+// Результаты сохраняются группами, если config.AccrualUpdateBatchSize больше 1, иначе по одному
 func (a *AppServer) updaterStatus(ctx context.Context) {
-	a.updateOrders(
+	update := a.updateOrders
+	if a.config.AccrualUpdateBatchSize() > 1 {
+		update = a.updateOrdersGroup
+	}
+	update(
 		ctx,
 		a.gettingInfoFromAccuralSystem(
 			ctx,
@@ -40,18 +101,24 @@ func (a *AppServer) updateOrders(ctx context.Context, accuralInfo <-chan model.R
 				"заказ не найден",
 				slog.String("заказ", string(ai.OrderNumber)),
 			)
+		case errors.Is(err, storage.ErrIllegalTransition):
+			a.log.Warn(
+				"недопустимая смена статуса заказа",
+				slog.String("заказ", string(ai.OrderNumber)),
+				slog.String("статус", string(ai.Status.Value())),
+			)
 		case errors.Is(err, storage.ErrNothingHasBeenDone):
 			a.log.Info(
 				"попытка повторного обновления",
 				slog.String("заказ", string(ai.OrderNumber)),
-				slog.Float64("начислено баллов", ai.Accrual),
+				slog.String("начислено баллов", ai.Accrual.String()),
 				slog.String("статус", string(ai.Status.Value())),
 			)
 		case err != nil:
 			a.log.Error(
 				"обновление заказа",
 				slog.String("заказ", string(ai.OrderNumber)),
-				slog.Float64("начислено баллов", ai.Accrual),
+				slog.String("начислено баллов", ai.Accrual.String()),
 				slog.String("статус", string(ai.Status.Value())),
 				slog.String("ошибка", err.Error()),
 			)
@@ -59,99 +126,379 @@ func (a *AppServer) updateOrders(ctx context.Context, accuralInfo <-chan model.R
 			a.log.Debug(
 				"заказ обновлен",
 				slog.String("заказ", string(ai.OrderNumber)),
-				slog.Float64("начислено баллов", ai.Accrual),
+				slog.String("начислено баллов", ai.Accrual.String()),
 				slog.String("статус", string(ai.Status.Value())),
 			)
 		}
 	}
 }
 
-// updateOrdersGroup обновляет сразу группу. Можно использовать эту функцию вместо updateOrders, единственное - при тикере больше чем время получения новых  заказов с определенными статусами, могут быть дубли
+// pendingUpdate результат системы расчета, ожидающий сохранения, и число неудачных попыток его сохранить
+type pendingUpdate struct {
+	info     model.ResponseAccuralSystem
+	attempts int
+}
+
+// updateOrdersGroup сохраняет результаты группами: когда набралось config.AccrualUpdateBatchSize новых результатов или прошел config.AccrualUpdateBatchInterval.
+// Результаты, не сохраненные из-за ошибки, повторяются со следующей группой до config.AccrualUpdateRetries раз.
+// Накопленное ограничено config.AccrualUpdateBufferSize: отброшенные результаты не теряются, заказы будут опрошены снова после истечения аренды.
+// При отмене ctx уже полученные результаты сохраняются последний раз, иначе они терялись бы при каждой остановке или потере ведущего
 func (a *AppServer) updateOrdersGroup(ctx context.Context, accuralInfo <-chan model.ResponseAccuralSystem) {
-	toRecord := make([]model.ResponseAccuralSystem, 0, 100)
-	ticker := time.NewTicker(time.Duration(a.config.UpdateGroupStatusesSec()) * time.Second)
+	batchSize := a.config.AccrualUpdateBatchSize()
+	bufferSize := max(a.config.AccrualUpdateBufferSize(), batchSize)
+	pending := make([]pendingUpdate, 0, batchSize)
+	// сколько результатов осталось после прошлого сохранения. по размеру группы считаем только новые, иначе при недоступной базе будем сохранять на каждый результат
+	retained := 0
+	ticker := time.NewTicker(a.config.AccrualUpdateBatchInterval())
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			a.log.Debug("получен сигнал остановки. Выходим из функции обновления группы заказов")
+			a.flushOrdersOnStop(pending, accuralInfo)
 			return
-		case ai := <-accuralInfo:
-			toRecord = append(toRecord, ai)
-			continue
+		case ai, ok := <-accuralInfo:
+			if !ok {
+				a.flushOrders(ctx, pending)
+				return
+			}
+			pending = addPendingUpdate(pending, ai)
+			if len(pending)-retained < batchSize {
+				continue
+			}
 		case <-ticker.C:
-			if len(toRecord) == 0 {
+			if len(pending) == 0 {
 				continue
 			}
 		}
-		success, err := a.storage.UpdateOrders(ctx, toRecord)
-		a.log.Debug("сохранение группы заказов", slog.Int("сохранено успешно", success), slog.Int("всего", len(toRecord)))
-		if err != nil {
-			a.log.Error("сохранение группы заказов", slog.String("ошибка", err.Error()))
-			continue
+		pending = a.flushOrders(ctx, pending)
+		if over := len(pending) - bufferSize; over > 0 {
+			a.log.Warn("переполнение очереди сохранения заказов. старые результаты отброшены", slog.Int("отброшено", over))
+			pending = pending[over:]
 		}
-		toRecord = make([]model.ResponseAccuralSystem, 0, 100)
+		retained = len(pending)
 	}
 }
 
-// gettingInfoFromAccuralSystem запрос к внешней системе расчета баллов лояльности
+// flushOrdersOnStop сохраняет накопленные результаты и те, что уже ждут в канале, после отмены контекста обработчика.
+// Контекст обработчика уже отменен, поэтому сохранение идет со своим ограничением по времени, как и снятие блокировки ведущего
+func (a *AppServer) flushOrdersOnStop(pending []pendingUpdate, accuralInfo <-chan model.ResponseAccuralSystem) {
+	for drained := false; !drained; {
+		select {
+		case ai, ok := <-accuralInfo:
+			if !ok {
+				drained = true
+				continue
+			}
+			pending = addPendingUpdate(pending, ai)
+		default:
+			drained = true
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), time.Duration(a.config.ShutdownServerSec())*time.Second)
+	defer cancelFlush()
+	if retry := a.flushOrders(flushCtx, pending); len(retry) > 0 {
+		a.log.Warn("остановка сохранения заказов. часть результатов не сохранена, заказы будут опрошены снова", slog.Int("не сохранено", len(retry)))
+	}
+}
+
+// addPendingUpdate добавляет результат в очередь на сохранение. Если по заказу уже ждет результат, он заменяется более новым
+func addPendingUpdate(pending []pendingUpdate, ai model.ResponseAccuralSystem) []pendingUpdate {
+	for i := range pending {
+		if pending[i].info.OrderNumber == ai.OrderNumber {
+			pending[i] = pendingUpdate{info: ai}
+			return pending
+		}
+	}
+	return append(pending, pendingUpdate{info: ai})
+}
+
+// flushOrders сохраняет группу результатов и возвращает те, что нужно повторить
+func (a *AppServer) flushOrders(ctx context.Context, pending []pendingUpdate) []pendingUpdate {
+	if len(pending) == 0 {
+		return pending
+	}
+	info := make([]model.ResponseAccuralSystem, len(pending))
+	for i := range pending {
+		info[i] = pending[i].info
+	}
+	results, err := a.storage.UpdateOrders(ctx, info)
+	if err != nil {
+		// группа не сохранена целиком, например база недоступна. попытки строк не тратим, размер очереди ограничен
+		a.log.Error("сохранение группы заказов", slog.Int("всего", len(pending)), slog.String("ошибка", err.Error()))
+		return pending
+	}
+	retry := pending[:0]
+	updated := 0
+	for i, err := range results {
+		ai := pending[i].info
+		switch {
+		case err == nil:
+			updated++
+		case errors.Is(err, storage.ErrOrdersNotFound):
+			a.log.Info("заказ не найден", slog.String("заказ", string(ai.OrderNumber)))
+		case errors.Is(err, storage.ErrNothingHasBeenDone):
+		case errors.Is(err, storage.ErrIllegalTransition):
+			a.log.Warn("недопустимая смена статуса заказа", slog.String("заказ", string(ai.OrderNumber)), slog.String("статус", ai.Status.Value()))
+		case errors.Is(err, storage.ErrUnknownStatus):
+			a.log.Error("неизвестный статус заказа", slog.String("заказ", string(ai.OrderNumber)), slog.String("статус", ai.Status.Value()))
+		case pending[i].attempts+1 >= a.config.AccrualUpdateRetries():
+			a.log.Error(
+				"обновление заказа. попытки исчерпаны",
+				slog.String("заказ", string(ai.OrderNumber)),
+				slog.String("статус", ai.Status.Value()),
+				slog.String("ошибка", err.Error()),
+			)
+		default:
+			retry = append(retry, pendingUpdate{info: ai, attempts: pending[i].attempts + 1})
+		}
+	}
+	a.log.Debug("сохранение группы заказов", slog.Int("обновлено", updated), slog.Int("к повтору", len(retry)), slog.Int("всего", len(pending)))
+	return retry
+}
+
+// gettingInfoFromAccuralSystem запрос к внешней системе расчета баллов лояльности. Заказы разбирает пул из config.AccrualWorkers обработчиков,
+// общая частота запросов ограничивается accrualLimiter, при недоступности системы расчета запросы останавливает accrualBreaker. Канал с результатами закрывается, когда все обработчики завершились:
+// по отмене контекста или когда закрыт канал заказов
 func (a *AppServer) gettingInfoFromAccuralSystem(ctx context.Context, orders <-chan model.ResponseOrder) chan model.ResponseAccuralSystem {
 	accuralInfo := make(chan model.ResponseAccuralSystem, 100)
-	go func(ctx context.Context, orders <-chan model.ResponseOrder) {
-		defer close(accuralInfo)
-		req := resty.
-			New().
-			AddRetryCondition(func(r *resty.Response, err error) bool {
-				return err != nil || r.StatusCode() == http.StatusTooManyRequests
-			}).
-			SetRetryCount(3).
-			SetRetryWaitTime(5 * time.Second).
-			SetBaseURL(a.config.AccruralSystemAddress()).
-			R()
-		for {
-			select {
-			case <-ctx.Done():
-				a.log.Debug("получен сигнал остановки. Выходим из функции запросов к внешней системе расчета баллов лояльности")
+	// номера заказов, которые сейчас проверяются. один и тот же заказ может попасть в канал повторно, пока идет запрос по нему
+	inFlight := &sync.Map{}
+
+	workers := max(a.config.AccrualWorkers(), 1)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(worker int) {
+			defer wg.Done()
+			a.accrualWorker(ctx, inFlight, orders, accuralInfo)
+			a.log.Debug("обработчик запросов к системе расчета баллов лояльности завершен", slog.Int("обработчик", worker))
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(accuralInfo)
+	}()
+	return accuralInfo
+}
+
+// accrualWorker обработчик из пула запросов к системе расчета. Берет заказы из orders, пока канал не закрыт и не отменен контекст
+func (a *AppServer) accrualWorker(ctx context.Context, inFlight *sync.Map, orders <-chan model.ResponseOrder, accuralInfo chan<- model.ResponseAccuralSystem) {
+	for {
+		var o model.ResponseOrder
+		select {
+		case <-ctx.Done():
+			a.log.Debug("получен сигнал остановки. Выходим из функции запросов к внешней системе расчета баллов лояльности")
+			return
+		case order, ok := <-orders:
+			if !ok {
 				return
-			case o := <-orders:
-				a.log.Debug("получили новый заказ для проверки расчета баллов", slog.String("номер заказа", string(o.OrderNumber)), slog.String("статус", o.Status.Value()))
-				result := model.ResponseAccuralSystem{}
-				resp, err := req.SetContext(ctx).SetResult(&result).Get("/api/orders/" + string(o.OrderNumber))
-				if err != nil {
-					a.log.Error(
-						"обращение к системе расчета баллов",
-						slog.String("BaseURL", a.config.AccruralSystemAddress()),
-						slog.String("path", "/api/orders/"+string(o.OrderNumber)),
-						slog.String("ошибка", err.Error()),
-					)
-					continue
-				}
-				a.log.Debug(
-					"результат обращения к системе расчета баллов лояльности",
-					slog.Int("статус", resp.StatusCode()),
-					slog.String("заказ", string(o.OrderNumber)),
-					slog.Any("result", result),
-				)
-				switch resp.StatusCode() {
-				case http.StatusOK:
-					accuralInfo <- result
-				case http.StatusNoContent:
-					a.log.Info("система расчета баллов лояльности вернула статус, что заказ не зарегистрирован", slog.String("заказ", string(o.OrderNumber)))
-				default:
-					a.log.Info("система расчета баллов лояльности вернула код ошибки", slog.String("код", resp.Status()))
-				}
 			}
+			o = order
 		}
-	}(ctx, orders)
-	return accuralInfo
+		if _, loaded := inFlight.LoadOrStore(o.OrderNumber, struct{}{}); loaded {
+			a.log.Debug("заказ уже проверяется в системе расчета баллов", slog.String("номер заказа", string(o.OrderNumber)))
+			continue
+		}
+		result, failure, ok := a.checkAccrual(ctx, o)
+		// при остановке заказ просто остается захваченным до истечения аренды, попыткой это не считаем
+		if ctx.Err() == nil && !(ok && isFinalStatus(result.Status)) {
+			a.scheduleRecheck(ctx, o, failure)
+		}
+		inFlight.Delete(o.OrderNumber)
+		if !ok {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			a.log.Debug("получен сигнал остановки. Выходим из функции запросов к внешней системе расчета баллов лояльности")
+			return
+		case accuralInfo <- result:
+		}
+	}
+}
+
+// checkAccrual проверяет заказ в системе расчета. Возвращает результат и true, если система расчета вернула информацию по заказу.
+// Если окончательного статуса нет, то в failure описано, почему
+func (a *AppServer) checkAccrual(ctx context.Context, o model.ResponseOrder) (model.ResponseAccuralSystem, model.AccrualFailure, bool) {
+	a.log.Debug("получили новый заказ для проверки расчета баллов", slog.String("номер заказа", string(o.OrderNumber)), slog.String("статус", o.Status.Value()))
+	resp, err := a.requestAccrual(ctx, o.OrderNumber)
+	if err != nil {
+		if ctx.Err() == nil {
+			a.log.Error(
+				"обращение к системе расчета баллов",
+				slog.String("BaseURL", a.config.AccruralSystemAddress()),
+				slog.String("path", "/api/orders/"+string(o.OrderNumber)),
+				slog.String("ошибка", err.Error()),
+			)
+		}
+		return model.ResponseAccuralSystem{}, model.AccrualFailure{Error: err.Error()}, false
+	}
+	a.log.Debug(
+		"результат обращения к системе расчета баллов лояльности",
+		slog.Int("статус", resp.StatusCode),
+		slog.String("заказ", string(o.OrderNumber)),
+		slog.Any("result", resp.Order),
+	)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if resp.Order.AccrualReported != "" {
+			a.log.Warn("начисление в ответе системы расчета точнее сотых, лишние знаки отброшены",
+				slog.String("заказ", string(o.OrderNumber)),
+				slog.String("прислано", resp.Order.AccrualReported),
+				slog.String("принято", resp.Order.Accrual.String()))
+		}
+		resp.Order.Source = model.StatusSourcePoller
+		return resp.Order, model.AccrualFailure{Error: "окончательный статус не получен: " + resp.Order.Status.Value(), HTTPStatus: resp.StatusCode}, true
+	case http.StatusNoContent:
+		a.log.Info("система расчета баллов лояльности вернула статус, что заказ не зарегистрирован", slog.String("заказ", string(o.OrderNumber)))
+		return model.ResponseAccuralSystem{}, model.AccrualFailure{Error: "заказ не зарегистрирован в системе расчета", HTTPStatus: resp.StatusCode}, false
+	default:
+		status := fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		a.log.Info("система расчета баллов лояльности вернула код ошибки", slog.String("код", status))
+		return model.ResponseAccuralSystem{}, model.AccrualFailure{Error: "система расчета вернула код ошибки: " + status, HTTPStatus: resp.StatusCode}, false
+	}
+}
+
+// scheduleRecheck назначает следующую проверку заказа, который не получил окончательный статус, с экспоненциально растущей паузой.
+// После config.AccrualMaxAttempts попыток заказ переводится в очередь недоставленных и больше не проверяется.
+// Вместе с заказом сохраняется последняя ошибка failure, чтобы ее было видно в очереди недоставленных
+func (a *AppServer) scheduleRecheck(ctx context.Context, o model.ResponseOrder, failure model.AccrualFailure) {
+	failure.Attempts = o.Attempts + 1
+	if maxAttempts := a.config.AccrualMaxAttempts(); maxAttempts > 0 && failure.Attempts >= maxAttempts {
+		err := a.storage.DeadLetterOrder(ctx, a.config.InstanceID(), o.OrderNumber, failure)
+		if err != nil {
+			a.log.Error("перевод заказа в очередь недоставленных", slog.String("заказ", string(o.OrderNumber)), slog.String("ошибка", err.Error()))
+			return
+		}
+		a.log.Warn(
+			"заказ переведен в очередь недоставленных",
+			slog.String("заказ", string(o.OrderNumber)),
+			slog.Int("попыток", failure.Attempts),
+			slog.String("последняя ошибка", failure.Error),
+		)
+		return
+	}
+	policy := backoff.Policy{
+		Base:   a.config.AccrualBackoffBase(),
+		Max:    a.config.AccrualBackoffMax(),
+		Jitter: a.config.AccrualBackoffJitter(),
+	}
+	nextCheckAt := time.Now().Add(policy.Delay(failure.Attempts))
+	err := a.storage.ScheduleOrderCheck(ctx, a.config.InstanceID(), o.OrderNumber, failure, nextCheckAt)
+	if err != nil {
+		a.log.Error("назначение следующей проверки заказа", slog.String("заказ", string(o.OrderNumber)), slog.String("ошибка", err.Error()))
+	}
+}
+
+// isFinalStatus окончательный ли статус заказа. Заказы с такими статусами больше не проверяются в системе расчета
+func isFinalStatus(st model.Status) bool {
+	return st.Value() == storage.StatusProcessed.Value() || st.Value() == storage.StatusInvalid.Value()
+}
+
+// requestAccrual запрашивает у системы расчета информацию по заказу. Каждый запрос проходит через автоматический выключатель
+// и общий ограничитель частоты. Сетевые ошибки и ответы 5xx считаются отказами системы расчета: после серии отказов выключатель
+// размыкается, и запросы ждут, пока он не пропустит пробный.
+// На ответ 429 все запросы приостанавливаются на время из заголовка Retry-After, частота снижается и запрос повторяется.
+// Возвращает ошибку только при сетевой ошибке или отмене контекста
+func (a *AppServer) requestAccrual(ctx context.Context, number model.OrderNumber) (accrual.Response, error) {
+	for {
+		if err := a.accrualBreaker.Wait(ctx); err != nil {
+			return accrual.Response{}, err
+		}
+		if err := a.accrualLimiter.Wait(ctx); err != nil {
+			a.accrualBreaker.Cancel()
+			return accrual.Response{}, err
+		}
+		resp, err := a.accrual.Order(ctx, number)
+		if err != nil {
+			if ctx.Err() != nil {
+				a.accrualBreaker.Cancel()
+			} else {
+				a.accrualBreaker.Failure()
+			}
+			return accrual.Response{}, err
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			a.accrualBreaker.Failure()
+			return resp, nil
+		}
+		// система расчета отвечает, даже если ограничивает нас по частоте
+		a.accrualBreaker.Success()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			if resp.StatusCode == http.StatusOK {
+				a.accrualLimiter.Succeeded()
+			}
+			return resp, nil
+		}
+
+		pause := resp.RetryAfter
+		if !resp.HasRetryAfter {
+			pause = a.config.AccrualRetryAfter()
+		}
+		rate := a.accrualLimiter.Throttled(pause)
+		a.log.Warn(
+			"система расчета баллов лояльности ограничила частоту запросов",
+			slog.String("заказ", string(number)),
+			slog.Duration("пауза", pause),
+			slog.Float64("лимит запросов в секунду", rate),
+		)
+	}
+}
+
+// initAccrual создает общие для всех обработчиков ограничитель частоты и автоматический выключатель запросов к системе расчета.
+// Если клиент системы расчета не задан заранее, используется http клиент по адресу из конфигурации. Ошибка - некорректные параметры в конфигурации
+func (a *AppServer) initAccrual() error {
+	if a.accrual == nil {
+		a.accrual = accrual.NewHTTPClient(a.config.AccruralSystemAddress())
+	}
+	limiter, err := ratelimit.New(ratelimit.Config{
+		Rate:     a.config.AccrualRate(),
+		MinRate:  a.config.AccrualRateMin(),
+		MaxRate:  a.config.AccrualRateMax(),
+		Burst:    a.config.AccrualBurst(),
+		Increase: a.config.AccrualRateIncrease(),
+	})
+	if err != nil {
+		return fmt.Errorf("ограничение частоты запросов к системе расчета. %w", err)
+	}
+	a.accrualLimiter = limiter
+	if a.config.AccrualUpdateBatchInterval() <= 0 {
+		return fmt.Errorf("интервал сохранения результатов системы расчета должен быть положительным: %s", a.config.AccrualUpdateBatchInterval())
+	}
+	a.accrualBreaker = breaker.New(
+		breaker.Config{
+			FailureThreshold: a.config.AccrualBreakerFailures(),
+			OpenTimeout:      a.config.AccrualBreakerOpenTimeout(),
+			HalfOpenRequests: a.config.AccrualBreakerProbes(),
+		},
+		func(from, to breaker.State) {
+			if to == breaker.Open {
+				a.log.Warn(
+					"система расчета баллов лояльности недоступна. запросы приостановлены",
+					slog.String("было", from.String()),
+					slog.String("стало", to.String()),
+					slog.Duration("пауза", a.config.AccrualBreakerOpenTimeout()),
+				)
+				return
+			}
+			a.log.Info("состояние выключателя запросов к системе расчета баллов", slog.String("было", from.String()), slog.String("стало", to.String()))
+		},
+	)
+	return nil
 }
 
-// gettingOrders используется для получения заказов с определенными статусами
+// gettingOrders захватывает заказы с неокончательными статусами и передает их на проверку во внешнюю систему расчета баллов лояльности.
+// Заказы захватываются в аренду на config.AccrualLeaseTTL, поэтому несколько экземпляров приложения не опрашивают одни и те же заказы,
+// а заказы упавшего экземпляра снова становятся доступны, когда истечет его аренда.
+// Пока аренда не истекла, заказ повторно не захватывается - это же задает и частоту проверки одного заказа
 func (a *AppServer) gettingOrders(ctx context.Context) chan model.ResponseOrder {
-	ordersCh := make(chan model.ResponseOrder, 100)
-	// максимум заказов за запрос
-	limit := 100
-	// начальное смещение 0
-	offset := 0
+	limit := a.config.AccrualBatchSize()
+	// канал не больше пачки: следующую пачку захватываем, только когда предыдущая разобрана, иначе аренда истечет в очереди
+	ordersCh := make(chan model.ResponseOrder, limit)
 	go func() {
 		defer close(ordersCh)
 
@@ -172,47 +519,49 @@ func (a *AppServer) gettingOrders(ctx context.Context) chan model.ResponseOrder
 			default:
 			}
 
-			// получаем заказы
-			orders, err := a.storage.OrdersByStatuses(ctx, wantSt, limit, offset)
+			// пока система расчета недоступна, заказы не захватываем: их все равно не проверить, а аренда будет истекать впустую
+			if until := a.accrualBreaker.OpenUntil(); !until.IsZero() {
+				a.log.Debug("опрос системы расчета приостановлен", slog.Time("до", until))
+				select {
+				case <-ctx.Done():
+					a.log.Debug("получен сигнал остановки. Выходим из функции получения заказов с определенными статусами")
+					return
+				case <-time.After(time.Until(until)):
+				}
+				continue
+			}
+
+			// захватываем заказы
+			orders, err := a.storage.ClaimOrders(ctx, a.config.InstanceID(), wantSt, limit, a.config.AccrualLeaseTTL())
 			if err != nil && !errors.Is(err, storage.ErrOrdersNotFound) {
 				a.log.Error(
-					"запрос заказов",
+					"захват заказов",
 					slog.Any("статусы по запросу", stVal),
 					slog.Int("лимит", limit),
-					slog.Int("смещение", offset),
 					slog.String("ошибка", err.Error()))
-				continue
 			}
-			a.log.Debug("запрос заказов", slog.Any("всего заказов", len(orders)), slog.Any("статусы по запросу", stVal), slog.Int("лимит", limit), slog.Int("смещение", offset))
-			if errors.Is(err, storage.ErrOrdersNotFound) {
+			a.log.Debug("захват заказов", slog.Any("всего заказов", len(orders)), slog.Any("статусы по запросу", stVal), slog.Int("лимит", limit))
+
+			// заказы были найдены
+			for _, o := range orders {
+				a.log.Debug("подходящий заказ был захвачен", slog.String("номер заказа", string(o.OrderNumber)))
 				select {
 				case <-ctx.Done():
 					a.log.Debug("получен сигнал остановки. Выходим из функции получения заказов с определенными статусами")
 					return
-				case <-time.After(5 * time.Second): // ждем перед новой попыткой запроса
+				case ordersCh <- o:
 				}
-				// сбрасываем смещение
-				offset = 0
-				continue
-			}
-
-			// заказы были найдены
-			for _, o := range orders {
-				a.log.Debug("подходящий заказ был найден", slog.String("номер заказа", string(o.OrderNumber)), slog.String("статус", o.Status.Value()))
-				ordersCh <- o
 			}
 
+			// свободных заказов больше нет (или произошла ошибка) - ждем перед новой попыткой
 			if len(orders) < limit {
 				select {
 				case <-ctx.Done():
 					a.log.Debug("получен сигнал остановки. Выходим из функции получения заказов с определенными статусами")
 					return
-				case <-time.After(5 * time.Second): // ждем перед новой попыткой запроса
+				case <-time.After(a.config.AccrualPollInterval()):
 				}
-				continue
 			}
-
-			offset += limit
 		}
 	}()
 	return ordersCh
@@ -0,0 +1,442 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/accrual"
+	accrualmocks "github.com/kTowkA/gophermart/internal/accrual/mocs"
+	"github.com/kTowkA/gophermart/internal/accrual/stub"
+	"github.com/kTowkA/gophermart/internal/breaker"
+	"github.com/kTowkA/gophermart/internal/config"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+	mocks "github.com/kTowkA/gophermart/internal/storage/mocs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccrualRetryAfter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []time.Time
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, time.Now())
+		first := len(requests) == 1
+		mu.Unlock()
+		// первый запрос упирается в лимит системы расчета
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		_, _ = w.Write([]byte(`{"order":"1","status":"PROCESSED","accrual":500}`))
+	}))
+	defer srv.Close()
+
+	a := &AppServer{
+		config: config.NewConfig("", "", srv.URL, "secret", config.WithAccrualRateLimit(100, 1, 100, 1, time.Minute)),
+		log:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	require.NoError(t, a.initAccrual())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	orders := make(chan model.ResponseOrder, 1)
+	orders <- model.ResponseOrder{OrderNumber: "1"}
+	info := a.gettingInfoFromAccuralSystem(ctx, orders)
+
+	select {
+	case ai := <-info:
+		assert.EqualValues(t, "1", ai.OrderNumber)
+		assert.Equal(t, model.Money(50000), ai.Accrual)
+	case <-ctx.Done():
+		require.FailNow(t, "информация о заказе не получена")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 2)
+	// повторный запрос только после паузы из Retry-After
+	assert.GreaterOrEqual(t, requests[1].Sub(requests[0]), time.Second)
+	// после 429 частота снижена
+	assert.Less(t, a.accrualLimiter.Rate(), 100.0)
+}
+
+func TestAccrualWorkers(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		mu.Lock()
+		requests = append(requests, number)
+		mu.Unlock()
+		// держим запросы, пока все заказы не разобраны обработчиками
+		<-release
+		w.Header().Set("content-type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"order":%q,"status":"PROCESSED","accrual":1}`, number)
+	}))
+	defer srv.Close()
+
+	a := &AppServer{
+		config: config.NewConfig(
+			"", "", srv.URL, "secret",
+			config.WithAccrualRateLimit(1000, 1, 1000, 10, time.Minute),
+			config.WithAccrualWorkers(3),
+		),
+		log: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	require.NoError(t, a.initAccrual())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	orders := make(chan model.ResponseOrder, 3)
+	info := a.gettingInfoFromAccuralSystem(ctx, orders)
+	// заказ 1 приходит дважды, пока первый запрос по нему еще идет
+	orders <- model.ResponseOrder{OrderNumber: "1"}
+	orders <- model.ResponseOrder{OrderNumber: "1"}
+	orders <- model.ResponseOrder{OrderNumber: "2"}
+	close(orders)
+
+	// заказы 1 и 2 проверяются одновременно разными обработчиками
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	close(release)
+
+	got := []model.OrderNumber{}
+	for ai := range info {
+		got = append(got, ai.OrderNumber)
+	}
+	assert.ElementsMatch(t, []model.OrderNumber{"1", "2"}, got)
+	assert.ElementsMatch(t, []string{"1", "2"}, requests)
+}
+
+func TestAccrualWorkersShutdown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	a := &AppServer{
+		config: config.NewConfig("", "", srv.URL, "secret", config.WithAccrualWorkers(2)),
+		log:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	require.NoError(t, a.initAccrual())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	orders := make(chan model.ResponseOrder, 1)
+	orders <- model.ResponseOrder{OrderNumber: "1"}
+	info := a.gettingInfoFromAccuralSystem(ctx, orders)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	// по отмене контекста обработчики прерывают запросы и канал результатов закрывается
+	select {
+	case _, ok := <-info:
+		assert.False(t, ok)
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "обработчики не завершились")
+	}
+}
+
+func TestAccrualRecheck(t *testing.T) {
+	srv := httptest.NewServer(stub.New(stub.Script{
+		// остальные заказы рассчитаны
+		Default: []stub.Step{{Status: "PROCESSED", Accrual: 1}},
+		Orders: map[model.OrderNumber][]stub.Step{
+			// система расчета еще не знает о заказе
+			"1": {{Code: http.StatusNoContent}},
+			"2": {{Status: "PROCESSING"}},
+		},
+	}))
+	defer srv.Close()
+
+	mockStorage := mocks.NewStorage(t)
+	a := &AppServer{
+		storage: mockStorage,
+		config: config.NewConfig(
+			"", "", srv.URL, "secret",
+			config.WithAccrualPolling("test-instance", time.Minute, 10, time.Second),
+			config.WithAccrualBackoff(time.Second, time.Minute, 0, 5),
+		),
+		log: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	require.NoError(t, a.initAccrual())
+
+	start := time.Now()
+	// неизвестный системе расчета заказ откладывается на паузу, растущую с числом попыток
+	mockStorage.On("ScheduleOrderCheck", mock.Anything, "test-instance", model.OrderNumber("1"), model.AccrualFailure{
+		Attempts:   3,
+		Error:      "заказ не зарегистрирован в системе расчета",
+		HTTPStatus: http.StatusNoContent,
+	}, mock.MatchedBy(func(next time.Time) bool {
+		return !next.Before(start.Add(4*time.Second)) && next.Before(time.Now().Add(5*time.Second))
+	})).Return(nil).Once()
+	// после последней попытки заказ уходит в очередь недоставленных
+	mockStorage.On("DeadLetterOrder", mock.Anything, "test-instance", model.OrderNumber("2"), model.AccrualFailure{
+		Attempts:   5,
+		Error:      "окончательный статус не получен: PROCESSING",
+		HTTPStatus: http.StatusOK,
+	}).Return(nil).Once()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	orders := make(chan model.ResponseOrder, 3)
+	orders <- model.ResponseOrder{OrderNumber: "1", Attempts: 2}
+	orders <- model.ResponseOrder{OrderNumber: "2", Attempts: 4}
+	// заказ с окончательным статусом больше не проверяется
+	orders <- model.ResponseOrder{OrderNumber: "3", Attempts: 4}
+	close(orders)
+
+	got := []model.OrderNumber{}
+	for ai := range a.gettingInfoFromAccuralSystem(ctx, orders) {
+		got = append(got, ai.OrderNumber)
+	}
+	assert.ElementsMatch(t, []model.OrderNumber{"2", "3"}, got)
+}
+
+func TestAccrualBreaker(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	mockStorage := mocks.NewStorage(t)
+	a := &AppServer{
+		storage: mockStorage,
+		config: config.NewConfig(
+			"", "", srv.URL, "secret",
+			config.WithAccrualPolling("test-instance", time.Minute, 10, time.Second),
+			config.WithAccrualWorkers(1),
+			config.WithAccrualBreaker(2, time.Hour, 1),
+		),
+		log: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	require.NoError(t, a.initAccrual())
+	mockStorage.On("ScheduleOrderCheck", mock.Anything, "test-instance", mock.Anything, mock.MatchedBy(func(f model.AccrualFailure) bool {
+		return f.HTTPStatus == http.StatusInternalServerError
+	}), mock.Anything).Return(nil).Twice()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	orders := make(chan model.ResponseOrder, 3)
+	orders <- model.ResponseOrder{OrderNumber: "1"}
+	orders <- model.ResponseOrder{OrderNumber: "2"}
+	orders <- model.ResponseOrder{OrderNumber: "3"}
+	info := a.gettingInfoFromAccuralSystem(ctx, orders)
+
+	// после двух ошибок подряд выключатель размыкается и третий заказ в систему расчета не уходит
+	require.Eventually(t, func() bool { return a.accrualBreaker.State() == breaker.Open }, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 2, requests)
+	mu.Unlock()
+
+	// пока выключатель разомкнут, новые заказы не захватываются
+	pollCtx, pollCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer pollCancel()
+	for range a.gettingOrders(pollCtx) {
+		assert.Fail(t, "заказ захвачен при разомкнутом выключателе")
+	}
+	mockStorage.AssertNotCalled(t, "ClaimOrders", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	cancel()
+	for range info {
+	}
+}
+
+func TestAccrualClientError(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	mockClient := accrualmocks.NewClient(t)
+	a := &AppServer{
+		storage: mockStorage,
+		accrual: mockClient,
+		config:  config.NewConfig("", "", "", "secret", config.WithAccrualPolling("test-instance", time.Minute, 10, time.Second)),
+		log:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	require.NoError(t, a.initAccrual())
+
+	// ответ не получен - попытка засчитывается с текстом ошибки и без кода ответа
+	mockClient.On("Order", mock.Anything, model.OrderNumber("1")).Return(accrual.Response{}, errors.New("connection refused")).Once()
+	mockStorage.On("ScheduleOrderCheck", mock.Anything, "test-instance", model.OrderNumber("1"), model.AccrualFailure{
+		Attempts: 1,
+		Error:    "connection refused",
+	}, mock.Anything).Return(nil).Once()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	orders := make(chan model.ResponseOrder, 1)
+	orders <- model.ResponseOrder{OrderNumber: "1"}
+	close(orders)
+	for range a.gettingInfoFromAccuralSystem(ctx, orders) {
+		assert.Fail(t, "получена информация по заказу без ответа системы расчета")
+	}
+}
+
+func TestUpdateOrdersGroup(t *testing.T) {
+	processed := func(numbers ...model.OrderNumber) []model.ResponseAccuralSystem {
+		info := make([]model.ResponseAccuralSystem, len(numbers))
+		for i, n := range numbers {
+			info[i] = model.ResponseAccuralSystem{OrderNumber: n, Status: storage.StatusProcessed, Accrual: 1}
+		}
+		return info
+	}
+	errRow := errors.New("deadlock detected")
+	errDown := errors.New("connection refused")
+
+	mockStorage := mocks.NewStorage(t)
+	a := &AppServer{
+		storage: mockStorage,
+		config:  config.NewConfig("", "", "", "secret", config.WithAccrualUpdateBatch(2, time.Hour, 3, 2)),
+		log:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	// ошибка одной строки не мешает остальным, строка повторяется со следующей группой
+	mockStorage.On("UpdateOrders", mock.Anything, processed("1", "2")).Return([]error{nil, errRow}, nil).Once()
+	// после исчерпания попыток строка отбрасывается
+	mockStorage.On("UpdateOrders", mock.Anything, processed("2", "3", "4")).Return([]error{errRow, nil, storage.ErrNothingHasBeenDone}, nil).Once()
+	// группа целиком не сохранилась - ждет следующего сохранения, а переполнение вытесняет самые старые результаты
+	mockStorage.On("UpdateOrders", mock.Anything, processed("5", "6")).Return(nil, errDown).Once()
+	mockStorage.On("UpdateOrders", mock.Anything, processed("5", "6", "7", "8")).Return(nil, errDown).Once()
+	// при закрытии канала сохраняется остаток
+	mockStorage.On("UpdateOrders", mock.Anything, processed("6", "7", "8")).Return([]error{nil, nil, nil}, nil).Once()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	info := make(chan model.ResponseAccuralSystem)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.updateOrdersGroup(ctx, info)
+	}()
+	// повторный результат по заказу заменяет ожидающий
+	for _, ai := range processed("1", "1", "2", "3", "4", "5", "6", "7", "8") {
+		info <- ai
+	}
+	close(info)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("сохранение группы заказов не завершилось")
+	}
+}
+
+func TestUpdateOrdersGroupStop(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	a := &AppServer{
+		storage: mockStorage,
+		config:  config.NewConfig("", "", "", "secret", config.WithAccrualUpdateBatch(10, time.Hour, 100, 2)),
+		log:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	info := []model.ResponseAccuralSystem{
+		{OrderNumber: "1", Status: storage.StatusProcessed, Accrual: 1},
+		{OrderNumber: "2", Status: storage.StatusInvalid},
+	}
+	// при остановке накопленное сохраняется с собственным, еще не отмененным контекстом
+	mockStorage.On("UpdateOrders", mock.MatchedBy(func(ctx context.Context) bool { return ctx.Err() == nil }), info).Return([]error{nil, nil}, nil).Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	infoCh := make(chan model.ResponseAccuralSystem, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.updateOrdersGroup(ctx, infoCh)
+	}()
+	infoCh <- info[0]
+	// второй результат уже в канале в момент остановки
+	infoCh <- info[1]
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("сохранение группы заказов не завершилось")
+	}
+}
+
+func TestInitAccrualUpdateInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		a := &AppServer{config: config.NewConfig("", "", "", "secret", config.WithAccrualUpdateBatch(10, interval, 100, 2))}
+		assert.Error(t, a.initAccrual(), interval)
+	}
+}
+
+func TestAccrualLeader(t *testing.T) {
+	mockStorage := mocks.NewStorage(t)
+	leader := mocks.NewLeader(t)
+	a := &AppServer{
+		storage: mockStorage,
+		config: config.NewConfig(
+			"", "", "", "secret",
+			config.WithAccrualPolling("test-instance", time.Minute, 10, 10*time.Millisecond),
+			config.WithAccrualLeaderElection(10*time.Millisecond),
+		),
+		log: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	require.NoError(t, a.initAccrual())
+
+	lost := make(chan struct{})
+	polling := make(chan struct{}, 1)
+	released := make(chan struct{})
+	// пока ведущий другой экземпляр, заказы не захватываются
+	mockStorage.On("TryLead", mock.Anything, accrualLeaderTask, 10*time.Millisecond).Return(nil, storage.ErrNotLeader).Once()
+	mockStorage.On("TryLead", mock.Anything, accrualLeaderTask, 10*time.Millisecond).Return(leader, nil).Once()
+	mockStorage.On("TryLead", mock.Anything, accrualLeaderTask, 10*time.Millisecond).Return(nil, storage.ErrNotLeader)
+	mockStorage.On("ClaimOrders", mock.Anything, "test-instance", mock.Anything, 10, time.Minute).
+		Run(func(mock.Arguments) {
+			select {
+			case polling <- struct{}{}:
+			default:
+			}
+		}).
+		Return(nil, storage.ErrOrdersNotFound)
+	leader.On("Lost").Return((<-chan struct{})(lost))
+	leader.On("Release", mock.Anything).Run(func(mock.Arguments) { close(released) }).Return(nil).Once()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.runUpdater(ctx)
+	}()
+
+	select {
+	case <-polling:
+	case <-ctx.Done():
+		t.Fatal("ведущий не начал опрос")
+	}
+	// потеряв блокировку, экземпляр останавливает опрос и отпускает ее
+	close(lost)
+	select {
+	case <-released:
+	case <-ctx.Done():
+		t.Fatal("блокировка ведущего не отпущена")
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,39 @@
+// пакет расчета пауз между повторными попытками: экспоненциальный рост с ограничением сверху и случайным разбросом,
+// чтобы повторы многих заказов не приходились на один момент
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Policy правила расчета пауз
+type Policy struct {
+	// Base пауза перед первой повторной попыткой
+	Base time.Duration
+	// Max больше этой паузы не бывает
+	Max time.Duration
+	// Jitter доля паузы от 0 до 1, на которую она случайно сокращается
+	Jitter float64
+}
+
+// Delay пауза перед попыткой attempt (с единицы): Base*2^(attempt-1), но не больше Max, за вычетом случайного разброса
+func (p Policy) Delay(attempt int) time.Duration {
+	return p.delay(attempt, rand.Float64())
+}
+
+// delay расчет паузы при случайном значении rnd из [0,1)
+func (p Policy) delay(attempt int, rnd float64) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := p.Max
+	// дальше сдвиг переполнит time.Duration, а пауза и так уже упрется в Max
+	if attempt <= 62 {
+		if exp := p.Base << (attempt - 1); exp > 0 && exp>>(attempt-1) == p.Base && exp < p.Max {
+			d = exp
+		}
+	}
+	jitter := min(max(p.Jitter, 0), 1)
+	return d - time.Duration(float64(d)*jitter*rnd)
+}
@@ -0,0 +1,37 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{Base: time.Second, Max: time.Minute}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 0, want: time.Second},
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 6, want: 32 * time.Second},
+		{attempt: 7, want: time.Minute},
+		{attempt: 100, want: time.Minute},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.delay(tt.attempt, 0.99), tt.attempt)
+	}
+}
+
+func TestDelayJitter(t *testing.T) {
+	p := Policy{Base: 10 * time.Second, Max: time.Hour, Jitter: 0.5}
+	assert.Equal(t, 10*time.Second, p.delay(1, 0))
+	assert.Equal(t, 5*time.Second, p.delay(1, 1))
+	for i := 0; i < 100; i++ {
+		d := p.Delay(3)
+		assert.GreaterOrEqual(t, d, 20*time.Second)
+		assert.LessOrEqual(t, d, 40*time.Second)
+	}
+}
@@ -0,0 +1,196 @@
+// пакет автоматического выключателя (circuit breaker) для запросов к внешней системе.
+// В закрытом состоянии запросы идут как обычно. После FailureThreshold ошибок подряд выключатель размыкается,
+// и запросы не делаются OpenTimeout. Затем он полуоткрыт: проходят HalfOpenRequests пробных запросов. Если все они успешны,
+// выключатель замыкается, а при первой ошибке снова размыкается
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// State состояние выключателя
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Config параметры выключателя
+type Config struct {
+	// FailureThreshold после стольких ошибок подряд выключатель размыкается
+	FailureThreshold int
+	// OpenTimeout сколько выключатель остается разомкнутым перед пробными запросами
+	OpenTimeout time.Duration
+	// HalfOpenRequests сколько пробных запросов должны пройти успешно, чтобы выключатель замкнулся
+	HalfOpenRequests int
+}
+
+// Breaker автоматический выключатель. Безопасен для одновременного использования.
+// После успешного Wait вызывающий обязан сообщить результат запроса одним из Success, Failure или Cancel
+type Breaker struct {
+	mu        sync.Mutex
+	cfg       Config
+	state     State
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+	// changed закрывается и заменяется новым при каждой смене состояния, чтобы разбудить ожидающих
+	changed  chan struct{}
+	onChange func(from, to State)
+	now      func() time.Time
+}
+
+// New выключатель с параметрами cfg. onChange, если задан, вызывается при каждой смене состояния под блокировкой выключателя,
+// поэтому не должен обращаться к самому выключателю
+func New(cfg Config, onChange func(from, to State)) *Breaker {
+	if cfg.FailureThreshold < 1 {
+		cfg.FailureThreshold = 1
+	}
+	if cfg.HalfOpenRequests < 1 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &Breaker{
+		cfg:      cfg,
+		changed:  make(chan struct{}),
+		onChange: onChange,
+		now:      time.Now,
+	}
+}
+
+// Wait ждет, пока выключатель пропустит запрос, или отмены контекста
+func (b *Breaker) Wait(ctx context.Context) error {
+	for {
+		wait, changed, ok := b.allow()
+		if ok {
+			return nil
+		}
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-timeout:
+		case <-changed:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// allow пропускает запрос, если можно. Иначе возвращает, сколько ждать до размыкания (0 - ждать смены состояния)
+// и канал, который закроется при смене состояния
+func (b *Breaker) allow() (time.Duration, <-chan struct{}, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open {
+		if wait := b.openedAt.Add(b.cfg.OpenTimeout).Sub(b.now()); wait > 0 {
+			return wait, b.changed, false
+		}
+		b.setState(HalfOpen)
+	}
+	if b.state == HalfOpen {
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return 0, b.changed, false
+		}
+		b.probes++
+	}
+	return 0, nil, true
+}
+
+// Success сообщает об успешном запросе
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Closed:
+		b.failures = 0
+	case HalfOpen:
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(Closed)
+		}
+	}
+}
+
+// Failure сообщает о неудачном запросе
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Closed:
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.setState(Open)
+		}
+	case HalfOpen:
+		b.setState(Open)
+	}
+}
+
+// Cancel сообщает, что пропущенный запрос не был сделан или прерван не по вине внешней системы. Пробный запрос освобождается
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == HalfOpen && b.probes > b.successes {
+		b.probes--
+	}
+}
+
+// State текущее состояние
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// OpenUntil до какого момента выключатель разомкнут. Нулевое значение - выключатель не разомкнут
+func (b *Breaker) OpenUntil() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return time.Time{}
+	}
+	return b.openedAt.Add(b.cfg.OpenTimeout)
+}
+
+// setState меняет состояние, сбрасывает счетчики и будит ожидающих. Вызывается под блокировкой
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	b.failures, b.successes, b.probes = 0, 0, 0
+	if state == Open {
+		b.openedAt = b.now()
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
@@ -0,0 +1,104 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	clock := time.Unix(1_700_000_000, 0)
+	changes := []string{}
+	b := New(Config{FailureThreshold: 3, OpenTimeout: time.Minute, HalfOpenRequests: 2}, func(from, to State) {
+		changes = append(changes, from.String()+"->"+to.String())
+	})
+	b.now = func() time.Time { return clock }
+
+	// успех сбрасывает счетчик ошибок подряд
+	for _, failure := range []bool{true, true, false, true, true} {
+		_, _, ok := b.allow()
+		require.True(t, ok)
+		if failure {
+			b.Failure()
+		} else {
+			b.Success()
+		}
+	}
+	assert.Equal(t, Closed, b.State())
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.Equal(t, clock.Add(time.Minute), b.OpenUntil())
+
+	// разомкнутый выключатель запросы не пропускает
+	wait, _, ok := b.allow()
+	assert.False(t, ok)
+	assert.Equal(t, time.Minute, wait)
+
+	// после таймаута пропускаются только пробные запросы
+	clock = clock.Add(time.Minute)
+	_, _, ok = b.allow()
+	assert.True(t, ok)
+	assert.Equal(t, HalfOpen, b.State())
+	assert.True(t, b.OpenUntil().IsZero())
+	_, _, ok = b.allow()
+	assert.True(t, ok)
+	_, _, ok = b.allow()
+	assert.False(t, ok)
+
+	// отмененный пробный запрос освобождает место
+	b.Cancel()
+	_, _, ok = b.allow()
+	assert.True(t, ok)
+
+	// ошибка пробного запроса снова размыкает
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+
+	// все пробные запросы успешны - выключатель замыкается
+	clock = clock.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		_, _, ok = b.allow()
+		require.True(t, ok)
+		b.Success()
+	}
+	assert.Equal(t, Closed, b.State())
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}, changes)
+}
+
+func TestWait(t *testing.T) {
+	b := New(Config{FailureThreshold: 1, OpenTimeout: 50 * time.Millisecond, HalfOpenRequests: 1}, nil)
+	require.NoError(t, b.Wait(context.Background()))
+	b.Failure()
+
+	// ждем окончания таймаута и проходим пробным запросом
+	start := time.Now()
+	require.NoError(t, b.Wait(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// пока идет пробный запрос, остальные ждут его результата
+	done := make(chan error)
+	go func() { done <- b.Wait(context.Background()) }()
+	select {
+	case <-done:
+		require.FailNow(t, "запрос прошел мимо пробного")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.Success()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "ожидающий не разбужен")
+	}
+
+	// ожидание прерывается отменой контекста
+	b.Failure()
+	b.Failure()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	b.cfg.OpenTimeout = time.Hour
+	assert.ErrorIs(t, b.Wait(ctx), context.DeadlineExceeded)
+}
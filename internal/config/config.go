@@ -2,6 +2,11 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -17,17 +22,87 @@ type Config struct {
 	databaseURI           string
 	accruralSystemAddress string
 	secret                string
+	accessTokenTTL        time.Duration
+	refreshTokenTTL       time.Duration
+	cookieSecure          bool
+	cookieSameSite        http.SameSite
+	cookiePath            string
+	cookieDomain          string
+	jwtSigningKeyFile     string
+	jwtVerifyKeyFiles     []string
+	jwtIssuer             string
+	jwtAudience           string
+	jwtLeeway             time.Duration
+	loginMaxFailures      int
+	loginIPMaxFailures    int
+	loginFreeAttempts     int
+	loginFailureWindow    time.Duration
+	loginLockout          time.Duration
+	loginDelayBase        time.Duration
+	loginDelayMax         time.Duration
+	trustProxyHeaders     bool
+	passwordResetTTL      time.Duration
+	passwordResetMax      int
+	passwordResetIPMax    int
+	passwordResetWindow   time.Duration
+	passwordResetQueue    int
+	notifier              string
+	notifierFile          string
+	loginMinLength        int
+	loginMaxLength        int
+	loginPattern          string
+	passwordMinLength     int
+	passwordMaxBytes      int
+	passwordMinClasses    int
+	passwordBreachedFile  string
+	passwordHash          string
+	bcryptCost            int
+	argon2Memory          uint32
+	argon2Time            uint32
+	argon2Threads         uint8
+	hashConcurrency       int
+	mfaIssuer             string
+	mfaTokenTTL           time.Duration
+	withdrawRequireMFA    bool
+	instanceID            string
+	accrualLeaseTTL       time.Duration
+	accrualBatchSize      int
+	accrualPollInterval   time.Duration
+	accrualRate           float64
+	accrualRateMin        float64
+	accrualRateMax        float64
+	accrualRateIncrease   float64
+	accrualBurst          int
+	accrualRetryAfter     time.Duration
+	accrualWorkers        int
+	accrualBackoffBase    time.Duration
+	accrualBackoffMax     time.Duration
+	accrualBackoffJitter  float64
+	accrualMaxAttempts    int
+	accrualBreakerFails   int
+	accrualBreakerOpen    time.Duration
+	accrualBreakerProbes  int
+	accrualWebhookSecret  string
+	accrualWebhookSkew    time.Duration
+	accrualPushTimeout    time.Duration
+	updateBatchSize       int
+	updateBatchInterval   time.Duration
+	updateBufferSize      int
+	updateRetries         int
+	mode                  string
+	leaderElection        bool
+	leaderInterval        time.Duration
 }
 
 func (c Config) ShutdownServerSec() int {
 	return shutdownServerSec
 }
-func (c Config) UpdateGroupStatusesSec() int {
-	return updateGroupStatusesSec
-}
 func (c Config) CookieTokenName() string {
 	return "app_token"
 }
+func (c Config) CookieRefreshTokenName() string {
+	return "app_refresh_token"
+}
 func (c Config) AddressApp() string {
 	return c.addressApp
 }
@@ -41,12 +116,602 @@ func (c Config) Secret() string {
 	return c.secret
 }
 
+// AccessTokenTTL время жизни токена доступа (jwt)
+func (c Config) AccessTokenTTL() time.Duration {
+	return c.accessTokenTTL
+}
+
+// RefreshTokenTTL время жизни refresh токена, а значит и максимальное время жизни сессии без обновления
+func (c Config) RefreshTokenTTL() time.Duration {
+	return c.refreshTokenTTL
+}
+
+// CookieSecure выставлять ли куки с атрибутом Secure (только по HTTPS)
+func (c Config) CookieSecure() bool {
+	return c.cookieSecure
+}
+
+// CookieSameSite атрибут SameSite для кук с токенами
+func (c Config) CookieSameSite() http.SameSite {
+	return c.cookieSameSite
+}
+
+// CookiePath атрибут Path для кук с токенами
+func (c Config) CookiePath() string {
+	return c.cookiePath
+}
+
+// CookieDomain атрибут Domain для кук с токенами. Пустое значение - кука только для текущего хоста
+func (c Config) CookieDomain() string {
+	return c.cookieDomain
+}
+
+// JWTSigningKeyFile файл с закрытым ключом (PEM, RSA или Ed25519) для подписи токенов. Если не задан - токены подписываются общим секретом (HS256)
+func (c Config) JWTSigningKeyFile() string {
+	return c.jwtSigningKeyFile
+}
+
+// JWTVerifyKeyFiles файлы с открытыми ключами (PEM), которыми дополнительно проверяются токены. Нужны на время ротации ключа подписи
+func (c Config) JWTVerifyKeyFiles() []string {
+	return c.jwtVerifyKeyFiles
+}
+
+// JWTIssuer издатель токенов (iss). Токены других издателей отклоняются
+func (c Config) JWTIssuer() string {
+	return c.jwtIssuer
+}
+
+// JWTAudience аудитория токенов (aud). Разная для окружений, чтобы токены одного окружения не принимались в другом
+func (c Config) JWTAudience() string {
+	return c.jwtAudience
+}
+
+// JWTLeeway допустимое расхождение часов при проверке времени в токенах
+func (c Config) JWTLeeway() time.Duration {
+	return c.jwtLeeway
+}
+
+// LoginMaxFailures сколько неудачных попыток входа подряд допускается для одного логина, после чего логин блокируется на LoginLockout
+func (c Config) LoginMaxFailures() int {
+	return c.loginMaxFailures
+}
+
+// LoginIPMaxFailures сколько неудачных попыток входа подряд допускается с одного ip адреса, после чего адрес блокируется на LoginLockout
+func (c Config) LoginIPMaxFailures() int {
+	return c.loginIPMaxFailures
+}
+
+// LoginFreeAttempts сколько неудачных попыток входа не приводят к задержке перед следующей попыткой
+func (c Config) LoginFreeAttempts() int {
+	return c.loginFreeAttempts
+}
+
+// LoginFailureWindow через сколько после последней неудачи счетчик неудачных попыток начинается заново
+func (c Config) LoginFailureWindow() time.Duration {
+	return c.loginFailureWindow
+}
+
+// LoginLockout на сколько блокируется вход при превышении числа неудачных попыток
+func (c Config) LoginLockout() time.Duration {
+	return c.loginLockout
+}
+
+// LoginDelayBase начальная задержка перед следующей попыткой входа. С каждой неудачей удваивается
+func (c Config) LoginDelayBase() time.Duration {
+	return c.loginDelayBase
+}
+
+// LoginDelayMax максимальная задержка перед следующей попыткой входа
+func (c Config) LoginDelayMax() time.Duration {
+	return c.loginDelayMax
+}
+
+// TrustProxyHeaders брать ли адрес клиента из заголовков X-Real-IP и X-Forwarded-For. Включать только за доверенным прокси
+func (c Config) TrustProxyHeaders() bool {
+	return c.trustProxyHeaders
+}
+
+// PasswordResetTTL время жизни токена сброса пароля
+func (c Config) PasswordResetTTL() time.Duration {
+	return c.passwordResetTTL
+}
+
+// PasswordResetMaxRequests сколько запросов на сброс пароля допускается для одного логина за PasswordResetWindow
+func (c Config) PasswordResetMaxRequests() int {
+	return c.passwordResetMax
+}
+
+// PasswordResetIPMaxRequests сколько запросов на сброс пароля допускается с одного ip адреса за PasswordResetWindow
+func (c Config) PasswordResetIPMaxRequests() int {
+	return c.passwordResetIPMax
+}
+
+// PasswordResetWindow окно подсчета запросов на сброс пароля. Отсчитывается от первого запроса, при превышении лимита запросы отклоняются до конца окна
+func (c Config) PasswordResetWindow() time.Duration {
+	return c.passwordResetWindow
+}
+
+// PasswordResetQueue сколько запросов на сброс пароля может ждать отправки. Лишние запросы отклоняются
+func (c Config) PasswordResetQueue() int {
+	return c.passwordResetQueue
+}
+
+// Notifier способ доставки уведомлений пользователям: log или file
+func (c Config) Notifier() string {
+	return c.notifier
+}
+
+// NotifierFile файл для уведомлений при способе доставки file
+func (c Config) NotifierFile() string {
+	return c.notifierFile
+}
+
+// LoginMinLength минимальная длина логина в символах
+func (c Config) LoginMinLength() int {
+	return c.loginMinLength
+}
+
+// LoginMaxLength максимальная длина логина в символах
+func (c Config) LoginMaxLength() int {
+	return c.loginMaxLength
+}
+
+// LoginPattern регулярное выражение с допустимыми символами логина. Проверяется уже нормализованный логин
+func (c Config) LoginPattern() string {
+	return c.loginPattern
+}
+
+// PasswordMinLength минимальная длина пароля в символах
+func (c Config) PasswordMinLength() int {
+	return c.passwordMinLength
+}
+
+// PasswordMaxBytes максимальная длина пароля в байтах. 0 - без ограничения
+func (c Config) PasswordMaxBytes() int {
+	return c.passwordMaxBytes
+}
+
+// PasswordMinClasses сколько разных классов символов должно быть в пароле
+func (c Config) PasswordMinClasses() int {
+	return c.passwordMinClasses
+}
+
+// PasswordBreachedFile файл со списком утекших паролей в дополнение к встроенному
+func (c Config) PasswordBreachedFile() string {
+	return c.passwordBreachedFile
+}
+
+// PasswordHash алгоритм хеширования новых паролей: argon2id или bcrypt. Хеши другого алгоритма и с другими параметрами пересчитываются при входе
+func (c Config) PasswordHash() string {
+	return c.passwordHash
+}
+
+// BcryptCost стоимость bcrypt
+func (c Config) BcryptCost() int {
+	return c.bcryptCost
+}
+
+// Argon2Memory память argon2id в KiB
+func (c Config) Argon2Memory() uint32 {
+	return c.argon2Memory
+}
+
+// Argon2Time число проходов argon2id
+func (c Config) Argon2Time() uint32 {
+	return c.argon2Time
+}
+
+// Argon2Threads степень параллелизма argon2id
+func (c Config) Argon2Threads() uint8 {
+	return c.argon2Threads
+}
+
+// PasswordHashConcurrency сколько хеширований и проверок паролей выполняется одновременно. Каждое вычисление argon2id занимает Argon2Memory памяти
+func (c Config) PasswordHashConcurrency() int {
+	return c.hashConcurrency
+}
+
+// MFAIssuer название сервиса, под которым секрет TOTP показывается в приложении-аутентификаторе
+func (c Config) MFAIssuer() string {
+	return c.mfaIssuer
+}
+
+// MFATokenTTL сколько после ввода пароля можно ввести второй фактор
+func (c Config) MFATokenTTL() time.Duration {
+	return c.mfaTokenTTL
+}
+
+// WithdrawRequireMFA требуется ли вход со вторым фактором для списания баллов у всех (WITHDRAW_REQUIRE_MFA).
+// У пользователей с подключенным TOTP второй фактор требуется всегда. Включение распространяет требование
+// на пользователей без TOTP и на api ключи
+func (c Config) WithdrawRequireMFA() bool {
+	return c.withdrawRequireMFA
+}
+
+// InstanceID идентификатор экземпляра приложения. Им помечаются захваченные для опроса системы расчета заказы
+func (c Config) InstanceID() string {
+	return c.instanceID
+}
+
+// AccrualLeaseTTL на сколько экземпляр захватывает заказы для опроса системы расчета. Должно хватать на обработку всей пачки
+func (c Config) AccrualLeaseTTL() time.Duration {
+	return c.accrualLeaseTTL
+}
+
+// AccrualBatchSize сколько заказов захватывается за раз
+func (c Config) AccrualBatchSize() int {
+	return c.accrualBatchSize
+}
+
+// AccrualPollInterval пауза перед новым захватом, если свободных заказов не осталось
+func (c Config) AccrualPollInterval() time.Duration {
+	return c.accrualPollInterval
+}
+
+// AccrualRate начальная частота запросов к системе расчета в секунду. Общая для всех запросов экземпляра
+func (c Config) AccrualRate() float64 {
+	return c.accrualRate
+}
+
+// AccrualRateMin ниже этой частоты запросов ограничитель не опускается при ответах 429
+func (c Config) AccrualRateMin() float64 {
+	return c.accrualRateMin
+}
+
+// AccrualRateMax выше этой частоты запросов ограничитель не поднимается при успешных ответах
+func (c Config) AccrualRateMax() float64 {
+	return c.accrualRateMax
+}
+
+// AccrualRateIncrease на сколько запросов в секунду частота растет за секунду работы без ответов 429
+func (c Config) AccrualRateIncrease() float64 {
+	return c.accrualRateIncrease
+}
+
+// AccrualBurst сколько запросов к системе расчета можно сделать подряд без ожидания
+func (c Config) AccrualBurst() int {
+	return c.accrualBurst
+}
+
+// AccrualRetryAfter пауза после ответа 429, если система расчета не прислала заголовок Retry-After
+func (c Config) AccrualRetryAfter() time.Duration {
+	return c.accrualRetryAfter
+}
+
+// AccrualWorkers сколько заказов одновременно проверяется в системе расчета
+func (c Config) AccrualWorkers() int {
+	return c.accrualWorkers
+}
+
+// AccrualBackoffBase пауза перед повторной проверкой заказа после первой неудачной попытки. Дальше пауза растет вдвое с каждой попыткой
+func (c Config) AccrualBackoffBase() time.Duration {
+	return c.accrualBackoffBase
+}
+
+// AccrualBackoffMax наибольшая пауза между проверками одного заказа
+func (c Config) AccrualBackoffMax() time.Duration {
+	return c.accrualBackoffMax
+}
+
+// AccrualBackoffJitter доля паузы от 0 до 1, на которую она случайно сокращается
+func (c Config) AccrualBackoffJitter() float64 {
+	return c.accrualBackoffJitter
+}
+
+// AccrualMaxAttempts после стольких попыток без окончательного статуса заказ переводится в очередь недоставленных. 0 - без ограничения
+func (c Config) AccrualMaxAttempts() int {
+	return c.accrualMaxAttempts
+}
+
+// AccrualBreakerFailures после стольких ошибок системы расчета подряд запросы к ней приостанавливаются
+func (c Config) AccrualBreakerFailures() int {
+	return c.accrualBreakerFails
+}
+
+// AccrualBreakerOpenTimeout на сколько приостанавливаются запросы к системе расчета после серии ошибок
+func (c Config) AccrualBreakerOpenTimeout() time.Duration {
+	return c.accrualBreakerOpen
+}
+
+// AccrualBreakerProbes сколько пробных запросов после паузы должны пройти успешно, чтобы возобновить работу с системой расчета
+func (c Config) AccrualBreakerProbes() int {
+	return c.accrualBreakerProbes
+}
+
+// AccrualWebhookSecret секрет для проверки подписи событий системы расчета. Пустой - прием событий выключен
+func (c Config) AccrualWebhookSecret() string {
+	return c.accrualWebhookSecret
+}
+
+// AccrualWebhookTolerance насколько время отправки события может расходиться с нашим. Более старые события отклоняются
+func (c Config) AccrualWebhookTolerance() time.Duration {
+	return c.accrualWebhookSkew
+}
+
+// AccrualPushTimeout через сколько после загрузки заказа или последнего события по нему заказ начинает проверяться опросом.
+// Действует, только если прием событий включен
+func (c Config) AccrualPushTimeout() time.Duration {
+	return c.accrualPushTimeout
+}
+
+// AccrualUpdateBatchSize сколько результатов системы расчета сохраняется за один обмен с базой. 1 и меньше - сохранение по одному
+func (c Config) AccrualUpdateBatchSize() int {
+	return c.updateBatchSize
+}
+
+// AccrualUpdateBatchInterval как часто сохраняется неполная группа результатов
+func (c Config) AccrualUpdateBatchInterval() time.Duration {
+	return c.updateBatchInterval
+}
+
+// AccrualUpdateBufferSize сколько результатов вместе с ожидающими повтора может накопиться до сохранения. Лишние отбрасываются,
+// такие заказы будут опрошены заново после истечения аренды
+func (c Config) AccrualUpdateBufferSize() int {
+	return c.updateBufferSize
+}
+
+// AccrualUpdateRetries сколько раз повторяется сохранение результата, не записанного из-за ошибки базы
+func (c Config) AccrualUpdateRetries() int {
+	return c.updateRetries
+}
+
+// Mode режим работы экземпляра: all - http сервер и опрос системы расчета, web - только http сервер, worker - только опрос
+func (c Config) Mode() string {
+	return c.mode
+}
+
+// AccrualLeaderElection опрос системы расчета ведет только один экземпляр, захвативший блокировку в базе
+func (c Config) AccrualLeaderElection() bool {
+	return c.leaderElection
+}
+
+// AccrualLeaderInterval как часто экземпляр пытается стать ведущим и ведущий проверяет, что блокировка за ним
+func (c Config) AccrualLeaderInterval() time.Duration {
+	return c.leaderInterval
+}
+
 // PublicConfig публичный кастомный конфиг приложения
 type PublicConfig struct {
-	AddressApp            string `env:"RUN_ADDRESS"`
-	DatabaseURI           string `env:"DATABASE_URI"`
-	AccruralSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	Secret                string `env:"SECRET"`
+	AddressApp            string        `env:"RUN_ADDRESS"`
+	DatabaseURI           string        `env:"DATABASE_URI"`
+	AccruralSystemAddress string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	Secret                string        `env:"SECRET"`
+	AccessTokenTTL        time.Duration `env:"ACCESS_TOKEN_TTL" envDefault:"15m"`
+	RefreshTokenTTL       time.Duration `env:"REFRESH_TOKEN_TTL" envDefault:"720h"`
+	CookieSecure          bool          `env:"COOKIE_SECURE" envDefault:"false"`
+	CookieSameSite        string        `env:"COOKIE_SAMESITE" envDefault:"lax"`
+	CookiePath            string        `env:"COOKIE_PATH" envDefault:"/"`
+	CookieDomain          string        `env:"COOKIE_DOMAIN"`
+	JWTSigningKeyFile     string        `env:"JWT_SIGNING_KEY_FILE"`
+	JWTVerifyKeyFiles     []string      `env:"JWT_VERIFY_KEY_FILES" envSeparator:","`
+	JWTIssuer             string        `env:"JWT_ISSUER" envDefault:"gophermart"`
+	JWTAudience           string        `env:"JWT_AUDIENCE" envDefault:"gophermart"`
+	JWTLeeway             time.Duration `env:"JWT_LEEWAY" envDefault:"30s"`
+	LoginMaxFailures      int           `env:"LOGIN_MAX_FAILURES" envDefault:"10"`
+	LoginIPMaxFailures    int           `env:"LOGIN_IP_MAX_FAILURES" envDefault:"50"`
+	LoginFreeAttempts     int           `env:"LOGIN_FREE_ATTEMPTS" envDefault:"3"`
+	LoginFailureWindow    time.Duration `env:"LOGIN_FAILURE_WINDOW" envDefault:"15m"`
+	LoginLockout          time.Duration `env:"LOGIN_LOCKOUT" envDefault:"15m"`
+	LoginDelayBase        time.Duration `env:"LOGIN_DELAY_BASE" envDefault:"1s"`
+	LoginDelayMax         time.Duration `env:"LOGIN_DELAY_MAX" envDefault:"1m"`
+	TrustProxyHeaders     bool          `env:"TRUST_PROXY_HEADERS" envDefault:"false"`
+	PasswordResetTTL      time.Duration `env:"PASSWORD_RESET_TTL" envDefault:"1h"`
+	PasswordResetMax      int           `env:"PASSWORD_RESET_MAX_REQUESTS" envDefault:"3"`
+	PasswordResetIPMax    int           `env:"PASSWORD_RESET_IP_MAX_REQUESTS" envDefault:"20"`
+	PasswordResetWindow   time.Duration `env:"PASSWORD_RESET_WINDOW" envDefault:"1h"`
+	PasswordResetQueue    int           `env:"PASSWORD_RESET_QUEUE" envDefault:"100"`
+	Notifier              string        `env:"NOTIFIER" envDefault:"log"`
+	NotifierFile          string        `env:"NOTIFIER_FILE" envDefault:"notifications.jsonl"`
+	LoginMinLength        int           `env:"LOGIN_MIN_LENGTH" envDefault:"3"`
+	LoginMaxLength        int           `env:"LOGIN_MAX_LENGTH" envDefault:"64"`
+	LoginPattern          string        `env:"LOGIN_PATTERN" envDefault:"^[\\p{L}\\p{N}._-]+$"`
+	PasswordMinLength     int           `env:"PASSWORD_MIN_LENGTH" envDefault:"8"`
+	PasswordMaxBytes      int           `env:"PASSWORD_MAX_BYTES" envDefault:"72"`
+	PasswordMinClasses    int           `env:"PASSWORD_MIN_CLASSES" envDefault:"2"`
+	PasswordBreachedFile  string        `env:"PASSWORD_BREACHED_FILE"`
+	PasswordHash          string        `env:"PASSWORD_HASH" envDefault:"argon2id"`
+	BcryptCost            int           `env:"BCRYPT_COST" envDefault:"10"`
+	Argon2Memory          uint32        `env:"ARGON2_MEMORY" envDefault:"65536"`
+	Argon2Time            uint32        `env:"ARGON2_TIME" envDefault:"3"`
+	Argon2Threads         uint8         `env:"ARGON2_THREADS" envDefault:"2"`
+	HashConcurrency       int           `env:"PASSWORD_HASH_CONCURRENCY" envDefault:"4"`
+	MFAIssuer             string        `env:"MFA_ISSUER" envDefault:"Gophermart"`
+	MFATokenTTL           time.Duration `env:"MFA_TOKEN_TTL" envDefault:"5m"`
+	WithdrawRequireMFA    bool          `env:"WITHDRAW_REQUIRE_MFA" envDefault:"false"`
+	InstanceID            string        `env:"INSTANCE_ID"`
+	AccrualLeaseTTL       time.Duration `env:"ACCRUAL_LEASE_TTL" envDefault:"1m"`
+	AccrualBatchSize      int           `env:"ACCRUAL_BATCH_SIZE" envDefault:"10"`
+	AccrualPollInterval   time.Duration `env:"ACCRUAL_POLL_INTERVAL" envDefault:"5s"`
+	AccrualRate           float64       `env:"ACCRUAL_RATE" envDefault:"10"`
+	AccrualRateMin        float64       `env:"ACCRUAL_RATE_MIN" envDefault:"0.5"`
+	AccrualRateMax        float64       `env:"ACCRUAL_RATE_MAX" envDefault:"50"`
+	AccrualRateIncrease   float64       `env:"ACCRUAL_RATE_INCREASE" envDefault:"0.5"`
+	AccrualBurst          int           `env:"ACCRUAL_BURST" envDefault:"5"`
+	AccrualRetryAfter     time.Duration `env:"ACCRUAL_RETRY_AFTER" envDefault:"60s"`
+	AccrualWorkers        int           `env:"ACCRUAL_WORKERS" envDefault:"4"`
+	AccrualBackoffBase    time.Duration `env:"ACCRUAL_BACKOFF_BASE" envDefault:"5s"`
+	AccrualBackoffMax     time.Duration `env:"ACCRUAL_BACKOFF_MAX" envDefault:"1h"`
+	AccrualBackoffJitter  float64       `env:"ACCRUAL_BACKOFF_JITTER" envDefault:"0.2"`
+	AccrualMaxAttempts    int           `env:"ACCRUAL_MAX_ATTEMPTS" envDefault:"30"`
+	AccrualBreakerFails   int           `env:"ACCRUAL_BREAKER_FAILURES" envDefault:"5"`
+	AccrualBreakerOpen    time.Duration `env:"ACCRUAL_BREAKER_OPEN_TIMEOUT" envDefault:"30s"`
+	AccrualBreakerProbes  int           `env:"ACCRUAL_BREAKER_PROBES" envDefault:"1"`
+	AccrualWebhookSecret  string        `env:"ACCRUAL_WEBHOOK_SECRET"`
+	AccrualWebhookSkew    time.Duration `env:"ACCRUAL_WEBHOOK_TOLERANCE" envDefault:"5m"`
+	AccrualPushTimeout    time.Duration `env:"ACCRUAL_PUSH_TIMEOUT" envDefault:"10m"`
+	UpdateBatchSize       int           `env:"ACCRUAL_UPDATE_BATCH" envDefault:"100"`
+	UpdateBatchInterval   time.Duration `env:"ACCRUAL_UPDATE_INTERVAL" envDefault:"1s"`
+	UpdateBufferSize      int           `env:"ACCRUAL_UPDATE_BUFFER" envDefault:"1000"`
+	UpdateRetries         int           `env:"ACCRUAL_UPDATE_RETRIES" envDefault:"3"`
+	Mode                  string        `env:"MODE" envDefault:"all"`
+	LeaderElection        bool          `env:"ACCRUAL_LEADER_ELECTION" envDefault:"false"`
+	LeaderInterval        time.Duration `env:"ACCRUAL_LEADER_INTERVAL" envDefault:"5s"`
+}
+
+// Option функция для изменения настроек конфига созданного через NewConfig
+type Option func(*Config)
+
+// WithAccessTokenTTL устанавливает время жизни токена доступа
+func WithAccessTokenTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.accessTokenTTL = ttl
+	}
+}
+
+// WithRefreshTokenTTL устанавливает время жизни refresh токена
+func WithRefreshTokenTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.refreshTokenTTL = ttl
+	}
+}
+
+// WithCookieSecure устанавливает атрибут Secure для кук с токенами
+func WithCookieSecure(secure bool) Option {
+	return func(c *Config) {
+		c.cookieSecure = secure
+	}
+}
+
+// WithJWTAudience устанавливает аудиторию токенов
+func WithJWTAudience(audience string) Option {
+	return func(c *Config) {
+		c.jwtAudience = audience
+	}
+}
+
+// WithLoginThrottle устанавливает ограничения на неудачные попытки входа: сколько неудач допускается для логина и ip адреса и на сколько блокируется вход
+func WithLoginThrottle(maxFailures, ipMaxFailures int, lockout time.Duration) Option {
+	return func(c *Config) {
+		c.loginMaxFailures = maxFailures
+		c.loginIPMaxFailures = ipMaxFailures
+		c.loginLockout = lockout
+	}
+}
+
+// WithLoginDelay устанавливает задержки между неудачными попытками входа
+func WithLoginDelay(freeAttempts int, base, max time.Duration) Option {
+	return func(c *Config) {
+		c.loginFreeAttempts = freeAttempts
+		c.loginDelayBase = base
+		c.loginDelayMax = max
+	}
+}
+
+// WithPasswordResetLimit устанавливает ограничения на запросы сброса пароля: сколько запросов допускается для логина и ip адреса за окно window
+// и сколько запросов может ждать отправки
+func WithPasswordResetLimit(maxRequests, ipMaxRequests int, window time.Duration, queue int) Option {
+	return func(c *Config) {
+		c.passwordResetMax = maxRequests
+		c.passwordResetIPMax = ipMaxRequests
+		c.passwordResetWindow = window
+		c.passwordResetQueue = queue
+	}
+}
+
+// WithPasswordHash устанавливает алгоритм хеширования новых паролей
+func WithPasswordHash(algorithm string) Option {
+	return func(c *Config) {
+		c.passwordHash = algorithm
+	}
+}
+
+// WithArgon2Params устанавливает параметры argon2id
+func WithArgon2Params(memory, time uint32, threads uint8) Option {
+	return func(c *Config) {
+		c.argon2Memory = memory
+		c.argon2Time = time
+		c.argon2Threads = threads
+	}
+}
+
+// WithWithdrawRequireMFA требовать ли для списания баллов вход со вторым фактором у всех, а не только у пользователей с TOTP
+func WithWithdrawRequireMFA(require bool) Option {
+	return func(c *Config) {
+		c.withdrawRequireMFA = require
+	}
+}
+
+// WithAccrualPolling устанавливает идентификатор экземпляра и параметры захвата заказов для опроса системы расчета
+func WithAccrualPolling(instanceID string, leaseTTL time.Duration, batchSize int, pollInterval time.Duration) Option {
+	return func(c *Config) {
+		c.instanceID = instanceID
+		c.accrualLeaseTTL = leaseTTL
+		c.accrualBatchSize = batchSize
+		c.accrualPollInterval = pollInterval
+	}
+}
+
+// WithAccrualRateLimit устанавливает ограничение частоты запросов к системе расчета: начальную частоту, ее границы, размер пачки
+// и паузу после ответа 429 без заголовка Retry-After
+func WithAccrualRateLimit(rate, rateMin, rateMax float64, burst int, retryAfter time.Duration) Option {
+	return func(c *Config) {
+		c.accrualRate = rate
+		c.accrualRateMin = rateMin
+		c.accrualRateMax = rateMax
+		c.accrualBurst = burst
+		c.accrualRetryAfter = retryAfter
+	}
+}
+
+// WithAccrualWorkers устанавливает число одновременных запросов к системе расчета
+func WithAccrualWorkers(workers int) Option {
+	return func(c *Config) {
+		c.accrualWorkers = workers
+	}
+}
+
+// WithAccrualBackoff устанавливает паузы между повторными проверками заказа и число попыток до перевода в очередь недоставленных
+func WithAccrualBackoff(base, maxDelay time.Duration, jitter float64, maxAttempts int) Option {
+	return func(c *Config) {
+		c.accrualBackoffBase = base
+		c.accrualBackoffMax = maxDelay
+		c.accrualBackoffJitter = jitter
+		c.accrualMaxAttempts = maxAttempts
+	}
+}
+
+// WithAccrualBreaker устанавливает пороги автоматического выключателя запросов к системе расчета
+func WithAccrualBreaker(failures int, openTimeout time.Duration, probes int) Option {
+	return func(c *Config) {
+		c.accrualBreakerFails = failures
+		c.accrualBreakerOpen = openTimeout
+		c.accrualBreakerProbes = probes
+	}
+}
+
+// WithAccrualWebhook включает прием событий системы расчета с секретом secret
+func WithAccrualWebhook(secret string, tolerance, pushTimeout time.Duration) Option {
+	return func(c *Config) {
+		c.accrualWebhookSecret = secret
+		c.accrualWebhookSkew = tolerance
+		c.accrualPushTimeout = pushTimeout
+	}
+}
+
+// WithAccrualUpdateBatch устанавливает размер группы сохраняемых результатов системы расчета, интервал сохранения неполной группы,
+// предел накопленных результатов и число повторов
+func WithAccrualUpdateBatch(size int, interval time.Duration, bufferSize, retries int) Option {
+	return func(c *Config) {
+		c.updateBatchSize = size
+		c.updateBatchInterval = interval
+		c.updateBufferSize = bufferSize
+		c.updateRetries = retries
+	}
+}
+
+// WithMode устанавливает режим работы экземпляра
+func WithMode(mode string) Option {
+	return func(c *Config) {
+		c.mode = mode
+	}
+}
+
+// WithAccrualLeaderElection включает выбор ведущего экземпляра для опроса системы расчета
+func WithAccrualLeaderElection(interval time.Duration) Option {
+	return func(c *Config) {
+		c.leaderElection = true
+		c.leaderInterval = interval
+	}
 }
 
 // LoadConfig загрузка конфигурации. В приоритете будут переменные окружения
@@ -81,20 +746,126 @@ func LoadConfig() (Config, error) {
 	if pcfg.Secret == "" {
 		pcfg.Secret = secret
 	}
+	return newConfig(pcfg), nil
+}
+
+// NewConfig если хотим задать вручную (для тестов). Незаданные параметры получают значения по умолчанию, изменить их можно через opts
+func NewConfig(addressApp string, databaseURI string, accruralSystemAddress string, secret string, opts ...Option) Config {
+	pcfg := PublicConfig{}
+	// пустое окружение - получаем только значения по умолчанию из тегов envDefault
+	_ = env.Parse(&pcfg, env.Options{Environment: map[string]string{}})
+	pcfg.AddressApp = addressApp
+	pcfg.DatabaseURI = databaseURI
+	pcfg.AccruralSystemAddress = accruralSystemAddress
+	pcfg.Secret = secret
+
+	cfg := newConfig(pcfg)
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
+}
+
+// newConfig переводит публичный конфиг во внутренний
+func newConfig(pcfg PublicConfig) Config {
 	return Config{
 		addressApp:            pcfg.AddressApp,
 		databaseURI:           pcfg.DatabaseURI,
 		accruralSystemAddress: pcfg.AccruralSystemAddress,
 		secret:                pcfg.Secret,
-	}, nil
+		accessTokenTTL:        pcfg.AccessTokenTTL,
+		refreshTokenTTL:       pcfg.RefreshTokenTTL,
+		cookieSecure:          pcfg.CookieSecure,
+		cookieSameSite:        parseSameSite(pcfg.CookieSameSite),
+		cookiePath:            pcfg.CookiePath,
+		cookieDomain:          pcfg.CookieDomain,
+		jwtSigningKeyFile:     pcfg.JWTSigningKeyFile,
+		jwtVerifyKeyFiles:     pcfg.JWTVerifyKeyFiles,
+		jwtIssuer:             pcfg.JWTIssuer,
+		jwtAudience:           pcfg.JWTAudience,
+		jwtLeeway:             pcfg.JWTLeeway,
+		loginMaxFailures:      pcfg.LoginMaxFailures,
+		loginIPMaxFailures:    pcfg.LoginIPMaxFailures,
+		loginFreeAttempts:     pcfg.LoginFreeAttempts,
+		loginFailureWindow:    pcfg.LoginFailureWindow,
+		loginLockout:          pcfg.LoginLockout,
+		loginDelayBase:        pcfg.LoginDelayBase,
+		loginDelayMax:         pcfg.LoginDelayMax,
+		trustProxyHeaders:     pcfg.TrustProxyHeaders,
+		passwordResetTTL:      pcfg.PasswordResetTTL,
+		passwordResetMax:      pcfg.PasswordResetMax,
+		passwordResetIPMax:    pcfg.PasswordResetIPMax,
+		passwordResetWindow:   pcfg.PasswordResetWindow,
+		passwordResetQueue:    pcfg.PasswordResetQueue,
+		notifier:              pcfg.Notifier,
+		notifierFile:          pcfg.NotifierFile,
+		loginMinLength:        pcfg.LoginMinLength,
+		loginMaxLength:        pcfg.LoginMaxLength,
+		loginPattern:          pcfg.LoginPattern,
+		passwordMinLength:     pcfg.PasswordMinLength,
+		passwordMaxBytes:      pcfg.PasswordMaxBytes,
+		passwordMinClasses:    pcfg.PasswordMinClasses,
+		passwordBreachedFile:  pcfg.PasswordBreachedFile,
+		passwordHash:          pcfg.PasswordHash,
+		bcryptCost:            pcfg.BcryptCost,
+		argon2Memory:          pcfg.Argon2Memory,
+		argon2Time:            pcfg.Argon2Time,
+		argon2Threads:         pcfg.Argon2Threads,
+		hashConcurrency:       pcfg.HashConcurrency,
+		mfaIssuer:             pcfg.MFAIssuer,
+		mfaTokenTTL:           pcfg.MFATokenTTL,
+		withdrawRequireMFA:    pcfg.WithdrawRequireMFA,
+		instanceID:            instanceID(pcfg.InstanceID),
+		accrualLeaseTTL:       pcfg.AccrualLeaseTTL,
+		accrualBatchSize:      pcfg.AccrualBatchSize,
+		accrualPollInterval:   pcfg.AccrualPollInterval,
+		accrualRate:           pcfg.AccrualRate,
+		accrualRateMin:        pcfg.AccrualRateMin,
+		accrualRateMax:        pcfg.AccrualRateMax,
+		accrualRateIncrease:   pcfg.AccrualRateIncrease,
+		accrualBurst:          pcfg.AccrualBurst,
+		accrualRetryAfter:     pcfg.AccrualRetryAfter,
+		accrualWorkers:        pcfg.AccrualWorkers,
+		accrualBackoffBase:    pcfg.AccrualBackoffBase,
+		accrualBackoffMax:     pcfg.AccrualBackoffMax,
+		accrualBackoffJitter:  pcfg.AccrualBackoffJitter,
+		accrualMaxAttempts:    pcfg.AccrualMaxAttempts,
+		accrualBreakerFails:   pcfg.AccrualBreakerFails,
+		accrualBreakerOpen:    pcfg.AccrualBreakerOpen,
+		accrualBreakerProbes:  pcfg.AccrualBreakerProbes,
+		accrualWebhookSecret:  pcfg.AccrualWebhookSecret,
+		accrualWebhookSkew:    pcfg.AccrualWebhookSkew,
+		accrualPushTimeout:    pcfg.AccrualPushTimeout,
+		updateBatchSize:       pcfg.UpdateBatchSize,
+		updateBatchInterval:   pcfg.UpdateBatchInterval,
+		updateBufferSize:      pcfg.UpdateBufferSize,
+		updateRetries:         pcfg.UpdateRetries,
+		mode:                  pcfg.Mode,
+		leaderElection:        pcfg.LeaderElection,
+		leaderInterval:        pcfg.LeaderInterval,
+	}
 }
 
-// NewConfig если хотим задать вручную (для тестов)
-func NewConfig(addressApp string, databaseURI string, accruralSystemAddress string, secret string) Config {
-	return Config{
-		addressApp:            addressApp,
-		databaseURI:           databaseURI,
-		accruralSystemAddress: accruralSystemAddress,
-		secret:                secret,
+// instanceID идентификатор экземпляра приложения. Если не задан, то собирается из имени хоста и pid процесса
+func instanceID(val string) string {
+	if val != "" {
+		return val
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "gophermart"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// parseSameSite переводит строковое значение SameSite из конфигурации в http.SameSite. Неизвестные значения считаем lax
+func parseSameSite(val string) http.SameSite {
+	switch strings.ToLower(val) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
 	}
 }
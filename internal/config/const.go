@@ -1,6 +1,5 @@
 package config
 
 const (
-	shutdownServerSec      = 10
-	updateGroupStatusesSec = 10
+	shutdownServerSec = 10
 )
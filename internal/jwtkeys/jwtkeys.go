@@ -0,0 +1,277 @@
+// пакет для работы с ключами подписи jwt. Поддерживает симметричную подпись (HS256) и асимметричную (RS256, EdDSA) с несколькими ключами проверки для бесшовной ротации
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrUnknownKey        = errors.New("неизвестный ключ подписи токена")
+	ErrUnsupportedKey    = errors.New("неподдерживаемый тип ключа")
+	ErrUnexpectedMethod  = errors.New("неожиданный метод подписи")
+	ErrPEMBlockNotFound  = errors.New("в файле не найден PEM блок")
+	ErrSigningKeyMissing = errors.New("не задан ключ подписи")
+)
+
+// key ключ проверки подписи
+type key struct {
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// KeySet набор ключей: один ключ для подписи новых токенов и несколько для проверки. Ключи асимметричной подписи различаются по kid (отпечаток открытого ключа по RFC 7638)
+type KeySet struct {
+	// signingKID kid ключа подписи. Пустой для HS256
+	signingKID string
+	method     jwt.SigningMethod
+	signingKey any
+	// verifyKeys ключи проверки по kid
+	verifyKeys map[string]key
+}
+
+// NewHMAC набор с одним общим секретом для подписи и проверки (HS256). Такой набор не публикует ключей в JWKS
+func NewHMAC(secret string) *KeySet {
+	return &KeySet{
+		method:     jwt.SigningMethodHS256,
+		signingKey: []byte(secret),
+	}
+}
+
+// New набор ключей с закрытым ключом подписи signingKey (*rsa.PrivateKey или ed25519.PrivateKey) и дополнительными открытыми ключами проверки verifyKeys.
+// Открытая часть ключа подписи всегда входит в ключи проверки
+func New(signingKey crypto.Signer, verifyKeys ...crypto.PublicKey) (*KeySet, error) {
+	if signingKey == nil {
+		return nil, ErrSigningKeyMissing
+	}
+	method, err := methodForKey(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	ks := &KeySet{
+		method:     method,
+		signingKey: signingKey,
+		verifyKeys: make(map[string]key),
+	}
+	ks.signingKID, err = ks.addVerifyKey(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	for _, vk := range verifyKeys {
+		if _, err = ks.addVerifyKey(vk); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// Load загружает набор ключей из PEM файлов: закрытого ключа подписи (PKCS#8 или PKCS#1) и открытых ключей проверки (PKIX)
+func Load(signingKeyFile string, verifyKeyFiles []string) (*KeySet, error) {
+	b, err := os.ReadFile(signingKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("чтение ключа подписи. %w", err)
+	}
+	signingKey, err := parsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("разбор ключа подписи %s. %w", signingKeyFile, err)
+	}
+	verifyKeys := make([]crypto.PublicKey, 0, len(verifyKeyFiles))
+	for _, f := range verifyKeyFiles {
+		b, err = os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("чтение ключа проверки. %w", err)
+		}
+		vk, err := parsePublicKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("разбор ключа проверки %s. %w", f, err)
+		}
+		verifyKeys = append(verifyKeys, vk)
+	}
+	return New(signingKey, verifyKeys...)
+}
+
+// SigningKID kid ключа, которым подписываются новые токены
+func (ks *KeySet) SigningKID() string {
+	return ks.signingKID
+}
+
+// Sign подписывает утверждения claims текущим ключом подписи
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.method, claims)
+	if ks.signingKID != "" {
+		token.Header["kid"] = ks.signingKID
+	}
+	return token.SignedString(ks.signingKey)
+}
+
+// Keyfunc функция выбора ключа проверки для jwt.Parse. Метод подписи токена должен совпадать с методом ключа, иначе токен отклоняется
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	if ks.verifyKeys == nil {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("%w: %v", ErrUnexpectedMethod, t.Header["alg"])
+		}
+		return ks.signingKey, nil
+	}
+	kid, _ := t.Header["kid"].(string)
+	k, ok := ks.verifyKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("%w: %v", ErrUnexpectedMethod, t.Header["alg"])
+	}
+	return k.public, nil
+}
+
+// JWK открытый ключ в формате RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// для RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// для Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS набор открытых ключей для /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS возвращает открытые ключи проверки. Для HS256 набор пустой - секрет не публикуется
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.verifyKeys))}
+	for kid, k := range ks.verifyKeys {
+		jwk := jwkForKey(k.public)
+		jwk.KeyID = kid
+		jwk.Use = "sig"
+		jwk.Algorithm = k.method.Alg()
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	// порядок ключей в map случаен, а ответ должен быть стабильным
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID })
+	return jwks
+}
+
+// addVerifyKey добавляет ключ проверки и возвращает его kid
+func (ks *KeySet) addVerifyKey(public crypto.PublicKey) (string, error) {
+	method, err := methodForKey(public)
+	if err != nil {
+		return "", err
+	}
+	kid, err := thumbprint(public)
+	if err != nil {
+		return "", err
+	}
+	ks.verifyKeys[kid] = key{method: method, public: public}
+	return kid, nil
+}
+
+// methodForKey метод подписи определяется типом ключа
+func methodForKey(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, public)
+	}
+}
+
+// jwkForKey параметры открытого ключа для JWK
+func jwkForKey(public crypto.PublicKey) JWK {
+	switch pk := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(pk.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pk.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(pk),
+		}
+	}
+	return JWK{}
+}
+
+// thumbprint отпечаток ключа по RFC 7638. Одинаков у всех сервисов, поэтому kid не нужно настраивать отдельно
+func thumbprint(public crypto.PublicKey) (string, error) {
+	jwk := jwkForKey(public)
+	// по RFC 7638 обязательные поля в лексикографическом порядке
+	var members any
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	default:
+		return "", fmt.Errorf("%w: %T", ErrUnsupportedKey, public)
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// parsePrivateKey разбор закрытого ключа из PEM
+func parsePrivateKey(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrPEMBlockNotFound
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, k)
+	}
+	return signer, nil
+}
+
+// parsePublicKey разбор открытого ключа из PEM
+func parsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, ErrPEMBlockNotFound
+	}
+	if k, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
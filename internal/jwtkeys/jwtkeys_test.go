@@ -0,0 +1,119 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func claims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "user", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+}
+
+func parse(ks *KeySet, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, ks.Keyfunc)
+	return err
+}
+
+func TestHMAC(t *testing.T) {
+	ks := NewHMAC("secret")
+	token, err := ks.Sign(claims())
+	require.NoError(t, err)
+	assert.NoError(t, parse(ks, token))
+	assert.Error(t, parse(NewHMAC("other secret"), token))
+	// секрет не публикуется
+	assert.Empty(t, ks.JWKS().Keys)
+}
+
+func TestRotation(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	oldSet, err := New(oldKey)
+	require.NoError(t, err)
+	oldToken, err := oldSet.Sign(claims())
+	require.NoError(t, err)
+
+	// новый ключ подписи, старый остается только для проверки
+	newSet, err := New(newKey, oldKey.Public())
+	require.NoError(t, err)
+	newToken, err := newSet.Sign(claims())
+	require.NoError(t, err)
+	assert.NotEqual(t, oldSet.SigningKID(), newSet.SigningKID())
+
+	assert.NoError(t, parse(newSet, oldToken))
+	assert.NoError(t, parse(newSet, newToken))
+	// старый набор не знает новый ключ
+	assert.ErrorIs(t, parse(oldSet, newToken), ErrUnknownKey)
+
+	jwks := newSet.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	kty := map[string]string{}
+	for _, k := range jwks.Keys {
+		kty[k.KeyID] = k.KeyType + "/" + k.Algorithm
+	}
+	assert.EqualValues(t, "RSA/RS256", kty[newSet.SigningKID()])
+	assert.EqualValues(t, "OKP/EdDSA", kty[oldSet.SigningKID()])
+}
+
+func TestRejectHMACWithPublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ks, err := New(key)
+	require.NoError(t, err)
+
+	// токен подписан открытым ключом как секретом HMAC с верным kid - классическая подмена алгоритма
+	pub := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	token.Header["kid"] = ks.SigningKID()
+	forged, err := token.SignedString(pub)
+	require.NoError(t, err)
+	assert.ErrorIs(t, parse(ks, forged), ErrUnexpectedMethod)
+
+	// токены с общим секретом асимметричный набор не принимает
+	hmacToken, err := NewHMAC("secret").Sign(claims())
+	require.NoError(t, err)
+	assert.ErrorIs(t, parse(ks, hmacToken), ErrUnknownKey)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	signing, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signingDER, err := x509.MarshalPKCS8PrivateKey(signing)
+	require.NoError(t, err)
+	signingFile := filepath.Join(dir, "signing.pem")
+	require.NoError(t, os.WriteFile(signingFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: signingDER}), 0600))
+
+	verify, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	verifyDER, err := x509.MarshalPKIXPublicKey(verify)
+	require.NoError(t, err)
+	verifyFile := filepath.Join(dir, "verify.pem")
+	require.NoError(t, os.WriteFile(verifyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: verifyDER}), 0600))
+
+	ks, err := Load(signingFile, []string{verifyFile})
+	require.NoError(t, err)
+	assert.Len(t, ks.JWKS().Keys, 2)
+
+	_, err = Load(filepath.Join(dir, "not-found.pem"), nil)
+	assert.Error(t, err)
+	_, err = Load(signingFile, []string{signingFile + "x"})
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("broken"), 0600))
+	_, err = Load(filepath.Join(dir, "broken.pem"), nil)
+	assert.ErrorIs(t, err, ErrPEMBlockNotFound)
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// ResponseAccrualState состояние опроса системы расчета на экземпляре приложения
+type ResponseAccrualState struct {
+	Instance string `json:"instance"`
+	// Breaker состояние автоматического выключателя: closed, open или half-open
+	Breaker          string     `json:"breaker"`
+	BreakerOpenUntil *time.Time `json:"breaker_open_until,omitempty"`
+	// RateLimit текущая частота запросов в секунду
+	RateLimit float64 `json:"rate_limit"`
+	// PausedUntil до какого момента запросы приостановлены по Retry-After
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+}
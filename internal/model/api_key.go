@@ -0,0 +1,52 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// области действия (scopes) api ключей
+const (
+	ScopeOrdersRead   = "orders:read"
+	ScopeOrdersWrite  = "orders:write"
+	ScopeBalanceRead  = "balance:read"
+	ScopeBalanceWrite = "balance:write"
+)
+
+// Scopes все известные области действия api ключей
+var Scopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead, ScopeBalanceWrite}
+
+// DefaultScopes области действия ключа, выпущенного без списка областей: только чтение. Загрузка заказов и тем более списание баллов выдаются только явно
+var DefaultScopes = []string{ScopeOrdersRead, ScopeBalanceRead}
+
+// APIKey api ключ пользователя. Сам ключ не хранится, только его хеш и начало для отображения
+type APIKey struct {
+	KeyID  uuid.UUID `json:"id"`
+	Name   string    `json:"name"`
+	Prefix string    `json:"prefix"`
+	// Scopes области действия ключа. Пустой список - ключу доступны только DefaultScopes
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// APIKeyOwner владелец действующего api ключа
+type APIKeyOwner struct {
+	KeyID  uuid.UUID
+	UserID uuid.UUID
+	Login  string
+	Role   string
+	Scopes []string
+}
+
+type RequestAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// ResponseAPIKeyCreated созданный api ключ. Сам ключ показывается только один раз
+type ResponseAPIKeyCreated struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// AccrualFailure неудачная проверка заказа в системе расчета
+type AccrualFailure struct {
+	// Attempts сколько всего попыток сделано, включая эту
+	Attempts int
+	// Error описание ошибки
+	Error string
+	// HTTPStatus код ответа системы расчета. 0 - ответ не получен
+	HTTPStatus int
+}
+
+// DeadLetter заказ в очереди недоставленных: система расчета так и не вернула по нему окончательный статус
+type DeadLetter struct {
+	OrderNumber    OrderNumber `json:"number"`
+	Login          string      `json:"login"`
+	Status         string      `json:"status"`
+	Attempts       int         `json:"attempts"`
+	LastError      string      `json:"last_error"`
+	LastHTTPStatus int         `json:"last_http_status,omitempty"`
+	UploadedAt     time.Time   `json:"uploaded_at"`
+	DeadLetteredAt time.Time   `json:"dead_lettered_at"`
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// источники смены статуса заказа в истории
+const (
+	// StatusSourceUpload заказ загружен пользователем
+	StatusSourceUpload = "upload"
+	// StatusSourcePoller статус получен опросом системы расчета
+	StatusSourcePoller = "poller"
+	// StatusSourceWebhook статус пришел событием от системы расчета
+	StatusSourceWebhook = "webhook"
+	// StatusSourceAdmin статус изменен сотрудником
+	StatusSourceAdmin = "admin"
+	// StatusSourceLegacy статус, записанный до появления истории. Откуда он пришел, неизвестно
+	StatusSourceLegacy = "legacy"
+)
+
+// OrderStatusChange запись истории статусов заказа
+type OrderStatusChange struct {
+	Status Status `json:"status"`
+	Source string `json:"source"`
+	// Payload ответ или событие системы расчета, с которым пришел статус, как есть
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+// OrderHistory история статусов заказа от загрузки к текущему
+type OrderHistory []OrderStatusChange
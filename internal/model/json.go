@@ -111,6 +111,8 @@ func (o *ResponseAccuralSystem) UnmarshalJSON(data []byte) (err error) {
 		// переопределяем поле внутри анонимной структуры
 		UploadedAt string `json:"uploaded_at"`
 		Status     string `json:"status"`
+		// начисление системы расчета может оказаться точнее сотых. Его не отклоняем, а усекаем и отмечаем в AccrualReported
+		Accrual json.RawMessage `json:"accrual"`
 	}{
 		ResponseAccuralSystemAlias: (*ResponseAccuralSystemAlias)(o),
 	}
@@ -119,5 +121,55 @@ func (o *ResponseAccuralSystem) UnmarshalJSON(data []byte) (err error) {
 		return err
 	}
 	o.Status = NewStatus(0, aliasValue.Status)
+	o.Accrual, o.AccrualReported = 0, ""
+	if aliasValue.Accrual != nil {
+		var truncated bool
+		o.Accrual, truncated, err = unmarshalMoney(aliasValue.Accrual)
+		if err != nil {
+			return err
+		}
+		if truncated {
+			o.AccrualReported = string(aliasValue.Accrual)
+		}
+	}
+	return
+}
+func (c OrderStatusChange) MarshalJSON() ([]byte, error) {
+	// чтобы избежать рекурсии при json.Marshal, объявляем новый тип
+	type OrderStatusChangeAlias OrderStatusChange
+
+	aliasValue := struct {
+		OrderStatusChangeAlias
+		// переопределяем поля внутри анонимной структуры
+		Status    string `json:"status"`
+		ChangedAt string `json:"changed_at"`
+	}{
+		OrderStatusChangeAlias: OrderStatusChangeAlias(c),
+		Status:                 c.Status.Value(),
+		ChangedAt:              c.ChangedAt.Format(time.RFC3339),
+	}
+
+	return json.Marshal(aliasValue)
+}
+func (c *OrderStatusChange) UnmarshalJSON(data []byte) (err error) {
+	// чтобы избежать рекурсии при json.Unmarshal, объявляем новый тип
+	type OrderStatusChangeAlias OrderStatusChange
+
+	aliasValue := &struct {
+		*OrderStatusChangeAlias
+		// переопределяем поля внутри анонимной структуры
+		Status    string `json:"status"`
+		ChangedAt string `json:"changed_at"`
+	}{
+		OrderStatusChangeAlias: (*OrderStatusChangeAlias)(c),
+	}
+	if err = json.Unmarshal(data, aliasValue); err != nil {
+		return err
+	}
+	c.ChangedAt, err = time.Parse(time.RFC3339, aliasValue.ChangedAt)
+	if err != nil {
+		return err
+	}
+	c.Status = NewStatus(0, aliasValue.Status)
 	return
 }
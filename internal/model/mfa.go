@@ -0,0 +1,44 @@
+package model
+
+import "github.com/google/uuid"
+
+// TOTP секрет двухфакторной аутентификации пользователя
+type TOTP struct {
+	Secret string
+	// Confirmed подтвердил ли пользователь привязку кодом из приложения. Неподтвержденный секрет при входе не используется
+	Confirmed bool
+	// LastStep последний принятый шаг времени. Коды этого и более ранних шагов повторно не принимаются
+	LastStep int64
+}
+
+// MFAChallenge незавершенный вход: пароль проверен, ожидается второй фактор
+type MFAChallenge struct {
+	UserID uuid.UUID
+	Login  string
+	Role   string
+}
+
+// RequestSecondFactor второй фактор: код из приложения или одноразовый код восстановления
+type RequestSecondFactor struct {
+	MFAToken     string `json:"mfa_token,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// ResponseMFARequired ответ на вход по паролю, если у пользователя включена двухфакторная аутентификация
+type ResponseMFARequired struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// ResponseTOTPEnroll секрет для добавления в приложение-аутентификатор
+type ResponseTOTPEnroll struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// ResponseRecoveryCodes одноразовые коды восстановления. Показываются только один раз
+type ResponseRecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
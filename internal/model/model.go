@@ -12,30 +12,58 @@ type RequestLogin struct {
 	Password string `json:"password"`
 }
 
+// ResponseToken пара токенов, выдаваемая при входе, регистрации и обновлении сессии
+type ResponseToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RequestRefreshToken struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RequestChangePassword struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type RequestPasswordReset struct {
+	Login string `json:"login"`
+}
+
+type RequestPasswordResetConfirm struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
 type OrderNumber string
 
 type ResponseOrder struct {
 	OrderNumber OrderNumber `json:"number"`
 	Status      Status      `json:"status"`
-	Accrual     float64     `json:"accrual,omitempty"`
+	Accrual     Money       `json:"accrual,omitempty"`
 	UploadedAt  time.Time   `json:"uploaded_at"`
+	// Attempts сколько раз заказ уже проверялся в системе расчета. Заполняется только при захвате заказов для проверки
+	Attempts int `json:"-"`
 }
 
 type ResponseOrders []ResponseOrder
 
 type ResponseBalance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   Money `json:"current"`
+	Withdrawn Money `json:"withdrawn"`
 }
 
 type RequestWithdraw struct {
 	OrderNumber OrderNumber `json:"order"`
-	Sum         float64     `json:"sum"`
+	Sum         Money       `json:"sum"`
 }
 
 type ResponseWithdraw struct {
 	OrderNumber OrderNumber `json:"order"`
-	Sum         float64     `json:"sum"`
+	Sum         Money       `json:"sum"`
 	ProcessedAt time.Time   `json:"processed_at"`
 }
 
@@ -44,5 +72,12 @@ type ResponseWithdrawals []ResponseWithdraw
 type ResponseAccuralSystem struct {
 	OrderNumber OrderNumber `json:"order"`
 	Status      Status      `json:"status"`
-	Accrual     float64     `json:"accrual,omitempty"`
+	Accrual     Money       `json:"accrual,omitempty"`
+	// AccrualReported начисление в том виде, в котором его прислала система расчета, если оно было точнее сотых и усечено до Accrual.
+	// Пустое, если усекать не пришлось
+	AccrualReported string `json:"-"`
+	// Source откуда получен статус (StatusSource...), попадает в историю статусов заказа
+	Source string `json:"-"`
+	// Payload тело ответа или события системы расчета как есть, попадает в историю статусов заказа
+	Payload []byte `json:"-"`
 }
@@ -13,7 +13,7 @@ func TestMarshalResponseOrder(t *testing.T) {
 	val := ResponseOrder{
 		OrderNumber: "12345",
 		Status:      NewStatus(0, "NEW"),
-		Accrual:     11.1,
+		Accrual:     1110,
 		UploadedAt:  t1,
 	}
 	expect := `{"number":"12345","status":"NEW","accrual":11.1,"uploaded_at":"` + t1.Format(time.RFC3339) + `"}`
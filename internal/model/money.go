@@ -0,0 +1,130 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money сумма баллов в сотых долях (копейках). Складывается и сравнивается без погрешностей, в JSON и в базе - десятичное число с двумя знаками
+type Money int64
+
+var (
+	ErrInvalidMoney  = errors.New("сумма должна быть десятичным числом")
+	ErrSubCentMoney  = errors.New("сумма точнее сотых долей")
+	ErrNegativeMoney = errors.New("сумма не может быть отрицательной")
+)
+
+// hundred сотых в единице
+var hundred = big.NewRat(100, 1)
+
+// ParseMoney разбирает десятичную запись суммы ("729.98", "500", "-1.5", "72998e-2").
+// Сумму точнее сотых долей не округляет, а возвращает ErrSubCentMoney
+func ParseMoney(s string) (Money, error) {
+	m, truncated, err := parseMoney(s)
+	if err != nil {
+		return 0, err
+	}
+	if truncated {
+		return 0, fmt.Errorf("%w: %q", ErrSubCentMoney, s)
+	}
+	return m, nil
+}
+
+// parseMoney разбирает десятичную запись суммы, отбрасывая знаки точнее сотых долей. truncated - были ли отброшены ненулевые знаки
+func parseMoney(s string) (m Money, truncated bool, err error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, false, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	r.Mul(r, hundred)
+	// Quo отбрасывает дробную часть (округляет к нулю)
+	v := new(big.Int).Quo(r.Num(), r.Denom())
+	if !v.IsInt64() {
+		return 0, false, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	return Money(v.Int64()), !r.IsInt(), nil
+}
+
+// String десятичная запись суммы без лишних нулей: 729.98, 500, 0.5
+func (m Money) String() string {
+	sign := ""
+	v := uint64(m)
+	if m < 0 {
+		sign = "-"
+		v = uint64(-m)
+	}
+	units, cents := v/100, v%100
+	switch {
+	case cents == 0:
+		return sign + strconv.FormatUint(units, 10)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON принимает только неотрицательное число не точнее сотых долей. Строки и null не принимаются
+func (m *Money) UnmarshalJSON(data []byte) error {
+	v, truncated, err := unmarshalMoney(data)
+	if err != nil {
+		return err
+	}
+	if truncated {
+		return fmt.Errorf("%w: %s", ErrSubCentMoney, data)
+	}
+	*m = v
+	return nil
+}
+
+// unmarshalMoney разбирает неотрицательное число из JSON, отбрасывая знаки точнее сотых долей.
+// Усеченная сумма принимается только от системы расчета (ResponseAccuralSystem), запросы пользователей разбираются строго Money.UnmarshalJSON
+func unmarshalMoney(data []byte) (m Money, truncated bool, err error) {
+	if len(data) == 0 || (data[0] != '-' && (data[0] < '0' || data[0] > '9')) {
+		return 0, false, fmt.Errorf("%w: %s", ErrInvalidMoney, data)
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return 0, false, fmt.Errorf("%w: %s", ErrInvalidMoney, data)
+	}
+	if strings.HasPrefix(n.String(), "-") {
+		return 0, false, fmt.Errorf("%w: %s", ErrNegativeMoney, data)
+	}
+	return parseMoney(n.String())
+}
+
+// Value сумма для базы. Передается десятичной строкой, чтобы numeric получил ее без потерь
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan сумма из numeric
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case string:
+		parsed, err := ParseMoney(v)
+		*m = parsed
+		return err
+	case []byte:
+		parsed, err := ParseMoney(string(v))
+		*m = parsed
+		return err
+	case int64:
+		*m = Money(v * 100)
+		return nil
+	default:
+		return fmt.Errorf("%w: %T", ErrInvalidMoney, src)
+	}
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		err  error
+	}{
+		{"729.98", 72998, nil},
+		{"500", 50000, nil},
+		{"0.5", 50, nil},
+		{"0.10", 10, nil},
+		{"1.230", 123, nil},
+		{"-1.5", -150, nil},
+		{"72998e-2", 72998, nil},
+		{"0.001", 0, ErrSubCentMoney},
+		{"1.005", 0, ErrSubCentMoney},
+		{"abc", 0, ErrInvalidMoney},
+		{"1e30", 0, ErrInvalidMoney},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMoney(tt.in)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "729.98", Money(72998).String())
+	assert.Equal(t, "500", Money(50000).String())
+	assert.Equal(t, "0.5", Money(50).String())
+	assert.Equal(t, "0.05", Money(5).String())
+	assert.Equal(t, "-1.5", Money(-150).String())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	// сумма сотых не накапливает погрешность, как 0.1+0.2 во float64
+	b, err := json.Marshal(Money(10) + Money(20))
+	require.NoError(t, err)
+	assert.Equal(t, "0.3", string(b))
+
+	var m Money
+	require.NoError(t, json.Unmarshal([]byte("729.98"), &m))
+	assert.Equal(t, Money(72998), m)
+	assert.ErrorIs(t, json.Unmarshal([]byte("0.001"), &m), ErrSubCentMoney)
+	assert.ErrorIs(t, json.Unmarshal([]byte("-1"), &m), ErrNegativeMoney)
+	assert.ErrorIs(t, json.Unmarshal([]byte(`"1"`), &m), ErrInvalidMoney)
+	assert.ErrorIs(t, json.Unmarshal([]byte("null"), &m), ErrInvalidMoney)
+
+	req := RequestWithdraw{}
+	assert.Error(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":751.005}`), &req))
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"2377225624","sum":751}`), &req))
+	assert.Equal(t, Money(75100), req.Sum)
+}
+
+func TestResponseAccuralSystemJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantAccrual  Money
+		wantReported string
+		wantErr      error
+	}{
+		{name: "без начисления", body: `{"order":"1","status":"PROCESSING"}`},
+		{name: "сотые", body: `{"order":"1","status":"PROCESSED","accrual":729.98}`, wantAccrual: 72998},
+		{name: "точнее сотых", body: `{"order":"1","status":"PROCESSED","accrual":729.989}`, wantAccrual: 72998, wantReported: "729.989"},
+		{name: "отрицательное", body: `{"order":"1","status":"PROCESSED","accrual":-0.5}`, wantErr: ErrNegativeMoney},
+		{name: "строка", body: `{"order":"1","status":"PROCESSED","accrual":"1"}`, wantErr: ErrInvalidMoney},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o ResponseAccuralSystem
+			err := json.Unmarshal([]byte(tt.body), &o)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAccrual, o.Accrual)
+			assert.Equal(t, tt.wantReported, o.AccrualReported)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// роли пользователей
+const (
+	// RoleUser обычный пользователь. Роль по умолчанию
+	RoleUser = "user"
+	// RoleSupport сотрудник поддержки. Может просматривать пользователей, снимать блокировки входа и завершать сессии
+	RoleSupport = "support"
+	// RoleAdmin администратор. Может все, что и поддержка, а также назначать роли
+	RoleAdmin = "admin"
+)
+
+// Roles все известные роли
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
+// User информация о пользователе для администрирования
+type User struct {
+	UserID    uuid.UUID `json:"id"`
+	Login     string    `json:"login"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RequestRole struct {
+	Role string `json:"role"`
+}
+
+type RequestUnlock struct {
+	Login string `json:"login"`
+	IP    string `json:"ip"`
+}
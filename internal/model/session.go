@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session сессия пользователя. Создается при входе и живет до отзыва или истечения refresh токена
+type Session struct {
+	SessionID uuid.UUID
+	UserID    uuid.UUID
+	Login     string
+	Role      string
+	// MFA вход в сессию подтвержден вторым фактором
+	MFA       bool
+	ExpiresAt time.Time
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserCredentials данные пользователя, необходимые для входа
+type UserCredentials struct {
+	UserID       uuid.UUID
+	Login        string
+	Role         string
+	PasswordHash string
+	// TOTPEnabled включена ли у пользователя двухфакторная аутентификация
+	TOTPEnabled bool
+}
+
+// LoginAttempts неудачные попытки входа по ключу (логин или ip адрес)
+type LoginAttempts struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	// LockedUntil до какого момента вход по ключу заблокирован. Нулевое значение - блокировки нет
+	LockedUntil time.Time
+}
+
+// LoginConflict логин, который не удалось привести к каноническому виду: канонический логин совпал с чужим.
+// Пока конфликт не решен, пользователь не может войти
+type LoginConflict struct {
+	UserID         uuid.UUID
+	Login          string
+	CanonicalLogin string
+	// OwnerLogin логин пользователя, которому принадлежит канонический логин. Пустой - канонический логин свободен,
+	// но на него претендуют несколько пользователей
+	OwnerLogin string
+	DetectedAt time.Time
+}
+
+// LoginAttemptsLoginKey ключ неудачных попыток входа для логина
+func LoginAttemptsLoginKey(login string) string {
+	return "login:" + login
+}
+
+// LoginAttemptsIPKey ключ неудачных попыток входа для ip адреса
+func LoginAttemptsIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// PasswordResetRequests запросы на сброс пароля по ключу (логин или ip адрес) в текущем окне подсчета
+type PasswordResetRequests struct {
+	Key      string
+	Requests int
+	// WindowStartedAt время первого запроса окна. Окно не продлевается следующими запросами
+	WindowStartedAt time.Time
+}
+
+// PasswordResetLoginKey ключ запросов на сброс пароля для логина
+func PasswordResetLoginKey(login string) string {
+	return "login:" + login
+}
+
+// PasswordResetIPKey ключ запросов на сброс пароля для ip адреса
+func PasswordResetIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// ValidationError нарушение правил в запросе. Code предназначен для клиента, Message для человека
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponseValidationErrors тело ответа 400 при нарушении правил
+type ResponseValidationErrors struct {
+	Errors []ValidationError `json:"errors"`
+}
@@ -0,0 +1,76 @@
+// пакет для отправки уведомлений пользователям (например, ссылки для сброса пароля).
+// Способ доставки подключается через интерфейс Notifier, для разработки есть вывод в лог и в файл
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message уведомление пользователю
+type Message struct {
+	// To получатель. Пока у пользователя нет других контактов, это его логин
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Notifier доставляет уведомления пользователям
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogNotifier пишет уведомления в лог. Подходит только для разработки: в лог попадают секреты из уведомлений
+type LogNotifier struct {
+	log *slog.Logger
+}
+
+// NewLog уведомления в лог log
+func NewLog(log *slog.Logger) *LogNotifier {
+	return &LogNotifier{log: log}
+}
+
+func (n *LogNotifier) Notify(_ context.Context, msg Message) error {
+	n.log.Info("уведомление пользователю",
+		slog.String("кому", msg.To),
+		slog.String("тема", msg.Subject),
+		slog.String("текст", msg.Body),
+	)
+	return nil
+}
+
+// FileNotifier дописывает уведомления в файл, по одному JSON на строку
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFile уведомления в файл path. Файл создается при первом уведомлении
+func NewFile(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(_ context.Context, msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("открытие файла уведомлений. %w", err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,36 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	n := NewFile(path)
+	require.NoError(t, n.Notify(context.Background(), Message{To: "user-1", Subject: "first", Body: "body-1"}))
+	require.NoError(t, n.Notify(context.Background(), Message{To: "user-2", Subject: "second", Body: "body-2"}))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	msgs := []Message{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		msg := Message{}
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &msg))
+		msgs = append(msgs, msg)
+	}
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "user-1", msgs[0].To)
+	assert.Equal(t, "body-2", msgs[1].Body)
+	assert.False(t, msgs[1].SentAt.IsZero())
+}
@@ -0,0 +1,101 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2idPrefix = "$argon2id$"
+	argon2SaltLen  = 16
+	argon2KeyLen   = 32
+)
+
+// Argon2Params параметры argon2id
+type Argon2Params struct {
+	// Memory память в KiB
+	Memory uint32
+	// Time число проходов
+	Time uint32
+	// Threads степень параллелизма
+	Threads uint8
+}
+
+// Argon2id алгоритм argon2id. Хеш хранится в формате PHC: $argon2id$v=19$m=65536,t=3,p=2$соль$хеш
+type Argon2id struct {
+	params Argon2Params
+}
+
+// NewArgon2id argon2id с параметрами params. Нулевые число проходов и параллелизм заменяются единицей
+func NewArgon2id(params Argon2Params) *Argon2id {
+	params.Time = max(params.Time, 1)
+	params.Threads = max(params.Threads, 1)
+	return &Argon2id{params: params}
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.params.Time, a.params.Memory, a.params.Threads, argon2KeyLen)
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		a.params.Memory,
+		a.params.Time,
+		a.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2id) Verify(password, hash string) (bool, error) {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, actual) == 1, nil
+}
+
+func (a *Argon2id) Supports(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (a *Argon2id) Outdated(hash string) bool {
+	params, _, _, err := parseArgon2id(hash)
+	return err != nil || params != a.params
+}
+
+// parseArgon2id разбор хеша в формате PHC
+func parseArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	// ["", "argon2id", "v=19", "m=...,t=...,p=...", соль, хеш]
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, ErrMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: версия %s", ErrMalformedHash, parts[2])
+	}
+	params := Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: параметры %s", ErrMalformedHash, parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: соль. %w", ErrMalformedHash, err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("%w: хеш", ErrMalformedHash)
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,99 @@
+// пакет для хеширования паролей. Алгоритм записывается в сам хеш, поэтому хеши разных алгоритмов и параметров могут храниться вперемешку,
+// а устаревшие прозрачно заменяются новыми при входе пользователя
+package password
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+var (
+	ErrUnknownHash      = errors.New("хеш пароля неизвестного формата")
+	ErrMalformedHash    = errors.New("хеш пароля поврежден")
+	ErrUnknownAlgorithm = errors.New("неизвестный алгоритм хеширования пароля")
+	ErrBusy             = errors.New("хеширование паролей перегружено")
+)
+
+// Algorithm алгоритм хеширования паролей с конкретными параметрами
+type Algorithm interface {
+	// Hash хеш пароля. Алгоритм и параметры записываются в результат
+	Hash(password string) (string, error)
+	// Verify сравнивает пароль с хешом, сделанным этим алгоритмом (с любыми параметрами)
+	Verify(password, hash string) (bool, error)
+	// Supports сделан ли хеш этим алгоритмом
+	Supports(hash string) bool
+	// Outdated сделан ли хеш с параметрами, отличными от текущих
+	Outdated(hash string) bool
+}
+
+// Hasher хеширует пароли текущим алгоритмом, а проверяет любым из известных
+type Hasher struct {
+	current    Algorithm
+	algorithms []Algorithm
+	// sem ограничивает число одновременных хеширований: argon2id на каждый вызов выделяет десятки мегабайт,
+	// и поток входов под случайными логинами без ограничения исчерпал бы память
+	sem chan struct{}
+}
+
+// New новые хеши делаются алгоритмом current, для проверки дополнительно поддерживаются алгоритмы legacy.
+// Одновременно выполняется не больше concurrency хеширований и проверок, остальные ждут до отмены контекста. Значение меньше 1 - по числу процессоров
+func New(concurrency int, current Algorithm, legacy ...Algorithm) *Hasher {
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+	return &Hasher{
+		current:    current,
+		algorithms: append([]Algorithm{current}, legacy...),
+		sem:        make(chan struct{}, concurrency),
+	}
+}
+
+// acquire занимает место для хеширования. Вызывающий обязан освободить его через release.
+// Если место не освободилось до отмены ctx, возвращает ErrBusy
+func (h *Hasher) acquire(ctx context.Context) error {
+	// при свободном месте select выбирал бы случайно, а отмененный запрос хешировать незачем
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrBusy, err)
+	}
+	select {
+	case h.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrBusy, ctx.Err())
+	}
+}
+
+// release освобождает место, занятое acquire
+func (h *Hasher) release() {
+	<-h.sem
+}
+
+// Hash хеш пароля текущим алгоритмом
+func (h *Hasher) Hash(ctx context.Context, password string) (string, error) {
+	if err := h.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer h.release()
+	return h.current.Hash(password)
+}
+
+// Verify сравнивает пароль с хешом. needsRehash - пароль верный, но хеш сделан другим алгоритмом или с устаревшими параметрами и его стоит пересчитать
+func (h *Hasher) Verify(ctx context.Context, password, hash string) (ok bool, needsRehash bool, err error) {
+	for _, alg := range h.algorithms {
+		if !alg.Supports(hash) {
+			continue
+		}
+		if err = h.acquire(ctx); err != nil {
+			return false, false, err
+		}
+		ok, err = alg.Verify(password, hash)
+		h.release()
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, alg != h.current || h.current.Outdated(hash), nil
+	}
+	return false, false, fmt.Errorf("%w: %.8q", ErrUnknownHash, hash)
+}
@@ -0,0 +1,170 @@
+package password
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2Params = Argon2Params{Memory: 1024, Time: 1, Threads: 1}
+
+func TestArgon2id(t *testing.T) {
+	a := NewArgon2id(testArgon2Params)
+	hash, err := a.Hash("secret-password")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.True(t, a.Supports(hash))
+	assert.False(t, a.Outdated(hash))
+
+	ok, err := a.Verify("secret-password", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = a.Verify("wrong-password", hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// одинаковые пароли дают разные хеши за счет соли
+	hash2, err := a.Hash("secret-password")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, hash2)
+
+	// другие параметры - хеш устарел, но проверяется
+	stronger := NewArgon2id(Argon2Params{Memory: 2048, Time: 1, Threads: 1})
+	assert.True(t, stronger.Outdated(hash))
+	ok, err = stronger.Verify("secret-password", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	for _, broken := range []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!$aGFzaA",
+	} {
+		_, err = a.Verify("secret-password", broken)
+		assert.ErrorIs(t, err, ErrMalformedHash, broken)
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	b := NewBcrypt(bcrypt.MinCost)
+	hash, err := b.Hash("secret-password")
+	require.NoError(t, err)
+	assert.True(t, b.Supports(hash))
+	assert.False(t, b.Outdated(hash))
+	assert.True(t, NewBcrypt(bcrypt.MinCost+1).Outdated(hash))
+
+	ok, err := b.Verify("secret-password", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = b.Verify("wrong-password", hash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// недопустимая стоимость заменяется стоимостью по умолчанию
+	assert.Equal(t, bcrypt.DefaultCost, NewBcrypt(100).cost)
+}
+
+func TestHasher(t *testing.T) {
+	current := NewArgon2id(testArgon2Params)
+	legacy := NewBcrypt(bcrypt.MinCost)
+	h := New(2, current, legacy)
+	ctx := context.Background()
+
+	hash, err := h.Hash(ctx, "secret-password")
+	require.NoError(t, err)
+	assert.True(t, current.Supports(hash))
+	ok, needsRehash, err := h.Verify(ctx, "secret-password", hash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+
+	// хеш старого алгоритма проверяется и требует пересчета
+	legacyHash, err := legacy.Hash("secret-password")
+	require.NoError(t, err)
+	ok, needsRehash, err = h.Verify(ctx, "secret-password", legacyHash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+
+	// при неверном пароле пересчет не нужен
+	ok, needsRehash, err = h.Verify(ctx, "wrong-password", legacyHash)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, needsRehash)
+
+	// хеш текущего алгоритма с устаревшими параметрами
+	oldParamsHash, err := NewArgon2id(Argon2Params{Memory: 512, Time: 1, Threads: 1}).Hash("secret-password")
+	require.NoError(t, err)
+	ok, needsRehash, err = h.Verify(ctx, "secret-password", oldParamsHash)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+
+	_, _, err = h.Verify(ctx, "secret-password", "plain-text")
+	assert.ErrorIs(t, err, ErrUnknownHash)
+}
+
+// slowAlgorithm алгоритм, который считает одновременные вызовы
+type slowAlgorithm struct {
+	running, peak atomic.Int32
+}
+
+func (a *slowAlgorithm) Hash(string) (string, error) {
+	n := a.running.Add(1)
+	defer a.running.Add(-1)
+	for {
+		p := a.peak.Load()
+		if n <= p || a.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return "slow", nil
+}
+func (a *slowAlgorithm) Verify(password, _ string) (bool, error) {
+	_, err := a.Hash(password)
+	return true, err
+}
+func (a *slowAlgorithm) Supports(hash string) bool { return hash == "slow" }
+func (a *slowAlgorithm) Outdated(string) bool      { return false }
+
+func TestHasherConcurrency(t *testing.T) {
+	alg := &slowAlgorithm{}
+	h := New(2, alg)
+	ctx := context.Background()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := h.Hash(ctx, "secret-password")
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _, err := h.Verify(ctx, "secret-password", "slow")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 2, alg.peak.Load())
+
+	// все места заняты - ожидание прерывается вместе с контекстом
+	require.NoError(t, h.acquire(ctx))
+	require.NoError(t, h.acquire(ctx))
+	ctxBusy, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := h.Hash(ctxBusy, "secret-password")
+	assert.ErrorIs(t, err, ErrBusy)
+	_, _, err = h.Verify(ctxBusy, "secret-password", "slow")
+	assert.ErrorIs(t, err, ErrBusy)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,36 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/kTowkA/gophermart/internal/model"
+)
+
+const (
+	CodeAPIKeyNameTooLong = "api_key_name_too_long"
+	CodeScopeUnknown      = "scope_unknown"
+)
+
+const (
+	FieldName   = "name"
+	FieldScopes = "scopes"
+)
+
+// apiKeyNameMaxLength максимальная длина названия api ключа в символах
+const apiKeyNameMaxLength = 64
+
+// ValidateAPIKey проверяет название и области действия нового api ключа
+func ValidateAPIKey(name string, scopes []string) []model.ValidationError {
+	var errs []model.ValidationError
+	if utf8.RuneCountInString(name) > apiKeyNameMaxLength {
+		errs = append(errs, model.ValidationError{Field: FieldName, Code: CodeAPIKeyNameTooLong, Message: fmt.Sprintf("название ключа длиннее %d символов", apiKeyNameMaxLength)})
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(model.Scopes, scope) {
+			errs = append(errs, model.ValidationError{Field: FieldScopes, Code: CodeScopeUnknown, Message: fmt.Sprintf("неизвестная область действия %q", scope)})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,172 @@
+// пакет с правилами для логина и пароля пользователя: нормализация логина, ограничения на длину и набор символов, сложность пароля и проверка по списку утекших паролей
+package policy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kTowkA/gophermart/internal/model"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// коды нарушений. По ним клиент понимает, что именно не так, не разбирая текст сообщения
+const (
+	CodeLoginRequired         = "login_required"
+	CodeLoginTooShort         = "login_too_short"
+	CodeLoginTooLong          = "login_too_long"
+	CodeLoginInvalidChars     = "login_invalid_chars"
+	CodePasswordRequired      = "password_required"
+	CodePasswordTooShort      = "password_too_short"
+	CodePasswordTooLong       = "password_too_long"
+	CodePasswordTooSimple     = "password_too_simple"
+	CodePasswordBreached      = "password_breached"
+	CodePasswordContainsLogin = "password_contains_login"
+)
+
+// поля запроса, к которым относятся нарушения
+const (
+	FieldLogin    = "login"
+	FieldPassword = "password"
+)
+
+// breachedDefault небольшой встроенный список самых распространенных утекших паролей. Дополняется файлом из Rules.BreachedFile
+//
+//go:embed breached.txt
+var breachedDefault string
+
+// Rules настройки правил
+type Rules struct {
+	// LoginMinLength и LoginMaxLength ограничения на длину логина в символах (после нормализации)
+	LoginMinLength int
+	LoginMaxLength int
+	// LoginPattern допустимые символы логина (после нормализации)
+	LoginPattern string
+	// PasswordMinLength минимальная длина пароля в символах
+	PasswordMinLength int
+	// PasswordMaxBytes максимальная длина пароля в байтах. bcrypt молча отбрасывает все после 72 байт
+	PasswordMaxBytes int
+	// PasswordMinClasses сколько разных классов символов (строчные, заглавные, цифры, прочие) должно быть в пароле
+	PasswordMinClasses int
+	// BreachedFile файл со списком утекших паролей, по одному на строку. Необязательный
+	BreachedFile string
+}
+
+// Policy проверка логина и пароля по правилам
+type Policy struct {
+	rules        Rules
+	loginPattern *regexp.Regexp
+	breached     map[string]struct{}
+}
+
+// New создает проверку по правилам rules. Список утекших паролей загружается сразу
+func New(rules Rules) (*Policy, error) {
+	loginPattern, err := regexp.Compile(rules.LoginPattern)
+	if err != nil {
+		return nil, fmt.Errorf("разбор шаблона логина. %w", err)
+	}
+	p := &Policy{
+		rules:        rules,
+		loginPattern: loginPattern,
+		breached:     make(map[string]struct{}),
+	}
+	_ = p.addBreached(strings.NewReader(breachedDefault))
+	if rules.BreachedFile != "" {
+		f, err := os.Open(rules.BreachedFile)
+		if err != nil {
+			return nil, fmt.Errorf("открытие списка утекших паролей. %w", err)
+		}
+		defer f.Close()
+		if err = p.addBreached(f); err != nil {
+			return nil, fmt.Errorf("чтение списка утекших паролей. %w", err)
+		}
+	}
+	return p, nil
+}
+
+// addBreached добавляет пароли из r в список утекших. Пароли сравниваются без учета регистра
+func (p *Policy) addBreached(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		p.breached[cases.Fold().String(line)] = struct{}{}
+	}
+	return sc.Err()
+}
+
+// NormalizeLogin приводит логин к каноническому виду: NFKC нормализация и приведение регистра (case folding).
+// Так "Alice", "ALICE" и "Ａｌｉｃｅ" считаются одним логином. Caser хранит состояние, поэтому создается на каждый вызов
+func NormalizeLogin(login string) string {
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(strings.TrimSpace(login))))
+}
+
+// ValidateLogin проверяет уже нормализованный логин
+func (p *Policy) ValidateLogin(login string) []model.ValidationError {
+	length := utf8.RuneCountInString(login)
+	switch {
+	case length == 0:
+		return []model.ValidationError{{Field: FieldLogin, Code: CodeLoginRequired, Message: "логин не указан"}}
+	case length < p.rules.LoginMinLength:
+		return []model.ValidationError{{Field: FieldLogin, Code: CodeLoginTooShort, Message: fmt.Sprintf("логин короче %d символов", p.rules.LoginMinLength)}}
+	case length > p.rules.LoginMaxLength:
+		return []model.ValidationError{{Field: FieldLogin, Code: CodeLoginTooLong, Message: fmt.Sprintf("логин длиннее %d символов", p.rules.LoginMaxLength)}}
+	case !p.loginPattern.MatchString(login):
+		return []model.ValidationError{{Field: FieldLogin, Code: CodeLoginInvalidChars, Message: "логин содержит недопустимые символы"}}
+	}
+	return nil
+}
+
+// ValidatePassword проверяет пароль. Если известен нормализованный логин login, пароль не должен его содержать
+func (p *Policy) ValidatePassword(password, login string) []model.ValidationError {
+	if password == "" {
+		return []model.ValidationError{{Field: FieldPassword, Code: CodePasswordRequired, Message: "пароль не указан"}}
+	}
+	errs := []model.ValidationError{}
+	if utf8.RuneCountInString(password) < p.rules.PasswordMinLength {
+		errs = append(errs, model.ValidationError{Field: FieldPassword, Code: CodePasswordTooShort, Message: fmt.Sprintf("пароль короче %d символов", p.rules.PasswordMinLength)})
+	}
+	if p.rules.PasswordMaxBytes > 0 && len(password) > p.rules.PasswordMaxBytes {
+		errs = append(errs, model.ValidationError{Field: FieldPassword, Code: CodePasswordTooLong, Message: fmt.Sprintf("пароль длиннее %d байт", p.rules.PasswordMaxBytes)})
+	}
+	if charClasses(password) < p.rules.PasswordMinClasses {
+		errs = append(errs, model.ValidationError{Field: FieldPassword, Code: CodePasswordTooSimple, Message: fmt.Sprintf("пароль должен содержать символы хотя бы %d разных видов: строчные и заглавные буквы, цифры, прочие символы", p.rules.PasswordMinClasses)})
+	}
+	if _, ok := p.breached[cases.Fold().String(password)]; ok {
+		errs = append(errs, model.ValidationError{Field: FieldPassword, Code: CodePasswordBreached, Message: "пароль встречается в списках утекших паролей"})
+	}
+	if login != "" && strings.Contains(NormalizeLogin(password), login) {
+		errs = append(errs, model.ValidationError{Field: FieldPassword, Code: CodePasswordContainsLogin, Message: "пароль не должен содержать логин"})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// charClasses сколько разных классов символов встречается в строке
+func charClasses(s string) int {
+	var lower, upper, digit, other int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
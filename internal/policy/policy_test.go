@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRules = Rules{
+	LoginMinLength:     3,
+	LoginMaxLength:     16,
+	LoginPattern:       `^[\p{L}\p{N}._-]+$`,
+	PasswordMinLength:  8,
+	PasswordMaxBytes:   72,
+	PasswordMinClasses: 2,
+}
+
+func codes(errs []model.ValidationError) []string {
+	res := []string{}
+	for _, e := range errs {
+		res = append(res, e.Code)
+	}
+	return res
+}
+
+func TestNormalizeLogin(t *testing.T) {
+	assert.Equal(t, "alice", NormalizeLogin("Alice"))
+	assert.Equal(t, "alice", NormalizeLogin(" ALICE "))
+	// полноширинные символы приводятся к обычным
+	assert.Equal(t, "alice", NormalizeLogin("Ａｌｉｃｅ"))
+	// case folding, а не просто нижний регистр
+	assert.Equal(t, NormalizeLogin("STRASSE"), NormalizeLogin("Straße"))
+}
+
+func TestValidateLogin(t *testing.T) {
+	p, err := New(testRules)
+	require.NoError(t, err)
+	tests := []struct {
+		login string
+		want  []string
+	}{
+		{login: "alice", want: []string{}},
+		{login: "алиса.2", want: []string{}},
+		{login: "", want: []string{CodeLoginRequired}},
+		{login: "al", want: []string{CodeLoginTooShort}},
+		{login: "alice-with-a-very-long-login", want: []string{CodeLoginTooLong}},
+		{login: "alice smith", want: []string{CodeLoginInvalidChars}},
+		{login: "alice<script>", want: []string{CodeLoginInvalidChars}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.login, func(t *testing.T) {
+			assert.ElementsMatch(t, tt.want, codes(p.ValidateLogin(tt.login)))
+		})
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(breached, []byte("Correct-Horse\n\n"), 0600))
+	rules := testRules
+	rules.BreachedFile = breached
+	p, err := New(rules)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		password string
+		login    string
+		want     []string
+	}{
+		{name: "хороший пароль", password: "Str0ng-passphrase", want: []string{}},
+		{name: "пустой", password: "", want: []string{CodePasswordRequired}},
+		{name: "короткий", password: "Ab1", want: []string{CodePasswordTooShort}},
+		{name: "один класс символов", password: "abcdefghij", want: []string{CodePasswordTooSimple}},
+		{name: "длиннее 72 байт", password: "Пароль-" + strings.Repeat("п", 33), want: []string{CodePasswordTooLong}},
+		{name: "встроенный список утекших", password: "Password1", want: []string{CodePasswordBreached}},
+		{name: "список утекших из файла", password: "correct-horse", want: []string{CodePasswordBreached}},
+		{name: "содержит логин", password: "xx-Alice-2024", login: "alice", want: []string{CodePasswordContainsLogin}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ElementsMatch(t, tt.want, codes(p.ValidatePassword(tt.password, tt.login)))
+		})
+	}
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	assert.Empty(t, ValidateAPIKey("partner", []string{model.ScopeOrdersRead, model.ScopeOrdersWrite}))
+	assert.Empty(t, ValidateAPIKey("", nil))
+	assert.ElementsMatch(t, []string{CodeScopeUnknown}, codes(ValidateAPIKey("partner", []string{"orders:delete"})))
+	assert.ElementsMatch(t, []string{CodeAPIKeyNameTooLong}, codes(ValidateAPIKey(strings.Repeat("к", 200), nil)))
+}
+
+func TestValidateRole(t *testing.T) {
+	for _, role := range model.Roles {
+		assert.Empty(t, ValidateRole(role))
+	}
+	assert.ElementsMatch(t, []string{CodeRoleUnknown}, codes(ValidateRole("root")))
+	assert.ElementsMatch(t, []string{CodeRoleUnknown}, codes(ValidateRole("")))
+}
+
+func TestNewErrors(t *testing.T) {
+	rules := testRules
+	rules.LoginPattern = "["
+	_, err := New(rules)
+	assert.Error(t, err)
+
+	rules = testRules
+	rules.BreachedFile = filepath.Join(t.TempDir(), "not-exists.txt")
+	_, err = New(rules)
+	assert.Error(t, err)
+}
@@ -0,0 +1,20 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/kTowkA/gophermart/internal/model"
+)
+
+const CodeRoleUnknown = "role_unknown"
+
+const FieldRole = "role"
+
+// ValidateRole проверяет, что роль role известна
+func ValidateRole(role string) []model.ValidationError {
+	if !slices.Contains(model.Roles, role) {
+		return []model.ValidationError{{Field: FieldRole, Code: CodeRoleUnknown, Message: fmt.Sprintf("неизвестная роль %q", role)}}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// пакет ограничения частоты запросов к внешней системе. Token bucket, общий для всех исходящих запросов, с паузой по Retry-After
+// и подстройкой частоты по ответам 429: при ограничении частота уменьшается вдвое, при успешных запросах плавно растет (AIMD)
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidRate частота запросов не положительная: ограничитель с такой частотой либо не ограничивает, либо не пропускает ничего
+var ErrInvalidRate = errors.New("частота запросов должна быть положительной")
+
+// Config параметры ограничителя
+type Config struct {
+	// Rate начальная частота запросов в секунду
+	Rate float64
+	// MinRate ниже этой частоты ограничитель не опускается
+	MinRate float64
+	// MaxRate выше этой частоты ограничитель не поднимается
+	MaxRate float64
+	// Burst сколько запросов можно сделать подряд без ожидания
+	Burst int
+	// Increase на сколько запросов в секунду частота растет за секунду успешной работы
+	Increase float64
+}
+
+// Limiter ограничитель частоты запросов. Безопасен для одновременного использования
+type Limiter struct {
+	mu          sync.Mutex
+	cfg         Config
+	rate        float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+// New ограничитель с параметрами cfg. Начальная частота должна быть положительной и конечной, иначе ErrInvalidRate.
+// Некорректные границы приводятся к начальной частоте
+func New(cfg Config) (*Limiter, error) {
+	if !(cfg.Rate > 0) || math.IsInf(cfg.Rate, 1) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRate, cfg.Rate)
+	}
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	if cfg.MinRate <= 0 || cfg.MinRate > cfg.Rate {
+		cfg.MinRate = cfg.Rate
+	}
+	if cfg.MaxRate < cfg.Rate || math.IsInf(cfg.MaxRate, 1) {
+		cfg.MaxRate = cfg.Rate
+	}
+	if cfg.Increase < 0 {
+		cfg.Increase = 0
+	}
+	return &Limiter{
+		cfg:    cfg,
+		rate:   cfg.Rate,
+		tokens: float64(cfg.Burst),
+		now:    time.Now,
+	}, nil
+}
+
+// Wait ждет, пока можно будет сделать запрос, или отмены контекста
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		wait := l.reserve()
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve забирает токен, если он есть, и возвращает 0. Иначе возвращает, сколько подождать перед следующей попыткой
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	l.refill(now)
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// refill пополняет токены за время с прошлого пополнения
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = math.Min(float64(l.cfg.Burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+// Throttled сообщает, что внешняя система ответила 429. Все запросы приостанавливаются на retryAfter, частота уменьшается вдвое.
+// Возвращает новую частоту
+func (l *Limiter) Throttled(retryAfter time.Duration) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if until := now.Add(retryAfter); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	// после паузы начинаем без накопленных токенов, чтобы не ударить пачкой запросов
+	l.tokens = 0
+	l.last = l.pausedUntil
+	l.rate = math.Max(l.cfg.MinRate, l.rate/2)
+	return l.rate
+}
+
+// Succeeded сообщает об успешном запросе. Частота растет так, чтобы за секунду работы на полной частоте прибавить cfg.Increase.
+// Возвращает новую частоту
+func (l *Limiter) Succeeded() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = math.Min(l.cfg.MaxRate, l.rate+l.cfg.Increase/l.rate)
+	return l.rate
+}
+
+// Rate текущая частота запросов в секунду
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// PausedUntil до какого момента запросы приостановлены. Нулевое значение или прошедшее время - паузы нет
+func (l *Limiter) PausedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pausedUntil
+}
+
+// ParseRetryAfter разбирает заголовок Retry-After: число секунд или дату в формате HTTP
+func ParseRetryAfter(val string, now time.Time) (time.Duration, bool) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return 0, false
+	}
+	if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+		if sec < 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	at, err := http.ParseTime(val)
+	if err != nil {
+		return 0, false
+	}
+	if d := at.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
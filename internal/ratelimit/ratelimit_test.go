@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock управляемые часы для ограничителя
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestLimiter(t *testing.T, cfg Config) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	l, err := New(cfg)
+	require.NoError(t, err)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestReserve(t *testing.T) {
+	l, clock := newTestLimiter(t, Config{Rate: 2, Burst: 2})
+	// пачка в пределах burst без ожидания
+	assert.Zero(t, l.reserve())
+	assert.Zero(t, l.reserve())
+	// дальше - по токену раз в 1/rate
+	assert.Equal(t, 500*time.Millisecond, l.reserve())
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	assert.Zero(t, l.reserve())
+	// токены не копятся сверх burst
+	clock.t = clock.t.Add(time.Hour)
+	assert.Zero(t, l.reserve())
+	assert.Zero(t, l.reserve())
+	assert.NotZero(t, l.reserve())
+}
+
+func TestThrottled(t *testing.T) {
+	l, clock := newTestLimiter(t, Config{Rate: 8, MinRate: 1, MaxRate: 10, Burst: 5, Increase: 1})
+	assert.Equal(t, 4.0, l.Throttled(time.Minute))
+	// во время паузы запросы не проходят, сколько бы токенов ни было
+	assert.Equal(t, time.Minute, l.reserve())
+	clock.t = clock.t.Add(30 * time.Second)
+	assert.Equal(t, 30*time.Second, l.reserve())
+	// более короткая пауза не сокращает уже объявленную
+	l.Throttled(time.Second)
+	assert.Equal(t, 30*time.Second, l.reserve())
+	// после паузы токены начинают копиться заново на сниженной частоте
+	assert.Equal(t, 2.0, l.Rate())
+	clock.t = l.PausedUntil()
+	assert.Equal(t, 500*time.Millisecond, l.reserve())
+
+	// частота не опускается ниже минимальной
+	for i := 0; i < 10; i++ {
+		l.Throttled(0)
+	}
+	assert.Equal(t, 1.0, l.Rate())
+}
+
+func TestSucceeded(t *testing.T) {
+	l, err := New(Config{Rate: 1, MinRate: 1, MaxRate: 3, Burst: 1, Increase: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, l.Succeeded())
+	assert.Equal(t, 2.5, l.Succeeded())
+	for i := 0; i < 100; i++ {
+		l.Succeeded()
+	}
+	assert.Equal(t, 3.0, l.Rate())
+}
+
+func TestWait(t *testing.T) {
+	l, err := New(Config{Rate: 1000, Burst: 1})
+	require.NoError(t, err)
+	require.NoError(t, l.Wait(context.Background()))
+	l.Throttled(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	d, ok := ParseRetryAfter("60", now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+	d, ok = ParseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Second, d)
+	d, ok = ParseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Zero(t, d)
+	for _, val := range []string{"", "-1", "soon"} {
+		_, ok = ParseRetryAfter(val, now)
+		assert.False(t, ok, val)
+	}
+}
+
+func TestNewInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		_, err := New(Config{Rate: rate, MinRate: 1, MaxRate: 10, Burst: 1})
+		assert.ErrorIs(t, err, ErrInvalidRate, rate)
+	}
+}
@@ -28,3 +28,49 @@ func StatusByValue(val string) model.Status {
 		return StatusUndefined
 	}
 }
+
+// StatusByKey статус по его ключу в БД. Неизвестный ключ дает StatusUndefined
+func StatusByKey(key int) model.Status {
+	for _, st := range []model.Status{StatusNew, StatusRegistered, StatusInvalid, StatusProcessing, StatusProcessed} {
+		if st.Key() == key {
+			return st
+		}
+	}
+	return StatusUndefined
+}
+
+// statusTransitions допустимые переходы статусов заказа: NEW -> REGISTERED -> PROCESSING -> PROCESSED/INVALID.
+// Опрос может не застать промежуточный статус, поэтому ступени можно пропускать, но только вперед. UNDEFINED - статус заказов, загруженных до появления NEW.
+// PROCESSED и INVALID окончательные, из них переходов нет
+var statusTransitions = map[string][]string{
+	"UNDEFINED":  {"REGISTERED", "PROCESSING", "PROCESSED", "INVALID"},
+	"NEW":        {"REGISTERED", "PROCESSING", "PROCESSED", "INVALID"},
+	"REGISTERED": {"PROCESSING", "PROCESSED", "INVALID"},
+	"PROCESSING": {"PROCESSED", "INVALID"},
+}
+
+// CanTransit можно ли перевести заказ из статуса from в статус to
+func CanTransit(from, to model.Status) bool {
+	for _, val := range statusTransitions[from.Value()] {
+		if val == to.Value() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal окончательный ли статус. Заказ в окончательном статусе больше не проверяется в системе расчета
+func IsFinal(status model.Status) bool {
+	return status.Value() == StatusProcessed.Value() || status.Value() == StatusInvalid.Value()
+}
+
+// StatusesBefore статусы, из которых заказ можно перевести в статус to
+func StatusesBefore(to model.Status) []model.Status {
+	before := make([]model.Status, 0, len(statusTransitions))
+	for _, from := range []model.Status{StatusUndefined, StatusNew, StatusRegistered, StatusProcessing, StatusInvalid, StatusProcessed} {
+		if CanTransit(from, to) {
+			before = append(before, from)
+		}
+	}
+	return before
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransit(t *testing.T) {
+	tests := []struct {
+		from, to model.Status
+		want     bool
+	}{
+		{StatusNew, StatusRegistered, true},
+		{StatusNew, StatusProcessed, true},
+		{StatusUndefined, StatusProcessing, true},
+		{StatusRegistered, StatusInvalid, true},
+		{StatusProcessing, StatusProcessed, true},
+		// назад и на месте
+		{StatusProcessing, StatusRegistered, false},
+		{StatusProcessing, StatusProcessing, false},
+		{StatusRegistered, StatusNew, false},
+		// из окончательных статусов
+		{StatusProcessed, StatusProcessing, false},
+		{StatusProcessed, StatusInvalid, false},
+		{StatusInvalid, StatusProcessed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from.Value()+"->"+tt.to.Value(), func(t *testing.T) {
+			assert.Equal(t, tt.want, CanTransit(tt.from, tt.to))
+		})
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	assert.True(t, IsFinal(StatusProcessed))
+	assert.True(t, IsFinal(StatusInvalid))
+	assert.False(t, IsFinal(StatusProcessing))
+	assert.False(t, IsFinal(StatusNew))
+}
+
+func TestStatusesBefore(t *testing.T) {
+	assert.Equal(t, []model.Status{StatusUndefined, StatusNew, StatusRegistered, StatusProcessing}, StatusesBefore(StatusProcessed))
+	assert.Equal(t, []model.Status{StatusUndefined, StatusNew}, StatusesBefore(StatusRegistered))
+	assert.Empty(t, StatusesBefore(StatusNew))
+}
@@ -5,12 +5,25 @@ import "errors"
 var (
 	ErrLoginIsUsed                 = errors.New("такой логин уже занят")
 	ErrUserNotFound                = errors.New("такого пользователя не существует")
+	ErrLoginConflictNotFound       = errors.New("конфликт логина не найден")
 	ErrOrderWasUploadByAnotherUser = errors.New("заказ с таким номером был загружен другим пользователем")
 	ErrOrderWasAlreadyUpload       = errors.New("заказ уже был загружен пользователем")
 	ErrOrdersNotFound              = errors.New("заказов не найдено")
 	ErrWithdrawalsNotFound         = errors.New("пользователь еще не производил списания")
 	ErrWithdrawNotEnough           = errors.New("пользователю не хватает средств для списания")
 	ErrNothingHasBeenDone          = errors.New("данные уже актуальны")
+	ErrSessionNotFound             = errors.New("сессия не найдена, истекла или была отозвана")
+	ErrPasswordResetNotFound       = errors.New("токен сброса пароля не найден, истек или уже был использован")
+	ErrAPIKeyNotFound              = errors.New("api ключ не найден или отозван")
+	ErrAPIKeysNotFound             = errors.New("у пользователя нет api ключей")
+	ErrTOTPNotFound                = errors.New("двухфакторная аутентификация не настроена")
+	ErrTOTPAlreadyEnabled          = errors.New("двухфакторная аутентификация уже включена")
+	ErrRecoveryCodeNotFound        = errors.New("код восстановления не найден или уже использован")
+	ErrMFAChallengeNotFound        = errors.New("вход со вторым фактором не найден или истек")
+	ErrWebhookReplay               = errors.New("событие уже было принято")
+	ErrUnknownStatus               = errors.New("неизвестный статус заказа")
+	ErrNotLeader                   = errors.New("ведущий экземпляр уже выбран")
+	ErrIllegalTransition           = errors.New("недопустимая смена статуса заказа")
 )
 
 // ErrorWithHttpStatus содержит ошибку базы данных и рекомендуемый ей http status код
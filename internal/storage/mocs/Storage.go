@@ -1,4 +1,4 @@
-// Code generated by mockery v2.43.2. DO NOT EDIT.
+// Code generated by mockery v2.53.7. DO NOT EDIT.
 
 package mocks
 
@@ -10,6 +10,8 @@ import (
 
 	storage "github.com/kTowkA/gophermart/internal/storage"
 
+	time "time"
+
 	uuid "github.com/google/uuid"
 )
 
@@ -18,6 +20,64 @@ type Storage struct {
 	mock.Mock
 }
 
+// APIKeyOwner provides a mock function with given fields: ctx, keyHash
+func (_m *Storage) APIKeyOwner(ctx context.Context, keyHash string) (model.APIKeyOwner, error) {
+	ret := _m.Called(ctx, keyHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for APIKeyOwner")
+	}
+
+	var r0 model.APIKeyOwner
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.APIKeyOwner, error)); ok {
+		return rf(ctx, keyHash)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.APIKeyOwner); ok {
+		r0 = rf(ctx, keyHash)
+	} else {
+		r0 = ret.Get(0).(model.APIKeyOwner)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, keyHash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// APIKeys provides a mock function with given fields: ctx, userID
+func (_m *Storage) APIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for APIKeys")
+	}
+
+	var r0 []model.APIKey
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) ([]model.APIKey, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) []model.APIKey); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.APIKey)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Balance provides a mock function with given fields: ctx, userID
 func (_m *Storage) Balance(ctx context.Context, userID uuid.UUID) (model.ResponseBalance, error) {
 	ret := _m.Called(ctx, userID)
@@ -46,6 +106,54 @@ func (_m *Storage) Balance(ctx context.Context, userID uuid.UUID) (model.Respons
 	return r0, r1
 }
 
+// ChangePassword provides a mock function with given fields: ctx, userID, hashPassword
+func (_m *Storage) ChangePassword(ctx context.Context, userID uuid.UUID, hashPassword string) error {
+	ret := _m.Called(ctx, userID, hashPassword)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ChangePassword")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) error); ok {
+		r0 = rf(ctx, userID, hashPassword)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ClaimOrders provides a mock function with given fields: ctx, owner, statuses, limit, leaseTTL
+func (_m *Storage) ClaimOrders(ctx context.Context, owner string, statuses []model.Status, limit int, leaseTTL time.Duration) (model.ResponseOrders, error) {
+	ret := _m.Called(ctx, owner, statuses, limit, leaseTTL)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ClaimOrders")
+	}
+
+	var r0 model.ResponseOrders
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []model.Status, int, time.Duration) (model.ResponseOrders, error)); ok {
+		return rf(ctx, owner, statuses, limit, leaseTTL)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, []model.Status, int, time.Duration) model.ResponseOrders); ok {
+		r0 = rf(ctx, owner, statuses, limit, leaseTTL)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(model.ResponseOrders)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []model.Status, int, time.Duration) error); ok {
+		r1 = rf(ctx, owner, statuses, limit, leaseTTL)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Close provides a mock function with given fields: ctx
 func (_m *Storage) Close(ctx context.Context) error {
 	ret := _m.Called(ctx)
@@ -64,27 +172,45 @@ func (_m *Storage) Close(ctx context.Context) error {
 	return r0
 }
 
-// HashPassword provides a mock function with given fields: ctx, userID
-func (_m *Storage) HashPassword(ctx context.Context, userID uuid.UUID) (string, error) {
-	ret := _m.Called(ctx, userID)
+// ConfirmTOTP provides a mock function with given fields: ctx, userID, step, recoveryHashes
+func (_m *Storage) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryHashes []string) error {
+	ret := _m.Called(ctx, userID, step, recoveryHashes)
 
 	if len(ret) == 0 {
-		panic("no return value specified for HashPassword")
+		panic("no return value specified for ConfirmTOTP")
 	}
 
-	var r0 string
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, int64, []string) error); ok {
+		r0 = rf(ctx, userID, step, recoveryHashes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateAPIKey provides a mock function with given fields: ctx, userID, name, keyHash, prefix, scopes
+func (_m *Storage) CreateAPIKey(ctx context.Context, userID uuid.UUID, name string, keyHash string, prefix string, scopes []string) (model.APIKey, error) {
+	ret := _m.Called(ctx, userID, name, keyHash, prefix, scopes)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateAPIKey")
+	}
+
+	var r0 model.APIKey
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) (string, error)); ok {
-		return rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, string, string, []string) (model.APIKey, error)); ok {
+		return rf(ctx, userID, name, keyHash, prefix, scopes)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) string); ok {
-		r0 = rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, string, string, []string) model.APIKey); ok {
+		r0 = rf(ctx, userID, name, keyHash, prefix, scopes)
 	} else {
-		r0 = ret.Get(0).(string)
+		r0 = ret.Get(0).(model.APIKey)
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
-		r1 = rf(ctx, userID)
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, string, string, string, []string) error); ok {
+		r1 = rf(ctx, userID, name, keyHash, prefix, scopes)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -92,29 +218,65 @@ func (_m *Storage) HashPassword(ctx context.Context, userID uuid.UUID) (string,
 	return r0, r1
 }
 
-// Orders provides a mock function with given fields: ctx, userID
-func (_m *Storage) Orders(ctx context.Context, userID uuid.UUID) (model.ResponseOrders, error) {
-	ret := _m.Called(ctx, userID)
+// CreateMFAChallenge provides a mock function with given fields: ctx, userID, tokenHash, expiresAt
+func (_m *Storage) CreateMFAChallenge(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, userID, tokenHash, expiresAt)
 
 	if len(ret) == 0 {
-		panic("no return value specified for Orders")
+		panic("no return value specified for CreateMFAChallenge")
 	}
 
-	var r0 model.ResponseOrders
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, time.Time) error); ok {
+		r0 = rf(ctx, userID, tokenHash, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreatePasswordReset provides a mock function with given fields: ctx, userID, tokenHash, expiresAt
+func (_m *Storage) CreatePasswordReset(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, userID, tokenHash, expiresAt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreatePasswordReset")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, time.Time) error); ok {
+		r0 = rf(ctx, userID, tokenHash, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateSession provides a mock function with given fields: ctx, userID, refreshHash, expiresAt, mfa
+func (_m *Storage) CreateSession(ctx context.Context, userID uuid.UUID, refreshHash string, expiresAt time.Time, mfa bool) (uuid.UUID, error) {
+	ret := _m.Called(ctx, userID, refreshHash, expiresAt, mfa)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateSession")
+	}
+
+	var r0 uuid.UUID
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) (model.ResponseOrders, error)); ok {
-		return rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, time.Time, bool) (uuid.UUID, error)); ok {
+		return rf(ctx, userID, refreshHash, expiresAt, mfa)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) model.ResponseOrders); ok {
-		r0 = rf(ctx, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, time.Time, bool) uuid.UUID); ok {
+		r0 = rf(ctx, userID, refreshHash, expiresAt, mfa)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(model.ResponseOrders)
+			r0 = ret.Get(0).(uuid.UUID)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
-		r1 = rf(ctx, userID)
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, string, time.Time, bool) error); ok {
+		r1 = rf(ctx, userID, refreshHash, expiresAt, mfa)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -122,29 +284,27 @@ func (_m *Storage) Orders(ctx context.Context, userID uuid.UUID) (model.Response
 	return r0, r1
 }
 
-// OrdersByStatuses provides a mock function with given fields: ctx, statuses, limit, offset
-func (_m *Storage) OrdersByStatuses(ctx context.Context, statuses []model.Status, limit int, offset int) (model.ResponseOrders, error) {
-	ret := _m.Called(ctx, statuses, limit, offset)
+// DeadLetter provides a mock function with given fields: ctx, orderNum
+func (_m *Storage) DeadLetter(ctx context.Context, orderNum model.OrderNumber) (model.DeadLetter, error) {
+	ret := _m.Called(ctx, orderNum)
 
 	if len(ret) == 0 {
-		panic("no return value specified for OrdersByStatuses")
+		panic("no return value specified for DeadLetter")
 	}
 
-	var r0 model.ResponseOrders
+	var r0 model.DeadLetter
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, []model.Status, int, int) (model.ResponseOrders, error)); ok {
-		return rf(ctx, statuses, limit, offset)
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderNumber) (model.DeadLetter, error)); ok {
+		return rf(ctx, orderNum)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, []model.Status, int, int) model.ResponseOrders); ok {
-		r0 = rf(ctx, statuses, limit, offset)
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderNumber) model.DeadLetter); ok {
+		r0 = rf(ctx, orderNum)
 	} else {
-		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(model.ResponseOrders)
-		}
+		r0 = ret.Get(0).(model.DeadLetter)
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, []model.Status, int, int) error); ok {
-		r1 = rf(ctx, statuses, limit, offset)
+	if rf, ok := ret.Get(1).(func(context.Context, model.OrderNumber) error); ok {
+		r1 = rf(ctx, orderNum)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -152,47 +312,47 @@ func (_m *Storage) OrdersByStatuses(ctx context.Context, statuses []model.Status
 	return r0, r1
 }
 
-// SaveOrder provides a mock function with given fields: ctx, userID, orderNum
-func (_m *Storage) SaveOrder(ctx context.Context, userID uuid.UUID, orderNum model.OrderNumber) storage.ErrorWithHTTPStatus {
-	ret := _m.Called(ctx, userID, orderNum)
+// DeadLetterOrder provides a mock function with given fields: ctx, owner, orderNum, failure
+func (_m *Storage) DeadLetterOrder(ctx context.Context, owner string, orderNum model.OrderNumber, failure model.AccrualFailure) error {
+	ret := _m.Called(ctx, owner, orderNum, failure)
 
 	if len(ret) == 0 {
-		panic("no return value specified for SaveOrder")
+		panic("no return value specified for DeadLetterOrder")
 	}
 
-	var r0 storage.ErrorWithHTTPStatus
-	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.OrderNumber) storage.ErrorWithHTTPStatus); ok {
-		r0 = rf(ctx, userID, orderNum)
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.OrderNumber, model.AccrualFailure) error); ok {
+		r0 = rf(ctx, owner, orderNum, failure)
 	} else {
-		r0 = ret.Get(0).(storage.ErrorWithHTTPStatus)
+		r0 = ret.Error(0)
 	}
 
 	return r0
 }
 
-// SaveUser provides a mock function with given fields: ctx, login, hashPassword
-func (_m *Storage) SaveUser(ctx context.Context, login string, hashPassword string) (uuid.UUID, error) {
-	ret := _m.Called(ctx, login, hashPassword)
+// DeadLetters provides a mock function with given fields: ctx, limit, offset
+func (_m *Storage) DeadLetters(ctx context.Context, limit int, offset int) ([]model.DeadLetter, error) {
+	ret := _m.Called(ctx, limit, offset)
 
 	if len(ret) == 0 {
-		panic("no return value specified for SaveUser")
+		panic("no return value specified for DeadLetters")
 	}
 
-	var r0 uuid.UUID
+	var r0 []model.DeadLetter
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) (uuid.UUID, error)); ok {
-		return rf(ctx, login, hashPassword)
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) ([]model.DeadLetter, error)); ok {
+		return rf(ctx, limit, offset)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) uuid.UUID); ok {
-		r0 = rf(ctx, login, hashPassword)
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) []model.DeadLetter); ok {
+		r0 = rf(ctx, limit, offset)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(uuid.UUID)
+			r0 = ret.Get(0).([]model.DeadLetter)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
-		r1 = rf(ctx, login, hashPassword)
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, limit, offset)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -200,17 +360,17 @@ func (_m *Storage) SaveUser(ctx context.Context, login string, hashPassword stri
 	return r0, r1
 }
 
-// UpdateOrder provides a mock function with given fields: ctx, info
-func (_m *Storage) UpdateOrder(ctx context.Context, info model.ResponseAccuralSystem) error {
-	ret := _m.Called(ctx, info)
+// DeferOrderCheck provides a mock function with given fields: ctx, orderNum, until
+func (_m *Storage) DeferOrderCheck(ctx context.Context, orderNum model.OrderNumber, until time.Time) error {
+	ret := _m.Called(ctx, orderNum, until)
 
 	if len(ret) == 0 {
-		panic("no return value specified for UpdateOrder")
+		panic("no return value specified for DeferOrderCheck")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.ResponseAccuralSystem) error); ok {
-		r0 = rf(ctx, info)
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderNumber, time.Time) error); ok {
+		r0 = rf(ctx, orderNum, until)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -218,27 +378,888 @@ func (_m *Storage) UpdateOrder(ctx context.Context, info model.ResponseAccuralSy
 	return r0
 }
 
-// UpdateOrders provides a mock function with given fields: ctx, info
-func (_m *Storage) UpdateOrders(ctx context.Context, info []model.ResponseAccuralSystem) (int, error) {
-	ret := _m.Called(ctx, info)
+// DeleteMFAChallenge provides a mock function with given fields: ctx, tokenHash
+func (_m *Storage) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	ret := _m.Called(ctx, tokenHash)
 
 	if len(ret) == 0 {
-		panic("no return value specified for UpdateOrders")
+		panic("no return value specified for DeleteMFAChallenge")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, tokenHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DisableTOTP provides a mock function with given fields: ctx, userID
+func (_m *Storage) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DisableTOTP")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// HashPassword provides a mock function with given fields: ctx, userID
+func (_m *Storage) HashPassword(ctx context.Context, userID uuid.UUID) (string, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for HashPassword")
 	}
 
-	var r0 int
+	var r0 string
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, []model.ResponseAccuralSystem) (int, error)); ok {
-		return rf(ctx, info)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) (string, error)); ok {
+		return rf(ctx, userID)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, []model.ResponseAccuralSystem) int); ok {
-		r0 = rf(ctx, info)
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) string); ok {
+		r0 = rf(ctx, userID)
 	} else {
-		r0 = ret.Get(0).(int)
+		r0 = ret.Get(0).(string)
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, []model.ResponseAccuralSystem) error); ok {
-		r1 = rf(ctx, info)
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InvalidateDeadLetter provides a mock function with given fields: ctx, orderNum
+func (_m *Storage) InvalidateDeadLetter(ctx context.Context, orderNum model.OrderNumber) error {
+	ret := _m.Called(ctx, orderNum)
+
+	if len(ret) == 0 {
+		panic("no return value specified for InvalidateDeadLetter")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderNumber) error); ok {
+		r0 = rf(ctx, orderNum)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LoginAttempts provides a mock function with given fields: ctx, keys
+func (_m *Storage) LoginAttempts(ctx context.Context, keys ...string) ([]model.LoginAttempts, error) {
+	_va := make([]interface{}, len(keys))
+	for _i := range keys {
+		_va[_i] = keys[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LoginAttempts")
+	}
+
+	var r0 []model.LoginAttempts
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, ...string) ([]model.LoginAttempts, error)); ok {
+		return rf(ctx, keys...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, ...string) []model.LoginAttempts); ok {
+		r0 = rf(ctx, keys...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.LoginAttempts)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, ...string) error); ok {
+		r1 = rf(ctx, keys...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LoginConflicts provides a mock function with given fields: ctx
+func (_m *Storage) LoginConflicts(ctx context.Context) ([]model.LoginConflict, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LoginConflicts")
+	}
+
+	var r0 []model.LoginConflict
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) ([]model.LoginConflict, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) []model.LoginConflict); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.LoginConflict)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MFAChallenge provides a mock function with given fields: ctx, tokenHash
+func (_m *Storage) MFAChallenge(ctx context.Context, tokenHash string) (model.MFAChallenge, error) {
+	ret := _m.Called(ctx, tokenHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MFAChallenge")
+	}
+
+	var r0 model.MFAChallenge
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.MFAChallenge, error)); ok {
+		return rf(ctx, tokenHash)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.MFAChallenge); ok {
+		r0 = rf(ctx, tokenHash)
+	} else {
+		r0 = ret.Get(0).(model.MFAChallenge)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tokenHash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// OrderHistory provides a mock function with given fields: ctx, userID, orderNum
+func (_m *Storage) OrderHistory(ctx context.Context, userID uuid.UUID, orderNum model.OrderNumber) (model.OrderHistory, error) {
+	ret := _m.Called(ctx, userID, orderNum)
+
+	if len(ret) == 0 {
+		panic("no return value specified for OrderHistory")
+	}
+
+	var r0 model.OrderHistory
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.OrderNumber) (model.OrderHistory, error)); ok {
+		return rf(ctx, userID, orderNum)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.OrderNumber) model.OrderHistory); ok {
+		r0 = rf(ctx, userID, orderNum)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(model.OrderHistory)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, model.OrderNumber) error); ok {
+		r1 = rf(ctx, userID, orderNum)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Orders provides a mock function with given fields: ctx, userID
+func (_m *Storage) Orders(ctx context.Context, userID uuid.UUID) (model.ResponseOrders, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Orders")
+	}
+
+	var r0 model.ResponseOrders
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) (model.ResponseOrders, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) model.ResponseOrders); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(model.ResponseOrders)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// OrdersByStatuses provides a mock function with given fields: ctx, statuses, limit, offset
+func (_m *Storage) OrdersByStatuses(ctx context.Context, statuses []model.Status, limit int, offset int) (model.ResponseOrders, error) {
+	ret := _m.Called(ctx, statuses, limit, offset)
+
+	if len(ret) == 0 {
+		panic("no return value specified for OrdersByStatuses")
+	}
+
+	var r0 model.ResponseOrders
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []model.Status, int, int) (model.ResponseOrders, error)); ok {
+		return rf(ctx, statuses, limit, offset)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []model.Status, int, int) model.ResponseOrders); ok {
+		r0 = rf(ctx, statuses, limit, offset)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(model.ResponseOrders)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []model.Status, int, int) error); ok {
+		r1 = rf(ctx, statuses, limit, offset)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RedeemPasswordReset provides a mock function with given fields: ctx, tokenHash, hashPassword
+func (_m *Storage) RedeemPasswordReset(ctx context.Context, tokenHash string, hashPassword string) (uuid.UUID, error) {
+	ret := _m.Called(ctx, tokenHash, hashPassword)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RedeemPasswordReset")
+	}
+
+	var r0 uuid.UUID
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (uuid.UUID, error)); ok {
+		return rf(ctx, tokenHash, hashPassword)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) uuid.UUID); ok {
+		r0 = rf(ctx, tokenHash, hashPassword)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(uuid.UUID)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, tokenHash, hashPassword)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RegisterLoginFailure provides a mock function with given fields: ctx, key, windowStart, maxFailures, lockedUntil
+func (_m *Storage) RegisterLoginFailure(ctx context.Context, key string, windowStart time.Time, maxFailures int, lockedUntil time.Time) (model.LoginAttempts, error) {
+	ret := _m.Called(ctx, key, windowStart, maxFailures, lockedUntil)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RegisterLoginFailure")
+	}
+
+	var r0 model.LoginAttempts
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, int, time.Time) (model.LoginAttempts, error)); ok {
+		return rf(ctx, key, windowStart, maxFailures, lockedUntil)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, int, time.Time) model.LoginAttempts); ok {
+		r0 = rf(ctx, key, windowStart, maxFailures, lockedUntil)
+	} else {
+		r0 = ret.Get(0).(model.LoginAttempts)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, int, time.Time) error); ok {
+		r1 = rf(ctx, key, windowStart, maxFailures, lockedUntil)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RegisterPasswordResetRequest provides a mock function with given fields: ctx, key, windowStart
+func (_m *Storage) RegisterPasswordResetRequest(ctx context.Context, key string, windowStart time.Time) (model.PasswordResetRequests, error) {
+	ret := _m.Called(ctx, key, windowStart)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RegisterPasswordResetRequest")
+	}
+
+	var r0 model.PasswordResetRequests
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) (model.PasswordResetRequests, error)); ok {
+		return rf(ctx, key, windowStart)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) model.PasswordResetRequests); ok {
+		r0 = rf(ctx, key, windowStart)
+	} else {
+		r0 = ret.Get(0).(model.PasswordResetRequests)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, key, windowStart)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RehashPassword provides a mock function with given fields: ctx, userID, oldHash, newHash
+func (_m *Storage) RehashPassword(ctx context.Context, userID uuid.UUID, oldHash string, newHash string) error {
+	ret := _m.Called(ctx, userID, oldHash, newHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RehashPassword")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string, string) error); ok {
+		r0 = rf(ctx, userID, oldHash, newHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReplaceRecoveryCodes provides a mock function with given fields: ctx, userID, codeHashes
+func (_m *Storage) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	ret := _m.Called(ctx, userID, codeHashes)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReplaceRecoveryCodes")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, []string) error); ok {
+		r0 = rf(ctx, userID, codeHashes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RequeueDeadLetter provides a mock function with given fields: ctx, orderNum
+func (_m *Storage) RequeueDeadLetter(ctx context.Context, orderNum model.OrderNumber) error {
+	ret := _m.Called(ctx, orderNum)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RequeueDeadLetter")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.OrderNumber) error); ok {
+		r0 = rf(ctx, orderNum)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResetLoginFailures provides a mock function with given fields: ctx, key
+func (_m *Storage) ResetLoginFailures(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ResetLoginFailures")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ResolveLoginConflict provides a mock function with given fields: ctx, userID, login
+func (_m *Storage) ResolveLoginConflict(ctx context.Context, userID uuid.UUID, login string) error {
+	ret := _m.Called(ctx, userID, login)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ResolveLoginConflict")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) error); ok {
+		r0 = rf(ctx, userID, login)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeAPIKey provides a mock function with given fields: ctx, userID, keyID
+func (_m *Storage) RevokeAPIKey(ctx context.Context, userID uuid.UUID, keyID uuid.UUID) error {
+	ret := _m.Called(ctx, userID, keyID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeAPIKey")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, uuid.UUID) error); ok {
+		r0 = rf(ctx, userID, keyID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeSession provides a mock function with given fields: ctx, sessionID
+func (_m *Storage) RevokeSession(ctx context.Context, sessionID uuid.UUID) error {
+	ret := _m.Called(ctx, sessionID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeSession")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, sessionID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeToken provides a mock function with given fields: ctx, tokenID, expiresAt
+func (_m *Storage) RevokeToken(ctx context.Context, tokenID uuid.UUID, expiresAt time.Time) error {
+	ret := _m.Called(ctx, tokenID, expiresAt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeToken")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, time.Time) error); ok {
+		r0 = rf(ctx, tokenID, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeUserSessions provides a mock function with given fields: ctx, userID
+func (_m *Storage) RevokeUserSessions(ctx context.Context, userID uuid.UUID) error {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RevokeUserSessions")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) error); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RotateSession provides a mock function with given fields: ctx, refreshHash, newRefreshHash, expiresAt
+func (_m *Storage) RotateSession(ctx context.Context, refreshHash string, newRefreshHash string, expiresAt time.Time) (model.Session, error) {
+	ret := _m.Called(ctx, refreshHash, newRefreshHash, expiresAt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RotateSession")
+	}
+
+	var r0 model.Session
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) (model.Session, error)); ok {
+		return rf(ctx, refreshHash, newRefreshHash, expiresAt)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) model.Session); ok {
+		r0 = rf(ctx, refreshHash, newRefreshHash, expiresAt)
+	} else {
+		r0 = ret.Get(0).(model.Session)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Time) error); ok {
+		r1 = rf(ctx, refreshHash, newRefreshHash, expiresAt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveOrder provides a mock function with given fields: ctx, userID, orderNum
+func (_m *Storage) SaveOrder(ctx context.Context, userID uuid.UUID, orderNum model.OrderNumber) storage.ErrorWithHTTPStatus {
+	ret := _m.Called(ctx, userID, orderNum)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveOrder")
+	}
+
+	var r0 storage.ErrorWithHTTPStatus
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, model.OrderNumber) storage.ErrorWithHTTPStatus); ok {
+		r0 = rf(ctx, userID, orderNum)
+	} else {
+		r0 = ret.Get(0).(storage.ErrorWithHTTPStatus)
+	}
+
+	return r0
+}
+
+// SaveTOTP provides a mock function with given fields: ctx, userID, secret
+func (_m *Storage) SaveTOTP(ctx context.Context, userID uuid.UUID, secret string) error {
+	ret := _m.Called(ctx, userID, secret)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveTOTP")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) error); ok {
+		r0 = rf(ctx, userID, secret)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SaveUser provides a mock function with given fields: ctx, login, hashPassword
+func (_m *Storage) SaveUser(ctx context.Context, login string, hashPassword string) (uuid.UUID, error) {
+	ret := _m.Called(ctx, login, hashPassword)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveUser")
+	}
+
+	var r0 uuid.UUID
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (uuid.UUID, error)); ok {
+		return rf(ctx, login, hashPassword)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) uuid.UUID); ok {
+		r0 = rf(ctx, login, hashPassword)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(uuid.UUID)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, login, hashPassword)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveWebhookNonce provides a mock function with given fields: ctx, nonce, expiresAt
+func (_m *Storage) SaveWebhookNonce(ctx context.Context, nonce string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, nonce, expiresAt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveWebhookNonce")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) error); ok {
+		r0 = rf(ctx, nonce, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ScheduleOrderCheck provides a mock function with given fields: ctx, owner, orderNum, failure, nextCheckAt
+func (_m *Storage) ScheduleOrderCheck(ctx context.Context, owner string, orderNum model.OrderNumber, failure model.AccrualFailure, nextCheckAt time.Time) error {
+	ret := _m.Called(ctx, owner, orderNum, failure, nextCheckAt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ScheduleOrderCheck")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.OrderNumber, model.AccrualFailure, time.Time) error); ok {
+		r0 = rf(ctx, owner, orderNum, failure, nextCheckAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SessionActive provides a mock function with given fields: ctx, sessionID, tokenID
+func (_m *Storage) SessionActive(ctx context.Context, sessionID uuid.UUID, tokenID uuid.UUID) (bool, error) {
+	ret := _m.Called(ctx, sessionID, tokenID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SessionActive")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, uuid.UUID) (bool, error)); ok {
+		return rf(ctx, sessionID, tokenID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, uuid.UUID) bool); ok {
+		r0 = rf(ctx, sessionID, tokenID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, uuid.UUID) error); ok {
+		r1 = rf(ctx, sessionID, tokenID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetUserRole provides a mock function with given fields: ctx, login, role
+func (_m *Storage) SetUserRole(ctx context.Context, login string, role string) error {
+	ret := _m.Called(ctx, login, role)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetUserRole")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, login, role)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TOTP provides a mock function with given fields: ctx, userID
+func (_m *Storage) TOTP(ctx context.Context, userID uuid.UUID) (model.TOTP, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TOTP")
+	}
+
+	var r0 model.TOTP
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) (model.TOTP, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) model.TOTP); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		r0 = ret.Get(0).(model.TOTP)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// TryLead provides a mock function with given fields: ctx, name, checkInterval
+func (_m *Storage) TryLead(ctx context.Context, name string, checkInterval time.Duration) (storage.Leader, error) {
+	ret := _m.Called(ctx, name, checkInterval)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TryLead")
+	}
+
+	var r0 storage.Leader
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration) (storage.Leader, error)); ok {
+		return rf(ctx, name, checkInterval)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration) storage.Leader); ok {
+		r0 = rf(ctx, name, checkInterval)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(storage.Leader)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration) error); ok {
+		r1 = rf(ctx, name, checkInterval)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateOrder provides a mock function with given fields: ctx, info
+func (_m *Storage) UpdateOrder(ctx context.Context, info model.ResponseAccuralSystem) error {
+	ret := _m.Called(ctx, info)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateOrder")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.ResponseAccuralSystem) error); ok {
+		r0 = rf(ctx, info)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateOrders provides a mock function with given fields: ctx, info
+func (_m *Storage) UpdateOrders(ctx context.Context, info []model.ResponseAccuralSystem) ([]error, error) {
+	ret := _m.Called(ctx, info)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateOrders")
+	}
+
+	var r0 []error
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []model.ResponseAccuralSystem) ([]error, error)); ok {
+		return rf(ctx, info)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []model.ResponseAccuralSystem) []error); ok {
+		r0 = rf(ctx, info)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []model.ResponseAccuralSystem) error); ok {
+		r1 = rf(ctx, info)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UseRecoveryCode provides a mock function with given fields: ctx, userID, codeHash
+func (_m *Storage) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	ret := _m.Called(ctx, userID, codeHash)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UseRecoveryCode")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) error); ok {
+		r0 = rf(ctx, userID, codeHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UseTOTPStep provides a mock function with given fields: ctx, userID, step
+func (_m *Storage) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	ret := _m.Called(ctx, userID, step)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UseTOTPStep")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, int64) error); ok {
+		r0 = rf(ctx, userID, step)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// User provides a mock function with given fields: ctx, login
+func (_m *Storage) User(ctx context.Context, login string) (model.User, error) {
+	ret := _m.Called(ctx, login)
+
+	if len(ret) == 0 {
+		panic("no return value specified for User")
+	}
+
+	var r0 model.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.User, error)); ok {
+		return rf(ctx, login)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.User); ok {
+		r0 = rf(ctx, login)
+	} else {
+		r0 = ret.Get(0).(model.User)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, login)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UserCredentials provides a mock function with given fields: ctx, login
+func (_m *Storage) UserCredentials(ctx context.Context, login string) (model.UserCredentials, error) {
+	ret := _m.Called(ctx, login)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UserCredentials")
+	}
+
+	var r0 model.UserCredentials
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.UserCredentials, error)); ok {
+		return rf(ctx, login)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.UserCredentials); ok {
+		r0 = rf(ctx, login)
+	} else {
+		r0 = ret.Get(0).(model.UserCredentials)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, login)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+func (p *PStorage) CreateAPIKey(ctx context.Context, userID uuid.UUID, name, keyHash, prefix string, scopes []string) (model.APIKey, error) {
+	if scopes == nil {
+		scopes = []string{}
+	}
+	key := model.APIKey{
+		KeyID:     uuid.New(),
+		Name:      name,
+		Prefix:    prefix,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	_, err := p.Exec(
+		ctx,
+		"INSERT INTO api_keys(key_id,user_id,name,key_hash,prefix,scopes,adding_at) VALUES($1,$2,$3,$4,$5,$6,$7)",
+		key.KeyID,
+		userID,
+		key.Name,
+		keyHash,
+		key.Prefix,
+		key.Scopes,
+		key.CreatedAt,
+	)
+	if err != nil {
+		p.Error("создание api ключа", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return model.APIKey{}, err
+	}
+	p.Debug("успешное создание api ключа", slog.String("userID", userID.String()), slog.String("ключ", key.KeyID.String()))
+	return key, nil
+}
+
+func (p *PStorage) APIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error) {
+	rows, err := p.Query(
+		ctx,
+		"SELECT key_id,name,prefix,scopes,adding_at,last_used_at FROM api_keys WHERE user_id=$1 AND revoked_at IS NULL ORDER BY adding_at",
+		userID,
+	)
+	if err != nil {
+		p.Error("получение api ключей пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []model.APIKey{}
+	for rows.Next() {
+		key := model.APIKey{}
+		lastUsedAt := sql.NullTime{}
+		err = rows.Scan(
+			&key.KeyID,
+			&key.Name,
+			&key.Prefix,
+			&key.Scopes,
+			&key.CreatedAt,
+			&lastUsedAt,
+		)
+		if err != nil {
+			p.Error("получение api ключей пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		p.Error("получение api ключей пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, storage.ErrAPIKeysNotFound
+	}
+	return keys, nil
+}
+
+func (p *PStorage) RevokeAPIKey(ctx context.Context, userID, keyID uuid.UUID) error {
+	tag, err := p.Exec(
+		ctx,
+		"UPDATE api_keys SET revoked_at=$3 WHERE key_id=$1 AND user_id=$2 AND revoked_at IS NULL",
+		keyID,
+		userID,
+		time.Now(),
+	)
+	if err != nil {
+		p.Error("отзыв api ключа", slog.String("ключ", keyID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("отзыв api ключа. ключ не найден", slog.String("userID", userID.String()), slog.String("ключ", keyID.String()))
+		return storage.ErrAPIKeyNotFound
+	}
+	p.Debug("успешный отзыв api ключа", slog.String("ключ", keyID.String()))
+	return nil
+}
+
+func (p *PStorage) APIKeyOwner(ctx context.Context, keyHash string) (model.APIKeyOwner, error) {
+	owner := model.APIKeyOwner{}
+	err := p.QueryRow(
+		ctx,
+		`
+		UPDATE api_keys
+		SET last_used_at=$2
+		FROM users
+		WHERE api_keys.key_hash=$1 AND api_keys.revoked_at IS NULL AND users.user_id=api_keys.user_id
+		RETURNING api_keys.key_id,api_keys.user_id,users.login,users.role,api_keys.scopes
+		`,
+		keyHash,
+		time.Now(),
+	).Scan(&owner.KeyID, &owner.UserID, &owner.Login, &owner.Role, &owner.Scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("проверка api ключа. ключ не найден")
+		return model.APIKeyOwner{}, storage.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		p.Error("проверка api ключа", slog.String("ошибка", err.Error()))
+		return model.APIKeyOwner{}, err
+	}
+	return owner, nil
+}
@@ -12,50 +12,49 @@ import (
 	"github.com/kTowkA/gophermart/internal/storage"
 )
 
-func (p *PStorage) Balance(ctx context.Context, userID uuid.UUID) (model.ResponseBalance, error) {
+// balanceQuery считает сумму списаний и пополнений пользователя
+const balanceQuery = `
+	SELECT
+		(SELECT coalesce(SUM(withdrawals.sum),0) FROM withdrawals WHERE user_id=$1) AS withdrawn,
+		(
+			SELECT coalesce(SUM(replenishments.sum),0)
+			FROM replenishments
+			INNER JOIN orders ON orders.order_id=replenishments.order_id
+			WHERE orders.user_id=$1
+		) AS replenishment
+	`
+
+// rowQuerier общая часть пула соединений и транзакции
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// balance получает баланс пользователя через q (пул или транзакцию)
+func balance(ctx context.Context, q rowQuerier, userID uuid.UUID) (model.ResponseBalance, error) {
 	var (
-		withdrawn float64
-		accural   float64
+		withdrawn model.Money
+		accural   model.Money
 	)
-	err := p.QueryRow(
-		ctx,
-		`
-		SELECT coalesce(SUM(withdrawals.sum),0) as withdrawn
-		FROM withdrawals 
-		WHERE user_id=$1
-		`,
-		userID,
-	).Scan(&withdrawn)
-	if err != nil {
-		p.Error("получение списаний пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
-		return model.ResponseBalance{}, err
-	}
-	err = p.QueryRow(
-		ctx,
-		`
-		SELECT coalesce(SUM(replenishments.sum),0) as replenishment
-		FROM 
-			replenishments,
-			(
-				SELECT order_id
-				FROM orders 
-				WHERE user_id=$1
-			) AS orders
-		WHERE replenishments.order_id=orders.order_id
-		`,
-		userID,
-	).Scan(&accural)
+	err := q.QueryRow(ctx, balanceQuery, userID).Scan(&withdrawn, &accural)
 	if err != nil {
-		p.Error("получение пополенений пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
 		return model.ResponseBalance{}, err
 	}
-	p.Debug("успешное получение баланса у пользователя", slog.String("userID", userID.String()), slog.Float64("withdrawn", withdrawn), slog.Float64("current", accural-withdrawn))
 	return model.ResponseBalance{
 		Current:   accural - withdrawn,
 		Withdrawn: withdrawn,
 	}, nil
 }
 
+func (p *PStorage) Balance(ctx context.Context, userID uuid.UUID) (model.ResponseBalance, error) {
+	b, err := balance(ctx, p, userID)
+	if err != nil {
+		p.Error("получение баланса пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return model.ResponseBalance{}, err
+	}
+	p.Debug("успешное получение баланса у пользователя", slog.String("userID", userID.String()), slog.String("withdrawn", b.Withdrawn.String()), slog.String("current", b.Current.String()))
+	return b, nil
+}
+
 func (p *PStorage) Withdrawals(ctx context.Context, userID uuid.UUID) (model.ResponseWithdrawals, error) {
 	rows, err := p.Query(
 		ctx,
@@ -99,21 +98,39 @@ func (p *PStorage) Withdrawals(ctx context.Context, userID uuid.UUID) (model.Res
 }
 
 func (p *PStorage) Withdraw(ctx context.Context, userID uuid.UUID, requestWithdraw model.RequestWithdraw) error {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		p.Error("списание средств у пользователя. начало транзакции", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
-	balance, err := p.Balance(ctx, userID)
+	// блокируем строку пользователя, чтобы параллельные списания проверяли баланс по очереди
+	var lockedID uuid.UUID
+	err = tx.QueryRow(ctx, "SELECT user_id FROM users WHERE user_id=$1 FOR UPDATE", userID).Scan(&lockedID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("списание средств у пользователя. пользователь не найден", slog.String("userID", userID.String()))
+		return storage.ErrUserNotFound
+	}
 	if err != nil {
-		return nil
+		p.Error("списание средств у пользователя. блокировка пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
 	}
-	if balance.Current < requestWithdraw.Sum {
+	b, err := balance(ctx, tx, userID)
+	if err != nil {
+		p.Error("списание средств у пользователя. получение баланса", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if b.Current < requestWithdraw.Sum {
+		p.Debug("списание средств у пользователя. недостаточно средств", slog.String("userID", userID.String()), slog.String("current", b.Current.String()), slog.String("сумма списания", requestWithdraw.Sum.String()))
 		return storage.ErrWithdrawNotEnough
 	}
-	withdrawnID := uuid.New()
-	_, err = p.Exec(
+	_, err = tx.Exec(
 		ctx,
 		`
 		INSERT INTO withdrawals(withdrawn_id,order_num,sum,user_id,withdrawn_at) VALUES($1,$2,$3,$4,$5)
 		`,
-		withdrawnID,
+		uuid.New(),
 		requestWithdraw.OrderNumber,
 		requestWithdraw.Sum,
 		userID,
@@ -121,7 +138,13 @@ func (p *PStorage) Withdraw(ctx context.Context, userID uuid.UUID, requestWithdr
 	)
 	if err != nil {
 		p.Error("списание средств у пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		p.Error("списание средств у пользователя. завершение транзакции", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
 	}
-	p.Debug("успешное списание у пользователя", slog.String("userID", userID.String()), slog.String("списание в счет заказа", string(requestWithdraw.OrderNumber)), slog.Float64("сумма списания", requestWithdraw.Sum))
+	p.Debug("успешное списание у пользователя", slog.String("userID", userID.String()), slog.String("списание в счет заказа", string(requestWithdraw.OrderNumber)), slog.String("сумма списания", requestWithdraw.Sum.String()))
 	return nil
 }
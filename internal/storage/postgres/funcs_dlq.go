@@ -0,0 +1,172 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+// deadLetterSelect выборка заказов из очереди недоставленных, к которой добавляются условия
+const deadLetterSelect = `
+	SELECT
+		orders.order_num,users.login,statuses.value,orders.attempts,coalesce(orders.last_error,''),coalesce(orders.last_http_status,0),orders.adding_at,orders.dead_lettered_at
+	FROM orders
+	INNER JOIN users ON users.user_id=orders.user_id
+	INNER JOIN statuses ON statuses.status_id=orders.status_id
+	WHERE orders.dead_lettered_at IS NOT NULL
+	`
+
+func scanDeadLetter(row pgx.Row) (model.DeadLetter, error) {
+	dl := model.DeadLetter{}
+	err := row.Scan(&dl.OrderNumber, &dl.Login, &dl.Status, &dl.Attempts, &dl.LastError, &dl.LastHTTPStatus, &dl.UploadedAt, &dl.DeadLetteredAt)
+	return dl, err
+}
+
+func (p *PStorage) DeadLetters(ctx context.Context, limit, offset int) ([]model.DeadLetter, error) {
+	rows, err := p.Query(
+		ctx,
+		deadLetterSelect+"ORDER BY orders.dead_lettered_at DESC LIMIT $1 OFFSET $2",
+		limit,
+		offset,
+	)
+	if err != nil {
+		p.Error("получение очереди недоставленных", slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+	dls := make([]model.DeadLetter, 0)
+	for rows.Next() {
+		dl, err := scanDeadLetter(rows)
+		if err != nil {
+			p.Error("получение заказа из очереди недоставленных", slog.String("ошибка", err.Error()))
+			return nil, err
+		}
+		dls = append(dls, dl)
+	}
+	if err = rows.Err(); err != nil {
+		p.Error("получение очереди недоставленных", slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	if len(dls) == 0 {
+		return nil, storage.ErrOrdersNotFound
+	}
+	return dls, nil
+}
+
+func (p *PStorage) DeadLetter(ctx context.Context, orderNum model.OrderNumber) (model.DeadLetter, error) {
+	dl, err := scanDeadLetter(p.QueryRow(ctx, deadLetterSelect+"AND orders.order_num=$1", orderNum))
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("получение заказа из очереди недоставленных. заказа нет в очереди", slog.String("номер заказа", string(orderNum)))
+		return model.DeadLetter{}, storage.ErrOrdersNotFound
+	}
+	if err != nil {
+		p.Error("получение заказа из очереди недоставленных", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return model.DeadLetter{}, err
+	}
+	return dl, nil
+}
+
+func (p *PStorage) RequeueDeadLetter(ctx context.Context, orderNum model.OrderNumber) error {
+	tag, err := p.Exec(
+		ctx,
+		"UPDATE orders SET dead_lettered_at=NULL,next_check_at=NULL,attempts=0 WHERE order_num=$1 AND dead_lettered_at IS NOT NULL",
+		orderNum,
+	)
+	if err != nil {
+		p.Error("возврат заказа из очереди недоставленных", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("возврат заказа из очереди недоставленных. заказа нет в очереди", slog.String("номер заказа", string(orderNum)))
+		return storage.ErrOrdersNotFound
+	}
+	p.Debug("заказ возвращен из очереди недоставленных на проверку", slog.String("номер заказа", string(orderNum)))
+	return nil
+}
+
+func (p *PStorage) InvalidateDeadLetter(ctx context.Context, orderNum model.OrderNumber) error {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		p.Error("создание транзакции", slog.String("ошибка", err.Error()))
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	statusID := storage.StatusInvalid.Key()
+	before := storage.StatusesBefore(storage.StatusInvalid)
+	beforeKeys := make([]int, len(before))
+	for i := range before {
+		beforeKeys[i] = before[i].Key()
+	}
+	// пока заказ в очереди, статус мог прийти событием от системы расчета, окончательный статус не меняем
+	var currentID int
+	err = tx.QueryRow(
+		ctx,
+		`
+		WITH found AS (
+			SELECT order_id,status_id FROM orders WHERE order_num=$1 AND dead_lettered_at IS NOT NULL FOR UPDATE
+		), updated AS (
+			UPDATE orders SET status_id=$2,dead_lettered_at=NULL,next_check_at=NULL
+			FROM found
+			WHERE orders.order_id=found.order_id AND found.status_id=ANY($3::integer[])
+		)
+		SELECT status_id FROM found
+		`,
+		orderNum,
+		statusID,
+		beforeKeys,
+	).Scan(&currentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("перевод заказа из очереди недоставленных в INVALID. заказа нет в очереди", slog.String("номер заказа", string(orderNum)))
+		return storage.ErrOrdersNotFound
+	}
+	if err != nil {
+		p.Error("перевод заказа из очереди недоставленных в INVALID", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if !storage.CanTransit(storage.StatusByKey(currentID), storage.StatusInvalid) {
+		p.Warn(
+			"перевод заказа из очереди недоставленных в INVALID. недопустимая смена статуса",
+			slog.String("номер заказа", string(orderNum)),
+			slog.String("текущий статус", storage.StatusByKey(currentID).Value()),
+		)
+		return storage.ErrIllegalTransition
+	}
+	_, err = tx.Exec(
+		ctx,
+		"UPDATE orders_statuses SET status_id=$1,update_at=$2 WHERE order_id=(SELECT order_id FROM orders WHERE order_num=$3)",
+		statusID,
+		time.Now(),
+		orderNum,
+	)
+	if err != nil {
+		p.Error("перевод заказа из очереди недоставленных в INVALID. связь заказа со статусом", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return err
+	}
+	_, err = tx.Exec(
+		ctx,
+		"INSERT INTO order_status_history(history_id,order_id,status_id,source,adding_at) SELECT $1,order_id,$2,$3,$4 FROM orders WHERE order_num=$5",
+		uuid.New(),
+		statusID,
+		model.StatusSourceAdmin,
+		time.Now(),
+		orderNum,
+	)
+	if err != nil {
+		p.Error("перевод заказа из очереди недоставленных в INVALID. история статусов", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		p.Error("перевод заказа из очереди недоставленных в INVALID. фиксация изменений", slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("заказ из очереди недоставленных переведен в INVALID", slog.String("номер заказа", string(orderNum)))
+	return nil
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+// pgLeader блокировка ведущего на advisory lock. Блокировка живет, пока открыто соединение conn,
+// поэтому при падении ведущего postgres отпускает ее сам и ведущим может стать другой экземпляр
+type pgLeader struct {
+	*slog.Logger
+	conn *pgxpool.Conn
+	key  int64
+	lost chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// leaderKey ключ advisory lock для задачи name
+func leaderKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+func (p *PStorage) TryLead(ctx context.Context, name string, checkInterval time.Duration) (storage.Leader, error) {
+	conn, err := p.Acquire(ctx)
+	if err != nil {
+		p.Error("получение соединения для блокировки ведущего", slog.String("задача", name), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	key := leaderKey(name)
+	var locked bool
+	err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+	if err != nil {
+		conn.Release()
+		p.Error("захват блокировки ведущего", slog.String("задача", name), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	if !locked {
+		conn.Release()
+		return nil, storage.ErrNotLeader
+	}
+	l := &pgLeader{
+		Logger: p.With(slog.String("задача", name)),
+		conn:   conn,
+		key:    key,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go l.watch(checkInterval)
+	p.Info("экземпляр стал ведущим", slog.String("задача", name))
+	return l, nil
+}
+
+// watch проверяет соединение с блокировкой, пока ее не отпустили. При обрыве закрывает lost
+func (l *pgLeader) watch(checkInterval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), checkInterval)
+		err := l.conn.Ping(ctx)
+		cancel()
+		if err != nil {
+			l.Error("блокировка ведущего потеряна", slog.String("ошибка", err.Error()))
+			close(l.lost)
+			return
+		}
+	}
+}
+
+func (l *pgLeader) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *pgLeader) Release(ctx context.Context) error {
+	close(l.stop)
+	<-l.done
+	defer l.conn.Release()
+	select {
+	case <-l.lost:
+		// соединение неисправно. закрываем его, чтобы оно не вернулось в пул, а блокировка гарантированно снялась
+		return l.conn.Conn().Close(ctx)
+	default:
+	}
+	_, err := l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	if err != nil {
+		l.Error("снятие блокировки ведущего", slog.String("ошибка", err.Error()))
+		_ = l.conn.Conn().Close(ctx)
+		return err
+	}
+	l.Info("экземпляр перестал быть ведущим")
+	return nil
+}
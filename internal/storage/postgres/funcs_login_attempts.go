@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/model"
+)
+
+func (p *PStorage) LoginAttempts(ctx context.Context, keys ...string) ([]model.LoginAttempts, error) {
+	rows, err := p.Query(
+		ctx,
+		"SELECT key,failures,last_failure_at,locked_until FROM login_attempts WHERE key = ANY ($1)",
+		keys,
+	)
+	if err != nil {
+		p.Error("получение неудачных попыток входа", slog.Any("ключи", keys), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+	attempts := make([]model.LoginAttempts, 0, len(keys))
+	for rows.Next() {
+		attempt := model.LoginAttempts{}
+		lockedUntil := sql.NullTime{}
+		err = rows.Scan(
+			&attempt.Key,
+			&attempt.Failures,
+			&attempt.LastFailureAt,
+			&lockedUntil,
+		)
+		if err != nil {
+			p.Error("получение неудачных попыток входа", slog.Any("ключи", keys), slog.String("ошибка", err.Error()))
+			return nil, err
+		}
+		attempt.LockedUntil = lockedUntil.Time
+		attempts = append(attempts, attempt)
+	}
+	if err = rows.Err(); err != nil {
+		p.Error("получение неудачных попыток входа", slog.Any("ключи", keys), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (p *PStorage) RegisterLoginFailure(ctx context.Context, key string, windowStart time.Time, maxFailures int, lockedUntil time.Time) (model.LoginAttempts, error) {
+	attempt := model.LoginAttempts{Key: key}
+	lockedUntilDB := sql.NullTime{}
+	// время храним в UTC: колонки без часового пояса, а блокировка сравнивается со временем приложения.
+	// счетчик обновляется одним запросом, чтобы параллельные попытки с разных экземпляров не терялись
+	err := p.QueryRow(
+		ctx,
+		`
+		INSERT INTO login_attempts AS la (key,failures,last_failure_at,locked_until)
+		VALUES($1,1,$2,CASE WHEN 1>=$4::integer THEN $5::timestamp END)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN la.last_failure_at<$3 THEN 1 ELSE la.failures+1 END,
+			last_failure_at = $2,
+			locked_until = CASE
+				WHEN (CASE WHEN la.last_failure_at<$3 THEN 1 ELSE la.failures+1 END)>=$4::integer THEN $5::timestamp
+				ELSE la.locked_until
+			END
+		RETURNING failures,last_failure_at,locked_until
+		`,
+		key,
+		time.Now().UTC(),
+		windowStart.UTC(),
+		maxFailures,
+		lockedUntil.UTC(),
+	).Scan(&attempt.Failures, &attempt.LastFailureAt, &lockedUntilDB)
+	if err != nil {
+		p.Error("сохранение неудачной попытки входа", slog.String("ключ", key), slog.String("ошибка", err.Error()))
+		return model.LoginAttempts{}, err
+	}
+	attempt.LockedUntil = lockedUntilDB.Time
+	p.Debug("неудачная попытка входа", slog.String("ключ", key), slog.Int("неудач подряд", attempt.Failures))
+	return attempt, nil
+}
+
+func (p *PStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := p.Exec(ctx, "DELETE FROM login_attempts WHERE key=$1", key)
+	if err != nil {
+		p.Error("сброс неудачных попыток входа", slog.String("ключ", key), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("сброс неудачных попыток входа", slog.String("ключ", key))
+	return nil
+}
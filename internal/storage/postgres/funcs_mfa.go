@@ -0,0 +1,234 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+func (p *PStorage) SaveTOTP(ctx context.Context, userID uuid.UUID, secret string) error {
+	// неподтвержденный секрет можно перевыпустить, подтвержденный - только после отключения
+	tag, err := p.Exec(
+		ctx,
+		`
+		INSERT INTO user_totp(user_id,secret,last_step,adding_at) VALUES($1,$2,0,$3)
+		ON CONFLICT (user_id) DO UPDATE SET secret=EXCLUDED.secret,last_step=0,adding_at=EXCLUDED.adding_at
+		WHERE user_totp.confirmed_at IS NULL
+		`,
+		userID,
+		secret,
+		time.Now(),
+	)
+	if err != nil {
+		p.Error("сохранение секрета TOTP", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("сохранение секрета TOTP. двухфакторная аутентификация уже включена", slog.String("userID", userID.String()))
+		return storage.ErrTOTPAlreadyEnabled
+	}
+	p.Debug("успешное сохранение секрета TOTP", slog.String("userID", userID.String()))
+	return nil
+}
+
+func (p *PStorage) TOTP(ctx context.Context, userID uuid.UUID) (model.TOTP, error) {
+	totp := model.TOTP{}
+	err := p.QueryRow(
+		ctx,
+		"SELECT secret,confirmed_at IS NOT NULL,last_step FROM user_totp WHERE user_id=$1",
+		userID,
+	).Scan(&totp.Secret, &totp.Confirmed, &totp.LastStep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.TOTP{}, storage.ErrTOTPNotFound
+	}
+	if err != nil {
+		p.Error("получение секрета TOTP", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return model.TOTP{}, err
+	}
+	return totp, nil
+}
+
+func (p *PStorage) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryHashes []string) error {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		p.Error("создание транзакции", slog.String("ошибка", err.Error()))
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(
+		ctx,
+		"UPDATE user_totp SET confirmed_at=$2,last_step=$3 WHERE user_id=$1 AND confirmed_at IS NULL",
+		userID,
+		time.Now(),
+		step,
+	)
+	if err != nil {
+		p.Error("подтверждение TOTP", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("подтверждение TOTP. нет неподтвержденного секрета", slog.String("userID", userID.String()))
+		return storage.ErrNothingHasBeenDone
+	}
+	err = p.replaceRecoveryCodes(ctx, tx, userID, recoveryHashes)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		p.Error("подтверждение TOTP. фиксация изменений", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("успешное подтверждение TOTP", slog.String("userID", userID.String()))
+	return nil
+}
+
+func (p *PStorage) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	// условие на шаг не дает использовать один и тот же код дважды, в том числе параллельно
+	tag, err := p.Exec(
+		ctx,
+		"UPDATE user_totp SET last_step=$2 WHERE user_id=$1 AND confirmed_at IS NOT NULL AND last_step<$2",
+		userID,
+		step,
+	)
+	if err != nil {
+		p.Error("использование кода TOTP", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("использование кода TOTP. код уже использован", slog.String("userID", userID.String()))
+		return storage.ErrNothingHasBeenDone
+	}
+	return nil
+}
+
+func (p *PStorage) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	b := pgx.Batch{}
+	b.Queue("DELETE FROM recovery_codes WHERE user_id=$1", userID)
+	b.Queue("DELETE FROM user_totp WHERE user_id=$1", userID)
+	err := p.SendBatch(ctx, &b).Close()
+	if err != nil {
+		p.Error("отключение TOTP", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("успешное отключение TOTP", slog.String("userID", userID.String()))
+	return nil
+}
+
+func (p *PStorage) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		p.Error("создание транзакции", slog.String("ошибка", err.Error()))
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	err = p.replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		p.Error("замена кодов восстановления. фиксация изменений", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("успешная замена кодов восстановления", slog.String("userID", userID.String()))
+	return nil
+}
+
+// replaceRecoveryCodes удаляет все коды восстановления пользователя и сохраняет новые в рамках транзакции tx
+func (p *PStorage) replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID uuid.UUID, codeHashes []string) error {
+	b := pgx.Batch{}
+	b.Queue("DELETE FROM recovery_codes WHERE user_id=$1", userID)
+	for _, codeHash := range codeHashes {
+		b.Queue("INSERT INTO recovery_codes(user_id,code_hash,adding_at) VALUES($1,$2,$3)", userID, codeHash, time.Now())
+	}
+	err := tx.SendBatch(ctx, &b).Close()
+	if err != nil {
+		p.Error("замена кодов восстановления", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func (p *PStorage) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error {
+	tag, err := p.Exec(
+		ctx,
+		"UPDATE recovery_codes SET used_at=$3 WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL",
+		userID,
+		codeHash,
+		time.Now(),
+	)
+	if err != nil {
+		p.Error("использование кода восстановления", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("использование кода восстановления. код не найден", slog.String("userID", userID.String()))
+		return storage.ErrRecoveryCodeNotFound
+	}
+	p.Info("использован код восстановления", slog.String("userID", userID.String()))
+	return nil
+}
+
+func (p *PStorage) CreateMFAChallenge(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	// заодно удаляем истекшие незавершенные входы
+	b := pgx.Batch{}
+	b.Queue("DELETE FROM mfa_challenges WHERE expires_at<$1", time.Now())
+	b.Queue(
+		"INSERT INTO mfa_challenges(token_hash,user_id,adding_at,expires_at) VALUES($1,$2,$3,$4)",
+		tokenHash,
+		userID,
+		time.Now(),
+		expiresAt,
+	)
+	err := p.SendBatch(ctx, &b).Close()
+	if err != nil {
+		p.Error("создание входа со вторым фактором", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func (p *PStorage) MFAChallenge(ctx context.Context, tokenHash string) (model.MFAChallenge, error) {
+	challenge := model.MFAChallenge{}
+	err := p.QueryRow(
+		ctx,
+		`
+		SELECT users.user_id,users.login,users.role
+		FROM mfa_challenges
+		JOIN users ON users.user_id=mfa_challenges.user_id
+		WHERE mfa_challenges.token_hash=$1 AND mfa_challenges.expires_at>$2
+		`,
+		tokenHash,
+		time.Now(),
+	).Scan(&challenge.UserID, &challenge.Login, &challenge.Role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("вход со вторым фактором не найден или истек")
+		return model.MFAChallenge{}, storage.ErrMFAChallengeNotFound
+	}
+	if err != nil {
+		p.Error("получение входа со вторым фактором", slog.String("ошибка", err.Error()))
+		return model.MFAChallenge{}, err
+	}
+	return challenge, nil
+}
+
+func (p *PStorage) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	tag, err := p.Exec(ctx, "DELETE FROM mfa_challenges WHERE token_hash=$1", tokenHash)
+	if err != nil {
+		p.Error("удаление входа со вторым фактором", slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrMFAChallengeNotFound
+	}
+	return nil
+}
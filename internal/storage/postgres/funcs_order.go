@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"log/slog"
 	"net/http"
@@ -93,6 +94,23 @@ func (p *PStorage) SaveOrder(ctx context.Context, userID uuid.UUID, orderNum mod
 			HTTPStatus:   http.StatusInternalServerError,
 		}
 	}
+	_, err = tx.Exec(
+		ctx,
+		"INSERT INTO order_status_history(history_id,order_id,status_id,source,adding_at) VALUES($1,$2,$3,$4,$5)",
+		uuid.New(),
+		orderID,
+		storage.StatusNew.Key(),
+		model.StatusSourceUpload,
+		time.Now(),
+	)
+	if err != nil {
+		p.Error("сохранение истории статусов заказа", slog.String("номер заказа", string(orderNum)), slog.String("пользователь", userID.String()), slog.String("ошибка", err.Error()))
+		_ = tx.Rollback(ctx)
+		return storage.ErrorWithHTTPStatus{
+			StorageError: err,
+			HTTPStatus:   http.StatusInternalServerError,
+		}
+	}
 	err = tx.Commit(ctx)
 	if err != nil {
 		p.Error("сохранение заказа. фиксация изменений", slog.String("номер заказа", string(orderNum)), slog.String("пользователь", userID.String()), slog.String("ошибка", err.Error()))
@@ -108,82 +126,164 @@ func (p *PStorage) SaveOrder(ctx context.Context, userID uuid.UUID, orderNum mod
 	}
 }
 
-func (p *PStorage) UpdateOrders(ctx context.Context, info []model.ResponseAccuralSystem) (int, error) {
+// updateOrderQuery обновляет заказ одним запросом, чтобы каждую строку группы можно было применить независимо от остальных.
+// Статус меняется, только если текущий входит в $7 - статусы, из которых разрешен переход. Пополнение добавляется только вместе со сменой статуса на PROCESSED,
+// каждая смена статуса дописывается в историю вместе с источником и ответом системы расчета.
+// Окончательный статус ($11) мог прийти событием или повторной проверкой, когда заказ уже в очереди недоставленных, поэтому заказ из нее убирается.
+// Возвращает статус заказа до обновления (NULL - заказа нет) и изменился ли он
+const updateOrderQuery = `
+WITH found AS (
+	SELECT order_id,status_id FROM orders WHERE order_num=$1 FOR UPDATE
+), updated AS (
+	UPDATE orders SET
+		status_id=$2,
+		dead_lettered_at=CASE WHEN $11::boolean THEN NULL ELSE orders.dead_lettered_at END,
+		next_check_at=CASE WHEN $11::boolean THEN NULL ELSE orders.next_check_at END
+	FROM found
+	WHERE orders.order_id=found.order_id AND found.status_id=ANY($7::integer[])
+	RETURNING orders.order_id
+), statuses AS (
+	UPDATE orders_statuses SET status_id=$2,adding_at=$3,update_at=$3
+	FROM updated
+	WHERE orders_statuses.order_id=updated.order_id
+), replenishment AS (
+	INSERT INTO replenishments(replenishment_id,order_id,sum,replenishment_at)
+	SELECT $4::uuid,order_id,$5::numeric,$3 FROM updated WHERE $6::boolean
+), history AS (
+	INSERT INTO order_status_history(history_id,order_id,status_id,source,payload,adding_at)
+	SELECT $8::uuid,order_id,$2,$9::text,$10::jsonb,$3 FROM updated
+)
+SELECT (SELECT status_id FROM found),EXISTS(SELECT 1 FROM updated)
+`
+
+func updateOrderArgs(info model.ResponseAccuralSystem) []any {
+	status := storage.StatusByValue(info.Status.Value())
+	before := storage.StatusesBefore(status)
+	beforeKeys := make([]int, len(before))
+	for i := range before {
+		beforeKeys[i] = before[i].Key()
+	}
+	return []any{
+		string(info.OrderNumber),
+		status.Key(),
+		time.Now(),
+		uuid.New(),
+		info.Accrual,
+		status.Value() == storage.StatusProcessed.Value(),
+		beforeKeys,
+		uuid.New(),
+		info.Source,
+		historyPayload(info.Payload),
+		storage.IsFinal(status),
+	}
+}
+
+// historyPayload ответ системы расчета для истории. Не json (например, ответ прокси) не сохраняем, чтобы не потерять саму смену статуса
+func historyPayload(payload []byte) any {
+	if len(payload) == 0 || !json.Valid(payload) {
+		return nil
+	}
+	return string(payload)
+}
+
+// updateOrderResult переводит результат updateOrderQuery в ошибку из контракта UpdateOrder
+func (p *PStorage) updateOrderResult(info model.ResponseAccuralSystem, statusID *int, updated bool) error {
+	switch {
+	case statusID == nil:
+		return storage.ErrOrdersNotFound
+	case updated:
+		return nil
+	case *statusID == storage.StatusByValue(info.Status.Value()).Key():
+		return storage.ErrNothingHasBeenDone
+	default:
+		p.Warn(
+			"обновление заказа. недопустимая смена статуса",
+			slog.String("номер заказа", string(info.OrderNumber)),
+			slog.String("текущий статус", storage.StatusByKey(*statusID).Value()),
+			slog.String("новый статус", info.Status.Value()),
+		)
+		return storage.ErrIllegalTransition
+	}
+}
+
+func (p *PStorage) UpdateOrders(ctx context.Context, info []model.ResponseAccuralSystem) ([]error, error) {
+	results := make([]error, len(info))
+	// строки с неизвестным статусом в базу не отправляем
+	queued := make([]int, 0, len(info))
+	for i := range info {
+		if storage.StatusByValue(info[i].Status.Value()).Value() == storage.StatusUndefined.Value() {
+			results[i] = storage.ErrUnknownStatus
+			continue
+		}
+		queued = append(queued, i)
+	}
+	if len(queued) == 0 {
+		return results, nil
+	}
+
 	tx, err := p.Begin(ctx)
 	if err != nil {
-		return 0, err
+		p.Error("создание транзакции", slog.String("ошибка", err.Error()))
+		return nil, err
 	}
+	defer func() { _ = tx.Rollback(ctx) }()
 	b := pgx.Batch{}
-	for _, new := range info {
-		// если был завершен расчет то сохраняем в таблице пополнений
-		if new.Status.Value() == storage.StatusProcessed.Value() {
-			replenishmentID := uuid.New()
-			b.Queue(
-				`INSERT INTO replenishments(replenishment_id,order_id,sum,replenishment_at) 
-				VALUES(
-					$1,
-					(SELECT order_id FROM orders WHERE order_num=$2),
-					$3,
-					$4)
-				`,
-				replenishmentID,
-				string(new.OrderNumber),
-				new.Accrual,
-				time.Now(),
-			)
-		}
-		// здесь обновляем таблицу заказов
-		b.Queue(
-			"UPDATE orders SET status_id=$1 WHERE order_num=$2",
-			storage.StatusByValue(new.Status.Value()).Key(),
-			string(new.OrderNumber),
-		)
-		// и в конце обновляем связи статусы-заказы
-		b.Queue(
-			"UPDATE orders_statuses SET status_id=$1,adding_at=$2,update_at=$3 WHERE order_id=(SELECT order_id FROM orders WHERE order_num=$4)",
-			storage.StatusByValue(new.Status.Value()).Key(),
-			time.Now(),
-			time.Now(),
-			string(new.OrderNumber),
-		)
+	for _, i := range queued {
+		b.Queue(updateOrderQuery, updateOrderArgs(info[i])...)
 	}
 	br := tx.SendBatch(ctx, &b)
-	err = br.Close()
-	if err != nil {
-		p.Error("закрытие пакета с данными", slog.String("ошибка", err.Error()))
-		_ = tx.Rollback(ctx)
-		return 0, err
+	for _, i := range queued {
+		var (
+			statusID *int
+			updated  bool
+		)
+		err = br.QueryRow().Scan(&statusID, &updated)
+		if err != nil {
+			break
+		}
+		results[i] = p.updateOrderResult(info[i], statusID, updated)
 	}
-	err = tx.Commit(ctx)
-	if err != nil {
-		p.Error("сохранение изменений", slog.String("ошибка", err.Error()))
-		_ = tx.Rollback(ctx)
-		return 0, err
+	if err == nil {
+		err = br.Close()
+	} else {
+		_ = br.Close()
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
 	}
-	p.Debug("успешное сохранение группы заказов", slog.Int("всего", len(info)))
-	return len(info), nil
+	if err == nil {
+		p.Debug("успешное сохранение группы заказов", slog.Int("всего", len(info)))
+		return results, nil
+	}
+
+	// ошибка одной строки прерывает всю транзакцию, поэтому применяем строки по отдельности: каждая - атомарный запрос
+	p.Warn("сохранение группы заказов. применяем заказы по одному", slog.Int("всего", len(info)), slog.String("ошибка", err.Error()))
+	_ = tx.Rollback(ctx)
+	for _, i := range queued {
+		var (
+			statusID *int
+			updated  bool
+		)
+		err = p.QueryRow(ctx, updateOrderQuery, updateOrderArgs(info[i])...).Scan(&statusID, &updated)
+		if err != nil {
+			p.Error("обновление заказа", slog.String("номер заказа", string(info[i].OrderNumber)), slog.String("ошибка", err.Error()))
+			results[i] = err
+			continue
+		}
+		results[i] = p.updateOrderResult(info[i], statusID, updated)
+	}
+	return results, nil
 }
 
 func (p *PStorage) UpdateOrder(ctx context.Context, info model.ResponseAccuralSystem) error {
-	var (
-		orderID  uuid.UUID
-		statusID int
-	)
-	err := p.QueryRow(ctx, "SELECT order_id,status_id FROM orders WHERE order_num=$1", info.OrderNumber).Scan(&orderID, &statusID)
-	if errors.Is(err, pgx.ErrNoRows) {
-		p.Warn("поиск ID заказа по переданному номеру. заказа с таким номером нет", slog.String("номер заказа", string(info.OrderNumber)))
-		return storage.ErrOrdersNotFound
-	}
+	results, err := p.UpdateOrders(ctx, []model.ResponseAccuralSystem{info})
 	if err != nil {
-		p.Error("поиск ID заказа по переданному номеру", slog.String("номер заказа", string(info.OrderNumber)), slog.String("ошибка", err.Error()))
 		return err
 	}
-	if statusID == storage.StatusByValue(info.Status.Value()).Key() {
+	if errors.Is(results[0], storage.ErrNothingHasBeenDone) {
 		p.Warn("обновление заказа. данные актуальны", slog.String("номер заказа", string(info.OrderNumber)))
-		return storage.ErrNothingHasBeenDone
 	}
-	_, err = p.UpdateOrders(ctx, []model.ResponseAccuralSystem{info})
-	return err
+	return results[0]
 }
 
 func (p *PStorage) OrdersByStatuses(ctx context.Context, statuses []model.Status, limit, offset int) (model.ResponseOrders, error) {
@@ -233,6 +333,118 @@ func (p *PStorage) OrdersByStatuses(ctx context.Context, statuses []model.Status
 	return orders, nil
 }
 
+func (p *PStorage) ClaimOrders(ctx context.Context, owner string, statuses []model.Status, limit int, leaseTTL time.Duration) (model.ResponseOrders, error) {
+	statusesValues := make([]string, len(statuses))
+	for i := range statuses {
+		statusesValues[i] = statuses[i].Value()
+	}
+	now := time.Now()
+	// SKIP LOCKED - заказы, которые прямо сейчас захватывает другой экземпляр, не ждем, а пропускаем
+	rows, err := p.Query(
+		ctx,
+		`
+		UPDATE orders
+		SET lease_owner=$1,lease_until=$2
+		WHERE order_id IN (
+			SELECT order_id
+			FROM orders
+			WHERE
+				status_id = ANY (SELECT status_id FROM statuses WHERE value = ANY ($3))
+				AND (lease_until IS NULL OR lease_until<$4)
+				AND (next_check_at IS NULL OR next_check_at<=$4)
+				AND dead_lettered_at IS NULL
+			ORDER BY next_check_at NULLS FIRST,adding_at
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING order_num,attempts
+		`,
+		owner,
+		now.Add(leaseTTL),
+		statusesValues,
+		now,
+		limit,
+	)
+	if err != nil {
+		p.Error("захват заказов", slog.String("экземпляр", owner), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+	orders := make([]model.ResponseOrder, 0)
+	for rows.Next() {
+		order := model.ResponseOrder{}
+		err = rows.Scan(&order.OrderNumber, &order.Attempts)
+		if err != nil {
+			p.Error("получение номера захваченного заказа", slog.String("ошибка", err.Error()))
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	if err = rows.Err(); err != nil {
+		p.Error("захват заказов", slog.String("экземпляр", owner), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, storage.ErrOrdersNotFound
+	}
+	p.Debug("успешный захват заказов", slog.String("экземпляр", owner), slog.Int("захвачено заказов", len(orders)))
+	return orders, nil
+}
+
+func (p *PStorage) ScheduleOrderCheck(ctx context.Context, owner string, orderNum model.OrderNumber, failure model.AccrualFailure, nextCheckAt time.Time) error {
+	tag, err := p.Exec(
+		ctx,
+		`
+		UPDATE orders
+		SET lease_owner=NULL,lease_until=NULL,attempts=$3,last_error=$4,last_http_status=NULLIF($5,0),next_check_at=$6
+		WHERE order_num=$1 AND lease_owner=$2
+		`,
+		orderNum,
+		owner,
+		failure.Attempts,
+		failure.Error,
+		failure.HTTPStatus,
+		nextCheckAt,
+	)
+	if err != nil {
+		p.Error("назначение следующей проверки заказа", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("назначение следующей проверки заказа. заказ не захвачен экземпляром", slog.String("номер заказа", string(orderNum)), slog.String("экземпляр", owner))
+		return storage.ErrOrdersNotFound
+	}
+	p.Debug("назначена следующая проверка заказа", slog.String("номер заказа", string(orderNum)), slog.Int("попыток", failure.Attempts), slog.Time("проверка", nextCheckAt))
+	return nil
+}
+
+func (p *PStorage) DeadLetterOrder(ctx context.Context, owner string, orderNum model.OrderNumber, failure model.AccrualFailure) error {
+	tag, err := p.Exec(
+		ctx,
+		`
+		UPDATE orders
+		SET lease_owner=NULL,lease_until=NULL,attempts=$3,last_error=$4,last_http_status=NULLIF($5,0),next_check_at=NULL,dead_lettered_at=$6
+		WHERE order_num=$1 AND lease_owner=$2
+		`,
+		orderNum,
+		owner,
+		failure.Attempts,
+		failure.Error,
+		failure.HTTPStatus,
+		time.Now(),
+	)
+	if err != nil {
+		p.Error("перевод заказа в очередь недоставленных", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("перевод заказа в очередь недоставленных. заказ не захвачен экземпляром", slog.String("номер заказа", string(orderNum)), slog.String("экземпляр", owner))
+		return storage.ErrOrdersNotFound
+	}
+	p.Debug("заказ переведен в очередь недоставленных", slog.String("номер заказа", string(orderNum)), slog.Int("попыток", failure.Attempts))
+	return nil
+}
+
 func (p *PStorage) Orders(ctx context.Context, userID uuid.UUID) (model.ResponseOrders, error) {
 	rows, err := p.Query(
 		ctx,
@@ -283,3 +495,49 @@ func (p *PStorage) Orders(ctx context.Context, userID uuid.UUID) (model.Response
 	p.Debug("успешное получение заказов у пользователя", slog.Int("найдено заказов", len(orders)), slog.String("пользователь", userID.String()))
 	return orders, nil
 }
+
+func (p *PStorage) OrderHistory(ctx context.Context, userID uuid.UUID, orderNum model.OrderNumber) (model.OrderHistory, error) {
+	rows, err := p.Query(
+		ctx,
+		`
+		SELECT statuses.value,coalesce(order_status_history.source,''),order_status_history.payload,order_status_history.adding_at
+		FROM order_status_history
+		INNER JOIN orders ON orders.order_id=order_status_history.order_id
+		INNER JOIN statuses ON statuses.status_id=order_status_history.status_id
+		WHERE orders.order_num=$1 AND orders.user_id=$2
+		ORDER BY order_status_history.adding_at
+		`,
+		string(orderNum),
+		userID,
+	)
+	if err != nil {
+		p.Error("получение истории статусов заказа", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+	history := model.OrderHistory{}
+	for rows.Next() {
+		var (
+			change    model.OrderStatusChange
+			statusVal string
+			payload   []byte
+		)
+		err = rows.Scan(&statusVal, &change.Source, &payload, &change.ChangedAt)
+		if err != nil {
+			p.Error("получение истории статусов заказа", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+			return nil, err
+		}
+		change.Status = storage.StatusByValue(statusVal)
+		change.Payload = payload
+		history = append(history, change)
+	}
+	if err = rows.Err(); err != nil {
+		p.Error("получение истории статусов заказа", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	if len(history) == 0 {
+		p.Warn("получение истории статусов заказа. заказ не найден у пользователя", slog.String("номер заказа", string(orderNum)), slog.String("пользователь", userID.String()))
+		return nil, storage.ErrOrdersNotFound
+	}
+	return history, nil
+}
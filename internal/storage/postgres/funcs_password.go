@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+func (p *PStorage) ChangePassword(ctx context.Context, userID uuid.UUID, hashPassword string) error {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		p.Error("создание транзакции", slog.String("ошибка", err.Error()))
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	err = p.setPassword(ctx, tx, userID, hashPassword)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		p.Error("смена пароля. фиксация изменений", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("успешная смена пароля", slog.String("userID", userID.String()))
+	return nil
+}
+
+func (p *PStorage) RehashPassword(ctx context.Context, userID uuid.UUID, oldHash, newHash string) error {
+	// условие на старый хеш не дает затереть пароль, смененный параллельно
+	tag, err := p.Exec(ctx, "UPDATE users SET password_hash=$3 WHERE user_id=$1 AND password_hash=$2", userID, oldHash, newHash)
+	if err != nil {
+		p.Error("пересчет хеша пароля", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("пересчет хеша пароля. хеш уже изменен", slog.String("userID", userID.String()))
+		return storage.ErrNothingHasBeenDone
+	}
+	p.Debug("успешный пересчет хеша пароля", slog.String("userID", userID.String()))
+	return nil
+}
+
+func (p *PStorage) CreatePasswordReset(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	// действовать должен только последний выданный токен
+	b := pgx.Batch{}
+	b.Queue("UPDATE password_resets SET used_at=$2 WHERE user_id=$1 AND used_at IS NULL", userID, time.Now())
+	b.Queue(
+		"INSERT INTO password_resets(token_hash,user_id,adding_at,expires_at) VALUES($1,$2,$3,$4)",
+		tokenHash,
+		userID,
+		time.Now(),
+		expiresAt,
+	)
+	err := p.SendBatch(ctx, &b).Close()
+	if err != nil {
+		p.Error("создание токена сброса пароля", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("успешное создание токена сброса пароля", slog.String("userID", userID.String()))
+	return nil
+}
+
+func (p *PStorage) RedeemPasswordReset(ctx context.Context, tokenHash string, hashPassword string) (uuid.UUID, error) {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		p.Error("создание транзакции", slog.String("ошибка", err.Error()))
+		return uuid.UUID{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var userID uuid.UUID
+	err = tx.QueryRow(
+		ctx,
+		"UPDATE password_resets SET used_at=$2 WHERE token_hash=$1 AND used_at IS NULL AND expires_at>$2 RETURNING user_id",
+		tokenHash,
+		time.Now(),
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("сброс пароля. токен не найден")
+		return uuid.UUID{}, storage.ErrPasswordResetNotFound
+	}
+	if err != nil {
+		p.Error("сброс пароля. использование токена", slog.String("ошибка", err.Error()))
+		return uuid.UUID{}, err
+	}
+	err = p.setPassword(ctx, tx, userID, hashPassword)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		p.Error("сброс пароля. фиксация изменений", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return uuid.UUID{}, err
+	}
+	p.Debug("успешный сброс пароля", slog.String("userID", userID.String()))
+	return userID, nil
+}
+
+// setPassword заменяет хеш пароля и отзывает все сессии пользователя в рамках транзакции tx
+func (p *PStorage) RegisterPasswordResetRequest(ctx context.Context, key string, windowStart time.Time) (model.PasswordResetRequests, error) {
+	requests := model.PasswordResetRequests{Key: key}
+	// время храним в UTC, как и в login_attempts. счетчик обновляется одним запросом, чтобы параллельные запросы не терялись
+	err := p.QueryRow(
+		ctx,
+		`
+		INSERT INTO password_reset_requests AS r (key,requests,window_started_at)
+		VALUES($1,1,$2)
+		ON CONFLICT (key) DO UPDATE SET
+			requests = CASE WHEN r.window_started_at<$3 THEN 1 ELSE r.requests+1 END,
+			window_started_at = CASE WHEN r.window_started_at<$3 THEN $2 ELSE r.window_started_at END
+		RETURNING requests,window_started_at
+		`,
+		key,
+		time.Now().UTC(),
+		windowStart.UTC(),
+	).Scan(&requests.Requests, &requests.WindowStartedAt)
+	if err != nil {
+		p.Error("учет запроса на сброс пароля", slog.String("ключ", key), slog.String("ошибка", err.Error()))
+		return model.PasswordResetRequests{}, err
+	}
+	p.Debug("запрос на сброс пароля", slog.String("ключ", key), slog.Int("запросов в окне", requests.Requests))
+	return requests, nil
+}
+
+func (p *PStorage) setPassword(ctx context.Context, tx pgx.Tx, userID uuid.UUID, hashPassword string) error {
+	tag, err := tx.Exec(ctx, "UPDATE users SET password_hash=$2 WHERE user_id=$1", userID, hashPassword)
+	if err != nil {
+		p.Error("замена хеша пароля", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("замена хеша пароля. пользователь не найден", slog.String("userID", userID.String()))
+		return storage.ErrUserNotFound
+	}
+	// со старым паролем могли войти посторонние, поэтому все сессии пользователя больше не действуют
+	_, err = tx.Exec(ctx, "UPDATE sessions SET revoked_at=$2 WHERE user_id=$1 AND revoked_at IS NULL", userID, time.Now())
+	if err != nil {
+		p.Error("отзыв сессий пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+func (p *PStorage) CreateSession(ctx context.Context, userID uuid.UUID, refreshHash string, expiresAt time.Time, mfa bool) (uuid.UUID, error) {
+	sessionID := uuid.New()
+	_, err := p.Exec(
+		ctx,
+		"INSERT INTO sessions(session_id,user_id,refresh_hash,adding_at,update_at,expires_at,mfa) VALUES($1,$2,$3,$4,$5,$6,$7)",
+		sessionID,
+		userID,
+		refreshHash,
+		time.Now(),
+		time.Now(),
+		expiresAt,
+		mfa,
+	)
+	if err != nil {
+		p.Error("создание сессии пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return uuid.UUID{}, err
+	}
+	p.Debug("успешное создание сессии", slog.String("userID", userID.String()), slog.String("сессия", sessionID.String()))
+	return sessionID, nil
+}
+
+func (p *PStorage) RotateSession(ctx context.Context, refreshHash, newRefreshHash string, expiresAt time.Time) (model.Session, error) {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		p.Error("создание транзакции", slog.String("ошибка", err.Error()))
+		return model.Session{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	session := model.Session{}
+	err = tx.QueryRow(
+		ctx,
+		`
+		UPDATE sessions
+		SET refresh_hash=$2,previous_refresh_hash=$1,update_at=$3,expires_at=$4
+		FROM users
+		WHERE sessions.refresh_hash=$1 AND sessions.revoked_at IS NULL AND sessions.expires_at>$3 AND users.user_id=sessions.user_id
+		RETURNING sessions.session_id,sessions.user_id,users.login,users.role,sessions.mfa
+		`,
+		refreshHash,
+		newRefreshHash,
+		time.Now(),
+		expiresAt,
+	).Scan(&session.SessionID, &session.UserID, &session.Login, &session.Role, &session.MFA)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		p.Error("обновление refresh токена сессии", slog.String("ошибка", err.Error()))
+		return model.Session{}, err
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+		if err != nil {
+			p.Error("обновление refresh токена сессии. фиксация изменений", slog.String("ошибка", err.Error()))
+			return model.Session{}, err
+		}
+		session.ExpiresAt = expiresAt
+		p.Debug("успешное обновление refresh токена сессии", slog.String("сессия", session.SessionID.String()))
+		return session, nil
+	}
+
+	// токен не найден среди действующих. проверяем, не предъявили ли нам уже замененный токен
+	var sessionID uuid.UUID
+	err = tx.QueryRow(
+		ctx,
+		"UPDATE sessions SET revoked_at=$2 WHERE previous_refresh_hash=$1 AND revoked_at IS NULL RETURNING session_id",
+		refreshHash,
+		time.Now(),
+	).Scan(&sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("обновление refresh токена сессии. сессия не найдена")
+		return model.Session{}, storage.ErrSessionNotFound
+	}
+	if err != nil {
+		p.Error("отзыв сессии при повторном использовании refresh токена", slog.String("ошибка", err.Error()))
+		return model.Session{}, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		p.Error("отзыв сессии при повторном использовании refresh токена. фиксация изменений", slog.String("ошибка", err.Error()))
+		return model.Session{}, err
+	}
+	p.Warn("повторное использование refresh токена. сессия отозвана", slog.String("сессия", sessionID.String()))
+	return model.Session{}, storage.ErrSessionNotFound
+}
+
+func (p *PStorage) RevokeSession(ctx context.Context, sessionID uuid.UUID) error {
+	_, err := p.Exec(
+		ctx,
+		"UPDATE sessions SET revoked_at=$2 WHERE session_id=$1 AND revoked_at IS NULL",
+		sessionID,
+		time.Now(),
+	)
+	if err != nil {
+		p.Error("отзыв сессии", slog.String("сессия", sessionID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("успешный отзыв сессии", slog.String("сессия", sessionID.String()))
+	return nil
+}
+
+func (p *PStorage) RevokeUserSessions(ctx context.Context, userID uuid.UUID) error {
+	_, err := p.Exec(
+		ctx,
+		"UPDATE sessions SET revoked_at=$2 WHERE user_id=$1 AND revoked_at IS NULL",
+		userID,
+		time.Now(),
+	)
+	if err != nil {
+		p.Error("отзыв всех сессий пользователя", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("успешный отзыв всех сессий пользователя", slog.String("userID", userID.String()))
+	return nil
+}
+
+func (p *PStorage) SessionActive(ctx context.Context, sessionID, tokenID uuid.UUID) (bool, error) {
+	var active bool
+	err := p.QueryRow(
+		ctx,
+		`
+		SELECT 
+			revoked_at IS NULL AND expires_at>$3 AND NOT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id=$2)
+		FROM sessions 
+		WHERE session_id=$1
+		`,
+		sessionID,
+		tokenID,
+		time.Now(),
+	).Scan(&active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("проверка сессии. сессия не найдена", slog.String("сессия", sessionID.String()))
+		return false, nil
+	}
+	if err != nil {
+		p.Error("проверка сессии", slog.String("сессия", sessionID.String()), slog.String("ошибка", err.Error()))
+		return false, err
+	}
+	return active, nil
+}
+
+func (p *PStorage) RevokeToken(ctx context.Context, tokenID uuid.UUID, expiresAt time.Time) error {
+	// заодно удаляем записи об уже истекших токенах, они больше ничего не защищают
+	b := pgx.Batch{}
+	b.Queue("DELETE FROM revoked_tokens WHERE expires_at<$1", time.Now())
+	b.Queue("INSERT INTO revoked_tokens(token_id,expires_at) VALUES($1,$2) ON CONFLICT (token_id) DO NOTHING", tokenID, expiresAt)
+	err := p.SendBatch(ctx, &b).Close()
+	if err != nil {
+		p.Error("отзыв токена", slog.String("токен", tokenID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("успешный отзыв токена", slog.String("токен", tokenID.String()))
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/kTowkA/gophermart/internal/model"
 	"github.com/kTowkA/gophermart/internal/storage"
 )
 
@@ -66,3 +67,137 @@ func (p *PStorage) HashPassword(ctx context.Context, userID uuid.UUID) (string,
 	p.Debug("успешное получение хеша пароля пользователя", slog.String("userID", userID.String()))
 	return hash, nil
 }
+
+func (p *PStorage) UserCredentials(ctx context.Context, login string) (model.UserCredentials, error) {
+	credentials := model.UserCredentials{}
+	err := p.QueryRow(
+		ctx,
+		`
+		SELECT users.user_id,users.login,users.role,users.password_hash,user_totp.confirmed_at IS NOT NULL
+		FROM users
+		LEFT JOIN user_totp ON user_totp.user_id=users.user_id
+		WHERE users.login=$1
+		`,
+		login,
+	).Scan(&credentials.UserID, &credentials.Login, &credentials.Role, &credentials.PasswordHash, &credentials.TOTPEnabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("запрос данных для входа по логину. пользователь не найден", slog.String("логин", login))
+		return model.UserCredentials{}, storage.ErrUserNotFound
+	}
+	if err != nil {
+		p.Error("запрос данных для входа по логину", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		return model.UserCredentials{}, err
+	}
+	p.Debug("получение данных для входа по логину", slog.String("логин", login), slog.String("userID", credentials.UserID.String()))
+	return credentials, nil
+}
+
+func (p *PStorage) User(ctx context.Context, login string) (model.User, error) {
+	user := model.User{}
+	err := p.QueryRow(
+		ctx,
+		"SELECT user_id,login,role,adding_at FROM users WHERE login=$1",
+		login,
+	).Scan(&user.UserID, &user.Login, &user.Role, &user.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("запрос информации о пользователе. пользователь не найден", slog.String("логин", login))
+		return model.User{}, storage.ErrUserNotFound
+	}
+	if err != nil {
+		p.Error("запрос информации о пользователе", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+func (p *PStorage) SetUserRole(ctx context.Context, login, role string) error {
+	tag, err := p.Exec(ctx, "UPDATE users SET role=$2 WHERE login=$1", login, role)
+	if err != nil {
+		p.Error("назначение роли пользователю", slog.String("логин", login), slog.String("роль", role), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("назначение роли пользователю. пользователь не найден", slog.String("логин", login))
+		return storage.ErrUserNotFound
+	}
+	p.Debug("успешное назначение роли пользователю", slog.String("логин", login), slog.String("роль", role))
+	return nil
+}
+
+func (p *PStorage) LoginConflicts(ctx context.Context) ([]model.LoginConflict, error) {
+	rows, err := p.Query(
+		ctx,
+		`
+		SELECT c.user_id,c.login,c.canonical_login,COALESCE(owner.login,''),c.detected_at
+		FROM login_conflicts c
+		LEFT JOIN users owner ON owner.user_id=c.owner_id
+		ORDER BY c.detected_at,c.canonical_login,c.login
+		`,
+	)
+	if err != nil {
+		p.Error("получение конфликтов логинов", slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	conflicts, err := pgx.CollectRows(rows, pgx.RowToStructByPos[model.LoginConflict])
+	if err != nil {
+		p.Error("получение конфликтов логинов", slog.String("ошибка", err.Error()))
+		return nil, err
+	}
+	if len(conflicts) == 0 {
+		return nil, storage.ErrLoginConflictNotFound
+	}
+	return conflicts, nil
+}
+
+func (p *PStorage) ResolveLoginConflict(ctx context.Context, userID uuid.UUID, login string) error {
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		p.Error("создание транзакции", slog.String("ошибка", err.Error()))
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var userLogin string
+	err = tx.QueryRow(ctx, "SELECT login FROM login_conflicts WHERE user_id=$1 FOR UPDATE", userID).Scan(&userLogin)
+	if errors.Is(err, pgx.ErrNoRows) {
+		p.Warn("решение конфликта логина. конфликта нет", slog.String("userID", userID.String()))
+		return storage.ErrLoginConflictNotFound
+	}
+	if err != nil {
+		p.Error("решение конфликта логина", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	var used bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE login=$1)", login).Scan(&used)
+	if err != nil {
+		p.Error("решение конфликта логина. проверка логина", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if used {
+		p.Warn("решение конфликта логина. логин занят", slog.String("логин", login))
+		return storage.ErrLoginIsUsed
+	}
+	_, err = tx.Exec(ctx, "UPDATE users SET login=$2 WHERE user_id=$1", userID, login)
+	if err != nil {
+		p.Error("решение конфликта логина. смена логина", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		return err
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM login_conflicts WHERE user_id=$1", userID)
+	if err != nil {
+		p.Error("решение конфликта логина. удаление конфликта", slog.String("userID", userID.String()), slog.String("ошибка", err.Error()))
+		return err
+	}
+	// если пользователь занял свободный канонический логин, остальные претенденты конфликтуют теперь с ним
+	_, err = tx.Exec(ctx, "UPDATE login_conflicts SET owner_id=$1 WHERE canonical_login=$2 AND owner_id IS NULL", userID, login)
+	if err != nil {
+		p.Error("решение конфликта логина. обновление владельца", slog.String("логин", login), slog.String("ошибка", err.Error()))
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		p.Error("решение конфликта логина. фиксация изменений", slog.String("ошибка", err.Error()))
+		return err
+	}
+	p.Debug("конфликт логина решен", slog.String("прежний логин", userLogin), slog.String("логин", login))
+	return nil
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/kTowkA/gophermart/internal/model"
+	"github.com/kTowkA/gophermart/internal/storage"
+)
+
+func (p *PStorage) DeferOrderCheck(ctx context.Context, orderNum model.OrderNumber, until time.Time) error {
+	tag, err := p.Exec(
+		ctx,
+		"UPDATE orders SET next_check_at=GREATEST(coalesce(next_check_at,$2),$2) WHERE order_num=$1",
+		orderNum,
+		until,
+	)
+	if err != nil {
+		p.Error("перенос проверки заказа", slog.String("номер заказа", string(orderNum)), slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("перенос проверки заказа. заказа с таким номером нет", slog.String("номер заказа", string(orderNum)))
+		return storage.ErrOrdersNotFound
+	}
+	p.Debug("проверка заказа перенесена", slog.String("номер заказа", string(orderNum)), slog.Time("до", until))
+	return nil
+}
+
+func (p *PStorage) SaveWebhookNonce(ctx context.Context, nonce string, expiresAt time.Time) error {
+	_, err := p.Exec(ctx, "DELETE FROM webhook_nonces WHERE expires_at<$1", time.Now())
+	if err != nil {
+		p.Error("удаление истекших признаков событий", slog.String("ошибка", err.Error()))
+		return err
+	}
+	tag, err := p.Exec(
+		ctx,
+		"INSERT INTO webhook_nonces(nonce,expires_at) VALUES($1,$2) ON CONFLICT (nonce) DO NOTHING",
+		nonce,
+		expiresAt,
+	)
+	if err != nil {
+		p.Error("сохранение признака события", slog.String("ошибка", err.Error()))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		p.Warn("повторное событие")
+		return storage.ErrWebhookReplay
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/kTowkA/gophermart/internal/policy"
+)
+
+// normalizeLoginsStep название шага приведения логинов в pending_code_migrations
+const normalizeLoginsStep = "normalize_logins"
+
+// userLogin логин пользователя в том виде, в котором он хранится в базе
+type userLogin struct {
+	UserID uuid.UUID
+	Login  string
+}
+
+// loginConflict логин, который нельзя привести к каноническому виду, не отобрав его у владельца OwnerID.
+// Нулевой OwnerID - каноническим логином не владеет никто, но на него претендуют несколько пользователей
+type loginConflict struct {
+	userLogin
+	Canonical string
+	OwnerID   uuid.UUID
+}
+
+// planLogins решает, какие логины можно привести к каноническому виду normalize, а какие конфликтуют.
+// Пользователи группируются по каноническому логину: если в группе один пользователь, его логин приводится,
+// если несколько - канонический логин остается у того, у кого он уже есть, остальные попадают в конфликты
+func planLogins(users []userLogin, normalize func(string) string) (updates []userLogin, conflicts []loginConflict) {
+	groups := make(map[string][]userLogin)
+	for _, u := range users {
+		canonical := normalize(u.Login)
+		groups[canonical] = append(groups[canonical], u)
+	}
+	for canonical, group := range groups {
+		if len(group) == 1 {
+			if group[0].Login != canonical {
+				updates = append(updates, userLogin{UserID: group[0].UserID, Login: canonical})
+			}
+			continue
+		}
+		var owner uuid.UUID
+		for _, u := range group {
+			if u.Login == canonical {
+				owner = u.UserID
+			}
+		}
+		for _, u := range group {
+			if u.UserID != owner {
+				conflicts = append(conflicts, loginConflict{userLogin: u, Canonical: canonical, OwnerID: owner})
+			}
+		}
+	}
+	// порядок важен только для предсказуемости тестов и логов
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Login < updates[j].Login })
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Login < conflicts[j].Login })
+	return updates, conflicts
+}
+
+// normalizeLogins приводит логины пользователей к каноническому виду policy.NormalizeLogin, конфликты записывает в login_conflicts.
+// Выполняется один раз: шаг удаляется из pending_code_migrations в той же транзакции
+func normalizeLogins(ctx context.Context, connString string) (err error) {
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return fmt.Errorf("подключение к базе. %w", err)
+	}
+	defer func() { err = errors.Join(err, conn.Close(ctx)) }()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("создание транзакции. %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// блокировка строки шага не дает двум экземплярам выполнить его одновременно
+	var step string
+	err = tx.QueryRow(ctx, "SELECT name FROM pending_code_migrations WHERE name=$1 FOR UPDATE", normalizeLoginsStep).Scan(&step)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("получение шага миграции. %w", err)
+	}
+
+	rows, err := tx.Query(ctx, "SELECT user_id,login FROM users")
+	if err != nil {
+		return fmt.Errorf("получение логинов. %w", err)
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowToStructByPos[userLogin])
+	if err != nil {
+		return fmt.Errorf("получение логинов. %w", err)
+	}
+
+	updates, conflicts := planLogins(users, policy.NormalizeLogin)
+	for _, u := range updates {
+		_, err = tx.Exec(ctx, "UPDATE users SET login=$2 WHERE user_id=$1", u.UserID, u.Login)
+		if err != nil {
+			return fmt.Errorf("приведение логина %q. %w", u.Login, err)
+		}
+	}
+	for _, c := range conflicts {
+		owner := &c.OwnerID
+		if c.OwnerID == uuid.Nil {
+			owner = nil
+		}
+		_, err = tx.Exec(
+			ctx,
+			`
+			INSERT INTO login_conflicts(user_id,login,canonical_login,owner_id,detected_at) VALUES($1,$2,$3,$4,$5)
+			ON CONFLICT (user_id) DO UPDATE SET login=EXCLUDED.login,canonical_login=EXCLUDED.canonical_login,owner_id=EXCLUDED.owner_id
+			`,
+			c.UserID,
+			c.Login,
+			c.Canonical,
+			owner,
+			time.Now(),
+		)
+		if err != nil {
+			return fmt.Errorf("сохранение конфликта логина %q. %w", c.Login, err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM pending_code_migrations WHERE name=$1", normalizeLoginsStep)
+	if err != nil {
+		return fmt.Errorf("завершение шага миграции. %w", err)
+	}
+	return tx.Commit(ctx)
+}
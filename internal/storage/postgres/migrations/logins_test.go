@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kTowkA/gophermart/internal/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanLogins(t *testing.T) {
+	var (
+		straße = userLogin{UserID: uuid.New(), Login: "straße"}
+		sigma  = userLogin{UserID: uuid.New(), Login: "ὀδυσσεύς"}
+		spaced = userLogin{UserID: uuid.New(), Login: " bob "}
+		bob    = userLogin{UserID: uuid.New(), Login: "bob"}
+		fi1    = userLogin{UserID: uuid.New(), Login: "ﬁle"}
+		fi2    = userLogin{UserID: uuid.New(), Login: "FILE"}
+		alice  = userLogin{UserID: uuid.New(), Login: "alice"}
+	)
+	updates, conflicts := planLogins([]userLogin{straße, sigma, spaced, bob, fi1, fi2, alice}, policy.NormalizeLogin)
+
+	// логины не в каноническом виде, на который больше никто не претендует
+	assert.Equal(t, []userLogin{
+		{UserID: straße.UserID, Login: "strasse"},
+		{UserID: sigma.UserID, Login: "ὀδυσσεύσ"},
+	}, updates)
+	assert.Equal(t, []loginConflict{
+		// канонический логин уже занят другим пользователем
+		{userLogin: spaced, Canonical: "bob", OwnerID: bob.UserID},
+		// на канонический логин претендуют двое, владельца нет
+		{userLogin: fi2, Canonical: "file"},
+		{userLogin: fi1, Canonical: "file"},
+	}, conflicts)
+}
@@ -2,6 +2,7 @@
 package migrations
 
 import (
+	"context"
 	"embed"
 	"errors"
 	"fmt"
@@ -22,11 +23,11 @@ func MigrationsUP(connString string) error {
 		return fmt.Errorf("создание драйвера для считывания миграций. %w", err)
 	}
 	// можно получить строку подключения разного вида, были с этим проблемы
-	connString = strings.TrimPrefix(connString, "postgres://")
-	connString = strings.TrimPrefix(connString, "postgresql://")
-	connString = "pgx5://" + connString
+	migrateConnString := strings.TrimPrefix(connString, "postgres://")
+	migrateConnString = strings.TrimPrefix(migrateConnString, "postgresql://")
+	migrateConnString = "pgx5://" + migrateConnString
 
-	m, err := migrate.NewWithSourceInstance("iofs", d, connString)
+	m, err := migrate.NewWithSourceInstance("iofs", d, migrateConnString)
 	if err != nil {
 		return fmt.Errorf("создание экземпляра миграций. %w", err)
 	}
@@ -34,5 +35,10 @@ func MigrationsUP(connString string) error {
 	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		return fmt.Errorf("применение миграций. %w", err)
 	}
+	// шаги, которые нельзя выразить на sql
+	err = normalizeLogins(context.Background(), connString)
+	if err != nil {
+		return fmt.Errorf("приведение логинов к каноническому виду. %w", err)
+	}
 	return nil
 }
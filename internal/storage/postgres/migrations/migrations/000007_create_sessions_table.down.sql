@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE sessions;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,15 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS sessions (
+    session_id uuid,
+    user_id uuid,
+    refresh_hash text,
+    previous_refresh_hash text,
+    adding_at timestamp,
+    update_at timestamp,
+    expires_at timestamp,
+    revoked_at timestamp,
+    PRIMARY KEY(session_id),
+    UNIQUE(refresh_hash)
+);
+CREATE INDEX IF NOT EXISTS sessions_previous_refresh_hash_idx ON sessions(previous_refresh_hash);
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE revoked_tokens;
+COMMIT;
\ No newline at end of file
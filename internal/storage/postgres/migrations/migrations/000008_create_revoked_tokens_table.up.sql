@@ -0,0 +1,7 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id uuid,
+    expires_at timestamp,
+    PRIMARY KEY(token_id)
+);
+COMMIT;
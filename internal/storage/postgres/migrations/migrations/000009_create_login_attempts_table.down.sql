@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE login_attempts;
+COMMIT;
\ No newline at end of file
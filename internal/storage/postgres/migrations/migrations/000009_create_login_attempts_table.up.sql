@@ -0,0 +1,9 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key text,
+    failures integer,
+    last_failure_at timestamp,
+    locked_until timestamp,
+    PRIMARY KEY(key)
+);
+COMMIT;
@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE IF EXISTS password_reset_requests;
+DROP TABLE password_resets;
+COMMIT;
\ No newline at end of file
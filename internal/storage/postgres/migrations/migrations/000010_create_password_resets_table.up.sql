@@ -0,0 +1,19 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash text,
+    user_id uuid,
+    adding_at timestamp,
+    expires_at timestamp,
+    used_at timestamp,
+    PRIMARY KEY(token_hash),
+    FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets(user_id);
+-- число запросов на сброс пароля по ключу (логин или ip адрес) в окне, которое начинается с первого запроса
+CREATE TABLE IF NOT EXISTS password_reset_requests (
+    key text,
+    requests integer,
+    window_started_at timestamp,
+    PRIMARY KEY(key)
+);
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+-- исходный вид логинов не сохранялся, откатываются только служебные таблицы
+DROP TABLE IF EXISTS login_conflicts;
+DROP TABLE IF EXISTS pending_code_migrations;
+COMMIT;
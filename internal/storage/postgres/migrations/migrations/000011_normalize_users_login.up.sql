@@ -0,0 +1,21 @@
+BEGIN;
+-- логины теперь хранятся в каноническом виде (NFKC, case folding, без пробелов по краям), приводим к нему уже существующие.
+-- case folding в sql не выразить (lower() оставляет ß и ς), поэтому приведение выполняет код приложения после миграций
+-- той же функцией, что используется при входе. Шаги, ожидающие выполнения, записываются в pending_code_migrations
+CREATE TABLE IF NOT EXISTS pending_code_migrations (
+    name text,
+    PRIMARY KEY(name)
+);
+INSERT INTO pending_code_migrations(name) VALUES('normalize_logins') ON CONFLICT DO NOTHING;
+-- логины, которые нельзя привести к каноническому виду: после приведения логин совпадает с чужим.
+-- такие пользователи не могут войти, пока им не назначен новый логин (gophermartctl logins rename)
+CREATE TABLE IF NOT EXISTS login_conflicts (
+    user_id uuid,
+    login text,
+    canonical_login text,
+    owner_id uuid,
+    detected_at timestamp,
+    PRIMARY KEY(user_id),
+    FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+COMMIT;
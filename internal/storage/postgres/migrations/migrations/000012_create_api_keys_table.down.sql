@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE api_keys;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,17 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS api_keys (
+    key_id uuid,
+    user_id uuid,
+    name text,
+    key_hash text,
+    prefix text,
+    scopes text[],
+    adding_at timestamp,
+    last_used_at timestamp,
+    revoked_at timestamp,
+    PRIMARY KEY(key_id),
+    UNIQUE(key_hash),
+    FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys(user_id);
+COMMIT;
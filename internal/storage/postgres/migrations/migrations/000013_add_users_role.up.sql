@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('user','support','admin'));
+COMMIT;
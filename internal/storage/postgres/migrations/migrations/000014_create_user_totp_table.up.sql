@@ -0,0 +1,11 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id uuid,
+    secret text,
+    last_step bigint NOT NULL DEFAULT 0,
+    adding_at timestamp,
+    confirmed_at timestamp,
+    PRIMARY KEY(user_id),
+    FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+COMMIT;
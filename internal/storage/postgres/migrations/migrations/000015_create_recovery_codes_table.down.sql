@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE recovery_codes;
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id uuid,
+    code_hash text,
+    adding_at timestamp,
+    used_at timestamp,
+    PRIMARY KEY(user_id,code_hash),
+    FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE mfa_challenges;
+COMMIT;
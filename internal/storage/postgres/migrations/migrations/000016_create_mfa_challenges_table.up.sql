@@ -0,0 +1,10 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    token_hash text,
+    user_id uuid,
+    adding_at timestamp,
+    expires_at timestamp,
+    PRIMARY KEY(token_hash),
+    FOREIGN KEY(user_id) REFERENCES users(user_id)
+);
+COMMIT;
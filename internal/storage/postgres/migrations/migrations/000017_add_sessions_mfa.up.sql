@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS mfa boolean NOT NULL DEFAULT false;
+COMMIT;
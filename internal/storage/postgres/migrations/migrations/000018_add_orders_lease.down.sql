@@ -0,0 +1,5 @@
+BEGIN;
+DROP INDEX IF EXISTS orders_status_id_lease_until_idx;
+ALTER TABLE orders DROP COLUMN lease_until;
+ALTER TABLE orders DROP COLUMN lease_owner;
+COMMIT;
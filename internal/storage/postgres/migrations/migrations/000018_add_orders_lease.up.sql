@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS lease_owner text;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS lease_until timestamp;
+CREATE INDEX IF NOT EXISTS orders_status_id_lease_until_idx ON orders(status_id,lease_until);
+COMMIT;
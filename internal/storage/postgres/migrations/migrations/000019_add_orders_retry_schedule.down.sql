@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE orders DROP COLUMN dead_lettered_at;
+ALTER TABLE orders DROP COLUMN attempts;
+ALTER TABLE orders DROP COLUMN next_check_at;
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_check_at timestamp;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS dead_lettered_at timestamp;
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+DROP INDEX IF EXISTS orders_dead_lettered_at_idx;
+ALTER TABLE orders DROP COLUMN last_http_status;
+ALTER TABLE orders DROP COLUMN last_error;
+COMMIT;
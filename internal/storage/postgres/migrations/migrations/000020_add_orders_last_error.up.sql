@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_error text;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_http_status integer;
+CREATE INDEX IF NOT EXISTS orders_dead_lettered_at_idx ON orders(dead_lettered_at) WHERE dead_lettered_at IS NOT NULL;
+COMMIT;
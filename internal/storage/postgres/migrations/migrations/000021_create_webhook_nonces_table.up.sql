@@ -0,0 +1,8 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS webhook_nonces (
+    nonce text,
+    expires_at timestamp,
+    PRIMARY KEY(nonce)
+);
+CREATE INDEX IF NOT EXISTS webhook_nonces_expires_at_idx ON webhook_nonces(expires_at);
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+-- перенесенные в replenishments_duplicates пополнения обратно не возвращаются: вернуть их значит снова начислить баллы дважды.
+-- таблица остается как запись о том, что было удалено
+DROP INDEX IF EXISTS replenishments_order_id_idx;
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+-- до проверки смены статусов пополнение по заказу могло записаться дважды. оставляем самое раннее,
+-- а лишние переносим в replenishments_duplicates, чтобы по ним можно было разобраться с балансом пользователя
+CREATE TABLE IF NOT EXISTS replenishments_duplicates (LIKE replenishments INCLUDING DEFAULTS);
+ALTER TABLE replenishments_duplicates ADD COLUMN IF NOT EXISTS moved_at timestamp NOT NULL DEFAULT now();
+WITH duplicates AS (
+    DELETE FROM replenishments r
+    USING replenishments earlier
+    WHERE r.order_id=earlier.order_id
+        AND (earlier.replenishment_at<r.replenishment_at OR (earlier.replenishment_at=r.replenishment_at AND earlier.replenishment_id<r.replenishment_id))
+    RETURNING r.*
+)
+INSERT INTO replenishments_duplicates SELECT * FROM duplicates;
+CREATE UNIQUE INDEX IF NOT EXISTS replenishments_order_id_idx ON replenishments(order_id);
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS order_status_history (
+    history_id uuid,
+    order_id uuid,
+    status_id integer,
+    source text,
+    payload jsonb,
+    adding_at timestamp,
+    PRIMARY KEY(history_id)
+);
+CREATE INDEX IF NOT EXISTS order_status_history_order_id_idx ON order_status_history(order_id,adding_at);
+-- до появления истории известен только текущий статус заказа
+INSERT INTO order_status_history(history_id,order_id,status_id,source,adding_at)
+SELECT md5(order_id::text||status_id::text)::uuid,order_id,status_id,'legacy',adding_at
+FROM orders_statuses;
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_sum_check;
+ALTER TABLE replenishments DROP CONSTRAINT IF EXISTS replenishments_sum_check;
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE real USING sum::real;
+ALTER TABLE replenishments ALTER COLUMN sum TYPE real USING sum::real;
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+-- суммы хранятся точно, в сотых долях. real дает погрешность при сложении
+ALTER TABLE replenishments ALTER COLUMN sum TYPE numeric(20,2) USING round(sum::numeric,2);
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE numeric(20,2) USING round(sum::numeric,2);
+-- старые строки не проверяем, чтобы миграция не падала на уже записанных данных
+ALTER TABLE replenishments ADD CONSTRAINT replenishments_sum_check CHECK (sum>=0) NOT VALID;
+ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_sum_check CHECK (sum>0) NOT VALID;
+COMMIT;
@@ -6,7 +6,11 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -131,12 +135,12 @@ func (suite *PStorageTestSuite) TestSaveOrder() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	_, _, userID := suite.generateUser()
-	err := suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("123"))
+	err := suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("123")).StorageError
 	suite.NoError(err)
-	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("123"))
+	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("123")).StorageError
 	suite.ErrorIs(err, storage.ErrOrderWasAlreadyUpload)
 	_, _, userID2 := suite.generateUser()
-	err = suite.pstorage.SaveOrder(ctx, userID2, model.OrderNumber("123"))
+	err = suite.pstorage.SaveOrder(ctx, userID2, model.OrderNumber("123")).StorageError
 	suite.ErrorIs(err, storage.ErrOrderWasUploadByAnotherUser)
 }
 
@@ -146,26 +150,45 @@ func (suite *PStorageTestSuite) TestUpdateOrders() {
 
 	_, _, userID := suite.generateUser()
 
-	err := suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("111"))
+	err := suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("111")).StorageError
 	suite.NoError(err)
-	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("222"))
+	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("222")).StorageError
 	suite.NoError(err)
-	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("333"))
+	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("333")).StorageError
 	suite.NoError(err)
 
-	_, err = suite.pstorage.UpdateOrders(ctx, []model.ResponseAccuralSystem{
+	results, err := suite.pstorage.UpdateOrders(ctx, []model.ResponseAccuralSystem{
 		{
 			OrderNumber: "222",
 			Status:      storage.StatusProcessed,
-			Accrual:     222.22,
+			Accrual:     22222,
 		},
 		{
 			OrderNumber: "333",
 			Status:      storage.StatusProcessing,
-			Accrual:     222.22,
+			Accrual:     22222,
 		},
 	})
 	suite.NoError(err)
+	suite.Equal([]error{nil, nil}, results)
+
+	// результат по каждой строке, неудачные строки не мешают остальным
+	results, err = suite.pstorage.UpdateOrders(ctx, []model.ResponseAccuralSystem{
+		{OrderNumber: "222", Status: storage.StatusProcessed, Accrual: 22222},
+		{OrderNumber: "444", Status: storage.StatusProcessed, Accrual: 1},
+		{OrderNumber: "111", Status: model.NewStatus(0, "DONE")},
+		{OrderNumber: "111", Status: storage.StatusProcessed, Accrual: 11111},
+	})
+	suite.NoError(err)
+	suite.Require().Len(results, 4)
+	suite.ErrorIs(results[0], storage.ErrNothingHasBeenDone)
+	suite.ErrorIs(results[1], storage.ErrOrdersNotFound)
+	suite.ErrorIs(results[2], storage.ErrUnknownStatus)
+	suite.NoError(results[3])
+	// пополнение начисляется один раз
+	balance, err := suite.pstorage.Balance(ctx, userID)
+	suite.NoError(err)
+	suite.Equal(model.Money(22222+11111), balance.Current)
 }
 
 func (suite *PStorageTestSuite) TestOrdersByStatuses() {
@@ -174,7 +197,7 @@ func (suite *PStorageTestSuite) TestOrdersByStatuses() {
 	_, err := suite.pstorage.OrdersByStatuses(ctx, []model.Status{storage.StatusRegistered, storage.StatusInvalid}, 10, 0)
 	suite.ErrorIs(err, storage.ErrOrdersNotFound)
 	_, _, userID := suite.generateUser()
-	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("qqq"))
+	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("qqq")).StorageError
 	suite.NoError(err)
 	new, err := suite.pstorage.OrdersByStatuses(ctx, []model.Status{storage.StatusNew}, 10, 0)
 	suite.NoError(err)
@@ -188,9 +211,9 @@ func (suite *PStorageTestSuite) TestOrders() {
 	_, _, userID := suite.generateUser()
 	_, err := suite.pstorage.Orders(ctx, userID)
 	suite.ErrorIs(err, storage.ErrOrdersNotFound)
-	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("www"))
+	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("www")).StorageError
 	suite.NoError(err)
-	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("sss"))
+	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("sss")).StorageError
 	suite.NoError(err)
 	orders, err := suite.pstorage.Orders(ctx, userID)
 	suite.NoError(err)
@@ -213,9 +236,9 @@ func (suite *PStorageTestSuite) TestBalance() {
 	_, err = suite.pstorage.Withdrawals(ctx, userID)
 	suite.ErrorIs(err, storage.ErrWithdrawalsNotFound)
 	// делаем 2 новых заказа
-	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("eee"))
+	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("eee")).StorageError
 	suite.NoError(err)
-	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("ddd"))
+	err = suite.pstorage.SaveOrder(ctx, userID, model.OrderNumber("ddd")).StorageError
 	suite.NoError(err)
 	// делаем пополнения созданных заказов
 	err = suite.pstorage.UpdateOrder(ctx, model.ResponseAccuralSystem{OrderNumber: "eee", Status: storage.StatusProcessed, Accrual: 400})
@@ -238,6 +261,775 @@ func (suite *PStorageTestSuite) TestBalance() {
 	suite.NoError(err)
 	suite.Len(withdrawals, 2)
 }
+func (suite *PStorageTestSuite) TestWithdrawConcurrent() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, _, userID := suite.generateUser()
+	orderNum := model.OrderNumber(fmt.Sprint(time.Now().UnixNano()))
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, orderNum).StorageError)
+	suite.Require().NoError(suite.pstorage.UpdateOrder(ctx, model.ResponseAccuralSystem{OrderNumber: orderNum, Status: storage.StatusProcessed, Accrual: 1000}))
+
+	// на счете 10.00, параллельно пытаемся 30 раз списать по 1.00
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		success   int
+		notEnough int
+	)
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := suite.pstorage.Withdraw(ctx, userID, model.RequestWithdraw{OrderNumber: model.OrderNumber(fmt.Sprint(i)), Sum: 100})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				success++
+			case errors.Is(err, storage.ErrWithdrawNotEnough):
+				notEnough++
+			default:
+				suite.NoError(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	suite.Equal(10, success)
+	suite.Equal(20, notEnough)
+	balance, err := suite.pstorage.Balance(ctx, userID)
+	suite.NoError(err)
+	suite.EqualValues(model.ResponseBalance{Current: 0, Withdrawn: 1000}, balance)
+
+	// списание у несуществующего пользователя
+	err = suite.pstorage.Withdraw(ctx, uuid.New(), model.RequestWithdraw{OrderNumber: "1", Sum: 100})
+	suite.ErrorIs(err, storage.ErrUserNotFound)
+}
+func (suite *PStorageTestSuite) TestSessions() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	login, _, userID := suite.generateUser()
+
+	sessionID, err := suite.pstorage.CreateSession(ctx, userID, "refresh-1", time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+	active, err := suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+	suite.NoError(err)
+	suite.True(active)
+
+	// обновление refresh токена
+	session, err := suite.pstorage.RotateSession(ctx, "refresh-1", "refresh-2", time.Now().Add(time.Hour))
+	suite.NoError(err)
+	suite.EqualValues(sessionID, session.SessionID)
+	suite.EqualValues(userID, session.UserID)
+	suite.EqualValues(login, session.Login)
+
+	// повторное использование старого токена отзывает сессию
+	_, err = suite.pstorage.RotateSession(ctx, "refresh-1", "refresh-3", time.Now().Add(time.Hour))
+	suite.ErrorIs(err, storage.ErrSessionNotFound)
+	active, err = suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+	suite.NoError(err)
+	suite.False(active)
+	_, err = suite.pstorage.RotateSession(ctx, "refresh-2", "refresh-3", time.Now().Add(time.Hour))
+	suite.ErrorIs(err, storage.ErrSessionNotFound)
+
+	// явный отзыв сессии
+	sessionID, err = suite.pstorage.CreateSession(ctx, userID, "refresh-4", time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+	err = suite.pstorage.RevokeSession(ctx, sessionID)
+	suite.NoError(err)
+	active, err = suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+	suite.NoError(err)
+	suite.False(active)
+
+	// истекшая сессия
+	sessionID, err = suite.pstorage.CreateSession(ctx, userID, "refresh-5", time.Now().Add(-time.Minute), false)
+	suite.NoError(err)
+	active, err = suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+	suite.NoError(err)
+	suite.False(active)
+
+	// отзыв отдельного токена не затрагивает сессию
+	sessionID, err = suite.pstorage.CreateSession(ctx, userID, "refresh-6", time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+	tokenID := uuid.New()
+	err = suite.pstorage.RevokeToken(ctx, tokenID, time.Now().Add(time.Hour))
+	suite.NoError(err)
+	err = suite.pstorage.RevokeToken(ctx, tokenID, time.Now().Add(time.Hour))
+	suite.NoError(err)
+	active, err = suite.pstorage.SessionActive(ctx, sessionID, tokenID)
+	suite.NoError(err)
+	suite.False(active)
+	active, err = suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+	suite.NoError(err)
+	suite.True(active)
+}
+func (suite *PStorageTestSuite) TestUserCredentials() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	login, hash, userID := suite.generateUser()
+	credentials, err := suite.pstorage.UserCredentials(ctx, login)
+	suite.NoError(err)
+	suite.EqualValues(model.UserCredentials{UserID: userID, Login: login, PasswordHash: hash}, credentials)
+	_, err = suite.pstorage.UserCredentials(ctx, login+"_test_credentials")
+	suite.ErrorIs(err, storage.ErrUserNotFound)
+}
+func (suite *PStorageTestSuite) TestLoginAttempts() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key, otherKey := model.LoginAttemptsLoginKey(uuid.NewString()), model.LoginAttemptsIPKey(uuid.NewString())
+	now := time.Now()
+
+	attempts, err := suite.pstorage.LoginAttempts(ctx, key, otherKey)
+	suite.NoError(err)
+	suite.Empty(attempts)
+
+	// до порога вход не блокируется
+	for i := 1; i < 3; i++ {
+		at, err := suite.pstorage.RegisterLoginFailure(ctx, key, now.Add(-time.Hour), 3, now.Add(time.Hour))
+		suite.NoError(err)
+		suite.EqualValues(i, at.Failures)
+		suite.True(at.LockedUntil.IsZero())
+	}
+	at, err := suite.pstorage.RegisterLoginFailure(ctx, key, now.Add(-time.Hour), 3, now.Add(time.Hour))
+	suite.NoError(err)
+	suite.EqualValues(3, at.Failures)
+	suite.WithinDuration(now.Add(time.Hour), at.LockedUntil, time.Second)
+
+	attempts, err = suite.pstorage.LoginAttempts(ctx, key, otherKey)
+	suite.NoError(err)
+	suite.Require().Len(attempts, 1)
+	suite.EqualValues(key, attempts[0].Key)
+	suite.WithinDuration(now.Add(time.Hour), attempts[0].LockedUntil, time.Second)
+
+	// неудача после окна начинает счетчик заново
+	at, err = suite.pstorage.RegisterLoginFailure(ctx, key, time.Now().Add(time.Minute), 3, now.Add(time.Hour))
+	suite.NoError(err)
+	suite.EqualValues(1, at.Failures)
+
+	// сброс
+	err = suite.pstorage.ResetLoginFailures(ctx, key)
+	suite.NoError(err)
+	attempts, err = suite.pstorage.LoginAttempts(ctx, key)
+	suite.NoError(err)
+	suite.Empty(attempts)
+}
+func (suite *PStorageTestSuite) TestChangePassword() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, userID := suite.generateUser()
+	sessionID, err := suite.pstorage.CreateSession(ctx, userID, uuid.NewString(), time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+
+	err = suite.pstorage.ChangePassword(ctx, userID, "new-hash")
+	suite.NoError(err)
+	hash, err := suite.pstorage.HashPassword(ctx, userID)
+	suite.NoError(err)
+	suite.EqualValues("new-hash", hash)
+	active, err := suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+	suite.NoError(err)
+	suite.False(active)
+
+	err = suite.pstorage.ChangePassword(ctx, uuid.New(), "new-hash")
+	suite.ErrorIs(err, storage.ErrUserNotFound)
+}
+func (suite *PStorageTestSuite) TestRehashPassword() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, hash, userID := suite.generateUser()
+	sessionID, err := suite.pstorage.CreateSession(ctx, userID, uuid.NewString(), time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+
+	err = suite.pstorage.RehashPassword(ctx, userID, hash, "rehashed")
+	suite.NoError(err)
+	actHash, err := suite.pstorage.HashPassword(ctx, userID)
+	suite.NoError(err)
+	suite.EqualValues("rehashed", actHash)
+	// пересчет хеша сессии не отзывает
+	active, err := suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+	suite.NoError(err)
+	suite.True(active)
+
+	// хеш уже изменен
+	err = suite.pstorage.RehashPassword(ctx, userID, hash, "rehashed-2")
+	suite.ErrorIs(err, storage.ErrNothingHasBeenDone)
+	actHash, err = suite.pstorage.HashPassword(ctx, userID)
+	suite.NoError(err)
+	suite.EqualValues("rehashed", actHash)
+}
+func (suite *PStorageTestSuite) TestPasswordReset() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, userID := suite.generateUser()
+	sessionID, err := suite.pstorage.CreateSession(ctx, userID, uuid.NewString(), time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+
+	// новый токен отменяет предыдущий
+	tokenOld, token := uuid.NewString(), uuid.NewString()
+	err = suite.pstorage.CreatePasswordReset(ctx, userID, tokenOld, time.Now().Add(time.Hour))
+	suite.NoError(err)
+	err = suite.pstorage.CreatePasswordReset(ctx, userID, token, time.Now().Add(time.Hour))
+	suite.NoError(err)
+	_, err = suite.pstorage.RedeemPasswordReset(ctx, tokenOld, "reset-hash")
+	suite.ErrorIs(err, storage.ErrPasswordResetNotFound)
+
+	actUserID, err := suite.pstorage.RedeemPasswordReset(ctx, token, "reset-hash")
+	suite.NoError(err)
+	suite.EqualValues(userID, actUserID)
+	hash, err := suite.pstorage.HashPassword(ctx, userID)
+	suite.NoError(err)
+	suite.EqualValues("reset-hash", hash)
+	active, err := suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+	suite.NoError(err)
+	suite.False(active)
+
+	// токен одноразовый
+	_, err = suite.pstorage.RedeemPasswordReset(ctx, token, "reset-hash-2")
+	suite.ErrorIs(err, storage.ErrPasswordResetNotFound)
+
+	// истекший токен
+	token = uuid.NewString()
+	err = suite.pstorage.CreatePasswordReset(ctx, userID, token, time.Now().Add(-time.Minute))
+	suite.NoError(err)
+	_, err = suite.pstorage.RedeemPasswordReset(ctx, token, "reset-hash-3")
+	suite.ErrorIs(err, storage.ErrPasswordResetNotFound)
+
+	// запросы считаются в окне от первого запроса, устаревшее окно начинается заново
+	key := model.PasswordResetLoginKey(uuid.NewString())
+	first, err := suite.pstorage.RegisterPasswordResetRequest(ctx, key, time.Now().Add(-time.Hour))
+	suite.Require().NoError(err)
+	suite.Equal(1, first.Requests)
+	second, err := suite.pstorage.RegisterPasswordResetRequest(ctx, key, time.Now().Add(-time.Hour))
+	suite.Require().NoError(err)
+	suite.Equal(2, second.Requests)
+	suite.Equal(first.WindowStartedAt, second.WindowStartedAt)
+	renewed, err := suite.pstorage.RegisterPasswordResetRequest(ctx, key, time.Now().Add(time.Second))
+	suite.Require().NoError(err)
+	suite.Equal(1, renewed.Requests)
+	suite.True(renewed.WindowStartedAt.After(first.WindowStartedAt))
+	// счетчик входа не затрагивается
+	attempts, err := suite.pstorage.LoginAttempts(ctx, key)
+	suite.NoError(err)
+	suite.Empty(attempts)
+}
+func (suite *PStorageTestSuite) TestLoginConflicts() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ownerLogin, _, ownerID := suite.generateUser()
+	login, _, userID := suite.generateUser()
+	_, _, otherID := suite.generateUser()
+
+	// конфликты записывает миграция приведения логинов, здесь достаточно записать их напрямую
+	free := uuid.NewString()
+	_, err := suite.pstorage.Exec(
+		ctx,
+		"INSERT INTO login_conflicts(user_id,login,canonical_login,owner_id,detected_at) VALUES($1,$2,$3,$4,$5),($6,$7,$8,NULL,$5)",
+		userID, login, ownerLogin, ownerID, time.Now(),
+		otherID, free+" ", free,
+	)
+	suite.Require().NoError(err)
+
+	conflicts, err := suite.pstorage.LoginConflicts(ctx)
+	suite.Require().NoError(err)
+	idx := slices.IndexFunc(conflicts, func(c model.LoginConflict) bool { return c.UserID == userID })
+	suite.Require().NotEqual(-1, idx)
+	suite.Equal(login, conflicts[idx].Login)
+	suite.Equal(ownerLogin, conflicts[idx].CanonicalLogin)
+	suite.Equal(ownerLogin, conflicts[idx].OwnerLogin)
+
+	// занятый логин не выдается
+	err = suite.pstorage.ResolveLoginConflict(ctx, userID, ownerLogin)
+	suite.ErrorIs(err, storage.ErrLoginIsUsed)
+	err = suite.pstorage.ResolveLoginConflict(ctx, userID, free)
+	suite.NoError(err)
+	_, err = suite.pstorage.UserCredentials(ctx, free)
+	suite.NoError(err)
+	// второй претендент на свободный логин теперь конфликтует с тем, кто его занял
+	conflicts, err = suite.pstorage.LoginConflicts(ctx)
+	suite.Require().NoError(err)
+	idx = slices.IndexFunc(conflicts, func(c model.LoginConflict) bool { return c.UserID == otherID })
+	suite.Require().NotEqual(-1, idx)
+	suite.Equal(free, conflicts[idx].OwnerLogin)
+
+	err = suite.pstorage.ResolveLoginConflict(ctx, userID, uuid.NewString())
+	suite.ErrorIs(err, storage.ErrLoginConflictNotFound)
+}
+func (suite *PStorageTestSuite) TestAPIKeys() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	login, _, userID := suite.generateUser()
+
+	_, err := suite.pstorage.APIKeys(ctx, userID)
+	suite.ErrorIs(err, storage.ErrAPIKeysNotFound)
+
+	keyHash := uuid.NewString()
+	key, err := suite.pstorage.CreateAPIKey(ctx, userID, "partner", keyHash, "gm_prefix", []string{model.ScopeOrdersWrite})
+	suite.NoError(err)
+	suite.NotEqualValues(uuid.UUID{}, key.KeyID)
+
+	owner, err := suite.pstorage.APIKeyOwner(ctx, keyHash)
+	suite.NoError(err)
+	suite.EqualValues(key.KeyID, owner.KeyID)
+	suite.EqualValues(userID, owner.UserID)
+	suite.EqualValues(login, owner.Login)
+	suite.EqualValues([]string{model.ScopeOrdersWrite}, owner.Scopes)
+
+	keys, err := suite.pstorage.APIKeys(ctx, userID)
+	suite.NoError(err)
+	suite.Require().Len(keys, 1)
+	suite.EqualValues("gm_prefix", keys[0].Prefix)
+	suite.NotNil(keys[0].LastUsedAt)
+
+	// чужой ключ отозвать нельзя
+	err = suite.pstorage.RevokeAPIKey(ctx, uuid.New(), key.KeyID)
+	suite.ErrorIs(err, storage.ErrAPIKeyNotFound)
+
+	err = suite.pstorage.RevokeAPIKey(ctx, userID, key.KeyID)
+	suite.NoError(err)
+	err = suite.pstorage.RevokeAPIKey(ctx, userID, key.KeyID)
+	suite.ErrorIs(err, storage.ErrAPIKeyNotFound)
+	_, err = suite.pstorage.APIKeyOwner(ctx, keyHash)
+	suite.ErrorIs(err, storage.ErrAPIKeyNotFound)
+	_, err = suite.pstorage.APIKeys(ctx, userID)
+	suite.ErrorIs(err, storage.ErrAPIKeysNotFound)
+}
+func (suite *PStorageTestSuite) TestUserRole() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	login, hash, userID := suite.generateUser()
+
+	// новый пользователь получает роль по умолчанию
+	user, err := suite.pstorage.User(ctx, login)
+	suite.NoError(err)
+	suite.EqualValues(userID, user.UserID)
+	suite.EqualValues(model.RoleUser, user.Role)
+
+	err = suite.pstorage.SetUserRole(ctx, login, model.RoleSupport)
+	suite.NoError(err)
+	credentials, err := suite.pstorage.UserCredentials(ctx, login)
+	suite.NoError(err)
+	suite.EqualValues(model.RoleSupport, credentials.Role)
+	suite.EqualValues(hash, credentials.PasswordHash)
+
+	// роль попадает в сессию при обновлении токенов
+	refreshHash := uuid.NewString()
+	_, err = suite.pstorage.CreateSession(ctx, userID, refreshHash, time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+	session, err := suite.pstorage.RotateSession(ctx, refreshHash, uuid.NewString(), time.Now().Add(time.Hour))
+	suite.NoError(err)
+	suite.EqualValues(model.RoleSupport, session.Role)
+
+	// неизвестная роль отклоняется базой
+	err = suite.pstorage.SetUserRole(ctx, login, "root")
+	suite.Error(err)
+	err = suite.pstorage.SetUserRole(ctx, login+"-unknown", model.RoleAdmin)
+	suite.ErrorIs(err, storage.ErrUserNotFound)
+	_, err = suite.pstorage.User(ctx, login+"-unknown")
+	suite.ErrorIs(err, storage.ErrUserNotFound)
+}
+func (suite *PStorageTestSuite) TestRevokeUserSessions() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, userID := suite.generateUser()
+	first, err := suite.pstorage.CreateSession(ctx, userID, uuid.NewString(), time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+	second, err := suite.pstorage.CreateSession(ctx, userID, uuid.NewString(), time.Now().Add(time.Hour), false)
+	suite.NoError(err)
+
+	err = suite.pstorage.RevokeUserSessions(ctx, userID)
+	suite.NoError(err)
+	for _, sessionID := range []uuid.UUID{first, second} {
+		active, err := suite.pstorage.SessionActive(ctx, sessionID, uuid.New())
+		suite.NoError(err)
+		suite.False(active)
+	}
+}
+func (suite *PStorageTestSuite) TestTOTP() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	login, _, userID := suite.generateUser()
+
+	_, err := suite.pstorage.TOTP(ctx, userID)
+	suite.ErrorIs(err, storage.ErrTOTPNotFound)
+
+	// неподтвержденный секрет можно перевыпустить
+	suite.NoError(suite.pstorage.SaveTOTP(ctx, userID, "secret-1"))
+	suite.NoError(suite.pstorage.SaveTOTP(ctx, userID, "secret-2"))
+	secret, err := suite.pstorage.TOTP(ctx, userID)
+	suite.NoError(err)
+	suite.EqualValues(model.TOTP{Secret: "secret-2"}, secret)
+	credentials, err := suite.pstorage.UserCredentials(ctx, login)
+	suite.NoError(err)
+	suite.False(credentials.TOTPEnabled)
+
+	err = suite.pstorage.ConfirmTOTP(ctx, userID, 10, []string{"code-1", "code-2"})
+	suite.NoError(err)
+	err = suite.pstorage.ConfirmTOTP(ctx, userID, 11, nil)
+	suite.ErrorIs(err, storage.ErrNothingHasBeenDone)
+	err = suite.pstorage.SaveTOTP(ctx, userID, "secret-3")
+	suite.ErrorIs(err, storage.ErrTOTPAlreadyEnabled)
+	secret, err = suite.pstorage.TOTP(ctx, userID)
+	suite.NoError(err)
+	suite.EqualValues(model.TOTP{Secret: "secret-2", Confirmed: true, LastStep: 10}, secret)
+	credentials, err = suite.pstorage.UserCredentials(ctx, login)
+	suite.NoError(err)
+	suite.True(credentials.TOTPEnabled)
+
+	// шаг принимается только один раз
+	suite.ErrorIs(suite.pstorage.UseTOTPStep(ctx, userID, 10), storage.ErrNothingHasBeenDone)
+	suite.NoError(suite.pstorage.UseTOTPStep(ctx, userID, 11))
+	suite.ErrorIs(suite.pstorage.UseTOTPStep(ctx, userID, 11), storage.ErrNothingHasBeenDone)
+
+	// коды восстановления одноразовые и заменяются целиком
+	suite.NoError(suite.pstorage.UseRecoveryCode(ctx, userID, "code-1"))
+	suite.ErrorIs(suite.pstorage.UseRecoveryCode(ctx, userID, "code-1"), storage.ErrRecoveryCodeNotFound)
+	suite.NoError(suite.pstorage.ReplaceRecoveryCodes(ctx, userID, []string{"code-3"}))
+	suite.ErrorIs(suite.pstorage.UseRecoveryCode(ctx, userID, "code-2"), storage.ErrRecoveryCodeNotFound)
+	suite.ErrorIs(suite.pstorage.UseRecoveryCode(ctx, uuid.New(), "code-3"), storage.ErrRecoveryCodeNotFound)
+
+	suite.NoError(suite.pstorage.DisableTOTP(ctx, userID))
+	_, err = suite.pstorage.TOTP(ctx, userID)
+	suite.ErrorIs(err, storage.ErrTOTPNotFound)
+	suite.ErrorIs(suite.pstorage.UseRecoveryCode(ctx, userID, "code-3"), storage.ErrRecoveryCodeNotFound)
+}
+func (suite *PStorageTestSuite) TestMFAChallenge() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	login, _, userID := suite.generateUser()
+
+	tokenHash := uuid.NewString()
+	suite.NoError(suite.pstorage.CreateMFAChallenge(ctx, userID, tokenHash, time.Now().Add(time.Minute)))
+	challenge, err := suite.pstorage.MFAChallenge(ctx, tokenHash)
+	suite.NoError(err)
+	suite.EqualValues(model.MFAChallenge{UserID: userID, Login: login, Role: model.RoleUser}, challenge)
+	suite.NoError(suite.pstorage.DeleteMFAChallenge(ctx, tokenHash))
+	suite.ErrorIs(suite.pstorage.DeleteMFAChallenge(ctx, tokenHash), storage.ErrMFAChallengeNotFound)
+	_, err = suite.pstorage.MFAChallenge(ctx, tokenHash)
+	suite.ErrorIs(err, storage.ErrMFAChallengeNotFound)
+
+	// истекший вход
+	tokenHash = uuid.NewString()
+	suite.NoError(suite.pstorage.CreateMFAChallenge(ctx, userID, tokenHash, time.Now().Add(-time.Minute)))
+	_, err = suite.pstorage.MFAChallenge(ctx, tokenHash)
+	suite.ErrorIs(err, storage.ErrMFAChallengeNotFound)
+
+	// признак второго фактора сохраняется при обновлении токенов
+	refreshHash := uuid.NewString()
+	_, err = suite.pstorage.CreateSession(ctx, userID, refreshHash, time.Now().Add(time.Hour), true)
+	suite.NoError(err)
+	session, err := suite.pstorage.RotateSession(ctx, refreshHash, uuid.NewString(), time.Now().Add(time.Hour))
+	suite.NoError(err)
+	suite.True(session.MFA)
+}
+func (suite *PStorageTestSuite) TestClaimOrders() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// в базе могут быть заказы других тестов, поэтому захватываем все и дальше смотрим только на свои
+	statuses := []model.Status{storage.StatusNew}
+	_, err := suite.pstorage.ClaimOrders(ctx, "other", statuses, 1000, time.Hour)
+	suite.Require().True(err == nil || errors.Is(err, storage.ErrOrdersNotFound))
+
+	_, _, userID := suite.generateUser()
+	orderNum := model.OrderNumber(fmt.Sprint(time.Now().UnixNano()))
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, orderNum).StorageError)
+
+	// заказ достается только одному экземпляру
+	orders, err := suite.pstorage.ClaimOrders(ctx, "first", statuses, 10, 200*time.Millisecond)
+	suite.NoError(err)
+	suite.EqualValues(model.ResponseOrders{{OrderNumber: orderNum}}, orders)
+	_, err = suite.pstorage.ClaimOrders(ctx, "second", statuses, 10, time.Minute)
+	suite.ErrorIs(err, storage.ErrOrdersNotFound)
+
+	// после истечения аренды заказ снова свободен
+	time.Sleep(300 * time.Millisecond)
+	orders, err = suite.pstorage.ClaimOrders(ctx, "second", statuses, 10, time.Minute)
+	suite.NoError(err)
+	suite.EqualValues(model.ResponseOrders{{OrderNumber: orderNum}}, orders)
+
+	// параллельный захват не выдает один заказ дважды
+	for i := 0; i < 20; i++ {
+		orderNum := model.OrderNumber(fmt.Sprintf("%d%d", time.Now().UnixNano(), i))
+		suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, orderNum).StorageError)
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		claimed = map[model.OrderNumber]int{}
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			for {
+				orders, err := suite.pstorage.ClaimOrders(ctx, owner, statuses, 3, time.Minute)
+				if errors.Is(err, storage.ErrOrdersNotFound) {
+					return
+				}
+				suite.NoError(err)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				for _, o := range orders {
+					claimed[o.OrderNumber]++
+				}
+				mu.Unlock()
+			}
+		}(fmt.Sprint("worker-", i))
+	}
+	wg.Wait()
+	suite.Len(claimed, 20)
+	for orderNum, n := range claimed {
+		suite.EqualValues(1, n, orderNum)
+	}
+}
+func (suite *PStorageTestSuite) TestOrderRetrySchedule() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	statuses := []model.Status{storage.StatusNew}
+	_, err := suite.pstorage.ClaimOrders(ctx, "other", statuses, 1000, time.Hour)
+	suite.Require().True(err == nil || errors.Is(err, storage.ErrOrdersNotFound))
+
+	_, _, userID := suite.generateUser()
+	orderNum := model.OrderNumber(fmt.Sprint(time.Now().UnixNano()))
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, orderNum).StorageError)
+
+	// назначить проверку можно только для своего заказа
+	suite.ErrorIs(suite.pstorage.ScheduleOrderCheck(ctx, "first", orderNum, model.AccrualFailure{Attempts: 1}, time.Now()), storage.ErrOrdersNotFound)
+	orders, err := suite.pstorage.ClaimOrders(ctx, "first", statuses, 10, time.Hour)
+	suite.Require().NoError(err)
+	suite.EqualValues(model.ResponseOrders{{OrderNumber: orderNum}}, orders)
+
+	// до времени следующей проверки заказ не захватывается, даже если аренда снята
+	suite.NoError(suite.pstorage.ScheduleOrderCheck(ctx, "first", orderNum, model.AccrualFailure{Attempts: 1}, time.Now().Add(200*time.Millisecond)))
+	_, err = suite.pstorage.ClaimOrders(ctx, "second", statuses, 10, time.Hour)
+	suite.ErrorIs(err, storage.ErrOrdersNotFound)
+	time.Sleep(300 * time.Millisecond)
+	orders, err = suite.pstorage.ClaimOrders(ctx, "second", statuses, 10, time.Hour)
+	suite.Require().NoError(err)
+	suite.EqualValues(model.ResponseOrders{{OrderNumber: orderNum, Attempts: 1}}, orders)
+
+	// заказ из очереди недоставленных больше не захватывается
+	suite.ErrorIs(suite.pstorage.DeadLetterOrder(ctx, "first", orderNum, model.AccrualFailure{Attempts: 2}), storage.ErrOrdersNotFound)
+	suite.NoError(suite.pstorage.DeadLetterOrder(ctx, "second", orderNum, model.AccrualFailure{Attempts: 2}))
+	_, err = suite.pstorage.ClaimOrders(ctx, "third", statuses, 10, time.Hour)
+	suite.ErrorIs(err, storage.ErrOrdersNotFound)
+}
+func (suite *PStorageTestSuite) TestDeadLetters() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	statuses := []model.Status{storage.StatusNew}
+	_, err := suite.pstorage.ClaimOrders(ctx, "other", statuses, 1000, time.Hour)
+	suite.Require().True(err == nil || errors.Is(err, storage.ErrOrdersNotFound))
+
+	login, _, userID := suite.generateUser()
+	first := model.OrderNumber(fmt.Sprint(time.Now().UnixNano()))
+	second := model.OrderNumber(fmt.Sprint(time.Now().UnixNano() + 1))
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, first).StorageError)
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, second).StorageError)
+	_, err = suite.pstorage.DeadLetter(ctx, first)
+	suite.ErrorIs(err, storage.ErrOrdersNotFound)
+
+	_, err = suite.pstorage.ClaimOrders(ctx, "first", statuses, 10, time.Hour)
+	suite.Require().NoError(err)
+	failure := model.AccrualFailure{Attempts: 5, Error: "заказ не зарегистрирован в системе расчета", HTTPStatus: http.StatusNoContent}
+	suite.Require().NoError(suite.pstorage.DeadLetterOrder(ctx, "first", first, failure))
+	suite.Require().NoError(suite.pstorage.DeadLetterOrder(ctx, "first", second, model.AccrualFailure{Attempts: 5, Error: "connection refused"}))
+
+	// последняя ошибка, код ответа и число попыток сохраняются
+	dl, err := suite.pstorage.DeadLetter(ctx, first)
+	suite.Require().NoError(err)
+	suite.Equal(first, dl.OrderNumber)
+	suite.Equal(login, dl.Login)
+	suite.Equal(storage.StatusNew.Value(), dl.Status)
+	suite.Equal(failure.Attempts, dl.Attempts)
+	suite.Equal(failure.Error, dl.LastError)
+	suite.Equal(failure.HTTPStatus, dl.LastHTTPStatus)
+	dl, err = suite.pstorage.DeadLetter(ctx, second)
+	suite.Require().NoError(err)
+	suite.Zero(dl.LastHTTPStatus)
+
+	dls, err := suite.pstorage.DeadLetters(ctx, 1000, 0)
+	suite.Require().NoError(err)
+	found := 0
+	for _, dl := range dls {
+		if dl.OrderNumber == first || dl.OrderNumber == second {
+			found++
+		}
+	}
+	suite.Equal(2, found)
+
+	// возвращенный на проверку заказ снова захватывается со сброшенным счетчиком попыток
+	suite.NoError(suite.pstorage.RequeueDeadLetter(ctx, first))
+	suite.ErrorIs(suite.pstorage.RequeueDeadLetter(ctx, first), storage.ErrOrdersNotFound)
+	orders, err := suite.pstorage.ClaimOrders(ctx, "second", statuses, 10, time.Hour)
+	suite.Require().NoError(err)
+	suite.EqualValues(model.ResponseOrders{{OrderNumber: first}}, orders)
+
+	// переведенный в INVALID заказ уходит из очереди
+	suite.NoError(suite.pstorage.InvalidateDeadLetter(ctx, second))
+	suite.ErrorIs(suite.pstorage.InvalidateDeadLetter(ctx, second), storage.ErrOrdersNotFound)
+	_, err = suite.pstorage.DeadLetter(ctx, second)
+	suite.ErrorIs(err, storage.ErrOrdersNotFound)
+	userOrders, err := suite.pstorage.Orders(ctx, userID)
+	suite.Require().NoError(err)
+	for _, o := range userOrders {
+		if o.OrderNumber == second {
+			suite.Equal(storage.StatusInvalid.Value(), o.Status.Value())
+		}
+	}
+}
+func (suite *PStorageTestSuite) TestDeadLetterFinalStatus() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	statuses := []model.Status{storage.StatusNew}
+	_, err := suite.pstorage.ClaimOrders(ctx, "other", statuses, 1000, time.Hour)
+	suite.Require().True(err == nil || errors.Is(err, storage.ErrOrdersNotFound))
+
+	_, _, userID := suite.generateUser()
+	orderNum := model.OrderNumber(fmt.Sprint(time.Now().UnixNano()))
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, orderNum).StorageError)
+	_, err = suite.pstorage.ClaimOrders(ctx, "first", statuses, 10, time.Hour)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.pstorage.DeadLetterOrder(ctx, "first", orderNum, model.AccrualFailure{Attempts: 5, Error: "connection refused"}))
+
+	// промежуточный статус из события оставляет заказ в очереди
+	err = suite.pstorage.UpdateOrder(ctx, model.ResponseAccuralSystem{OrderNumber: orderNum, Status: storage.StatusProcessing, Source: model.StatusSourceWebhook})
+	suite.Require().NoError(err)
+	_, err = suite.pstorage.DeadLetter(ctx, orderNum)
+	suite.NoError(err)
+
+	// окончательный статус из события убирает заказ из очереди
+	err = suite.pstorage.UpdateOrder(ctx, model.ResponseAccuralSystem{OrderNumber: orderNum, Status: storage.StatusProcessed, Accrual: 100, Source: model.StatusSourceWebhook})
+	suite.Require().NoError(err)
+	_, err = suite.pstorage.DeadLetter(ctx, orderNum)
+	suite.ErrorIs(err, storage.ErrOrdersNotFound)
+	dls, err := suite.pstorage.DeadLetters(ctx, 1000, 0)
+	suite.True(err == nil || errors.Is(err, storage.ErrOrdersNotFound))
+	for _, dl := range dls {
+		suite.NotEqual(orderNum, dl.OrderNumber)
+	}
+	suite.ErrorIs(suite.pstorage.InvalidateDeadLetter(ctx, orderNum), storage.ErrOrdersNotFound)
+	balance, err := suite.pstorage.Balance(ctx, userID)
+	suite.NoError(err)
+	suite.Equal(model.Money(100), balance.Current)
+}
+func (suite *PStorageTestSuite) TestWebhook() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	statuses := []model.Status{storage.StatusNew}
+	_, err := suite.pstorage.ClaimOrders(ctx, "other", statuses, 1000, time.Hour)
+	suite.Require().True(err == nil || errors.Is(err, storage.ErrOrdersNotFound))
+
+	// событие принимается один раз, пока не истек срок признака
+	nonce := fmt.Sprint("nonce-", time.Now().UnixNano())
+	suite.NoError(suite.pstorage.SaveWebhookNonce(ctx, nonce, time.Now().Add(time.Hour)))
+	suite.ErrorIs(suite.pstorage.SaveWebhookNonce(ctx, nonce, time.Now().Add(time.Hour)), storage.ErrWebhookReplay)
+	expired := fmt.Sprint("nonce-", time.Now().UnixNano())
+	suite.NoError(suite.pstorage.SaveWebhookNonce(ctx, expired, time.Now().Add(-time.Second)))
+	suite.NoError(suite.pstorage.SaveWebhookNonce(ctx, expired, time.Now().Add(time.Hour)))
+
+	_, _, userID := suite.generateUser()
+	orderNum := model.OrderNumber(fmt.Sprint(time.Now().UnixNano()))
+	suite.ErrorIs(suite.pstorage.DeferOrderCheck(ctx, orderNum, time.Now()), storage.ErrOrdersNotFound)
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, orderNum).StorageError)
+
+	// отложенный заказ не опрашивается до срока, более ранний срок его не сдвигает
+	suite.NoError(suite.pstorage.DeferOrderCheck(ctx, orderNum, time.Now().Add(300*time.Millisecond)))
+	suite.NoError(suite.pstorage.DeferOrderCheck(ctx, orderNum, time.Now()))
+	_, err = suite.pstorage.ClaimOrders(ctx, "first", statuses, 10, time.Hour)
+	suite.ErrorIs(err, storage.ErrOrdersNotFound)
+	time.Sleep(400 * time.Millisecond)
+	orders, err := suite.pstorage.ClaimOrders(ctx, "first", statuses, 10, time.Hour)
+	suite.Require().NoError(err)
+	suite.EqualValues(model.ResponseOrders{{OrderNumber: orderNum}}, orders)
+}
+func (suite *PStorageTestSuite) TestLeader() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	task := fmt.Sprint("task-", time.Now().UnixNano())
+
+	// ведущий для задачи только один
+	leader, err := suite.pstorage.TryLead(ctx, task, time.Second)
+	suite.Require().NoError(err)
+	_, err = suite.pstorage.TryLead(ctx, task, time.Second)
+	suite.ErrorIs(err, storage.ErrNotLeader)
+	other, err := suite.pstorage.TryLead(ctx, task+"-other", time.Second)
+	suite.Require().NoError(err)
+	suite.NoError(other.Release(ctx))
+
+	// после снятия блокировки ведущим может стать другой экземпляр
+	suite.NoError(leader.Release(ctx))
+	leader, err = suite.pstorage.TryLead(ctx, task, time.Second)
+	suite.Require().NoError(err)
+	select {
+	case <-leader.Lost():
+		suite.Fail("блокировка потеряна без обрыва соединения")
+	default:
+	}
+	suite.NoError(leader.Release(ctx))
+}
+func (suite *PStorageTestSuite) TestOrderTransitions() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, userID := suite.generateUser()
+	orderNum := model.OrderNumber(fmt.Sprint(time.Now().UnixNano()))
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, orderNum).StorageError)
+	update := func(status model.Status) error {
+		return suite.pstorage.UpdateOrder(ctx, model.ResponseAccuralSystem{OrderNumber: orderNum, Status: status, Accrual: 100})
+	}
+
+	suite.NoError(update(storage.StatusProcessing))
+	// запоздавший ответ не возвращает заказ назад
+	suite.ErrorIs(update(storage.StatusRegistered), storage.ErrIllegalTransition)
+	suite.ErrorIs(update(storage.StatusProcessing), storage.ErrNothingHasBeenDone)
+	suite.NoError(update(storage.StatusProcessed))
+	// окончательный статус не меняется, пополнение не повторяется
+	suite.ErrorIs(update(storage.StatusProcessing), storage.ErrIllegalTransition)
+	suite.ErrorIs(update(storage.StatusInvalid), storage.ErrIllegalTransition)
+	suite.ErrorIs(update(storage.StatusProcessed), storage.ErrNothingHasBeenDone)
+	orders, err := suite.pstorage.Orders(ctx, userID)
+	suite.Require().NoError(err)
+	suite.Require().Len(orders, 1)
+	suite.Equal(storage.StatusProcessed.Value(), orders[0].Status.Value())
+	balance, err := suite.pstorage.Balance(ctx, userID)
+	suite.NoError(err)
+	suite.Equal(model.Money(100), balance.Current)
+}
+func (suite *PStorageTestSuite) TestOrderHistory() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, userID := suite.generateUser()
+	_, _, otherID := suite.generateUser()
+	orderNum := model.OrderNumber(fmt.Sprint(time.Now().UnixNano()))
+	suite.Require().NoError(suite.pstorage.SaveOrder(ctx, userID, orderNum).StorageError)
+	payload := []byte(`{"order":"` + string(orderNum) + `","status":"PROCESSED","accrual":100}`)
+	suite.Require().NoError(suite.pstorage.UpdateOrder(ctx, model.ResponseAccuralSystem{OrderNumber: orderNum, Status: storage.StatusProcessing, Source: model.StatusSourcePoller}))
+	suite.Require().NoError(suite.pstorage.UpdateOrder(ctx, model.ResponseAccuralSystem{
+		OrderNumber: orderNum,
+		Status:      storage.StatusProcessed,
+		Accrual:     100,
+		Source:      model.StatusSourceWebhook,
+		Payload:     payload,
+	}))
+	// отклоненная смена статуса в историю не попадает
+	suite.ErrorIs(suite.pstorage.UpdateOrder(ctx, model.ResponseAccuralSystem{OrderNumber: orderNum, Status: storage.StatusProcessing}), storage.ErrIllegalTransition)
+
+	history, err := suite.pstorage.OrderHistory(ctx, userID, orderNum)
+	suite.Require().NoError(err)
+	suite.Require().Len(history, 3)
+	suite.Equal(storage.StatusNew.Value(), history[0].Status.Value())
+	suite.Equal(model.StatusSourceUpload, history[0].Source)
+	suite.Equal(storage.StatusProcessing.Value(), history[1].Status.Value())
+	suite.Equal(model.StatusSourcePoller, history[1].Source)
+	suite.Nil(history[1].Payload)
+	suite.Equal(storage.StatusProcessed.Value(), history[2].Status.Value())
+	suite.Equal(model.StatusSourceWebhook, history[2].Source)
+	suite.JSONEq(string(payload), string(history[2].Payload))
+	suite.False(history[2].ChangedAt.Before(history[1].ChangedAt))
+
+	// историю видит только владелец заказа
+	_, err = suite.pstorage.OrderHistory(ctx, otherID, orderNum)
+	suite.ErrorIs(err, storage.ErrOrdersNotFound)
+}
 func TestExampleTestSuite(t *testing.T) {
 	suite.Run(t, new(PStorageTestSuite))
 }
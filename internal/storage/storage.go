@@ -3,6 +3,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/kTowkA/gophermart/internal/model"
@@ -22,6 +23,112 @@ type Storage interface {
 	// Если по такому id не находит пользователя, то возвращает ErrUserNotFound
 	HashPassword(ctx context.Context, userID uuid.UUID) (string, error)
 
+	// UserCredentials возвращает данные для входа пользователя с логином login одним запросом.
+	// Если по такому логину не находит пользователя, то возвращает ErrUserNotFound
+	UserCredentials(ctx context.Context, login string) (model.UserCredentials, error)
+
+	// User возвращает информацию о пользователе с логином login.
+	// Если по такому логину не находит пользователя, то возвращает ErrUserNotFound
+	User(ctx context.Context, login string) (model.User, error)
+
+	// SetUserRole назначает пользователю с логином login роль role.
+	// Если по такому логину не находит пользователя, то возвращает ErrUserNotFound
+	SetUserRole(ctx context.Context, login, role string) error
+
+	// LoginConflicts возвращает логины, которые не удалось привести к каноническому виду, начиная с самых старых.
+	// Если конфликтов нет, возвращает ErrLoginConflictNotFound
+	LoginConflicts(ctx context.Context) ([]model.LoginConflict, error)
+
+	// ResolveLoginConflict решает конфликт логина пользователя userID: меняет его логин на login и убирает конфликт.
+	// Если у пользователя нет конфликта, возвращает ErrLoginConflictNotFound, если логин login занят - ErrLoginIsUsed
+	ResolveLoginConflict(ctx context.Context, userID uuid.UUID, login string) error
+
+	// LoginAttempts возвращает информацию о неудачных попытках входа по ключам keys (логин, ip адрес).
+	// Ключи без неудачных попыток в результат не попадают
+	LoginAttempts(ctx context.Context, keys ...string) ([]model.LoginAttempts, error)
+
+	// RegisterLoginFailure увеличивает счетчик неудачных попыток входа по ключу key и возвращает обновленное состояние.
+	// Если предыдущая неудача была раньше windowStart, счетчик начинается заново.
+	// При достижении maxFailures вход по ключу блокируется до lockedUntil
+	RegisterLoginFailure(ctx context.Context, key string, windowStart time.Time, maxFailures int, lockedUntil time.Time) (model.LoginAttempts, error)
+
+	// ResetLoginFailures сбрасывает неудачные попытки и блокировку по ключу key
+	ResetLoginFailures(ctx context.Context, key string) error
+
+	// ChangePassword заменяет хеш пароля пользователя userID и отзывает все его сессии.
+	// Если пользователь не найден, то возвращает ErrUserNotFound
+	ChangePassword(ctx context.Context, userID uuid.UUID, hashPassword string) error
+
+	// RehashPassword заменяет хеш пароля пользователя userID на пересчитанный newHash, если хеш все еще равен oldHash. Сессии не затрагиваются.
+	// Если хеш успели поменять, то возвращает ErrNothingHasBeenDone
+	RehashPassword(ctx context.Context, userID uuid.UUID, oldHash, newHash string) error
+
+	// CreatePasswordReset сохраняет хеш токена сброса пароля пользователя userID, действующего до expiresAt.
+	// Ранее выданные и еще не использованные токены пользователя перестают действовать
+	CreatePasswordReset(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error
+
+	// RedeemPasswordReset использует токен сброса пароля: заменяет хеш пароля и отзывает все сессии пользователя. Возвращает ID пользователя.
+	// Токен одноразовый. Если токен не найден, истек или уже использован, то возвращает ErrPasswordResetNotFound
+	RedeemPasswordReset(ctx context.Context, tokenHash string, hashPassword string) (uuid.UUID, error)
+
+	// RegisterPasswordResetRequest учитывает запрос на сброс пароля по ключу key и возвращает число запросов в текущем окне.
+	// Если окно началось раньше windowStart, счетчик начинается заново с новым окном
+	RegisterPasswordResetRequest(ctx context.Context, key string, windowStart time.Time) (model.PasswordResetRequests, error)
+
+	// CreateAPIKey сохраняет api ключ пользователя userID. Сам ключ не передается, только его хеш keyHash и начало prefix для отображения
+	CreateAPIKey(ctx context.Context, userID uuid.UUID, name, keyHash, prefix string, scopes []string) (model.APIKey, error)
+
+	// APIKeys возвращает действующие api ключи пользователя userID.
+	// Если ключей нет, то возвращает ErrAPIKeysNotFound
+	APIKeys(ctx context.Context, userID uuid.UUID) ([]model.APIKey, error)
+
+	// RevokeAPIKey отзывает api ключ keyID пользователя userID.
+	// Если у пользователя нет такого действующего ключа, то возвращает ErrAPIKeyNotFound
+	RevokeAPIKey(ctx context.Context, userID, keyID uuid.UUID) error
+
+	// APIKeyOwner возвращает владельца действующего api ключа по хешу keyHash и отмечает время использования ключа.
+	// Если ключ не найден или отозван, то возвращает ErrAPIKeyNotFound
+	APIKeyOwner(ctx context.Context, keyHash string) (model.APIKeyOwner, error)
+
+	// SaveTOTP сохраняет новый, еще не подтвержденный секрет TOTP пользователя userID, заменяя прежний неподтвержденный.
+	// Если у пользователя уже включена двухфакторная аутентификация, то возвращает ErrTOTPAlreadyEnabled
+	SaveTOTP(ctx context.Context, userID uuid.UUID, secret string) error
+
+	// TOTP возвращает секрет TOTP пользователя userID.
+	// Если секрета нет, то возвращает ErrTOTPNotFound
+	TOTP(ctx context.Context, userID uuid.UUID) (model.TOTP, error)
+
+	// ConfirmTOTP включает двухфакторную аутентификацию пользователя userID: подтверждает секрет, запоминает принятый шаг step
+	// и сохраняет хеши кодов восстановления recoveryHashes вместо прежних.
+	// Если неподтвержденного секрета нет, то возвращает ErrNothingHasBeenDone
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryHashes []string) error
+
+	// UseTOTPStep отмечает шаг времени step как использованный.
+	// Если этот или более поздний шаг уже использован, то возвращает ErrNothingHasBeenDone
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error
+
+	// DisableTOTP отключает двухфакторную аутентификацию пользователя userID и удаляет его коды восстановления
+	DisableTOTP(ctx context.Context, userID uuid.UUID) error
+
+	// ReplaceRecoveryCodes заменяет все коды восстановления пользователя userID новыми (хранятся только хеши codeHashes)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+
+	// UseRecoveryCode использует одноразовый код восстановления с хешом codeHash.
+	// Если код не найден или уже использован, то возвращает ErrRecoveryCodeNotFound
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) error
+
+	// CreateMFAChallenge сохраняет незавершенный вход пользователя userID, ожидающий второй фактор, до expiresAt.
+	// tokenHash - хеш от токена, которым клиент продолжит вход
+	CreateMFAChallenge(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error
+
+	// MFAChallenge возвращает незавершенный вход по хешу токена tokenHash.
+	// Если вход не найден или истек, то возвращает ErrMFAChallengeNotFound
+	MFAChallenge(ctx context.Context, tokenHash string) (model.MFAChallenge, error)
+
+	// DeleteMFAChallenge удаляет незавершенный вход после успешной проверки второго фактора.
+	// Если вход уже удален (например, параллельным запросом), то возвращает ErrMFAChallengeNotFound
+	DeleteMFAChallenge(ctx context.Context, tokenHash string) error
+
 	// SaveOrder сохраняет заказ orderNum в системе, привязывая его к пользователю userID.
 	// Возвращает структуру ErrorWithHttpStatus с ошибкой бд и рекомендуемым кодом http.
 	// Возвращает ErrOrderWasUploadByAnotherUser + http.StatusConflict если другой пользователь уже загрузил заказ с таким номером.
@@ -42,7 +149,8 @@ type Storage interface {
 	Withdrawals(ctx context.Context, userID uuid.UUID) (model.ResponseWithdrawals, error)
 
 	// Withdraw списывает баллы (RequestWithdraw.Sum) с накопительного счета на заказ requestWithdraw.OrderNumber.
-	// При нехватке средств на балансе возвращает ErrWithdrawNotEnough
+	// Проверка баланса и списание выполняются атомарно, параллельные списания одного пользователя не уводят баланс в минус.
+	// При нехватке средств на балансе возвращает ErrWithdrawNotEnough, при отсутствии пользователя ErrUserNotFound
 	Withdraw(ctx context.Context, userID uuid.UUID, requestWithdraw model.RequestWithdraw) error
 
 	// OrdersByStatuses получает список из заказов у которых статус входит в заданную группу статусов statuses.
@@ -50,14 +158,100 @@ type Storage interface {
 	// Для пагинации служат limit - максимальное количество данных для возврата и offset - смещение относительно начала подходящей выборки
 	OrdersByStatuses(ctx context.Context, statuses []model.Status, limit, offset int) (model.ResponseOrders, error)
 
-	// UpdateOrders обновляет информацию о заказе info.
+	// ClaimOrders захватывает для экземпляра приложения owner до limit заказов со статусами из statuses на время leaseTTL.
+	// Заказы, захваченные другими экземплярами, пропускаются, пока не истечет их аренда. Так упавший экземпляр не держит заказы вечно.
+	// Заказы, время следующей проверки которых еще не наступило, и заказы в очереди недоставленных не захватываются.
+	// У захваченных заказов заполняется число уже сделанных попыток.
+	// При отсутствии свободных заказов возвращает ErrOrdersNotFound
+	ClaimOrders(ctx context.Context, owner string, statuses []model.Status, limit int, leaseTTL time.Duration) (model.ResponseOrders, error)
+
+	// ScheduleOrderCheck освобождает захваченный экземпляром owner заказ и назначает его следующую проверку на nextCheckAt.
+	// Сохраняет число сделанных попыток и последнюю ошибку из failure.
+	// Если заказ не захвачен owner (аренда истекла и его забрал другой экземпляр), возвращает ErrOrdersNotFound
+	ScheduleOrderCheck(ctx context.Context, owner string, orderNum model.OrderNumber, failure model.AccrualFailure, nextCheckAt time.Time) error
+
+	// DeadLetterOrder освобождает захваченный экземпляром owner заказ и переводит его в очередь недоставленных:
+	// больше он в систему расчета не запрашивается. Сохраняет число сделанных попыток и последнюю ошибку из failure.
+	// Если заказ не захвачен owner, возвращает ErrOrdersNotFound
+	DeadLetterOrder(ctx context.Context, owner string, orderNum model.OrderNumber, failure model.AccrualFailure) error
+
+	// DeferOrderCheck откладывает проверку заказа orderNum в системе расчета до until, не меняя число попыток.
+	// Уже назначенная более поздняя проверка не переносится. При отсутствии заказа возвращает ErrOrdersNotFound
+	DeferOrderCheck(ctx context.Context, orderNum model.OrderNumber, until time.Time) error
+
+	// SaveWebhookNonce запоминает уникальный признак nonce принятого события до expiresAt. Заодно удаляет истекшие признаки.
+	// Если событие с таким признаком уже принималось, возвращает ErrWebhookReplay
+	SaveWebhookNonce(ctx context.Context, nonce string, expiresAt time.Time) error
+
+	// DeadLetters возвращает заказы из очереди недоставленных, начиная с последних переведенных.
+	// Для пагинации служат limit и offset. При пустой очереди возвращает ErrOrdersNotFound
+	DeadLetters(ctx context.Context, limit, offset int) ([]model.DeadLetter, error)
+
+	// DeadLetter возвращает заказ orderNum из очереди недоставленных.
+	// Если заказа нет в очереди, возвращает ErrOrdersNotFound
+	DeadLetter(ctx context.Context, orderNum model.OrderNumber) (model.DeadLetter, error)
+
+	// RequeueDeadLetter возвращает заказ orderNum из очереди недоставленных на проверку в систему расчета со сброшенным числом попыток.
+	// Если заказа нет в очереди, возвращает ErrOrdersNotFound
+	RequeueDeadLetter(ctx context.Context, orderNum model.OrderNumber) error
+
+	// InvalidateDeadLetter убирает заказ orderNum из очереди недоставленных и переводит его в статус INVALID.
+	// Если заказа нет в очереди, возвращает ErrOrdersNotFound, если заказ уже в окончательном статусе - ErrIllegalTransition
+	InvalidateDeadLetter(ctx context.Context, orderNum model.OrderNumber) error
+
+	// UpdateOrder обновляет информацию о заказе info.
 	// Возвращает ErrNothingHasBeenDone если данные в репозитории уже актальны.
-	// При отсутствии заказов с переданным номером возвращает ErrOrdersNotFound
+	// При отсутствии заказов с переданным номером возвращает ErrOrdersNotFound, при неизвестном статусе - ErrUnknownStatus.
+	// Если переход в новый статус не разрешен CanTransit (в том числе из окончательного статуса), заказ не меняется и возвращается ErrIllegalTransition
 	UpdateOrder(ctx context.Context, info model.ResponseAccuralSystem) error
 
-	// UpdateOrders обновляет информацию о группе заказов info
-	UpdateOrders(ctx context.Context, info []model.ResponseAccuralSystem) (int, error)
+	// OrderHistory возвращает историю статусов заказа orderNum пользователя userID от загрузки к текущему.
+	// Если у пользователя нет такого заказа, возвращает ErrOrdersNotFound
+	OrderHistory(ctx context.Context, userID uuid.UUID, orderNum model.OrderNumber) (model.OrderHistory, error)
+
+	// UpdateOrders обновляет информацию о группе заказов info за один обмен с хранилищем.
+	// Возвращает результат по каждой строке в порядке info с теми же ошибками, что и UpdateOrder (nil - заказ обновлен).
+	// Ошибка в одной строке не отменяет остальные. Вторая ошибка - если группу не удалось обработать целиком
+	UpdateOrders(ctx context.Context, info []model.ResponseAccuralSystem) ([]error, error)
+
+	// CreateSession создает новую сессию пользователя userID, действующую до expiresAt.
+	// refreshHash - хеш от refresh токена сессии, сам токен в хранилище не попадает.
+	// mfa - вход подтвержден вторым фактором.
+	// Возвращает id созданной сессии
+	CreateSession(ctx context.Context, userID uuid.UUID, refreshHash string, expiresAt time.Time, mfa bool) (uuid.UUID, error)
+
+	// RotateSession заменяет refresh токен активной сессии с хешом refreshHash на новый newRefreshHash и продлевает сессию до expiresAt.
+	// Возвращает ErrSessionNotFound если сессия не найдена, истекла или отозвана.
+	// Повторное предъявление уже замененного refresh токена считается кражей - сессия отзывается и также возвращается ErrSessionNotFound
+	RotateSession(ctx context.Context, refreshHash, newRefreshHash string, expiresAt time.Time) (model.Session, error)
+
+	// RevokeSession отзывает сессию sessionID. Повторный отзыв не считается ошибкой
+	RevokeSession(ctx context.Context, sessionID uuid.UUID) error
+
+	// RevokeUserSessions отзывает все действующие сессии пользователя userID
+	RevokeUserSessions(ctx context.Context, userID uuid.UUID) error
+
+	// SessionActive проверяет, что сессия sessionID существует, не истекла и не отозвана, а токен tokenID не отозван отдельно
+	SessionActive(ctx context.Context, sessionID, tokenID uuid.UUID) (bool, error)
+
+	// RevokeToken отзывает отдельный токен доступа tokenID (jti), не затрагивая сессию.
+	// expiresAt - время истечения токена, после него запись об отзыве больше не нужна
+	RevokeToken(ctx context.Context, tokenID uuid.UUID, expiresAt time.Time) error
+
+	// TryLead пытается сделать экземпляр ведущим для задачи name. Ведущий для задачи один на все экземпляры, работающие с хранилищем.
+	// Пока блокировка за экземпляром, ее состояние проверяется раз в checkInterval.
+	// Если ведущий уже есть, возвращает ErrNotLeader
+	TryLead(ctx context.Context, name string, checkInterval time.Duration) (Leader, error)
 
 	// Close закрывает соединение с хранилищем
 	Close(ctx context.Context) error
 }
+
+// Leader блокировка ведущего экземпляра, полученная через Storage.TryLead
+type Leader interface {
+	// Lost закрывается, если блокировка потеряна, например разорвано соединение с хранилищем. Работу ведущего нужно остановить
+	Lost() <-chan struct{}
+
+	// Release отпускает блокировку, чтобы ведущим мог стать другой экземпляр. Вызывается один раз, в том числе после потери блокировки
+	Release(ctx context.Context) error
+}
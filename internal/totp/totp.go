@@ -0,0 +1,110 @@
+// пакет одноразовых паролей по времени (TOTP, RFC 6238) для двухфакторной аутентификации.
+// Параметры совместимы с распространенными приложениями-аутентификаторами: HMAC-SHA1, 6 цифр, шаг 30 секунд
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits количество цифр в коде
+	Digits = 6
+	// Period шаг времени, в течение которого действует код
+	Period = 30 * time.Second
+	// secretSize размер секрета в байтах. RFC 4226 рекомендует не меньше 160 бит
+	secretSize = 20
+)
+
+var ErrMalformedSecret = errors.New("секрет TOTP поврежден")
+
+// encoding base32 без выравнивания, как секрет принимают приложения-аутентификаторы
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret генерирует новый случайный секрет в base32
+func NewSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI ссылка otpauth:// для добавления секрета в приложение-аутентификатор (обычно показывается QR-кодом)
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step номер шага времени для момента t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code код для момента t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Step(t)), nil
+}
+
+// Verify проверяет код для момента t. Принимаются коды соседних шагов в пределах skew, чтобы пережить расхождение часов и время на ввод.
+// Возвращает шаг, которому соответствует код. Вызывающий должен помнить последний принятый шаг и не принимать его повторно
+func Verify(secret, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+	current := Step(t)
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// decodeSecret декодирует секрет base32. Пробелы и регистр не важны - так секрет удобнее вводить вручную
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrMalformedSecret
+	}
+	return key, nil
+}
+
+// hotp код HOTP (RFC 4226) для счетчика counter
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	// динамическое усечение
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
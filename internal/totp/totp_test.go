@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// секрет из тестовых векторов RFC 6238 ("12345678901234567890") в base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// в RFC коды из 8 цифр, у нас берутся последние 6
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, code, tt.unix)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := NewSecret()
+	require.NoError(t, err)
+	now := time.Now()
+	code, err := Code(secret, now)
+	require.NoError(t, err)
+
+	step, ok := Verify(secret, code, now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// соседний шаг принимается, дальний - нет
+	step, ok = Verify(secret, code, now.Add(Period), 1)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+	_, ok = Verify(secret, code, now.Add(2*Period), 1)
+	assert.False(t, ok)
+
+	_, ok = Verify(secret, "12345", now, 1)
+	assert.False(t, ok)
+	_, ok = Verify("не base32", code, now, 1)
+	assert.False(t, ok)
+	_, err = Code("не base32", now)
+	assert.ErrorIs(t, err, ErrMalformedSecret)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Gophermart", "alice", rfcSecret)
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Gophermart:alice", u.Path)
+	assert.Equal(t, rfcSecret, u.Query().Get("secret"))
+	assert.Equal(t, "Gophermart", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+}